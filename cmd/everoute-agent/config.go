@@ -20,22 +20,31 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/everoute/everoute/pkg/agent/datapath"
+	"github.com/everoute/everoute/pkg/agent/ipam"
 	"github.com/everoute/everoute/pkg/constants"
 	"github.com/everoute/everoute/pkg/utils"
 )
 
-const agentConfigFilePath = "/var/lib/everoute/agentconfig.yaml"
+const (
+	agentConfigFilePath           = "/var/lib/everoute/agentconfig.yaml"
+	policyCheckpointFilePath      = "/var/lib/everoute/agent/policy-checkpoint.json"
+	defaultFlowDriftCheckInterval = 5 * time.Minute
+)
 
 type agentConfig struct {
 	DatapathConfig map[string]string `yaml:"datapathConfig"`
@@ -43,8 +52,45 @@ type agentConfig struct {
 
 	// InternalIPs allow the items all ingress and egress traffics
 	InternalIPs []string `yaml:"internalIPs,omitempty"`
+
+	// EnableDHCPSnooping learns endpoint ipv4 address only from dhcp ack
+	EnableDHCPSnooping bool `yaml:"enableDHCPSnooping,omitempty"`
+
+	// EnableSpoofGuard drops packets from local endpoint with spoofed mac or ip
+	EnableSpoofGuard bool `yaml:"enableSpoofGuard,omitempty"`
+
+	// FlowDriftCheckInterval is the interval of checking policy bridge flows against
+	// the agent, e.g. "5m", default 5m, "0" disables the check.
+	FlowDriftCheckInterval string `yaml:"flowDriftCheckInterval,omitempty"`
+
+	// IPAM is the ipam of cni, "host-local" or "everoute", default "host-local".
+	// Everoute ipam allocates pod ip addresses from IPPools.
+	IPAM string `yaml:"ipam,omitempty"`
+
+	// TunnelType is the overlay encapsulation of inter-node pod traffic, "geneve" or "vxlan".
+	// Pod traffic is routed by underlay network if empty.
+	TunnelType string `yaml:"tunnelType,omitempty"`
+
+	// EnableServiceProxy load balances service cluster ips and node ports of tcp and udp in datapath,
+	// which replaces kube-proxy. Node port traffic from outside routed to backends on other nodes is
+	// masqueraded by the node.
+	EnableServiceProxy bool `yaml:"enableServiceProxy,omitempty"`
+
+	// EnableHostEndpointPolicy enforces policies of host endpoints on traffic to nodes from pods, other
+	// nodes and outside of the cluster, on ipv4 node addresses. Tunnel traffic and node ports balanced
+	// by service proxy are not affected.
+	EnableHostEndpointPolicy bool `yaml:"enableHostEndpointPolicy,omitempty"`
+
+	// HostEndpointSafePorts are always allowed to nodes with host endpoint policy enabled, e.g. "tcp/22",
+	// default ssh, kube-apiserver, etcd and kubelet.
+	HostEndpointSafePorts []string `yaml:"hostEndpointSafePorts,omitempty"`
 }
 
+const (
+	ipamHostLocal = "host-local"
+	ipamEveroute  = "everoute"
+)
+
 func getAgentConfig() (*agentConfig, error) {
 	var err error
 	agentConfig := agentConfig{}
@@ -69,7 +115,17 @@ func getDatapathConfig() (*datapath.Config, error) {
 	}
 
 	dpConfig := &datapath.Config{
-		InternalIPs: agentConfig.InternalIPs,
+		InternalIPs:            agentConfig.InternalIPs,
+		EnableDHCPSnooping:     agentConfig.EnableDHCPSnooping,
+		EnableSpoofGuard:       agentConfig.EnableSpoofGuard,
+		FlowDriftCheckInterval: defaultFlowDriftCheckInterval,
+	}
+
+	if agentConfig.FlowDriftCheckInterval != "" {
+		dpConfig.FlowDriftCheckInterval, err = time.ParseDuration(agentConfig.FlowDriftCheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse flowDriftCheckInterval, error: %v. ", err)
+		}
 	}
 
 	managedVDSMap := make(map[string]string)
@@ -81,6 +137,63 @@ func getDatapathConfig() (*datapath.Config, error) {
 	return dpConfig, nil
 }
 
+// getIPAMAllocator returns everoute ipam allocator if enabled in agent config, or nil
+// if host-local ipam should be used.
+func getIPAMAllocator(k8sReader client.Reader, k8sClient client.Client, agentInfo *datapath.AgentConf) (*ipam.Allocator, error) {
+	agentConfig, err := getAgentConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agentConfig, error: %v. ", err)
+	}
+
+	switch agentConfig.IPAM {
+	case "", ipamHostLocal:
+		return nil, nil
+	case ipamEveroute:
+		return ipam.NewAllocator(k8sReader, k8sClient, agentInfo.NodeName, agentInfo.PodCIDR), nil
+	default:
+		return nil, fmt.Errorf("unknown ipam %s", agentConfig.IPAM)
+	}
+}
+
+// watchAgentConfig reloads datapath config when agent config file changes. The directory
+// is watched, because the config file may be replaced instead of written.
+func watchAgentConfig(datapathManager *datapath.DpManager, stopChan <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("unable watch agent config %s: %s", agentConfigFilePath, err)
+		return
+	}
+	defer watcher.Close()
+
+	if err = watcher.Add(filepath.Dir(agentConfigFilePath)); err != nil {
+		klog.Errorf("unable watch agent config %s: %s", agentConfigFilePath, err)
+		return
+	}
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case err := <-watcher.Errors:
+			klog.Errorf("agent config watcher error: %s", err)
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) != agentConfigFilePath ||
+				event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			dpConfig, err := getDatapathConfig()
+			if err != nil {
+				klog.Errorf("unable reload agent config: %s", err)
+				continue
+			}
+			klog.Infof("reload agent config %s", agentConfigFilePath)
+			if err = datapathManager.UpdateDatapathConfig(dpConfig, stopChan); err != nil {
+				klog.Errorf("unable apply agent config: %s", err)
+			}
+		}
+	}
+}
+
 func setAgentConf(datapathManager *datapath.DpManager, k8sReader client.Reader) {
 	k8sClient := k8sReader.(client.Client)
 	agentInfo := datapathManager.AgentInfo
@@ -118,16 +231,27 @@ func setAgentConf(datapathManager *datapath.DpManager, k8sReader client.Reader)
 			for _, container := range pod.Spec.Containers {
 				for _, commond := range container.Command {
 					if strings.HasPrefix(commond, "--service-cluster-ip-range=") {
-						cidr, _ := cnitypes.ParseCIDR(strings.TrimPrefix(commond, "--service-cluster-ip-range="))
-						cidrNet := cnitypes.IPNet(*cidr)
-						agentInfo.ClusterCIDR = &cidrNet
+						// dual-stack cluster has service cidrs of both ip families
+						for _, cidrString := range strings.Split(strings.TrimPrefix(commond, "--service-cluster-ip-range="), ",") {
+							cidr, err := cnitypes.ParseCIDR(strings.TrimSpace(cidrString))
+							if err != nil {
+								klog.Errorf("ignore invalid service cluster cidr %s: %s", cidrString, err)
+								continue
+							}
+							cidrNet := cnitypes.IPNet(*cidr)
+							if cidr.IP.To4() != nil {
+								agentInfo.ClusterCIDR = &cidrNet
+							} else {
+								agentInfo.ClusterCIDRv6 = &cidrNet
+							}
+						}
 						loopExit = true
 					}
 				}
 			}
 		}
 	}
-	if agentInfo.ClusterCIDR == nil {
+	if agentInfo.ClusterCIDR == nil && agentInfo.ClusterCIDRv6 == nil {
 		klog.Fatalf("Service cluster CIDR should be specified when setup kubernetes cluster. E.g. `kubeadm init --service-cidr 10.244.0.0/16`")
 	}
 
@@ -153,6 +277,43 @@ func setAgentConf(datapathManager *datapath.DpManager, k8sReader client.Reader)
 
 	agentInfo.LocalGwIP = localGwIP
 	agentInfo.LocalGwMac = localGwMac
-	agentInfo.GatewayIP = ip.NextIP(agentInfo.PodCIDR[0].IP)
+	if podCIDR := agentInfo.PodCIDROfFamily(false); podCIDR != nil {
+		agentInfo.GatewayIP = ip.NextIP(podCIDR.IP)
+	}
+	if podCIDR := agentInfo.PodCIDROfFamily(true); podCIDR != nil {
+		agentInfo.GatewayIPv6 = ip.NextIP(podCIDR.IP)
+	}
 	agentInfo.GatewayMac = GwMac
+
+	agentConfig, err := getAgentConfig()
+	if err != nil {
+		klog.Fatalf("Failed to get agent config, error:%s", err)
+	}
+	switch agentConfig.TunnelType {
+	case "", datapath.TunnelTypeGeneve, datapath.TunnelTypeVXLAN:
+		agentInfo.TunnelType = agentConfig.TunnelType
+	default:
+		klog.Fatalf("Unknown tunnel type %s", agentConfig.TunnelType)
+	}
+	agentInfo.EnableServiceProxy = agentConfig.EnableServiceProxy
+
+	agentInfo.EnableHostEndpointPolicy = agentConfig.EnableHostEndpointPolicy
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+			if nodeIP := net.ParseIP(address.Address); nodeIP != nil {
+				agentInfo.NodeIPs = append(agentInfo.NodeIPs, nodeIP)
+			}
+		}
+	}
+	agentInfo.HostEndpointSafePorts = datapath.DefaultHostEndpointSafePorts
+	if len(agentConfig.HostEndpointSafePorts) != 0 {
+		agentInfo.HostEndpointSafePorts = nil
+		for _, item := range agentConfig.HostEndpointSafePorts {
+			safePort, err := datapath.ParseHostEndpointPort(item)
+			if err != nil {
+				klog.Fatalf("Failed to parse host endpoint safe ports, error:%s", err)
+			}
+			agentInfo.HostEndpointSafePorts = append(agentInfo.HostEndpointSafePorts, safePort)
+		}
+	}
 }
@@ -17,28 +17,43 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
-	"net"
+	"fmt"
+	"os"
 
 	corev1 "k8s.io/api/core/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/everoute/everoute/pkg/agent/cniserver"
+	"github.com/everoute/everoute/pkg/agent/controller/bandwidth"
 	"github.com/everoute/everoute/pkg/agent/controller/policy"
+	"github.com/everoute/everoute/pkg/agent/controller/spoofguard"
+	"github.com/everoute/everoute/pkg/agent/controller/traceflow"
 	"github.com/everoute/everoute/pkg/agent/datapath"
+	"github.com/everoute/everoute/pkg/agent/debug"
+	"github.com/everoute/everoute/pkg/agent/ipam"
 	"github.com/everoute/everoute/pkg/agent/proxy"
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
 	clientsetscheme "github.com/everoute/everoute/pkg/client/clientset_generated/clientset/scheme"
 	"github.com/everoute/everoute/pkg/constants"
 	"github.com/everoute/everoute/pkg/monitor"
+	libvirtplugin "github.com/everoute/everoute/plugin/libvirt/pkg/register"
 )
 
 var (
-	enableCNI   bool
-	metricsAddr string
+	enableCNI            bool
+	metricsAddr          string
+	cleanup              bool
+	dryRun               bool
+	libvirtPluginOptions libvirtplugin.Options
 )
 
 func init() {
@@ -48,13 +63,23 @@ func init() {
 func main() {
 	flag.BoolVar(&enableCNI, "enable-cni", false, "Enable CNI in agent.")
 	flag.StringVar(&metricsAddr, "metrics-addr", "0", "The address the metric endpoint binds to.")
+	flag.BoolVar(&cleanup, "cleanup", false, "Restore managed bridges and remove everoute datapath, then exit.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the plan of cleanup without executing.")
 	klog.InitFlags(nil)
+	libvirtplugin.InitFlags(&libvirtPluginOptions, nil, "plugins.libvirt.")
 	flag.Parse()
 	defer klog.Flush()
 
+	if cleanup {
+		if err := cleanupDatapath(dryRun); err != nil {
+			klog.Fatalf("Failed to cleanup datapath: %v", err)
+		}
+		return
+	}
+
 	// Init everoute datapathManager: init bridge chain config and default flow
 	stopChan := ctrl.SetupSignalHandler()
-	ofPortIPAddrMoniotorChan := make(chan map[string]net.IP, 1024)
+	ofPortIPAddrMoniotorChan := make(chan map[string]datapath.LearnedIPAddress, 1024)
 
 	// TODO Update vds which is managed by everoute agent from datapathConfig.
 	datapathConfig, err := getDatapathConfig()
@@ -80,21 +105,32 @@ func main() {
 	if enableCNI {
 		setAgentConf(datapathManager, mgr.GetAPIReader())
 
+		// everoute ipam, nil if host-local ipam used
+		allocator, err := getIPAMAllocator(mgr.GetAPIReader(), k8sClient, datapathManager.AgentInfo)
+		if err != nil {
+			klog.Fatalf("Failed to get ipam allocator, error: %v. ", err)
+		}
+		if allocator != nil {
+			go allocator.Run(ipam.DefaultGCInterval, stopChan)
+		}
+
 		// cni server
-		cniServer := cniserver.Initialize(k8sClient, datapathManager)
+		cniServer := cniserver.Initialize(k8sClient, mgr.GetAPIReader(), datapathManager, allocator)
 		go cniServer.Run(stopChan)
+		go cniServer.RunGC(cniserver.DefaultGCInterval, stopChan)
 	}
 
 	datapathManager.InitializeCNI()
 
-	if err = startManager(mgr, datapathManager, stopChan); err != nil {
-		klog.Fatalf("error %v when start controller manager.", err)
-	}
-
 	agentmonitor, err := monitor.NewAgentMonitor(k8sClient, ofPortIPAddrMoniotorChan)
 	if err != nil {
 		klog.Fatalf("error %v when start agentmonitor.", err)
 	}
+
+	if err = startManager(mgr, datapathManager, agentmonitor.Name(), stopChan); err != nil {
+		klog.Fatalf("error %v when start controller manager.", err)
+	}
+
 	agentmonitor.RegisterOvsdbEventHandler(monitor.OvsdbEventHandlerFuncs{
 		LocalEndpointAddFunc: func(endpoint datapath.Endpoint) {
 			err := datapathManager.AddLocalEndpoint(&endpoint)
@@ -117,20 +153,111 @@ func main() {
 	})
 	go agentmonitor.Run(stopChan)
 
+	// heal flows drift from datapath, and report drift as events of the agentinfo
+	recorder := mgr.GetEventRecorderFor("everoute-agent")
+	go datapathManager.RunFlowDriftCheck(flowDriftEventHandler(k8sClient, recorder, agentmonitor.Name()), stopChan)
+
+	// apply agent config changes without restart
+	go watchAgentConfig(datapathManager, stopChan)
+
 	<-stopChan
 }
 
-func startManager(mgr manager.Manager, datapathManager *datapath.DpManager, stopChan <-chan struct{}) error {
+func flowDriftEventHandler(k8sClient client.Client, recorder record.EventRecorder, agentName string) datapath.FlowDriftHandler {
+	return func(drift *datapath.FlowDrift, err error) {
+		agentInfo := agentv1alpha1.AgentInfo{}
+		if getErr := k8sClient.Get(context.Background(), client.ObjectKey{Name: agentName}, &agentInfo); getErr != nil {
+			klog.Errorf("failed to get agentinfo %s for flow drift event: %s", agentName, getErr)
+			return
+		}
+
+		if err != nil {
+			recorder.Eventf(&agentInfo, corev1.EventTypeWarning, "FlowDriftHealFailed", "%s, error: %s", drift, err)
+			return
+		}
+		recorder.Eventf(&agentInfo, corev1.EventTypeWarning, "FlowDriftHealed", "%s", drift)
+	}
+}
+
+// cleanupDatapath teardowns datapath of bridges in agent config, and removes the agent state.
+func cleanupDatapath(dryRun bool) error {
+	datapathConfig, err := getDatapathConfig()
+	if err != nil {
+		return err
+	}
+
+	plan, err := datapath.TeardownDatapath(datapathConfig, dryRun)
+	for _, step := range plan {
+		fmt.Println(step)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("remove policy checkpoint: rm -f %s\n", policyCheckpointFilePath)
+	if dryRun {
+		return nil
+	}
+	if err = os.Remove(policyCheckpointFilePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func startManager(mgr manager.Manager, datapathManager *datapath.DpManager, agentName string, stopChan <-chan struct{}) error {
 	var err error
 	// Policy controller: watch policy related resource and update
-	if err = (&policy.Reconciler{
+	policyReconciler := &policy.Reconciler{
 		Client:          mgr.GetClient(),
 		Scheme:          mgr.GetScheme(),
 		DatapathManager: datapathManager,
-	}).SetupWithManager(mgr); err != nil {
+		CheckpointFile:  policyCheckpointFilePath,
+	}
+	if err = policyReconciler.SetupWithManager(mgr); err != nil {
 		klog.Fatalf("unable to create policy controller: %s", err.Error())
 	}
 
+	// datapath metrics: installed rules and flows, rule operations and reconnects
+	if err = metrics.Registry.Register(datapathManager); err != nil {
+		klog.Errorf("unable to register datapath metrics: %s", err.Error())
+		return err
+	}
+
+	// debug server: serve agent internal state for everoute-agentctl
+	debugServer := debug.NewServer(policyReconciler.GetCompleteRuleLister(), policyReconciler.GetGlobalRuleLister(),
+		policyReconciler.GetGroupCache(), datapathManager)
+	go debugServer.Run(stopChan)
+
+	// Spoof guard controller: watch endpoint and update spoof guard policy of local endpoints
+	if err = (&spoofguard.Reconciler{
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		DatapathManager: datapathManager,
+	}).SetupWithManager(mgr); err != nil {
+		klog.Errorf("unable to create spoof guard controller: %s", err.Error())
+		return err
+	}
+
+	// Bandwidth controller: watch endpoint and update bandwidth limit of local endpoints
+	if err = (&bandwidth.Reconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		klog.Errorf("unable to create bandwidth controller: %s", err.Error())
+		return err
+	}
+
+	// Traceflow controller: inject and observe traceflow packets on this agent
+	if err = (&traceflow.Reconciler{
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		DatapathManager: datapathManager,
+		AgentName:       agentName,
+	}).SetupWithManager(mgr); err != nil {
+		klog.Errorf("unable to create traceflow controller: %s", err.Error())
+		return err
+	}
+
 	if enableCNI {
 		if err = (&proxy.NodeReconciler{
 			Client:          mgr.GetClient(),
@@ -141,6 +268,33 @@ func startManager(mgr manager.Manager, datapathManager *datapath.DpManager, stop
 			klog.Errorf("unable to create node controller: %s", err.Error())
 			return err
 		}
+
+		if err = (&proxy.EgressReconciler{
+			Client:          mgr.GetClient(),
+			Scheme:          mgr.GetScheme(),
+			DatapathManager: datapathManager,
+			StopChan:        stopChan,
+		}).SetupWithManager(mgr); err != nil {
+			klog.Errorf("unable to create egress controller: %s", err.Error())
+			return err
+		}
+
+		if datapathManager.AgentInfo.EnableServiceProxy {
+			if err = (&proxy.ServiceReconciler{
+				Client:          mgr.GetClient(),
+				Scheme:          mgr.GetScheme(),
+				DatapathManager: datapathManager,
+			}).SetupWithManager(mgr); err != nil {
+				klog.Errorf("unable to create service controller: %s", err.Error())
+				return err
+			}
+		}
+	}
+
+	// register libvirt plugin
+	if err = libvirtplugin.AddToManager(&libvirtPluginOptions, mgr); err != nil {
+		klog.Errorf("unable register libvirt plugin: %s", err.Error())
+		return err
 	}
 
 	klog.Info("starting manager")
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newRuleCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rule [rule id]",
+		Short: "List policy rules and their expanded policy rules",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules, err := opts.client().ListRules()
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				return printRules(cmd.OutOrStdout(), opts.output, rules)
+			}
+
+			for _, rule := range rules {
+				if rule.RuleID == args[0] {
+					return printPolicyRules(cmd.OutOrStdout(), opts.output, rule.PolicyRules)
+				}
+			}
+			return fmt.Errorf("rule %s not found", args[0])
+		},
+	}
+
+	return cmd
+}
+
+func newGroupCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "group [group name]",
+		Short: "List group members and pending patches",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			groups, err := opts.client().ListGroups()
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				return printGroups(cmd.OutOrStdout(), opts.output, groups)
+			}
+
+			for _, group := range groups {
+				if group.Name == args[0] {
+					return printGroupMembers(cmd.OutOrStdout(), opts.output, group)
+				}
+			}
+			return fmt.Errorf("group %s not found", args[0])
+		},
+	}
+
+	return cmd
+}
+
+func newFlowCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flow [datapath rule id]",
+		Short: "List datapath rules, or show ovs flows of the datapath rule on each vds",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				rules, err := opts.client().ListDatapathRules()
+				if err != nil {
+					return err
+				}
+				return printDatapathRules(cmd.OutOrStdout(), opts.output, rules)
+			}
+
+			ruleFlows, err := opts.client().GetDatapathRuleFlows(args[0])
+			if err != nil {
+				return err
+			}
+			return printRuleFlows(cmd.OutOrStdout(), opts.output, ruleFlows)
+		},
+	}
+
+	return cmd
+}
+
+func newEndpointCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "endpoint",
+		Aliases: []string{"ep"},
+		Short:   "List local endpoints",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			endpoints, err := opts.client().ListEndpoints()
+			if err != nil {
+				return err
+			}
+			return printEndpoints(cmd.OutOrStdout(), opts.output, endpoints)
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/everoute/everoute/pkg/agent/debug"
+)
+
+type options struct {
+	socketAddr string
+	output     string
+}
+
+func main() {
+	if err := rootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func rootCommand() *cobra.Command {
+	opts := &options{}
+
+	rootCmd := &cobra.Command{
+		Use:   "everoute-agentctl",
+		Short: "Everoute-agentctl: inspect state of the local everoute agent",
+	}
+
+	rootCmd.Root().SilenceUsage = true
+	rootCmd.Root().SetHelpCommand(&cobra.Command{Hidden: true})
+	rootCmd.PersistentFlags().StringVar(&opts.socketAddr, "socket", debug.SocketAddr, "agent debug socket address")
+	rootCmd.PersistentFlags().StringVarP(&opts.output, "output", "o", outputTable, "output format, one of: table, json")
+
+	rootCmd.AddCommand(newRuleCommand(opts))
+	rootCmd.AddCommand(newGroupCommand(opts))
+	rootCmd.AddCommand(newFlowCommand(opts))
+	rootCmd.AddCommand(newEndpointCommand(opts))
+
+	return rootCmd
+}
+
+func (o *options) client() *debug.Client {
+	return debug.NewClient(o.socketAddr)
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/printers"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	"github.com/everoute/everoute/pkg/agent/debug"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printRules(output io.Writer, format string, rules []debug.CompleteRule) error {
+	var table = newTable("rule-id", "tier", "action", "direction", "src-groups", "dst-groups", "policy-rules")
+
+	for _, rule := range rules {
+		var row = []interface{}{}
+
+		row = append(row, rule.RuleID)
+		row = append(row, rule.Tier)
+		row = append(row, rule.Action)
+		row = append(row, rule.Direction)
+		row = append(row, groupsJoin(rule.SrcGroups))
+		row = append(row, groupsJoin(rule.DstGroups))
+		row = append(row, len(rule.PolicyRules))
+
+		addRow(table, row)
+	}
+
+	return printObject(output, format, rules, table)
+}
+
+func printPolicyRules(output io.Writer, format string, policyRules []policycache.PolicyRule) error {
+	var table = newTable("name", "action", "direction", "type", "tier", "src-ip", "dst-ip", "protocol", "src-port", "dst-port")
+
+	for _, rule := range policyRules {
+		var row = []interface{}{}
+
+		row = append(row, rule.Name)
+		row = append(row, rule.Action)
+		row = append(row, rule.Direction)
+		row = append(row, rule.RuleType)
+		row = append(row, rule.Tier)
+		row = append(row, rule.SrcIPAddr)
+		row = append(row, rule.DstIPAddr)
+		row = append(row, rule.IPProtocol)
+		row = append(row, portString(rule.SrcPort, rule.SrcPortMask))
+		row = append(row, portString(rule.DstPort, rule.DstPortMask))
+
+		addRow(table, row)
+	}
+
+	return printObject(output, format, policyRules, table)
+}
+
+func printGroups(output io.Writer, format string, groups []policycache.GroupMembership) error {
+	var table = newTable("name", "revision", "members", "pending-patches")
+
+	for _, group := range groups {
+		var row = []interface{}{}
+		var patches []string
+		for _, patch := range group.Patches {
+			patches = append(patches, fmt.Sprintf("%s(%d)", patch.Name, patch.Revision))
+		}
+
+		row = append(row, group.Name)
+		row = append(row, group.Revision)
+		row = append(row, len(group.Members))
+		row = append(row, strings.Join(patches, ","))
+
+		addRow(table, row)
+	}
+
+	return printObject(output, format, groups, table)
+}
+
+func printGroupMembers(output io.Writer, format string, group policycache.GroupMembership) error {
+	var table = newTable("external-id", "agents", "ips")
+
+	for _, member := range group.Members {
+		var row = []interface{}{}
+		var ips []string
+		for _, ip := range member.IPs {
+			ips = append(ips, string(ip))
+		}
+
+		row = append(row, fmt.Sprintf("%s=%s", member.EndpointReference.ExternalIDName, member.EndpointReference.ExternalIDValue))
+		row = append(row, strings.Join(member.EndpointAgent, ","))
+		row = append(row, strings.Join(ips, ","))
+
+		addRow(table, row)
+	}
+
+	return printObject(output, format, group, table)
+}
+
+func printDatapathRules(output io.Writer, format string, rules []debug.DatapathRule) error {
+	var table = newTable("rule-id", "direction", "tier", "priority", "src-ip", "dst-ip", "protocol", "src-port", "dst-port", "action")
+
+	for _, rule := range rules {
+		var row = []interface{}{}
+
+		row = append(row, rule.RuleID)
+		row = append(row, rule.Direction)
+		row = append(row, rule.Tier)
+		row = append(row, rule.Priority)
+		row = append(row, rule.SrcIPAddr)
+		row = append(row, rule.DstIPAddr)
+		row = append(row, rule.IPProtocol)
+		row = append(row, portString(rule.SrcPort, rule.SrcPortMask))
+		row = append(row, portString(rule.DstPort, rule.DstPortMask))
+		row = append(row, rule.Action)
+
+		addRow(table, row)
+	}
+
+	return printObject(output, format, rules, table)
+}
+
+func printRuleFlows(output io.Writer, format string, ruleFlows []datapath.RuleFlows) error {
+	var table = newTable("vds", "bridge", "table", "priority", "flow-id", "flow")
+
+	for _, flows := range ruleFlows {
+		for _, flow := range flows.Flows {
+			var row = []interface{}{}
+
+			row = append(row, flows.VDS)
+			row = append(row, flows.Bridge)
+			row = append(row, flows.TableID)
+			row = append(row, flows.Priority)
+			row = append(row, fmt.Sprintf("%#x", flows.FlowID))
+			row = append(row, flow)
+
+			addRow(table, row)
+		}
+	}
+
+	return printObject(output, format, ruleFlows, table)
+}
+
+func printEndpoints(output io.Writer, format string, endpoints []debug.LocalEndpoint) error {
+	var table = newTable("interface", "bridge", "ofport", "mac", "vlan", "ipv4", "ipv6")
+
+	for _, endpoint := range endpoints {
+		var row = []interface{}{}
+
+		row = append(row, endpoint.InterfaceName)
+		row = append(row, endpoint.BridgeName)
+		row = append(row, endpoint.PortNo)
+		row = append(row, endpoint.MacAddrStr)
+		row = append(row, endpoint.VlanID)
+		row = append(row, ipString(endpoint.IPAddr))
+		row = append(row, ipString(endpoint.IPv6Addr))
+
+		addRow(table, row)
+	}
+
+	return printObject(output, format, endpoints, table)
+}
+
+func printObject(output io.Writer, format string, obj interface{}, table *metav1.Table) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(obj)
+	case outputTable:
+		printer := printers.NewTablePrinter(printers.PrintOptions{})
+		return printer.PrintObj(table, output)
+	default:
+		return fmt.Errorf("unknown output format %s", format)
+	}
+}
+
+func newTable(columns ...string) *metav1.Table {
+	var table = &metav1.Table{}
+
+	for _, column := range columns {
+		table.ColumnDefinitions = append(table.ColumnDefinitions, metav1.TableColumnDefinition{
+			Name: column,
+			Type: "string",
+		})
+	}
+
+	return table
+}
+
+func addRow(table *metav1.Table, row []interface{}) {
+	table.Rows = append(table.Rows, metav1.TableRow{
+		Cells: row,
+	})
+}
+
+func groupsJoin(groups map[string]int32) string {
+	var groupList []string
+	for group, revision := range groups {
+		groupList = append(groupList, fmt.Sprintf("%s(%d)", group, revision))
+	}
+	sort.Strings(groupList)
+	return strings.Join(groupList, ",")
+}
+
+func portString(port, mask uint16) string {
+	switch {
+	case port == 0:
+		return ""
+	case mask == 0 || mask == 0xffff:
+		return fmt.Sprintf("%d", port)
+	default:
+		return fmt.Sprintf("%d/%#x", port, mask)
+	}
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -45,7 +45,9 @@ import (
 	groupctrl "github.com/everoute/everoute/pkg/controller/group"
 	"github.com/everoute/everoute/pkg/controller/k8s"
 	ctrlpolicy "github.com/everoute/everoute/pkg/controller/policy"
+	traceflowctrl "github.com/everoute/everoute/pkg/controller/traceflow"
 	"github.com/everoute/everoute/pkg/webhook"
+	genericplugin "github.com/everoute/everoute/plugin/generic/pkg/register"
 	towerplugin "github.com/everoute/everoute/plugin/tower/pkg/register"
 	"github.com/everoute/everoute/third_party/cert"
 )
@@ -63,6 +65,7 @@ func main() {
 	var serverPort int
 	var leaderElectionNamespace string
 	var towerPluginOptions towerplugin.Options
+	var genericPluginOptions genericplugin.Options
 	var enableCNI bool
 
 	flag.StringVar(&metricsAddr, "metrics-addr", "0", "The address the metric endpoint binds to.")
@@ -75,6 +78,7 @@ func main() {
 	flag.BoolVar(&enableCNI, "enable-cni", false, "Enable CNI related controller.")
 	klog.InitFlags(nil)
 	towerplugin.InitFlags(&towerPluginOptions, nil, "plugins.tower.")
+	genericplugin.InitFlags(&genericPluginOptions, nil, "plugins.generic.")
 	flag.Parse()
 
 	config := ctrl.GetConfigOrDie()
@@ -125,6 +129,14 @@ func main() {
 		klog.Fatalf("unable to create policy controller: %s", err.Error())
 	}
 
+	// traceflow controller allocate tag for traceflows and complete them.
+	if err = (&traceflowctrl.TraceflowReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		klog.Fatalf("unable to create traceflow controller: %s", err.Error())
+	}
+
 	if enableCNI {
 		// pod controller
 		if err = (&k8s.PodReconciler{
@@ -135,6 +147,15 @@ func main() {
 		}
 		klog.Info("start pod controller")
 
+		// node controller
+		if err = (&k8s.NodeReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			klog.Fatalf("unable to create node controller: %s", err.Error())
+		}
+		klog.Info("start node controller")
+
 		// networkPolicy controller
 		if err = (&k8s.NetworkPolicyReconciler{
 			Client: mgr.GetClient(),
@@ -158,6 +179,12 @@ func main() {
 		klog.Fatalf("unable register tower plugin: %s", err.Error())
 	}
 
+	// register generic plugin
+	err = genericplugin.AddToManager(&genericPluginOptions, mgr)
+	if err != nil {
+		klog.Fatalf("unable register generic plugin: %s", err.Error())
+	}
+
 	klog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		klog.Fatalf("error while running manager: %s", err.Error())
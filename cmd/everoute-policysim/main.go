@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/printers"
+
+	"github.com/everoute/everoute/pkg/agent/controller/policy"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+type options struct {
+	filenames []string
+	output    string
+	srcIP     string
+	dstIP     string
+	protocol  string
+	srcPort   uint16
+	dstPort   uint16
+	expect    string
+}
+
+func main() {
+	if err := rootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func rootCommand() *cobra.Command {
+	opts := &options{}
+
+	rootCmd := &cobra.Command{
+		Use:   "everoute-policysim",
+		Short: "Everoute-policysim: evaluate a packet against policies offline",
+		Long: "Everoute-policysim loads SecurityPolicies, GlobalPolicy, Endpoints, EndpointGroups and Namespaces " +
+			"from yaml or json files, compiles them the same way as everoute-agent, and reports whether the packet would be allowed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.OutOrStdout(), opts)
+		},
+	}
+
+	rootCmd.Root().SilenceUsage = true
+	rootCmd.Flags().StringSliceVarP(&opts.filenames, "filename", "f", nil, "files or directories contain resources")
+	rootCmd.Flags().StringVarP(&opts.output, "output", "o", outputTable, "output format, one of: table, json")
+	rootCmd.Flags().StringVar(&opts.srcIP, "src-ip", "", "source ip of the packet")
+	rootCmd.Flags().StringVar(&opts.dstIP, "dst-ip", "", "destination ip of the packet")
+	rootCmd.Flags().StringVar(&opts.protocol, "protocol", string(securityv1alpha1.ProtocolICMP), "protocol of the packet, one of: TCP, UDP, ICMP")
+	rootCmd.Flags().Uint16Var(&opts.srcPort, "src-port", 0, "source port of the packet")
+	rootCmd.Flags().Uint16Var(&opts.dstPort, "dst-port", 0, "destination port of the packet")
+	rootCmd.Flags().StringVar(&opts.expect, "expect", "", "exit with error if the verdict is not the expect one, one of: Allow, Drop")
+	_ = rootCmd.MarkFlagRequired("filename")
+	_ = rootCmd.MarkFlagRequired("src-ip")
+	_ = rootCmd.MarkFlagRequired("dst-ip")
+
+	return rootCmd
+}
+
+func run(output io.Writer, opts *options) error {
+	packet := &policy.SimulatePacket{
+		SrcIP:    net.ParseIP(opts.srcIP),
+		DstIP:    net.ParseIP(opts.dstIP),
+		Protocol: securityv1alpha1.Protocol(strings.ToUpper(opts.protocol)),
+		SrcPort:  opts.srcPort,
+		DstPort:  opts.dstPort,
+	}
+	if packet.SrcIP.To4() == nil || packet.DstIP.To4() == nil {
+		return fmt.Errorf("invalid ipv4 address %s or %s", opts.srcIP, opts.dstIP)
+	}
+	switch packet.Protocol {
+	case securityv1alpha1.ProtocolTCP, securityv1alpha1.ProtocolUDP, securityv1alpha1.ProtocolICMP:
+	default:
+		return fmt.Errorf("unsupported protocol %s", opts.protocol)
+	}
+
+	input, err := loadInput(opts.filenames)
+	if err != nil {
+		return err
+	}
+	simulator, err := policy.NewSimulator(input)
+	if err != nil {
+		return err
+	}
+
+	result := simulator.Simulate(packet)
+	if err = printResult(output, opts.output, result); err != nil {
+		return err
+	}
+
+	if opts.expect != "" && !strings.EqualFold(opts.expect, string(result.Action)) {
+		return fmt.Errorf("expect verdict %s, got %s", opts.expect, result.Action)
+	}
+	return nil
+}
+
+func loadInput(filenames []string) (*policy.SimulatorInput, error) {
+	var input = &policy.SimulatorInput{}
+
+	for _, filename := range filenames {
+		err := filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			switch filepath.Ext(path) {
+			case ".yaml", ".yml", ".json":
+			default:
+				if path != filename {
+					// only load yaml or json files in the directory
+					return nil
+				}
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err = policy.DecodeSimulatorInput(input, data); err != nil {
+				return fmt.Errorf("decode %s: %s", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return input, nil
+}
+
+func printResult(output io.Writer, format string, result *policy.SimulateResult) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case outputTable:
+		table := &metav1.Table{ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "direction"}, {Name: "verdict"}, {Name: "tier"}, {Name: "rule-id"}, {Name: "policy-rules"},
+		}}
+		for _, verdict := range result.Verdicts {
+			table.Rows = append(table.Rows, metav1.TableRow{Cells: []interface{}{
+				verdict.Direction, verdict.Action, verdict.Tier, verdict.RuleID, strings.Join(verdict.PolicyRules, ","),
+			}})
+		}
+		if err := printers.NewTablePrinter(printers.PrintOptions{}).PrintObj(table, output); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(output, "\nverdict: %s\n", result.Action)
+		return err
+	default:
+		return fmt.Errorf("unknown output format %s", format)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/disk"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	coretypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	"github.com/everoute/everoute/pkg/utils"
+)
+
+// DefaultGCInterval is the default interval of cleaning up stale pod ports and addresses.
+const DefaultGCInterval = 5 * time.Minute
+
+// hostLocalDataDir is where host-local ipam stores the allocations of each network
+var hostLocalDataDir = "/var/lib/cni/networks"
+
+// RunGC cleans up the stale pod ports and addresses periodically.
+func (s *CNIServer) RunGC(interval time.Duration, stopChan <-chan struct{}) {
+	klog.Infof("start cni garbage collector with interval %s", interval)
+	wait.Until(func() {
+		if err := s.GarbageCollect(context.Background()); err != nil {
+			klog.Errorf("cni garbage collect: %s", err)
+		}
+	}, interval, stopChan)
+}
+
+// GarbageCollect deletes ovs ports of pods no longer running on the node, or whose veth
+// has gone with the netns, including ports left without external ids. The addresses of host-local ipam not held by any pod port would
+// be released, everoute ipam collects its garbage itself.
+func (s *CNIServer) GarbageCollect(ctx context.Context) error {
+	// cni requests are not handled during garbage collection, so that all
+	// addresses allocated have their ports created.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var pods corev1.PodList
+	if err := s.k8sReader.List(ctx, &pods, client.MatchingFields{"spec.nodeName": s.nodeName}); err != nil {
+		return err
+	}
+	podUUIDs := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != s.nodeName || pod.Spec.HostNetwork {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		podUUIDs[podUUIDOf(pod.Namespace, pod.Name)] = true
+	}
+
+	interfaces, err := listPodInterfaces(s.datapathManager.AgentInfo.BridgeName)
+	if err != nil {
+		return fmt.Errorf("list ovs interfaces: %s", err)
+	}
+
+	livePorts := make(map[string]bool)
+	liveContainers := make(map[string]bool)
+	for _, iface := range interfaces {
+		if podUUIDs[podUUIDOfInterface(iface.ExternalIDs)] && vethExists(iface.Name) {
+			livePorts[iface.Name] = true
+			liveContainers[iface.ExternalIDs[containerIDExternalID]] = true
+			continue
+		}
+		// secondary interfaces are on local bridge of their vds
+		ovsDriver := s.ovsDriver
+		if vds, ok := iface.ExternalIDs[vdsExternalID]; ok {
+			if ovsDriver = s.datapathManager.LocalBridgeOvsdbDriver(vds); ovsDriver == nil {
+				klog.Errorf("skip stale ovs port %s of vds %s not managed", iface.Name, vds)
+				continue
+			}
+		}
+		klog.Infof("delete stale ovs port %s of pod %s", iface.Name, podUUIDOfInterface(iface.ExternalIDs))
+		if err = datapath.SetInterfaceBandwidth(iface.Name, nil); err != nil {
+			return fmt.Errorf("clear bandwidth of ovs port %s: %s", iface.Name, err)
+		}
+		if err = ovsDriver.DeletePort(iface.Name); err != nil {
+			return fmt.Errorf("delete ovs port %s: %s", iface.Name, err)
+		}
+	}
+
+	// qos of ports deleted by others are left
+	if err = datapath.CleanOrphanBandwidth(); err != nil {
+		return fmt.Errorf("clean orphan bandwidth qos: %s", err)
+	}
+
+	if s.ipam != nil {
+		return nil
+	}
+	return s.releaseStaleAddresses(func(containerID string) bool {
+		// ports created before container-id external id added are named after the container id
+		return liveContainers[containerID] || livePorts["_"+containerID[:vethNameLength]]
+	})
+}
+
+// releaseStaleAddresses releases addresses of host-local ipam in pod cidrs, whose container is not alive.
+func (s *CNIServer) releaseStaleAddresses(alive func(containerID string) bool) error {
+	networks, err := ioutil.ReadDir(hostLocalDataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, network := range networks {
+		if !network.IsDir() {
+			continue
+		}
+		if err = s.releaseNetworkStaleAddresses(network.Name(), alive); err != nil {
+			return fmt.Errorf("release addresses of network %s: %s", network.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *CNIServer) releaseNetworkStaleAddresses(network string, alive func(containerID string) bool) error {
+	store, err := disk.New(network, hostLocalDataDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err = store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock()
+
+	files, err := ioutil.ReadDir(filepath.Join(hostLocalDataDir, network))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		addr := net.ParseIP(file.Name())
+		if addr == nil || !s.inPodCIDR(addr) {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(hostLocalDataDir, network, file.Name()))
+		if err != nil {
+			return err
+		}
+		containerID := strings.TrimSpace(strings.SplitN(string(content), disk.LineBreak, 2)[0])
+		if len(containerID) < vethNameLength || alive(containerID) {
+			continue
+		}
+		klog.Infof("release stale address %s of container %s from network %s", addr, containerID, network)
+		if err = store.Release(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *CNIServer) inPodCIDR(addr net.IP) bool {
+	for _, item := range s.podCIDR {
+		if (*net.IPNet)(&item).Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// podUUIDOf returns pod-uuid external id of the pod.
+func podUUIDOf(namespace, name string) string {
+	return utils.EncodeNamespacedName(coretypes.NamespacedName{
+		Name:      "pod-" + name,
+		Namespace: namespace,
+	})
+}
+
+// vethExists returns false only if the host veth has been confirmed removed.
+func vethExists(name string) bool {
+	_, err := netlink.LinkByName(name)
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		return false
+	}
+	return true
+}
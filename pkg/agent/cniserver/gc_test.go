@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test garbage collect", func() {
+	It("Test ovsdbMapOf", func() {
+		Expect(ovsdbMapOf([]interface{}{"map", []interface{}{
+			[]interface{}{podUUIDExternalID, "uuid"},
+			[]interface{}{containerIDExternalID, "id"},
+		}})).Should(Equal(map[string]string{podUUIDExternalID: "uuid", containerIDExternalID: "id"}))
+		Expect(ovsdbMapOf([]interface{}{"map", []interface{}{}})).Should(BeEmpty())
+		Expect(ovsdbMapOf("invalid")).Should(BeEmpty())
+	})
+
+	It("Test ovsdbUUIDsOf", func() {
+		Expect(ovsdbUUIDsOf([]interface{}{"uuid", "uuid01"})).Should(Equal([]string{"uuid01"}))
+		Expect(ovsdbUUIDsOf([]interface{}{"set", []interface{}{
+			[]interface{}{"uuid", "uuid01"},
+			[]interface{}{"uuid", "uuid02"},
+		}})).Should(Equal([]string{"uuid01", "uuid02"}))
+		Expect(ovsdbUUIDsOf([]interface{}{"set", []interface{}{}})).Should(BeEmpty())
+		Expect(ovsdbUUIDsOf("invalid")).Should(BeEmpty())
+	})
+
+	It("Test isOrphanPort", func() {
+		podBridgePorts := map[string]bool{"_0123456789ab": true, "gw0": true}
+		Expect(isOrphanPort("_0123456789ab", map[string]string{}, podBridgePorts)).Should(BeTrue())
+		Expect(isOrphanPort("_0123456789ab", map[string]string{attachedMacExternalID: "mac"}, podBridgePorts)).Should(BeFalse())
+		Expect(isOrphanPort("_ba9876543210", map[string]string{}, podBridgePorts)).Should(BeFalse())
+		Expect(isOrphanPort("gw0", map[string]string{}, podBridgePorts)).Should(BeFalse())
+	})
+
+	It("Test release stale addresses", func() {
+		dataDir, err := ioutil.TempDir("", "everoute-cni")
+		Expect(err).Should(Succeed())
+		defer os.RemoveAll(dataDir)
+		defaultDataDir := hostLocalDataDir
+		hostLocalDataDir = dataDir
+		defer func() { hostLocalDataDir = defaultDataDir }()
+
+		_, podCIDR, _ := net.ParseCIDR("10.0.0.0/24")
+		s := &CNIServer{podCIDR: []types.IPNet{types.IPNet(*podCIDR)}}
+
+		networkDir := filepath.Join(dataDir, "everoute")
+		Expect(os.MkdirAll(networkDir, 0755)).Should(Succeed())
+		allocations := map[string]string{
+			"10.0.0.2": "aliveaaaaaaaaaaaaaaa\r\neth0",
+			"10.0.0.3": "staleaaaaaaaaaaaaaaa\r\neth0",
+			"10.0.1.3": "outofcidraaaaaaaaaaa\r\neth0",
+		}
+		for addr, containerID := range allocations {
+			Expect(ioutil.WriteFile(filepath.Join(networkDir, addr), []byte(containerID), 0644)).Should(Succeed())
+		}
+
+		Expect(s.releaseStaleAddresses(func(containerID string) bool {
+			return containerID == "aliveaaaaaaaaaaaaaaa"
+		})).Should(Succeed())
+		Expect(filepath.Join(networkDir, "10.0.0.2")).Should(BeAnExistingFile())
+		Expect(filepath.Join(networkDir, "10.0.0.3")).ShouldNot(BeAnExistingFile())
+		Expect(filepath.Join(networkDir, "10.0.1.3")).Should(BeAnExistingFile())
+	})
+})
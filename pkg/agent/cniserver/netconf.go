@@ -0,0 +1,211 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+)
+
+const (
+	// VethNameSchemeContainerID names host veth with prefix of the container id, the default scheme.
+	VethNameSchemeContainerID = "container-id"
+	// VethNameSchemePod names host veth with hash of pod namespace and name, stays the same when
+	// the pod sandbox recreated.
+	VethNameSchemePod = "pod"
+
+	vethNameLength = 12
+	// max vlan id of access port
+	maxVLAN = 4094
+	// tx checksum offload feature of veth
+	txChecksumFeature = "tx-checksum-ip-generic"
+)
+
+// NetConf is the network config of everoute cni plugin.
+type NetConf struct {
+	cnitypes.NetConf
+
+	// MTU of pod interfaces, default is mtu of the uplink minus encapsulation overhead.
+	MTU int `json:"mtu,omitempty"`
+	// TxChecksumOffload sets tx checksum offload of pod interfaces, left unchanged if not set.
+	TxChecksumOffload *bool `json:"txChecksumOffload,omitempty"`
+	// VethNameScheme is how host side veth named, "container-id" or "pod", default "container-id".
+	VethNameScheme string `json:"vethNameScheme,omitempty"`
+	// Gateways replace gateway of pod default routes, at most one for each ip family. The gateway
+	// must be in pod cidr of the node, and would be added to the gateway interface.
+	Gateways []string `json:"gateways,omitempty"`
+
+	// VDS is the managed vds which the pod secondary interface attached to, the config is of a
+	// secondary network attachment if set. The address of the interface is allocated by the ipam
+	// plugin in the config, left unconfigured if the ipam not set.
+	VDS string `json:"vds,omitempty"`
+	// VLAN is the access vlan of the pod secondary interface on the vds.
+	VLAN int `json:"vlan,omitempty"`
+
+	// RuntimeConfig is set by runtime with capabilities of the plugin.
+	RuntimeConfig struct {
+		Bandwidth *BandwidthEntry `json:"bandwidth,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// BandwidthEntry is the bandwidth capability args, rates are in bits per second and bursts are in bits.
+type BandwidthEntry struct {
+	IngressRate  int `json:"ingressRate"`
+	IngressBurst int `json:"ingressBurst"`
+	EgressRate   int `json:"egressRate"`
+	EgressBurst  int `json:"egressBurst"`
+}
+
+// validate checks the net config against pod cidrs of the node.
+func (c *NetConf) validate(podCIDR []cnitypes.IPNet) error {
+	if c.MTU < 0 {
+		return fmt.Errorf("invalid mtu %d", c.MTU)
+	}
+
+	switch c.VethNameScheme {
+	case "", VethNameSchemeContainerID, VethNameSchemePod:
+	default:
+		return fmt.Errorf("unknown veth name scheme %s", c.VethNameScheme)
+	}
+
+	if c.VLAN < 0 || c.VLAN > maxVLAN {
+		return fmt.Errorf("invalid vlan %d", c.VLAN)
+	}
+	if c.VDS == "" && c.VLAN != 0 {
+		return fmt.Errorf("vlan only allowed on secondary network with vds")
+	}
+	if c.VDS != "" && len(c.Gateways) != 0 {
+		return fmt.Errorf("gateways not allowed on secondary network with vds")
+	}
+
+	families := make(map[bool]bool)
+	for _, item := range c.Gateways {
+		gateway := net.ParseIP(item)
+		if gateway == nil {
+			return fmt.Errorf("invalid gateway %s", item)
+		}
+		ipv6 := gateway.To4() == nil
+		if families[ipv6] {
+			return fmt.Errorf("more than one gateway of the ip family of %s", item)
+		}
+		families[ipv6] = true
+
+		var inPodCIDR bool
+		for i := range podCIDR {
+			inPodCIDR = inPodCIDR || (*net.IPNet)(&podCIDR[i]).Contains(gateway)
+		}
+		if !inPodCIDR {
+			return fmt.Errorf("gateway %s not in pod cidr %v", item, podCIDR)
+		}
+	}
+
+	return nil
+}
+
+// gatewayOf returns gateway of the ip family in the config, nil if not set.
+func (c *NetConf) gatewayOf(ipv6 bool) net.IP {
+	for _, item := range c.Gateways {
+		if gateway := net.ParseIP(item); gateway != nil && (gateway.To4() == nil) == ipv6 {
+			return gateway
+		}
+	}
+	return nil
+}
+
+// bandwidth returns bandwidth limit of the bandwidth capability args, nil if not set.
+func (c *NetConf) bandwidth() *datapath.Bandwidth {
+	entry := c.RuntimeConfig.Bandwidth
+	if entry == nil || (entry.IngressRate <= 0 && entry.EgressRate <= 0) {
+		return nil
+	}
+	return datapath.NewBandwidth(int64(entry.IngressRate), int64(entry.IngressBurst),
+		int64(entry.EgressRate), int64(entry.EgressBurst))
+}
+
+// vethName returns name of the host side veth, which is also the ovs port name. Name of the secondary
+// interface is hashed with the interface name in the pod.
+func (c *NetConf) vethName(containerID, podNamespace, podName, ifname string) string {
+	if c.VethNameScheme != VethNameSchemePod && c.VDS == "" {
+		return "_" + containerID[:vethNameLength]
+	}
+	key := containerID
+	if c.VethNameScheme == VethNameSchemePod {
+		key = podNamespace + "/" + podName
+	}
+	if c.VDS != "" {
+		key += "/" + ifname
+	}
+	hash := sha256.Sum256([]byte(key))
+	return "_" + hex.EncodeToString(hash[:])[:vethNameLength]
+}
+
+// defaultRouteLinkMTU returns mtu of the link of ipv4 or ipv6 default route.
+func defaultRouteLinkMTU() (int, error) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteList(nil, family)
+		if err != nil {
+			return 0, err
+		}
+		for _, route := range routes {
+			if route.Dst != nil && !route.Dst.IP.IsUnspecified() {
+				continue
+			}
+			link, err := netlink.LinkByIndex(route.LinkIndex)
+			if err != nil {
+				return 0, err
+			}
+			return link.Attrs().MTU, nil
+		}
+	}
+	return 0, fmt.Errorf("default route not found")
+}
+
+// setTxChecksumOffload enables or disables tx checksum offload of the interface.
+func setTxChecksumOffload(ifname string, enable bool) error {
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	return e.Change(ifname, map[string]bool{txChecksumFeature: enable})
+}
+
+// getTxChecksumOffload returns whether tx checksum offload of the interface enabled.
+func getTxChecksumOffload(ifname string) (bool, error) {
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return false, err
+	}
+	defer e.Close()
+	features, err := e.Features(ifname)
+	if err != nil {
+		return false, err
+	}
+	enabled, ok := features[txChecksumFeature]
+	if !ok {
+		return false, fmt.Errorf("unsupported feature %s of %s", txChecksumFeature, ifname)
+	}
+	return enabled, nil
+}
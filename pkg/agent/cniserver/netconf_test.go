@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	"net"
+
+	"github.com/containernetworking/cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	cnipb "github.com/everoute/everoute/pkg/apis/cni/v1alpha1"
+)
+
+var _ = Describe("Test netconf", func() {
+	_, v4CIDR, _ := net.ParseCIDR("10.0.0.0/24")
+	_, v6CIDR, _ := net.ParseCIDR("fd00::/64")
+	podCIDR := []types.IPNet{types.IPNet(*v4CIDR), types.IPNet(*v6CIDR)}
+
+	It("Test Parse NetConf", func() {
+		s := &CNIServer{}
+		request := &cnipb.CniRequest{
+			Args: "K8S_POD_NAME=123;K8S_POD_NAMESPACE=456",
+			Stdin: []byte(`{"cniVersion": "1.0.0", "name": "test", "mtu": 1400, "txChecksumOffload": false, ` +
+				`"vethNameScheme": "pod", "gateways": ["10.0.0.254"]}`),
+		}
+		conf, _, err := s.ParseConf(request)
+		Expect(err).Should(Succeed())
+		Expect(conf.CNIVersion).Should(Equal("1.0.0"))
+		Expect(conf.MTU).Should(Equal(1400))
+		Expect(conf.TxChecksumOffload).ShouldNot(BeNil())
+		Expect(*conf.TxChecksumOffload).Should(BeFalse())
+		Expect(conf.VethNameScheme).Should(Equal(VethNameSchemePod))
+		Expect(conf.Gateways).Should(Equal([]string{"10.0.0.254"}))
+		Expect(s.podMTU(conf)).Should(Equal(1400))
+	})
+
+	It("Test validate", func() {
+		Expect((&NetConf{}).validate(podCIDR)).Should(Succeed())
+		Expect((&NetConf{Gateways: []string{"10.0.0.254", "fd00::fe"}}).validate(podCIDR)).Should(Succeed())
+
+		Expect((&NetConf{MTU: -1}).validate(podCIDR)).ShouldNot(Succeed())
+		Expect((&NetConf{VethNameScheme: "unknown"}).validate(podCIDR)).ShouldNot(Succeed())
+		Expect((&NetConf{Gateways: []string{"invalid"}}).validate(podCIDR)).ShouldNot(Succeed())
+		Expect((&NetConf{Gateways: []string{"10.0.1.1"}}).validate(podCIDR)).ShouldNot(Succeed())
+		Expect((&NetConf{Gateways: []string{"10.0.0.1", "10.0.0.2"}}).validate(podCIDR)).ShouldNot(Succeed())
+
+		Expect((&NetConf{VDS: "vds1", VLAN: 100}).validate(podCIDR)).Should(Succeed())
+		Expect((&NetConf{VDS: "vds1", VLAN: 4095}).validate(podCIDR)).ShouldNot(Succeed())
+		Expect((&NetConf{VLAN: 100}).validate(podCIDR)).ShouldNot(Succeed())
+		Expect((&NetConf{VDS: "vds1", Gateways: []string{"10.0.0.254"}}).validate(podCIDR)).ShouldNot(Succeed())
+	})
+
+	It("Test gatewayOf", func() {
+		conf := &NetConf{Gateways: []string{"fd00::fe"}}
+		Expect(conf.gatewayOf(false)).Should(BeNil())
+		Expect(conf.gatewayOf(true)).Should(Equal(net.ParseIP("fd00::fe")))
+	})
+
+	It("Test bandwidth", func() {
+		s := &CNIServer{}
+		request := &cnipb.CniRequest{
+			Args: "K8S_POD_NAME=123;K8S_POD_NAMESPACE=456",
+			Stdin: []byte(`{"cniVersion": "1.0.0", "name": "test", "runtimeConfig": {"bandwidth": ` +
+				`{"ingressRate": 1000000, "ingressBurst": 200000, "egressRate": 2000000, "egressBurst": -1}}}`),
+		}
+		conf, _, err := s.ParseConf(request)
+		Expect(err).Should(Succeed())
+		Expect(conf.bandwidth()).Should(Equal(&datapath.Bandwidth{IngressRate: 1000000, IngressBurst: 200000, EgressRate: 2000000}))
+
+		Expect((&NetConf{}).bandwidth()).Should(BeNil())
+		conf.RuntimeConfig.Bandwidth = &BandwidthEntry{IngressBurst: 200000}
+		Expect(conf.bandwidth()).Should(BeNil())
+	})
+
+	It("Test vethName", func() {
+		containerID := "0123456789abcdef"
+		Expect((&NetConf{}).vethName(containerID, "ns", "pod", "eth0")).Should(Equal("_0123456789ab"))
+
+		conf := &NetConf{VethNameScheme: VethNameSchemePod}
+		name := conf.vethName(containerID, "ns", "pod", "eth0")
+		Expect(name).Should(HaveLen(vethNameLength + 1))
+		Expect(conf.vethName("fedcba9876543210", "ns", "pod", "eth0")).Should(Equal(name))
+		Expect(conf.vethName(containerID, "ns", "pod-1", "eth0")).ShouldNot(Equal(name))
+
+		secondary := &NetConf{VDS: "vds1"}
+		name = secondary.vethName(containerID, "ns", "pod", "net1")
+		Expect(name).Should(HaveLen(vethNameLength + 1))
+		Expect(name).ShouldNot(Equal("_0123456789ab"))
+		Expect(secondary.vethName(containerID, "ns", "pod", "net2")).ShouldNot(Equal(name))
+		secondary.VethNameScheme = VethNameSchemePod
+		Expect(secondary.vethName(containerID, "ns", "pod", "net1")).Should(Equal(secondary.vethName("fedcba9876543210", "ns", "pod", "net1")))
+	})
+})
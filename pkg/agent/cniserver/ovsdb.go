@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	"fmt"
+	"strings"
+
+	ovsdb "github.com/contiv/libovsdb"
+)
+
+const (
+	// external ids set on ovs interfaces of pods
+	attachedMacExternalID  = "attached-mac"
+	podUUIDExternalID      = "pod-uuid"
+	containerIDExternalID  = "container-id"
+	ifaceIDExternalID      = "iface-id"
+	ownerPodUUIDExternalID = "owner-pod-uuid"
+	vdsExternalID          = "vds"
+)
+
+// podInterface is an ovs interface created for pod by cni server.
+type podInterface struct {
+	Name        string
+	ExternalIDs map[string]string
+}
+
+// listPodInterfaces returns ovs interfaces with pod-uuid external id, and secondary interfaces with
+// owner-pod-uuid external id. Ports of pod bridge without external ids, whose host veth has gone,
+// are also returned, they may be left by cni add failed before external ids set. Empty podBridge
+// skips these ports.
+func listPodInterfaces(podBridge string) ([]podInterface, error) {
+	client, err := ovsdb.ConnectUnix(ovsdb.DEFAULT_SOCK)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect()
+
+	results, err := client.Transact("Open_vSwitch", ovsdb.Operation{
+		Op:      "select",
+		Table:   "Interface",
+		Where:   []interface{}{ovsdb.NewCondition("name", "!=", "")},
+		Columns: []string{"name", "external_ids"},
+	}, ovsdb.Operation{
+		Op:      "select",
+		Table:   "Bridge",
+		Where:   []interface{}{ovsdb.NewCondition("name", "==", podBridge)},
+		Columns: []string{"ports"},
+	}, ovsdb.Operation{
+		Op:      "select",
+		Table:   "Port",
+		Where:   []interface{}{ovsdb.NewCondition("name", "!=", "")},
+		Columns: []string{"_uuid", "name"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 3 {
+		return nil, fmt.Errorf("select ovs interfaces: %+v", results)
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			return nil, fmt.Errorf("select ovs interfaces: %+v", results)
+		}
+	}
+
+	podBridgePorts := make(map[string]bool)
+	if podBridge != "" && len(results[1].Rows) != 0 {
+		portUUIDs := make(map[string]bool)
+		for _, uuid := range ovsdbUUIDsOf(results[1].Rows[0]["ports"]) {
+			portUUIDs[uuid] = true
+		}
+		for _, row := range results[2].Rows {
+			name, _ := row["name"].(string)
+			uuids := ovsdbUUIDsOf(row["_uuid"])
+			if len(uuids) == 1 && portUUIDs[uuids[0]] {
+				podBridgePorts[name] = true
+			}
+		}
+	}
+
+	var interfaces []podInterface
+	for _, row := range results[0].Rows {
+		name, _ := row["name"].(string)
+		externalIDs := ovsdbMapOf(row["external_ids"])
+		if podUUIDOfInterface(externalIDs) == "" && !isOrphanPort(name, externalIDs, podBridgePorts) {
+			continue
+		}
+		interfaces = append(interfaces, podInterface{Name: name, ExternalIDs: externalIDs})
+	}
+	return interfaces, nil
+}
+
+// isOrphanPort returns true if the port is a pod port of pod bridge without external ids, and its host
+// veth has gone.
+func isOrphanPort(name string, externalIDs map[string]string, podBridgePorts map[string]bool) bool {
+	return strings.HasPrefix(name, "_") && len(externalIDs) == 0 && podBridgePorts[name] && !vethExists(name)
+}
+
+// podUUIDOfInterface returns pod-uuid of the pod which the interface belongs to.
+func podUUIDOfInterface(externalIDs map[string]string) string {
+	if podUUID, ok := externalIDs[ownerPodUUIDExternalID]; ok {
+		return podUUID
+	}
+	return externalIDs[podUUIDExternalID]
+}
+
+// getPodInterface returns the pod interface of the name, nil if not found.
+func getPodInterface(name string) (*podInterface, error) {
+	interfaces, err := listPodInterfaces("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range interfaces {
+		if interfaces[i].Name == name {
+			return &interfaces[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ovsdbUUIDsOf converts ovsdb uuid ["uuid", uuid] or uuid set ["set", [["uuid", uuid], ...]] in json
+// notation to uuid strings.
+func ovsdbUUIDsOf(value interface{}) []string {
+	notation, ok := value.([]interface{})
+	if !ok || len(notation) != 2 {
+		return nil
+	}
+	switch notation[0] {
+	case "uuid":
+		uuid, _ := notation[1].(string)
+		return []string{uuid}
+	case "set":
+		var uuids []string
+		items, _ := notation[1].([]interface{})
+		for _, item := range items {
+			uuids = append(uuids, ovsdbUUIDsOf(item)...)
+		}
+		return uuids
+	default:
+		return nil
+	}
+}
+
+// ovsdbMapOf converts ovsdb map in json notation ["map", [[key, value], ...]] to go map.
+func ovsdbMapOf(value interface{}) map[string]string {
+	m := make(map[string]string)
+	notation, ok := value.([]interface{})
+	if !ok || len(notation) != 2 || notation[0] != "map" {
+		return m
+	}
+	pairs, _ := notation[1].([]interface{})
+	for _, item := range pairs {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		k, _ := pair[0].(string)
+		v, _ := pair[1].(string)
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/contiv/ofnet/ovsdbDriver"
+	corev1 "k8s.io/api/core/v1"
+	coretypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cnipb "github.com/everoute/everoute/pkg/apis/cni/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/types"
+	"github.com/everoute/everoute/pkg/utils"
+)
+
+// default mtu of pod secondary interfaces if not set in net config
+const defaultSecondaryMTU = 1500
+
+// ovsDriverOf returns ovsdb driver of the bridge which the pod interface attached to, local bridge of
+// the vds for secondary interfaces.
+func (s *CNIServer) ovsDriverOf(conf *NetConf) (*ovsdbDriver.OvsDriver, error) {
+	if conf.VDS == "" {
+		return s.ovsDriver, nil
+	}
+	if conf.VDS == s.datapathManager.AgentInfo.BridgeName {
+		return nil, fmt.Errorf("vds %s is the pod network", conf.VDS)
+	}
+	driver := s.datapathManager.LocalBridgeOvsdbDriver(conf.VDS)
+	if driver == nil {
+		return nil, fmt.Errorf("vds %s not managed", conf.VDS)
+	}
+	return driver, nil
+}
+
+// interfaceExternalIDs returns external ids of the ovs interface of the pod. The secondary interface is
+// referenced by its own endpoint with iface-id, and owned by the pod with owner-pod-uuid.
+func interfaceExternalIDs(request *cnipb.CniRequest, args *CNIArgs, conf *NetConf, mac string) map[string]string {
+	externalIDs := map[string]string{
+		attachedMacExternalID: mac,
+		containerIDExternalID: request.ContainerId,
+	}
+	podUUID := podUUIDOf(string(args.K8S_POD_NAMESPACE), string(args.K8S_POD_NAME))
+	if conf.VDS == "" {
+		externalIDs[podUUIDExternalID] = podUUID
+		return externalIDs
+	}
+	externalIDs[ownerPodUUIDExternalID] = podUUID
+	externalIDs[ifaceIDExternalID] = utils.EncodeNamespacedName(coretypes.NamespacedName{
+		Namespace: string(args.K8S_POD_NAMESPACE),
+		Name:      utils.SecondaryEndpointName(string(args.K8S_POD_NAME), request.Ifname),
+	})
+	externalIDs[vdsExternalID] = conf.VDS
+	return externalIDs
+}
+
+// recordPodInterface records the secondary interface in annotation of the pod, the pod controller would
+// create an endpoint for each interface recorded. Nil podInterface removes the interface of ifname.
+func (s *CNIServer) recordPodInterface(ctx context.Context, args *CNIArgs, ifname string, podInterface *types.PodInterface) error {
+	var pod corev1.Pod
+	podKey := coretypes.NamespacedName{Namespace: string(args.K8S_POD_NAMESPACE), Name: string(args.K8S_POD_NAME)}
+	if err := s.k8sReader.Get(ctx, podKey, &pod); err != nil {
+		return err
+	}
+	interfaces, err := utils.PodInterfacesFromAnnotations(pod.Annotations)
+	if err != nil {
+		return err
+	}
+
+	var newInterfaces []types.PodInterface
+	for _, item := range interfaces {
+		if item.Interface != ifname {
+			newInterfaces = append(newInterfaces, item)
+		}
+	}
+	if podInterface != nil {
+		newInterfaces = append(newInterfaces, *podInterface)
+	}
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	if len(newInterfaces) == 0 {
+		delete(pod.Annotations, constants.PodInterfacesAnnotation)
+	} else {
+		pod.Annotations[constants.PodInterfacesAnnotation] = utils.PodInterfacesAnnotationValue(newInterfaces)
+	}
+	return s.k8sClient.Patch(ctx, &pod, patch)
+}
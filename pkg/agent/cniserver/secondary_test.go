@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cniserver
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	coretypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	cnipb "github.com/everoute/everoute/pkg/apis/cni/v1alpha1"
+	"github.com/everoute/everoute/pkg/utils"
+)
+
+var _ = Describe("Test secondary interface", func() {
+	request := &cnipb.CniRequest{ContainerId: "0123456789abcdef", Ifname: "net1"}
+	args := &CNIArgs{K8S_POD_NAMESPACE: "ns", K8S_POD_NAME: "pod"}
+
+	It("Test ovsDriverOf", func() {
+		s := &CNIServer{datapathManager: &datapath.DpManager{AgentInfo: &datapath.AgentConf{BridgeName: "cnibr0"}}}
+
+		driver, err := s.ovsDriverOf(&NetConf{})
+		Expect(err).Should(Succeed())
+		Expect(driver).Should(BeNil())
+		_, err = s.ovsDriverOf(&NetConf{VDS: "cnibr0"})
+		Expect(err).ShouldNot(Succeed())
+		_, err = s.ovsDriverOf(&NetConf{VDS: "vds1"})
+		Expect(err).ShouldNot(Succeed())
+	})
+
+	It("Test interfaceExternalIDs", func() {
+		podUUID := podUUIDOf("ns", "pod")
+		externalIDs := interfaceExternalIDs(request, args, &NetConf{}, "00:00:aa:aa:aa:aa")
+		Expect(externalIDs).Should(Equal(map[string]string{
+			attachedMacExternalID: "00:00:aa:aa:aa:aa",
+			containerIDExternalID: "0123456789abcdef",
+			podUUIDExternalID:     podUUID,
+		}))
+		Expect(podUUIDOfInterface(externalIDs)).Should(Equal(podUUID))
+
+		externalIDs = interfaceExternalIDs(request, args, &NetConf{VDS: "vds1", VLAN: 100}, "00:00:aa:aa:aa:aa")
+		Expect(externalIDs).Should(Equal(map[string]string{
+			attachedMacExternalID:  "00:00:aa:aa:aa:aa",
+			containerIDExternalID:  "0123456789abcdef",
+			ownerPodUUIDExternalID: podUUID,
+			ifaceIDExternalID:      utils.EncodeNamespacedName(coretypes.NamespacedName{Namespace: "ns", Name: "podnet-pod-net1"}),
+			vdsExternalID:          "vds1",
+		}))
+		Expect(podUUIDOfInterface(externalIDs)).Should(Equal(podUUID))
+	})
+})
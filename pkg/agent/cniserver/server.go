@@ -20,12 +20,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"sync"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	cniv1 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
@@ -34,23 +36,35 @@ import (
 	"github.com/j-keck/arping"
 	"github.com/vishvananda/netlink"
 	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
 	coretypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/everoute/everoute/pkg/agent/datapath"
+	ipamallocator "github.com/everoute/everoute/pkg/agent/ipam"
 	cnipb "github.com/everoute/everoute/pkg/apis/cni/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
 	"github.com/everoute/everoute/pkg/utils"
 )
 
 const CNISocketAddr = "/var/run/everoute/cni.sock"
 
 type CNIServer struct {
+	datapathManager *datapath.DpManager
+
 	k8sClient client.Client
+	// k8sReader reads from api server directly, pods in cache may lag behind cni requests
+	k8sReader client.Reader
+	nodeName  string
 	ovsDriver *ovsdbDriver.OvsDriver
 	gwName    string
 	brName    string
 	podCIDR   []cnitypes.IPNet
+	// mtu of pod interfaces if not set in net config
+	mtu int
+	// ipam allocates addresses from IPPools, host-local ipam would be used if nil
+	ipam *ipamallocator.Allocator
 
 	mutex sync.Mutex
 }
@@ -62,9 +76,9 @@ type CNIArgs struct {
 	K8S_POD_INFRA_CONTAINER_ID cnitypes.UnmarshallableString //nolint
 }
 
-func (s *CNIServer) ParseConf(request *cnipb.CniRequest) (*cnitypes.NetConf, *CNIArgs, error) {
+func (s *CNIServer) ParseConf(request *cnipb.CniRequest) (*NetConf, *CNIArgs, error) {
 	// parse request Stdin
-	conf := &cnitypes.NetConf{}
+	conf := &NetConf{}
 	err := json.Unmarshal(request.Stdin, &conf)
 	if err != nil {
 		return nil, nil, err
@@ -100,7 +114,7 @@ func (s *CNIServer) ParseResult(result *cniv1.Result) (*cnipb.CniResponse, error
 	}, nil
 }
 
-func (s *CNIServer) CmdAdd(ctx context.Context, request *cnipb.CniRequest) (*cnipb.CniResponse, error) {
+func (s *CNIServer) CmdAdd(ctx context.Context, request *cnipb.CniRequest) (resp *cnipb.CniResponse, err error) {
 	klog.Infof("Create new pod %s", request)
 
 	s.mutex.Lock()
@@ -111,47 +125,91 @@ func (s *CNIServer) CmdAdd(ctx context.Context, request *cnipb.CniRequest) (*cni
 		klog.Errorf("Parse request conf error, err: %s", err)
 		return s.RetError(cnipb.ErrorCode_DECODING_FAILURE, "Parse request conf error", err)
 	}
+	if err = conf.validate(s.podCIDR); err != nil {
+		klog.Errorf("Invalid network config, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_INVALID_NETWORK_CONFIG, "invalid network config", err)
+	}
+
+	ovsDriver, err := s.ovsDriverOf(conf)
+	if err != nil {
+		klog.Errorf("Invalid network config, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_INVALID_NETWORK_CONFIG, "invalid network config", err)
+	}
 
 	// require ipam for a new ip address
-	SetEnv(request)
-	r, err := ipam.ExecAdd("host-local", s.GetIpamConfByte(conf))
+	ipamResult, err := s.allocateIP(ctx, request, conf, args)
 	if err != nil {
 		klog.Errorf("could not allocate ip address, err: %s", err)
 		return s.RetError(cnipb.ErrorCode_INVALID_NETWORK_CONFIG, "could not allocate ip address", err)
 	}
-	ipamResult, err := cniv1.NewResultFromResult(r)
-	if err != nil {
-		klog.Errorf("could not convert result, err: %s", err)
-		return s.RetError(cnipb.ErrorCode_DECODING_FAILURE, "could not convert result", err)
-	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// release the allocation on failure, or it would be held until gc
+		if releaseErr := s.releaseIP(ctx, request, conf); releaseErr != nil {
+			klog.Errorf("release ip address of container %s error, err: %s", request.ContainerId, releaseErr)
+		}
+	}()
 
 	// create cni result structure
 	result := &cniv1.Result{
 		CNIVersion: conf.CNIVersion,
 		IPs:        ipamResult.IPs,
-		Routes: []*cnitypes.Route{{
-			Dst: net.IPNet{
-				IP:   net.IPv4zero,
-				Mask: net.IPMask(net.IPv4zero)},
-			GW: ipamResult.IPs[0].Gateway}},
 		Interfaces: []*cniv1.Interface{{
 			Name:    request.Ifname,
 			Sandbox: request.Netns}},
 	}
-	// set the correspondence between interface and ip address
-	result.IPs[0].Interface = cniv1.Int(0)
+	// routes of secondary interface are from its ipam
+	if conf.VDS != "" {
+		result.Routes = ipamResult.Routes
+	}
+	for _, ipConfig := range result.IPs {
+		// set the correspondence between interface and ip address
+		ipConfig.Interface = cniv1.Int(0)
+		if conf.VDS != "" {
+			continue
+		}
+		// gateway in net config replaces the one from ipam
+		gateway := conf.gatewayOf(ipConfig.Address.IP.To4() == nil)
+		if gateway == nil && s.ipam != nil {
+			// gateway of ippool may not be the node gateway address
+			gateway = ipConfig.Gateway
+		}
+		if gateway != nil {
+			if err = s.ensureGatewayAddr(gateway); err != nil {
+				klog.Errorf("add gateway %s to %s error, err: %s", gateway, s.gwName, err)
+				return s.RetError(cnipb.ErrorCode_IO_FAILURE, "add gateway address error", err)
+			}
+			ipConfig.Gateway = gateway
+		}
+		// default route of each ip family
+		result.Routes = append(result.Routes, &cnitypes.Route{
+			Dst: defaultRouteDst(ipConfig.Address.IP),
+			GW:  ipConfig.Gateway,
+		})
+	}
 
 	nsPath := "/host" + request.Netns
 	// vethName - ovs port name
-	vethName := "_" + request.ContainerId[:12]
+	vethName := conf.vethName(request.ContainerId, string(args.K8S_POD_NAMESPACE), string(args.K8S_POD_NAME), request.Ifname)
+	if err = removeStalePort(ovsDriver, vethName, request.ContainerId); err != nil {
+		klog.Errorf("remove stale port %s error, err: %s", vethName, err)
+		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "remove stale port error", err)
+	}
 	if err = ns.WithNetNSPath(nsPath, func(hostNS ns.NetNS) error {
 		// create veth pair in container NS and host NS
-		// TODO: MTU is a const variable here
-		_, containerVeth, err := ip.SetupVethWithName(request.Ifname, vethName, 1500, "", hostNS)
+		_, containerVeth, err := ip.SetupVethWithName(request.Ifname, vethName, s.podMTU(conf), "", hostNS)
 		if err != nil {
 			klog.Errorf("create veth device error, err: %s", err)
 			return err
 		}
+		if conf.TxChecksumOffload != nil {
+			if err = setTxChecksumOffload(request.Ifname, *conf.TxChecksumOffload); err != nil {
+				klog.Errorf("set tx checksum offload of %s error, err: %s", request.Ifname, err)
+				return err
+			}
+		}
 		result.Interfaces[0].Mac = containerVeth.HardwareAddr.String()
 		if err = ipam.ConfigureIface(request.Ifname, result); err != nil {
 			klog.Errorf("configure ip address in container error, err: %s", err)
@@ -162,28 +220,42 @@ func (s *CNIServer) CmdAdd(ctx context.Context, request *cnipb.CniRequest) (*cni
 		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "exec error in namespace", err)
 	}
 
-	// add the veth device to ovs bridge
-	if err = s.ovsDriver.CreatePort(vethName, "", 0); err != nil {
+	// add the veth device to ovs bridge, secondary interface is access port of its vlan
+	if err = ovsDriver.CreatePort(vethName, "", uint(conf.VLAN)); err != nil {
 		klog.Errorf("create ovs port error, vethName: %s, err: %s", vethName, err)
 		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "add port to ovs bridge error", err)
 	}
 
 	// set externalID on the interface for arp learning
-	externalID := make(map[string]string)
-	externalID["attached-mac"] = result.Interfaces[0].Mac
-	externalID["pod-uuid"] = utils.EncodeNamespacedName(coretypes.NamespacedName{
-		Name:      "pod-" + string(args.K8S_POD_NAME),
-		Namespace: string(args.K8S_POD_NAMESPACE),
-	})
-	if err = s.ovsDriver.UpdateInterface(vethName, externalID); err != nil {
+	externalID := interfaceExternalIDs(request, args, conf, result.Interfaces[0].Mac)
+	if err = ovsDriver.UpdateInterface(vethName, externalID); err != nil {
 		klog.Errorf("set externalID for %s error, err: %s", vethName, err)
 		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "set externalID for %s error", err)
 	}
 
+	// limit bandwidth of the pod interface
+	if err = datapath.SetInterfaceBandwidth(vethName, s.podBandwidth(ctx, conf, args)); err != nil {
+		klog.Errorf("set bandwidth for %s error, err: %s", vethName, err)
+		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "set bandwidth error", err)
+	}
+
+	// record secondary interface on the pod for its endpoint
+	if conf.VDS != "" {
+		podInterface := &types.PodInterface{Interface: request.Ifname, Network: conf.Name, VDS: conf.VDS, VLAN: uint16(conf.VLAN)}
+		if err = s.recordPodInterface(ctx, args, request.Ifname, podInterface); err != nil {
+			klog.Errorf("record secondary interface %s of pod error, err: %s", request.Ifname, err)
+			return s.RetError(cnipb.ErrorCode_IO_FAILURE, "record secondary interface error", err)
+		}
+	}
+
 	// broadcast arp pkg in namespace
 	// pod-endpoint may not sync when sending arp, so this part may not have effects.
 	if err = ns.WithNetNSPath(nsPath, func(hostNS ns.NetNS) error {
 		for index := range result.IPs {
+			// ipv6 neighbors learn the address from neighbor discovery
+			if result.IPs[index].Address.IP.To4() == nil {
+				continue
+			}
 			err = arping.GratuitousArpOverIfaceByName(result.IPs[index].Address.IP,
 				result.Interfaces[*result.IPs[index].Interface].Name)
 			if err != nil {
@@ -205,23 +277,51 @@ func (s *CNIServer) CmdCheck(ctx context.Context, request *cnipb.CniRequest) (*c
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	conf, _, err := s.ParseConf(request)
+	conf, args, err := s.ParseConf(request)
 	if err != nil {
 		klog.Errorf("failed to decode request, err: %s", err)
 		return s.RetError(cnipb.ErrorCode_DECODING_FAILURE, "failed to decode request", err)
 	}
+	if err = conf.validate(s.podCIDR); err != nil {
+		klog.Errorf("Invalid network config, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_INVALID_NETWORK_CONFIG, "invalid network config", err)
+	}
 
-	vethName := "_" + request.ContainerId[:12]
+	ovsDriver, err := s.ovsDriverOf(conf)
+	if err != nil {
+		klog.Errorf("Invalid network config, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_INVALID_NETWORK_CONFIG, "invalid network config", err)
+	}
+
+	vethName := conf.vethName(request.ContainerId, string(args.K8S_POD_NAMESPACE), string(args.K8S_POD_NAME), request.Ifname)
 
 	// check ovs port
-	if !s.ovsDriver.IsPortNamePresent(vethName) {
+	if !ovsDriver.IsPortNamePresent(vethName) {
+		err = fmt.Errorf("ovs port %s does not exist", vethName)
 		klog.Errorf("ovs port does not exist, err: %s", err)
 		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "ovs port does not exist", err)
 	}
 
-	// require ipam for a new ip address
-	SetEnv(request)
-	err = ipam.ExecCheck("host-local", s.GetIpamConfByte(conf))
+	prevResult, err := parsePrevResult(conf)
+	if err != nil {
+		klog.Errorf("parse prevResult error, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_DECODING_FAILURE, "parse prevResult error", err)
+	}
+
+	// check interfaces settings against the previous result
+	if err = s.checkInterface(request, conf, prevResult, vethName); err != nil {
+		klog.Errorf("interface check error, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "interface check error", err)
+	}
+
+	// check external ids of the ovs interface
+	if err = checkExternalIDs(request, args, conf, prevResult, vethName); err != nil {
+		klog.Errorf("ovs interface external ids check error, err: %s", err)
+		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "ovs interface external ids check error", err)
+	}
+
+	// check the ip address allocated
+	err = s.checkIP(ctx, request, conf)
 	if err != nil {
 		klog.Errorf("ipam check error, err: %s", err)
 		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "ipam check error", err)
@@ -236,25 +336,52 @@ func (s *CNIServer) CmdDel(ctx context.Context, request *cnipb.CniRequest) (*cni
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	conf, _, err := s.ParseConf(request)
+	conf, args, err := s.ParseConf(request)
 	if err != nil {
 		klog.Errorf("Parse request conf error, err: %s", err)
 		return s.RetError(cnipb.ErrorCode_DECODING_FAILURE, "Parse request conf error", err)
 	}
 
-	vethName := "_" + request.ContainerId[:12]
+	vethName := conf.vethName(request.ContainerId, string(args.K8S_POD_NAMESPACE), string(args.K8S_POD_NAME), request.Ifname)
+
+	// the port would be left for garbage collection if the vds removed
+	ovsDriver, err := s.ovsDriverOf(conf)
+	if err != nil {
+		klog.Errorf("skip delete ovs port %s, err: %s", vethName, err)
+	}
 
-	// delete ovs port
-	if s.ovsDriver.IsPortNamePresent(vethName) {
-		if err = s.ovsDriver.DeletePort(vethName); err != nil {
-			klog.Errorf("delete ovs port %s error, err: %s", vethName, err)
-			return s.RetError(cnipb.ErrorCode_IO_FAILURE, "delete ovs port error", err)
+	// delete ovs port, unless it has been replaced by the new sandbox of the pod
+	if ovsDriver != nil && ovsDriver.IsPortNamePresent(vethName) {
+		owner, err := portContainerID(vethName)
+		if err != nil {
+			klog.Errorf("get owner of ovs port %s error, err: %s", vethName, err)
+			return s.RetError(cnipb.ErrorCode_IO_FAILURE, "get owner of ovs port error", err)
+		}
+		switch {
+		case owner != "" && owner != request.ContainerId:
+			klog.Infof("skip delete ovs port %s owned by container %s", vethName, owner)
+		default:
+			// qos of the port would not be destroyed with the port
+			if err = datapath.SetInterfaceBandwidth(vethName, nil); err != nil {
+				klog.Errorf("clear bandwidth of %s error, err: %s", vethName, err)
+			}
+			if err = ovsDriver.DeletePort(vethName); err != nil {
+				klog.Errorf("delete ovs port %s error, err: %s", vethName, err)
+				return s.RetError(cnipb.ErrorCode_IO_FAILURE, "delete ovs port error", err)
+			}
+		}
+	}
+
+	// remove secondary interface from the pod, the pod may have been deleted
+	if conf.VDS != "" {
+		if err = s.recordPodInterface(ctx, args, request.Ifname, nil); client.IgnoreNotFound(err) != nil {
+			klog.Errorf("remove secondary interface %s of pod error, err: %s", request.Ifname, err)
+			return s.RetError(cnipb.ErrorCode_IO_FAILURE, "remove secondary interface error", err)
 		}
 	}
 
 	// release allocated IP
-	SetEnv(request)
-	if err = ipam.ExecDel("host-local", s.GetIpamConfByte(conf)); err != nil {
+	if err = s.releaseIP(ctx, request, conf); err != nil {
 		klog.Errorf("release ip error, ipam conf: %s, err: %s", conf.IPAM, err)
 		return s.RetError(cnipb.ErrorCode_IO_FAILURE, "release ip error", err)
 	}
@@ -262,6 +389,256 @@ func (s *CNIServer) CmdDel(ctx context.Context, request *cnipb.CniRequest) (*cni
 	return s.ParseResult(&cniv1.Result{CNIVersion: conf.CNIVersion})
 }
 
+// portContainerID returns the container-id external id of the ovs port, empty if not set.
+func portContainerID(vethName string) (string, error) {
+	iface, err := getPodInterface(vethName)
+	if err != nil || iface == nil {
+		return "", err
+	}
+	return iface.ExternalIDs[containerIDExternalID], nil
+}
+
+// removeStalePort removes the ovs port and host veth of the name left by another container. Sandboxes
+// of the same pod share the port name with pod veth name scheme.
+func removeStalePort(ovsDriver *ovsdbDriver.OvsDriver, vethName, containerID string) error {
+	if !ovsDriver.IsPortNamePresent(vethName) {
+		return nil
+	}
+	owner, err := portContainerID(vethName)
+	if err != nil {
+		return err
+	}
+	if owner == containerID {
+		return nil
+	}
+
+	klog.Infof("remove ovs port %s of container %s for container %s", vethName, owner, containerID)
+	if err = datapath.SetInterfaceBandwidth(vethName, nil); err != nil {
+		klog.Errorf("clear bandwidth of %s error, err: %s", vethName, err)
+	}
+	if err = ovsDriver.DeletePort(vethName); err != nil {
+		return err
+	}
+	if link, err := netlink.LinkByName(vethName); err == nil {
+		return netlink.LinkDel(link)
+	}
+	return nil
+}
+
+// podBandwidth returns bandwidth limit of the bandwidth capability args, or the bandwidth annotations
+// of the pod if the args not set on primary interface. Later changes of the annotations are applied by the agent through
+// bandwidth of the pod endpoint.
+func (s *CNIServer) podBandwidth(ctx context.Context, conf *NetConf, args *CNIArgs) *datapath.Bandwidth {
+	if bandwidth := conf.bandwidth(); bandwidth != nil || conf.VDS != "" {
+		return bandwidth
+	}
+
+	var pod corev1.Pod
+	podKey := coretypes.NamespacedName{Namespace: string(args.K8S_POD_NAMESPACE), Name: string(args.K8S_POD_NAME)}
+	if err := s.k8sReader.Get(ctx, podKey, &pod); err != nil {
+		klog.Errorf("get pod %s error, bandwidth would be set by agent later, err: %s", podKey, err)
+		return nil
+	}
+	limit, err := utils.BandwidthLimitFromAnnotations(pod.Annotations)
+	if err != nil {
+		klog.Errorf("invalid bandwidth annotations of pod %s, err: %s", podKey, err)
+		return nil
+	}
+	if limit == nil {
+		return nil
+	}
+	return &datapath.Bandwidth{IngressRate: uint64(limit.IngressRate), EgressRate: uint64(limit.EgressRate)}
+}
+
+// parsePrevResult returns the result of cni add in the check request.
+func parsePrevResult(conf *NetConf) (*cniv1.Result, error) {
+	if conf.RawPrevResult == nil {
+		return nil, fmt.Errorf("required prevResult missing")
+	}
+	if err := version.ParsePrevResult(&conf.NetConf); err != nil {
+		return nil, err
+	}
+	return cniv1.NewResultFromResult(conf.PrevResult)
+}
+
+// containerMacOf returns mac address of the container interface in the result.
+func containerMacOf(result *cniv1.Result, ifname string) string {
+	for _, iface := range result.Interfaces {
+		if iface.Name == ifname && iface.Sandbox != "" {
+			return iface.Mac
+		}
+	}
+	return ""
+}
+
+// checkExternalIDs verifies external ids of the ovs interface set in cni add.
+func checkExternalIDs(request *cnipb.CniRequest, args *CNIArgs, conf *NetConf, prevResult *cniv1.Result, vethName string) error {
+	iface, err := getPodInterface(vethName)
+	if err != nil {
+		return err
+	}
+	if iface == nil {
+		return fmt.Errorf("ovs interface %s not found", vethName)
+	}
+
+	mac := containerMacOf(prevResult, request.Ifname)
+	expectExternalIDs := interfaceExternalIDs(request, args, conf, mac)
+	if mac == "" {
+		delete(expectExternalIDs, attachedMacExternalID)
+	}
+	for key, value := range expectExternalIDs {
+		// ports created before container-id external id added
+		if key == containerIDExternalID && iface.ExternalIDs[key] == "" {
+			continue
+		}
+		if iface.ExternalIDs[key] != value {
+			return fmt.Errorf("ovs interface %s external id %s=%s, expect %s", vethName, key, iface.ExternalIDs[key], value)
+		}
+	}
+	return nil
+}
+
+// checkInterface verifies the host veth and the container interface against the prevResult and net config.
+func (s *CNIServer) checkInterface(request *cnipb.CniRequest, conf *NetConf, prevResult *cniv1.Result, vethName string) error {
+	mtu := s.podMTU(conf)
+
+	hostVeth, err := netlink.LinkByName(vethName)
+	if err != nil {
+		return fmt.Errorf("host veth %s not found: %s", vethName, err)
+	}
+	if _, ok := hostVeth.(*netlink.Veth); !ok {
+		return fmt.Errorf("host interface %s type %s is not veth", vethName, hostVeth.Type())
+	}
+	if hostVeth.Attrs().MTU != mtu {
+		return fmt.Errorf("host veth %s mtu %d, expect %d", vethName, hostVeth.Attrs().MTU, mtu)
+	}
+
+	return ns.WithNetNSPath("/host"+request.Netns, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(request.Ifname)
+		if err != nil {
+			return fmt.Errorf("container interface %s not found: %s", request.Ifname, err)
+		}
+		if link.Attrs().MTU != mtu {
+			return fmt.Errorf("container interface %s mtu %d, expect %d", request.Ifname, link.Attrs().MTU, mtu)
+		}
+		// the container interface must be peer of the host veth
+		if _, ok := link.(*netlink.Veth); !ok {
+			return fmt.Errorf("container interface %s type %s is not veth", request.Ifname, link.Type())
+		}
+		peerIndex, err := netlink.VethPeerIndex(link.(*netlink.Veth))
+		if err != nil {
+			return err
+		}
+		if peerIndex != hostVeth.Attrs().Index {
+			return fmt.Errorf("container interface %s peer index %d, expect host veth %s index %d",
+				request.Ifname, peerIndex, vethName, hostVeth.Attrs().Index)
+		}
+		if mac := containerMacOf(prevResult, request.Ifname); mac != "" && link.Attrs().HardwareAddr.String() != mac {
+			return fmt.Errorf("container interface %s mac %s, expect %s", request.Ifname, link.Attrs().HardwareAddr, mac)
+		}
+		if conf.TxChecksumOffload != nil {
+			enabled, err := getTxChecksumOffload(request.Ifname)
+			if err != nil {
+				return err
+			}
+			if enabled != *conf.TxChecksumOffload {
+				return fmt.Errorf("container interface %s tx checksum offload %t, expect %t",
+					request.Ifname, enabled, *conf.TxChecksumOffload)
+			}
+		}
+
+		if err = ip.ValidateExpectedInterfaceIPs(request.Ifname, prevResult.IPs); err != nil {
+			return err
+		}
+		if err = ip.ValidateExpectedRoute(prevResult.Routes); err != nil {
+			return err
+		}
+		for _, route := range prevResult.Routes {
+			ones, _ := route.Dst.Mask.Size()
+			if ones != 0 {
+				continue
+			}
+			if gateway := conf.gatewayOf(route.Dst.IP.To4() == nil); gateway != nil && !gateway.Equal(route.GW) {
+				return fmt.Errorf("default route gateway %s, expect %s", route.GW, gateway)
+			}
+		}
+		return nil
+	})
+}
+
+// allocateIP requires an ip address from everoute ipam if enabled, otherwise from host-local ipam.
+func (s *CNIServer) allocateIP(ctx context.Context, request *cnipb.CniRequest, conf *NetConf, args *CNIArgs) (*cniv1.Result, error) {
+	// address of secondary interface is allocated by the ipam plugin in net config
+	if conf.VDS != "" {
+		if conf.IPAM.Type == "" {
+			return &cniv1.Result{}, nil
+		}
+		SetEnv(request)
+		r, err := ipam.ExecAdd(conf.IPAM.Type, request.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return cniv1.NewResultFromResult(r)
+	}
+
+	if s.ipam != nil {
+		results, err := s.ipam.Allocate(ctx, string(args.K8S_POD_NAMESPACE), string(args.K8S_POD_NAME), request.ContainerId)
+		if err != nil {
+			return nil, err
+		}
+		ipamResult := &cniv1.Result{}
+		for _, r := range results {
+			ipamResult.IPs = append(ipamResult.IPs, &cniv1.IPConfig{Address: *r.IP, Gateway: r.Gateway})
+		}
+		return ipamResult, nil
+	}
+
+	SetEnv(request)
+	r, err := ipam.ExecAdd("host-local", s.GetIpamConfByte(conf))
+	if err != nil {
+		return nil, err
+	}
+	return cniv1.NewResultFromResult(r)
+}
+
+func (s *CNIServer) checkIP(ctx context.Context, request *cnipb.CniRequest, conf *NetConf) error {
+	if conf.VDS != "" {
+		if conf.IPAM.Type == "" {
+			return nil
+		}
+		SetEnv(request)
+		return ipam.ExecCheck(conf.IPAM.Type, request.Stdin)
+	}
+
+	if s.ipam != nil {
+		r, err := s.ipam.Lookup(ctx, request.ContainerId)
+		if err == nil && len(r) == 0 {
+			err = fmt.Errorf("no ip address allocated for container %s", request.ContainerId)
+		}
+		return err
+	}
+
+	SetEnv(request)
+	return ipam.ExecCheck("host-local", s.GetIpamConfByte(conf))
+}
+
+func (s *CNIServer) releaseIP(ctx context.Context, request *cnipb.CniRequest, conf *NetConf) error {
+	if conf.VDS != "" {
+		if conf.IPAM.Type == "" {
+			return nil
+		}
+		SetEnv(request)
+		return ipam.ExecDel(conf.IPAM.Type, request.Stdin)
+	}
+
+	if s.ipam != nil {
+		return s.ipam.Release(ctx, request.ContainerId)
+	}
+
+	SetEnv(request)
+	return ipam.ExecDel("host-local", s.GetIpamConfByte(conf))
+}
+
 func (s *CNIServer) RetError(code cnipb.ErrorCode, msg string, err error) (*cnipb.CniResponse, error) {
 	resp := &cnipb.CniResponse{
 		Result: nil,
@@ -274,10 +651,15 @@ func (s *CNIServer) RetError(code cnipb.ErrorCode, msg string, err error) (*cnip
 	return resp, err
 }
 
-func (s *CNIServer) GetIpamConfByte(conf *cnitypes.NetConf) []byte {
-	var ipamRanges allocator.RangeSet
+func (s *CNIServer) GetIpamConfByte(conf *NetConf) []byte {
+	// host-local allocates an address from each range set, one range set for
+	// each pod cidr to allocate addresses of both ip families on dual-stack node.
+	var ipamRanges []allocator.RangeSet
 	for _, item := range s.podCIDR {
-		ipamRanges = append(ipamRanges, allocator.Range{Subnet: item})
+		ipamRanges = append(ipamRanges, allocator.RangeSet{allocator.Range{
+			Subnet:  item,
+			Gateway: conf.gatewayOf(item.IP.To4() == nil),
+		}})
 	}
 
 	ipamConf := allocator.Net{
@@ -285,7 +667,7 @@ func (s *CNIServer) GetIpamConfByte(conf *cnitypes.NetConf) []byte {
 		CNIVersion: conf.CNIVersion,
 		IPAM: &allocator.IPAMConfig{
 			Type:   "host-local",
-			Ranges: []allocator.RangeSet{ipamRanges},
+			Ranges: ipamRanges,
 		},
 		Args: nil,
 	}
@@ -301,6 +683,14 @@ func SetEnv(request *cnipb.CniRequest) {
 	os.Setenv("CNI_IFNAME", request.Ifname)
 }
 
+// defaultRouteDst returns the default route destination of the address family.
+func defaultRouteDst(addr net.IP) net.IPNet {
+	if addr.To4() != nil {
+		return net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, net.IPv4len*8)}
+	}
+	return net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, net.IPv6len*8)}
+}
+
 func SetLinkAddr(ifname string, inet *net.IPNet) error {
 	link, err := netlink.LinkByName(ifname)
 	if err != nil {
@@ -321,20 +711,79 @@ func SetLinkAddr(ifname string, inet *net.IPNet) error {
 	return nil
 }
 
-func Initialize(k8sClient client.Client, datapathManager *datapath.DpManager) *CNIServer {
+// ensureGatewayAddr adds the gateway address to the gateway interface if not exists.
+func (s *CNIServer) ensureGatewayAddr(gateway net.IP) error {
+	for _, item := range s.podCIDR {
+		if !(*net.IPNet)(&item).Contains(gateway) {
+			continue
+		}
+		link, err := netlink.LinkByName(s.gwName)
+		if err != nil {
+			return err
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(gateway) {
+				return nil
+			}
+		}
+		return SetLinkAddr(s.gwName, &net.IPNet{IP: gateway, Mask: item.Mask})
+	}
+	return fmt.Errorf("gateway %s not in pod cidr %v", gateway, s.podCIDR)
+}
+
+// podMTU returns mtu of pod interfaces, the mtu in net config takes precedence.
+func (s *CNIServer) podMTU(conf *NetConf) int {
+	if conf.MTU != 0 {
+		return conf.MTU
+	}
+	if conf.VDS != "" {
+		return defaultSecondaryMTU
+	}
+	return s.mtu
+}
+
+// Initialize creates a CNIServer, the allocator is optional, host-local ipam would be used if nil.
+func Initialize(k8sClient client.Client, k8sReader client.Reader, datapathManager *datapath.DpManager,
+	allocator *ipamallocator.Allocator) *CNIServer {
 	s := &CNIServer{
-		k8sClient: k8sClient,
-		gwName:    datapathManager.AgentInfo.GatewayName,
-		ovsDriver: datapathManager.OvsdbDriverMap[datapathManager.AgentInfo.BridgeName][datapath.LOCAL_BRIDGE_KEYWORD],
-		podCIDR:   append([]cnitypes.IPNet{}, datapathManager.AgentInfo.PodCIDR...),
-	}
-
-	// set gateway ip address, first ip in first CIDR
-	if err := SetLinkAddr(s.gwName,
-		&net.IPNet{
-			IP:   datapathManager.AgentInfo.GatewayIP,
-			Mask: s.podCIDR[0].Mask}); err != nil {
-		klog.Errorf("set gateway ip address error, err:%s", err)
+		datapathManager: datapathManager,
+		k8sClient:       k8sClient,
+		k8sReader:       k8sReader,
+		nodeName:        datapathManager.AgentInfo.NodeName,
+		ipam:            allocator,
+		gwName:          datapathManager.AgentInfo.GatewayName,
+		ovsDriver:       datapathManager.OvsdbDriverMap[datapathManager.AgentInfo.BridgeName][datapath.LOCAL_BRIDGE_KEYWORD],
+		podCIDR:         append([]cnitypes.IPNet{}, datapathManager.AgentInfo.PodCIDR...),
+		mtu:             datapath.PodMTU(datapathManager.AgentInfo.TunnelType),
+	}
+
+	// pod mtu fits in the mtu of uplink after encapsulation
+	if linkMTU, err := defaultRouteLinkMTU(); err != nil {
+		klog.Errorf("detect mtu of uplink error, use default pod mtu %d, err: %s", s.mtu, err)
+	} else {
+		s.mtu = linkMTU - datapath.TunnelOverhead(datapathManager.AgentInfo.TunnelType)
+	}
+
+	// set gateway ip address of each ip family, first ip in the CIDR
+	for _, ipv6 := range []bool{false, true} {
+		podCIDR := datapathManager.AgentInfo.PodCIDROfFamily(ipv6)
+		if podCIDR == nil {
+			continue
+		}
+		gatewayIP := datapathManager.AgentInfo.GatewayIP
+		if ipv6 {
+			gatewayIP = datapathManager.AgentInfo.GatewayIPv6
+		}
+		if err := SetLinkAddr(s.gwName,
+			&net.IPNet{
+				IP:   gatewayIP,
+				Mask: podCIDR.Mask}); err != nil {
+			klog.Errorf("set gateway ip address error, err:%s", err)
+		}
 	}
 
 	return s
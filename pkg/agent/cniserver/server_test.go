@@ -37,9 +37,9 @@ var _ = Describe("Test cniserver", func() {
 			Args:        "K8S_POD_NAME=123;K8S_POD_NAMESPACE=456;K8S_POD_INFRA_CONTAINER_ID=789",
 			Path:        "path",
 			Stdin: []byte("{\n  \"cniVersion\": \"1.0.0\",\n  \"name\": \"test\",\n  \"plugins\": [\n    {\n      " +
-				"\"type\": \"bridge\",\n      // plugin specific parameters\n      \"bridge\": \"cni0\",\n      " +
+				"\"type\": \"bridge\",\n      \"bridge\": \"cni0\",\n      " +
 				"\"keyA\": [\"some more\", \"plugin specific\", \"configuration\"],\n      \n      \"ipam\": {\n      " +
-				"  \"type\": \"host-local\",\n        // ipam specific\n        \"subnet\": \"10.1.0.0/16\",\n       " +
+				"  \"type\": \"host-local\",\n        \"subnet\": \"10.1.0.0/16\",\n       " +
 				" \"gateway\": \"10.1.0.1\",\n        \"routes\": [\n            {\"dst\": \"0.0.0.0/0\"}\n        ]\n " +
 				"     },\n      \"dns\": {\n        \"nameservers\": [ \"10.1.0.1\" ]\n      }\n    },\n    {\n     " +
 				" \"type\": \"tuning\",\n      \"capabilities\": {\n        \"mac\": true\n      },\n     " +
@@ -49,11 +49,12 @@ var _ = Describe("Test cniserver", func() {
 		conf, args, err := s.ParseConf(request)
 		Expect(err).Should(Succeed())
 		Expect(conf.CNIVersion).Should(Equal("1.0.0"))
-		Expect(args.K8S_POD_NAMESPACE).Should(Equal("123"))
+		Expect(string(args.K8S_POD_NAME)).Should(Equal("123"))
+		Expect(string(args.K8S_POD_NAMESPACE)).Should(Equal("456"))
 	})
 	It("Test Parse Result", func() {
 		result := &cniv1.Result{
-			CNIVersion: "",
+			CNIVersion: "1.0.0",
 			Interfaces: nil,
 			IPs:        nil,
 			Routes:     nil,
@@ -70,10 +71,10 @@ var _ = Describe("Test cniserver", func() {
 			Path:        "44",
 		}
 		SetEnv(req)
-		Expect(os.Getenv("CNI_CONTAINERID")).Should(Equal(11))
-		Expect(os.Getenv("CNI_NETNS")).Should(Equal(22))
-		Expect(os.Getenv("CNI_IFNAME")).Should(Equal(33))
-		Expect(os.Getenv("CNI_PATH")).Should(Equal(44))
+		Expect(os.Getenv("CNI_CONTAINERID")).Should(Equal("11"))
+		Expect(os.Getenv("CNI_NETNS")).Should(Equal("22"))
+		Expect(os.Getenv("CNI_IFNAME")).Should(Equal("33"))
+		Expect(os.Getenv("CNI_PATH")).Should(Equal("44"))
 	})
 	It("Test SetEnv", func() {
 		req := &cnipb.CniRequest{
@@ -83,9 +84,9 @@ var _ = Describe("Test cniserver", func() {
 			Path:        "44",
 		}
 		SetEnv(req)
-		Expect(os.Getenv("CNI_CONTAINERID")).Should(Equal(11))
-		Expect(os.Getenv("CNI_NETNS")).Should(Equal(22))
-		Expect(os.Getenv("CNI_IFNAME")).Should(Equal(33))
-		Expect(os.Getenv("CNI_PATH")).Should(Equal(44))
+		Expect(os.Getenv("CNI_CONTAINERID")).Should(Equal("11"))
+		Expect(os.Getenv("CNI_NETNS")).Should(Equal("22"))
+		Expect(os.Getenv("CNI_IFNAME")).Should(Equal("33"))
+		Expect(os.Getenv("CNI_PATH")).Should(Equal("44"))
 	})
 })
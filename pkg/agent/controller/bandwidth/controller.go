@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+// Reconciler watch endpoints and apply their bandwidth limit to local ovs interfaces
+type Reconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	lock sync.Mutex
+	// limited map endpoint to local interfaces limited by the endpoint
+	limited map[string][]string
+}
+
+// Reconcile receive endpoint from work queue, update bandwidth limit of interfaces of the endpoint
+func (r *Reconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	klog.V(4).Infof("BandwidthReconciler received endpoint %s reconcile", req.NamespacedName)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var endpoint securityv1alpha1.Endpoint
+	err := r.Get(context.Background(), req.NamespacedName, &endpoint)
+	if client.IgnoreNotFound(err) != nil {
+		klog.Errorf("unable to fetch endpoint %s: %s", req.NamespacedName, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	key := req.NamespacedName.String()
+	if apierrors.IsNotFound(err) {
+		if err = r.clearBandwidth(key); err != nil {
+			klog.Errorf("failed to clear endpoint %s bandwidth: %s", req.NamespacedName, err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	ref := endpoint.Spec.Reference
+	interfaces, err := datapath.InterfacesWithExternalID(ref.ExternalIDName, ref.ExternalIDValue)
+	if err != nil {
+		klog.Errorf("failed to find interfaces of endpoint %s: %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	if endpoint.Spec.Bandwidth == nil {
+		if err = r.clearBandwidth(key); err != nil {
+			klog.Errorf("failed to clear endpoint %s bandwidth: %s", req.NamespacedName, err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	for _, iface := range interfaces {
+		if err = datapath.SetInterfaceBandwidth(iface, toBandwidth(endpoint.Spec.Bandwidth)); err != nil {
+			klog.Errorf("failed to set endpoint %s bandwidth: %s", req.NamespacedName, err)
+			return ctrl.Result{}, err
+		}
+	}
+	if len(interfaces) == 0 {
+		delete(r.limited, key)
+	} else {
+		r.limited[key] = interfaces
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// clearBandwidth removes bandwidth limit of interfaces limited by the endpoint. Limits of other interfaces
+// of the endpoint are kept, they may be applied by cni from bandwidth capability args of the pod.
+// Interfaces deleted would be ignored.
+func (r *Reconciler) clearBandwidth(key string) error {
+	for _, iface := range r.limited[key] {
+		if err := datapath.SetInterfaceBandwidth(iface, nil); err != nil {
+			return err
+		}
+	}
+	delete(r.limited, key)
+	return nil
+}
+
+// SetupWithManager create and add bandwidth controller to the manager.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+	r.limited = make(map[string][]string)
+
+	c, err := controller.New("bandwidth-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	// interfaces attached later would be handled when the endpoint status updated
+	return c.Watch(&source.Kind{Type: &securityv1alpha1.Endpoint{}}, &handler.EnqueueRequestForObject{})
+}
+
+func toBandwidth(limit *securityv1alpha1.BandwidthLimit) *datapath.Bandwidth {
+	if limit == nil {
+		return nil
+	}
+	return datapath.NewBandwidth(limit.IngressRate, limit.IngressBurst, limit.EgressRate, limit.EgressBurst)
+}
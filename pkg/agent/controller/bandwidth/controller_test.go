@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bandwidth
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+)
+
+func TestToBandwidth(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  *securityv1alpha1.BandwidthLimit
+		expect *datapath.Bandwidth
+	}{
+		{
+			name: "endpoint without bandwidth limit",
+		},
+		{
+			name:   "endpoint with ingress limit",
+			limit:  &securityv1alpha1.BandwidthLimit{IngressRate: 10000000, IngressBurst: 1000000},
+			expect: &datapath.Bandwidth{IngressRate: 10000000, IngressBurst: 1000000},
+		},
+		{
+			name:   "endpoint with invalid egress limit",
+			limit:  &securityv1alpha1.BandwidthLimit{EgressRate: 10000000, EgressBurst: -1},
+			expect: &datapath.Bandwidth{EgressRate: 10000000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bandwidth := toBandwidth(tt.limit)
+			if !reflect.DeepEqual(bandwidth, tt.expect) {
+				t.Errorf("expect bandwidth %+v, got %+v", tt.expect, bandwidth)
+			}
+		})
+	}
+}
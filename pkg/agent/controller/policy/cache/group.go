@@ -17,8 +17,11 @@ limitations under the License.
 package cache
 
 import (
+	"sort"
 	"sync"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog"
 
 	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
@@ -40,6 +43,8 @@ type groupMembership struct {
 	name      string
 	revision  int32
 	endpoints map[groupv1alpha1.EndpointReference]groupv1alpha1.GroupMember
+	// restored membership from checkpoint would be replaced by GroupMembers.
+	restored bool
 }
 
 // GroupCache cache GroupMembers and GroupMembersPatch, it's thread safe.
@@ -176,12 +181,24 @@ func (cache *GroupCache) PatchLen(groupName string) int {
 	return len(cache.patches[groupName])
 }
 
+// TotalPatchLen return patches length of all groups.
+func (cache *GroupCache) TotalPatchLen() int {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	var total int
+	for _, patches := range cache.patches {
+		total += len(patches)
+	}
+	return total
+}
+
 // AddGroupMembership add GroupMembers to cache.
 func (cache *GroupCache) AddGroupMembership(members *groupv1alpha1.GroupMembers) {
 	cache.lock.Lock()
 	defer cache.lock.Unlock()
 
-	if _, exist := cache.members[members.Name]; exist {
+	if membership, exist := cache.members[members.Name]; exist && !membership.restored {
 		klog.Warningf("add groupmembers %s already exist in cache", members.Name)
 		return
 	}
@@ -219,6 +236,61 @@ func (cache *GroupCache) DelGroupMembership(groupName string) {
 	delete(cache.members, groupName)
 }
 
+// RestoreGroupMembership add groups and their pending patches restored from checkpoint to cache.
+// Restored groups are replaced when AddGroupMembership with the same name.
+func (cache *GroupCache) RestoreGroupMembership(groups []GroupMembership) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for _, group := range groups {
+		if _, exist := cache.members[group.Name]; exist {
+			continue
+		}
+		membership := &groupMembership{
+			name:      group.Name,
+			revision:  group.Revision,
+			endpoints: make(map[groupv1alpha1.EndpointReference]groupv1alpha1.GroupMember),
+			restored:  true,
+		}
+		for _, member := range group.Members {
+			membership.endpoints[member.EndpointReference] = member
+		}
+		if _, ok := cache.patches[group.Name]; !ok {
+			cache.patches[group.Name] = make(map[int32]*groupv1alpha1.GroupMembersPatch)
+		}
+		for _, patch := range group.Patches {
+			if _, exist := cache.patches[group.Name][patch.Revision]; exist || patch.Revision < group.Revision {
+				continue
+			}
+			cache.patches[group.Name][patch.Revision] = &groupv1alpha1.GroupMembersPatch{
+				ObjectMeta: metav1.ObjectMeta{Name: patch.Name},
+				AppliedToGroupMembers: groupv1alpha1.GroupMembersReference{
+					Name:     group.Name,
+					Revision: patch.Revision,
+				},
+				AddedGroupMembers:   patch.AddedGroupMembers,
+				UpdatedGroupMembers: patch.UpdatedGroupMembers,
+				RemovedGroupMembers: patch.RemovedGroupMembers,
+			}
+		}
+		cache.members[group.Name] = membership
+	}
+}
+
+// DelRestoredGroupMembership removed the group if it's restored from checkpoint and
+// never replaced by GroupMembers.
+func (cache *GroupCache) DelRestoredGroupMembership(groupName string) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if membership, exist := cache.members[groupName]; !exist || !membership.restored {
+		return false
+	}
+	delete(cache.patches, groupName)
+	delete(cache.members, groupName)
+	return true
+}
+
 // ListGroupIPBlocks return a list of IPBlocks of the group.
 func (cache *GroupCache) ListGroupIPBlocks(groupName string) (revision int32, ipBlocks []string, exist bool) {
 	cache.lock.RLock()
@@ -237,3 +309,64 @@ func (cache *GroupCache) ListGroupIPBlocks(groupName string) (revision int32, ip
 
 	return membership.revision, ipBlocks, true
 }
+
+// GroupMembership is a snapshot of group members and pending patches of a group.
+type GroupMembership struct {
+	Name     string                       `json:"name"`
+	Revision int32                        `json:"revision"`
+	Members  []groupv1alpha1.GroupMember  `json:"members,omitempty"`
+	Patches  []GroupMembershipPatchStatus `json:"patches,omitempty"`
+}
+
+// GroupMembershipPatchStatus is a pending GroupMembersPatch of a group.
+type GroupMembershipPatchStatus struct {
+	Name     string `json:"name"`
+	Revision int32  `json:"revision"`
+	Added    int    `json:"added"`
+	Updated  int    `json:"updated"`
+	Removed  int    `json:"removed"`
+
+	AddedGroupMembers   []groupv1alpha1.GroupMember `json:"addedGroupMembers,omitempty"`
+	UpdatedGroupMembers []groupv1alpha1.GroupMember `json:"updatedGroupMembers,omitempty"`
+	RemovedGroupMembers []groupv1alpha1.GroupMember `json:"removedGroupMembers,omitempty"`
+}
+
+// ListGroupMembership return snapshot of all groups in cache, used for debug.
+func (cache *GroupCache) ListGroupMembership() []GroupMembership {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	groupNames := sets.StringKeySet(cache.members).Union(sets.StringKeySet(cache.patches))
+	groupList := make([]GroupMembership, 0, groupNames.Len())
+
+	for _, groupName := range groupNames.List() {
+		group := GroupMembership{Name: groupName}
+		if membership, ok := cache.members[groupName]; ok {
+			group.Revision = membership.revision
+			for _, member := range membership.endpoints {
+				group.Members = append(group.Members, member)
+			}
+		}
+		for revision, patch := range cache.patches[groupName] {
+			group.Patches = append(group.Patches, GroupMembershipPatchStatus{
+				Name:                patch.Name,
+				Revision:            revision,
+				Added:               len(patch.AddedGroupMembers),
+				Updated:             len(patch.UpdatedGroupMembers),
+				Removed:             len(patch.RemovedGroupMembers),
+				AddedGroupMembers:   patch.AddedGroupMembers,
+				UpdatedGroupMembers: patch.UpdatedGroupMembers,
+				RemovedGroupMembers: patch.RemovedGroupMembers,
+			})
+		}
+		sort.Slice(group.Members, func(i, j int) bool {
+			return group.Members[i].EndpointReference.ExternalIDValue < group.Members[j].EndpointReference.ExternalIDValue
+		})
+		sort.Slice(group.Patches, func(i, j int) bool {
+			return group.Patches[i].Revision < group.Patches[j].Revision
+		})
+		groupList = append(groupList, group)
+	}
+
+	return groupList
+}
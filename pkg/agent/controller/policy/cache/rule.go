@@ -120,6 +120,26 @@ func (rule *CompleteRule) ListRules() []PolicyRule {
 	return rule.generateRuleList(srcIPBlocks, dstIPBlocks, rule.Ports)
 }
 
+// DeepCopy return a copy of the CompleteRule, it's thread safe.
+func (rule *CompleteRule) DeepCopy() *CompleteRule {
+	rule.lock.RLock()
+	defer rule.lock.RUnlock()
+
+	return &CompleteRule{
+		RuleID:            rule.RuleID,
+		Tier:              rule.Tier,
+		Action:            rule.Action,
+		Direction:         rule.Direction,
+		SymmetricMode:     rule.SymmetricMode,
+		DefaultPolicyRule: rule.DefaultPolicyRule,
+		SrcGroups:         DeepCopyMap(rule.SrcGroups).(map[string]int32),
+		DstGroups:         DeepCopyMap(rule.DstGroups).(map[string]int32),
+		SrcIPBlocks:       DeepCopyMap(rule.SrcIPBlocks).(map[string]int),
+		DstIPBlocks:       DeepCopyMap(rule.DstIPBlocks).(map[string]int),
+		Ports:             append([]RulePort(nil), rule.Ports...),
+	}
+}
+
 func (rule *CompleteRule) generateRuleList(srcIPBlocks, dstIPBlocks []string, ports []RulePort) []PolicyRule {
 	var policyRuleList []PolicyRule
 
@@ -220,6 +240,20 @@ func (rule *CompleteRule) ApplyPatch(patch *GroupPatch) {
 	}
 }
 
+// MatchGroupRevision return false if the rule references the group with a different revision.
+func (rule *CompleteRule) MatchGroupRevision(groupName string, revision int32) bool {
+	rule.lock.RLock()
+	defer rule.lock.RUnlock()
+
+	if srcRevision, exist := rule.SrcGroups[groupName]; exist && srcRevision != revision {
+		return false
+	}
+	if dstRevision, exist := rule.DstGroups[groupName]; exist && dstRevision != revision {
+		return false
+	}
+	return true
+}
+
 func applyCountMap(count map[string]int, added, deled []string) (new []string, old []string) {
 	for _, add := range added {
 		if count[add] == 0 {
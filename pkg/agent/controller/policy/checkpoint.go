@@ -0,0 +1,231 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+)
+
+const checkpointVersion = 1
+
+// checkpoint is the persisted state of the policy reconciler and the datapath rules.
+type checkpoint struct {
+	Version           int                             `json:"version"`
+	CompleteRules     []*policycache.CompleteRule     `json:"completeRules,omitempty"`
+	GlobalRules       []policycache.PolicyRule        `json:"globalRules,omitempty"`
+	Groups            []policycache.GroupMembership   `json:"groups,omitempty"`
+	FlowKeyReferences map[string][]string             `json:"flowKeyReferences,omitempty"`
+	DatapathRules     []datapath.PolicyRuleCheckpoint `json:"datapathRules,omitempty"`
+}
+
+// saveCheckpoint writes caches and datapath rules into CheckpointFile. Rules are only changed under
+// reconcilerLock, so caches and datapath rules in the checkpoint are always consistent.
+func (r *Reconciler) saveCheckpoint() error {
+	r.reconcilerLock.RLock()
+	r.flowKeyReferenceMapLock.RLock()
+
+	cp := checkpoint{
+		Version:           checkpointVersion,
+		Groups:            r.groupCache.ListGroupMembership(),
+		FlowKeyReferences: make(map[string][]string, len(r.flowKeyReferenceMap)),
+	}
+	// only rules created by policies, other datapath rules would be created on agent start
+	for _, ruleCheckpoint := range r.DatapathManager.ListPolicyRuleCheckpoints() {
+		if _, ok := r.flowKeyReferenceMap[ruleCheckpoint.Rule.RuleID]; ok {
+			cp.DatapathRules = append(cp.DatapathRules, ruleCheckpoint)
+		}
+	}
+	for _, completeRule := range r.ruleCache.List() {
+		cp.CompleteRules = append(cp.CompleteRules, completeRule.(*policycache.CompleteRule).DeepCopy())
+	}
+	for _, globalRule := range r.globalRuleCache.List() {
+		cp.GlobalRules = append(cp.GlobalRules, globalRule.(policycache.PolicyRule))
+	}
+	for flowKey, ruleNames := range r.flowKeyReferenceMap {
+		cp.FlowKeyReferences[flowKey] = ruleNames.List()
+	}
+
+	r.flowKeyReferenceMapLock.RUnlock()
+	r.reconcilerLock.RUnlock()
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	// write into temp file and rename, never leave a broken checkpoint
+	if err = os.MkdirAll(filepath.Dir(r.CheckpointFile), 0755); err != nil {
+		return err
+	}
+	tmpFile := r.CheckpointFile + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, r.CheckpointFile)
+}
+
+// restoreCheckpoint restores caches from CheckpointFile, and adopts datapath rule flows. It returns
+// false if the checkpoint not found.
+func (r *Reconciler) restoreCheckpoint() (bool, error) {
+	data, err := ioutil.ReadFile(r.CheckpointFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var cp checkpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return false, err
+	}
+	if cp.Version != checkpointVersion {
+		return false, fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+
+	ruleCache := policycache.NewCompleteRuleCache()
+	for _, completeRule := range cp.CompleteRules {
+		if err = ruleCache.Add(completeRule); err != nil {
+			return false, err
+		}
+	}
+	globalRuleCache := policycache.NewGlobalRuleCache()
+	for _, globalRule := range cp.GlobalRules {
+		if err = globalRuleCache.Add(globalRule); err != nil {
+			return false, err
+		}
+	}
+	groupCache := policycache.NewGroupCache()
+	groupCache.RestoreGroupMembership(cp.Groups)
+	flowKeyReferenceMap := make(map[string]sets.String, len(cp.FlowKeyReferences))
+	for flowKey, ruleNames := range cp.FlowKeyReferences {
+		flowKeyReferenceMap[flowKey] = sets.NewString(ruleNames...)
+	}
+
+	if _, _, err = r.DatapathManager.AdoptPolicyRules(cp.DatapathRules); err != nil {
+		return false, err
+	}
+
+	r.ruleCache = ruleCache
+	r.globalRuleCache = globalRuleCache
+	r.groupCache = groupCache
+	r.flowKeyReferenceMap = flowKeyReferenceMap
+	klog.Infof("restore %d complete rules, %d global rules, %d groups from checkpoint %s",
+		len(cp.CompleteRules), len(cp.GlobalRules), len(cp.Groups), r.CheckpointFile)
+
+	return true, nil
+}
+
+// notifyCheckpoint notifies runCheckpoint to save checkpoint, notifications are merged when
+// checkpoint is saving. The checkpoint would be saved after the reconcile, which holds reconcilerLock.
+func (r *Reconciler) notifyCheckpoint() {
+	select {
+	case r.checkpointChan <- struct{}{}:
+	default:
+	}
+}
+
+// runCheckpoint saves checkpoint on every rule changes in datapath until stopped.
+func (r *Reconciler) runCheckpoint(stopChan <-chan struct{}) error {
+	for {
+		select {
+		case <-r.checkpointChan:
+		case <-stopChan:
+			if err := r.saveCheckpoint(); err != nil {
+				klog.Errorf("unable save checkpoint %s: %s", r.CheckpointFile, err)
+			}
+			return nil
+		}
+		if err := r.saveCheckpoint(); err != nil {
+			klog.Errorf("unable save checkpoint %s: %s", r.CheckpointFile, err)
+		}
+	}
+}
+
+// syncRestoredPolicies removes rules and groups restored from checkpoint, which have been deleted
+// when agent not running. Policies still exist are synced by policy controller.
+func (r *Reconciler) syncRestoredPolicies(mgr ctrl.Manager) func(stopChan <-chan struct{}) error {
+	return func(stopChan <-chan struct{}) error {
+		if !mgr.GetCache().WaitForCacheSync(stopChan) {
+			klog.Errorf("unable sync restored policies: wait for cache sync failed")
+			return nil
+		}
+		if err := r.removeRestoredPolicies(); err != nil {
+			klog.Errorf("unable sync restored policies: %s", err)
+		}
+		return nil
+	}
+}
+
+func (r *Reconciler) removeRestoredPolicies() error {
+	var ctx = context.Background()
+
+	policyList := securityv1alpha1.SecurityPolicyList{}
+	if err := r.List(ctx, &policyList); err != nil {
+		return err
+	}
+	policySet := sets.NewString()
+	for _, policy := range policyList.Items {
+		policySet.Insert(policy.Namespace + "/" + policy.Name)
+	}
+	for _, policyKey := range r.ruleCache.ListIndexFuncValues(policycache.PolicyIndex) {
+		if policySet.Has(policyKey) {
+			continue
+		}
+		namespace, name, _ := cache.SplitMetaNamespaceKey(policyKey)
+		if _, err := r.ReconcilePolicy(ctrl.Request{NamespacedName: k8stypes.NamespacedName{Namespace: namespace, Name: name}}); err != nil {
+			return fmt.Errorf("remove policy %s: %s", policyKey, err)
+		}
+	}
+
+	// global policy may have been deleted
+	if _, err := r.ReconcileGlobalPolicy(ctrl.Request{}); err != nil {
+		return fmt.Errorf("sync global policy: %s", err)
+	}
+
+	groupList := groupv1alpha1.GroupMembersList{}
+	if err := r.List(ctx, &groupList); err != nil {
+		return err
+	}
+	groupSet := sets.NewString()
+	for _, group := range groupList.Items {
+		groupSet.Insert(group.Name)
+	}
+	for _, group := range r.groupCache.ListGroupMembership() {
+		if !groupSet.Has(group.Name) && r.groupCache.DelRestoredGroupMembership(group.Name) {
+			klog.Infof("remove restored group %s not found", group.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+func TestCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	member := groupv1alpha1.GroupMember{
+		EndpointReference: groupv1alpha1.EndpointReference{ExternalIDName: "idk", ExternalIDValue: "ep01"},
+		IPs:               []types.IPAddress{"10.0.0.1"},
+	}
+	completeRule := &policycache.CompleteRule{
+		RuleID:      "default/policy/ingress.rule01",
+		Action:      policycache.RuleActionAllow,
+		Direction:   policycache.RuleDirectionIn,
+		SrcGroups:   map[string]int32{"group01": 2},
+		DstGroups:   map[string]int32{},
+		SrcIPBlocks: map[string]int{"10.0.0.1/32": 1},
+		DstIPBlocks: map[string]int{"": 1},
+		Ports:       []policycache.RulePort{{}},
+	}
+	globalRules := newGlobalPolicyRulePair("", policycache.RuleTypeGlobalDefaultRule, policycache.RuleActionAllow)
+
+	r := &Reconciler{
+		ruleCache:       policycache.NewCompleteRuleCache(),
+		globalRuleCache: policycache.NewGlobalRuleCache(),
+		groupCache:      policycache.NewGroupCache(),
+		DatapathManager: datapath.NewDatapathManager(&datapath.Config{}, nil),
+		CheckpointFile:  checkpointFile,
+		flowKeyReferenceMap: map[string]sets.String{
+			"flowkey01": sets.NewString("rule01", "rule02"),
+		},
+	}
+	_ = r.ruleCache.Add(completeRule)
+	_ = r.globalRuleCache.Add(globalRules[0])
+	r.groupCache.RestoreGroupMembership([]policycache.GroupMembership{{Name: "group01", Revision: 2, Members: []groupv1alpha1.GroupMember{member}}})
+	r.groupCache.AddPatch(&groupv1alpha1.GroupMembersPatch{
+		ObjectMeta:            metav1.ObjectMeta{Name: "patch01"},
+		AppliedToGroupMembers: groupv1alpha1.GroupMembersReference{Name: "group01", Revision: 2},
+		RemovedGroupMembers:   []groupv1alpha1.GroupMember{member},
+	})
+	r.DatapathManager.Rules["flowkey01"] = &datapath.EveroutePolicyRuleEntry{
+		EveroutePolicyRule: &datapath.EveroutePolicyRule{RuleID: "flowkey01", Action: "allow"},
+		RuleFlowMap:        map[string]*datapath.FlowEntry{},
+	}
+
+	if err := r.saveCheckpoint(); err != nil {
+		t.Fatalf("unable save checkpoint: %s", err)
+	}
+
+	restore := &Reconciler{
+		DatapathManager: datapath.NewDatapathManager(&datapath.Config{}, nil),
+		CheckpointFile:  checkpointFile,
+	}
+	restored, err := restore.restoreCheckpoint()
+	if err != nil || !restored {
+		t.Fatalf("unable restore checkpoint, restored %t: %v", restored, err)
+	}
+
+	if obj, exist, _ := restore.ruleCache.GetByKey(completeRule.RuleID); !exist || !reflect.DeepEqual(obj.(*policycache.CompleteRule).ListRules(), completeRule.ListRules()) {
+		t.Errorf("expect complete rule %s restored, got %+v", completeRule.RuleID, obj)
+	}
+	if _, exist, _ := restore.globalRuleCache.GetByKey(globalRules[0].Name); !exist {
+		t.Errorf("expect global rule %s restored", globalRules[0].Name)
+	}
+	if !reflect.DeepEqual(restore.groupCache.ListGroupMembership(), r.groupCache.ListGroupMembership()) {
+		t.Errorf("expect groups %+v, got %+v", r.groupCache.ListGroupMembership(), restore.groupCache.ListGroupMembership())
+	}
+	if patch := restore.groupCache.NextPatch("group01"); patch == nil || !reflect.DeepEqual(patch.Del, []string{"10.0.0.1/32"}) {
+		t.Errorf("expect pending patch of group01 restored, got %+v", patch)
+	}
+	if !restore.flowKeyReferenceMap["flowkey01"].Equal(sets.NewString("rule01", "rule02")) {
+		t.Errorf("unexpect flowkey references %+v", restore.flowKeyReferenceMap)
+	}
+	if ruleEntry := restore.DatapathManager.Rules["flowkey01"]; ruleEntry == nil || ruleEntry.EveroutePolicyRule.Action != "allow" {
+		t.Errorf("expect datapath rule flowkey01 restored, got %+v", restore.DatapathManager.Rules)
+	}
+
+	// restored group should be replaced by GroupMembers, and rules reference it become stale
+	restore.groupCache.AddGroupMembership(&groupv1alpha1.GroupMembers{
+		ObjectMeta: metav1.ObjectMeta{Name: "group01"},
+		Revision:   5,
+	})
+	if revision, _, _ := restore.groupCache.ListGroupIPBlocks("group01"); revision != 5 {
+		t.Errorf("expect restored group replaced with revision 5, got %d", revision)
+	}
+	if completeRule.MatchGroupRevision("group01", 5) {
+		t.Errorf("expect rule reference stale revision of group01")
+	}
+	if restore.groupCache.DelRestoredGroupMembership("group01") {
+		t.Errorf("group01 has been replaced, should not be removed as restored group")
+	}
+}
+
+func TestRunCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	r := &Reconciler{
+		ruleCache:           policycache.NewCompleteRuleCache(),
+		globalRuleCache:     policycache.NewGlobalRuleCache(),
+		groupCache:          policycache.NewGroupCache(),
+		DatapathManager:     datapath.NewDatapathManager(&datapath.Config{}, nil),
+		CheckpointFile:      checkpointFile,
+		checkpointChan:      make(chan struct{}, 1),
+		flowKeyReferenceMap: map[string]sets.String{},
+	}
+
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go func() { _ = r.runCheckpoint(stopChan) }()
+
+	// notifications never block the reconcile
+	r.notifyCheckpoint()
+	r.notifyCheckpoint()
+
+	err := wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		_, err := os.Stat(checkpointFile)
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Errorf("expect checkpoint saved after rules changed: %s", err)
+	}
+}
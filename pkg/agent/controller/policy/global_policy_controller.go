@@ -19,6 +19,7 @@ package policy
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -32,6 +33,10 @@ import (
 // so we full sync PolicyRules every reconcile.
 func (r *Reconciler) ReconcileGlobalPolicy(_ ctrl.Request) (ctrl.Result, error) {
 	var newPolicyRule, oldPolicyRule []cache.PolicyRule
+	defer observeReconcileDuration(globalPolicyReconciler, time.Now())
+
+	r.reconcilerLock.Lock()
+	defer r.reconcilerLock.Unlock()
 
 	oldPolicyRuleList := r.globalRuleCache.List()
 	for _, rule := range oldPolicyRuleList {
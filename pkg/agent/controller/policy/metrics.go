@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	policyReconciler       = "policy"
+	patchReconciler        = "patch"
+	globalPolicyReconciler = "global_policy"
+)
+
+var (
+	groupPatchBacklogDesc = prometheus.NewDesc(
+		"everoute_agent_group_patch_backlog",
+		"Number of GroupMembersPatches waiting to be applied in group cache.",
+		nil, nil,
+	)
+	completeRulesDesc = prometheus.NewDesc(
+		"everoute_agent_policy_complete_rules",
+		"Number of complete rules calculated from policies.",
+		nil, nil,
+	)
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "everoute_agent_policy_reconcile_duration_seconds",
+		Help: "Duration of each reconcile of policy related reconcilers.",
+	}, []string{"reconciler"})
+)
+
+// Describe implements prometheus.Collector
+func (r *Reconciler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- groupPatchBacklogDesc
+	ch <- completeRulesDesc
+	reconcileDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (r *Reconciler) Collect(ch chan<- prometheus.Metric) {
+	reconcileDuration.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(groupPatchBacklogDesc, prometheus.GaugeValue, float64(r.groupCache.TotalPatchLen()))
+	ch <- prometheus.MustNewConstMetric(completeRulesDesc, prometheus.GaugeValue, float64(len(r.ruleCache.ListKeys())))
+}
+
+func observeReconcileDuration(reconciler string, start time.Time) {
+	reconcileDuration.WithLabelValues(reconciler).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+)
+
+func TestReconcilerMetrics(t *testing.T) {
+	r := &Reconciler{
+		ruleCache:  policycache.NewCompleteRuleCache(),
+		groupCache: policycache.NewGroupCache(),
+	}
+
+	for revision := int32(1); revision <= 3; revision++ {
+		r.groupCache.AddPatch(&groupv1alpha1.GroupMembersPatch{
+			ObjectMeta:            metav1.ObjectMeta{Name: "patch"},
+			AppliedToGroupMembers: groupv1alpha1.GroupMembersReference{Name: "group01", Revision: revision},
+		})
+	}
+	_ = r.ruleCache.Add(&policycache.CompleteRule{RuleID: "default/policy/ingress.rule01"})
+	observeReconcileDuration(policyReconciler, time.Now())
+
+	expect := `
+# HELP everoute_agent_group_patch_backlog Number of GroupMembersPatches waiting to be applied in group cache.
+# TYPE everoute_agent_group_patch_backlog gauge
+everoute_agent_group_patch_backlog 3
+# HELP everoute_agent_policy_complete_rules Number of complete rules calculated from policies.
+# TYPE everoute_agent_policy_complete_rules gauge
+everoute_agent_policy_complete_rules 1
+`
+	err := testutil.CollectAndCompare(r, strings.NewReader(expect),
+		"everoute_agent_group_patch_backlog", "everoute_agent_policy_complete_rules")
+	if err != nil {
+		t.Fatalf("unexpect metrics: %s", err)
+	}
+
+	if count := testutil.CollectAndCount(reconcileDuration); count != 1 {
+		t.Errorf("expect 1 reconcile duration series, got %d", count)
+	}
+}
@@ -22,11 +22,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -36,6 +36,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
@@ -71,28 +73,40 @@ type Reconciler struct {
 
 	flowKeyReferenceMapLock sync.RWMutex
 	flowKeyReferenceMap     map[string]sets.String // Map flowKey to policyRule names
+
+	// CheckpointFile persists rule caches and datapath rules, agent restore from it on restart
+	// without reinstall flows. Empty disables the checkpoint.
+	CheckpointFile string
+	// checkpointChan notifies to save checkpoint after rules changed in datapath
+	checkpointChan chan struct{}
 }
 
 func (r *Reconciler) ReconcilePolicy(req ctrl.Request) (ctrl.Result, error) {
-	var policy securityv1alpha1.SecurityPolicy
-	var ctx = context.Background()
+	defer observeReconcileDuration(policyReconciler, time.Now())
 
 	r.reconcilerLock.Lock()
 	defer r.reconcilerLock.Unlock()
 
-	err := r.Get(ctx, req.NamespacedName, &policy)
+	return r.reconcilePolicy(req.NamespacedName)
+}
+
+func (r *Reconciler) reconcilePolicy(namespacedName k8stypes.NamespacedName) (ctrl.Result, error) {
+	var policy securityv1alpha1.SecurityPolicy
+	var ctx = context.Background()
+
+	err := r.Get(ctx, namespacedName, &policy)
 	if client.IgnoreNotFound(err) != nil {
-		klog.Errorf("unable to fetch policy %s: %s", req.Name, err.Error())
+		klog.Errorf("unable to fetch policy %s: %s", namespacedName.Name, err.Error())
 		return ctrl.Result{}, err
 	}
 
 	if apierrors.IsNotFound(err) {
-		err := r.cleanPolicyDependents(req.NamespacedName)
+		err := r.cleanPolicyDependents(namespacedName)
 		if err != nil {
-			klog.Errorf("failed to delete policy %s dependents: %s", req.Name, err.Error())
+			klog.Errorf("failed to delete policy %s dependents: %s", namespacedName.Name, err.Error())
 			return ctrl.Result{}, err
 		}
-		klog.Infof("succeed remove policy %s all rules", req.Name)
+		klog.Infof("succeed remove policy %s all rules", namespacedName.Name)
 		return ctrl.Result{}, nil
 	}
 
@@ -102,6 +116,12 @@ func (r *Reconciler) ReconcilePolicy(req ctrl.Request) (ctrl.Result, error) {
 func (r *Reconciler) ReconcilePatch(req ctrl.Request) (ctrl.Result, error) {
 	var groupName = req.Name
 	var requeue bool
+	defer observeReconcileDuration(patchReconciler, time.Now())
+
+	if err := r.syncGroupStalePolicies(groupName); err != nil {
+		klog.Errorf("unable sync policies reference stale revision of group %s: %s", groupName, err)
+		return ctrl.Result{}, err
+	}
 
 	patch := r.groupCache.NextPatch(groupName)
 	if patch == nil {
@@ -131,6 +151,42 @@ func (r *Reconciler) ReconcilePatch(req ctrl.Request) (ctrl.Result, error) {
 	return ctrl.Result{Requeue: requeue}, nil
 }
 
+// syncGroupStalePolicies recalculates policies which rules reference the group with a revision
+// different from group cache. It happens when group restored from checkpoint has been replaced
+// by the GroupMembers.
+func (r *Reconciler) syncGroupStalePolicies(groupName string) error {
+	r.reconcilerLock.Lock()
+	defer r.reconcilerLock.Unlock()
+
+	revision, _, exist := r.groupCache.ListGroupIPBlocks(groupName)
+	if !exist {
+		return nil
+	}
+
+	stalePolicies := sets.NewString()
+	completeRules, _ := r.ruleCache.ByIndex(policycache.GroupIndex, groupName)
+	for _, completeRule := range completeRules {
+		if !completeRule.(*policycache.CompleteRule).MatchGroupRevision(groupName, revision) {
+			policyKeys, _ := r.ruleCache.GetIndexers()[policycache.PolicyIndex](completeRule)
+			stalePolicies.Insert(policyKeys...)
+		}
+	}
+
+	for _, policyKey := range stalePolicies.List() {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(policyKey)
+		klog.Infof("sync policy %s with group %s revision %d", policyKey, groupName, revision)
+		result, err := r.reconcilePolicy(k8stypes.NamespacedName{Namespace: namespace, Name: name})
+		if err != nil {
+			return err
+		}
+		if result.Requeue {
+			return fmt.Errorf("policy %s wait for groups", policyKey)
+		}
+	}
+
+	return nil
+}
+
 // GetCompleteRuleLister return cache.CompleteRule lister, used for debug or testing
 func (r *Reconciler) GetCompleteRuleLister() informer.Lister {
 	return r.ruleCache
@@ -141,6 +197,11 @@ func (r *Reconciler) GetGlobalRuleLister() informer.Lister {
 	return r.globalRuleCache
 }
 
+// GetGroupCache return group cache, used for debug or testing
+func (r *Reconciler) GetGroupCache() *policycache.GroupCache {
+	return r.groupCache
+}
+
 func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if mgr == nil {
 		return fmt.Errorf("can't setup with nil manager")
@@ -149,6 +210,21 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	var err error
 	var policyController, patchController, globalPolicyController controller.Controller
 
+	// restore caches from checkpoint before start
+	var restored bool
+	if r.CheckpointFile != "" {
+		if restored, err = r.restoreCheckpoint(); err != nil {
+			klog.Errorf("unable restore from checkpoint %s, start without checkpoint: %s", r.CheckpointFile, err)
+		}
+		r.checkpointChan = make(chan struct{}, 1)
+	}
+	if !restored {
+		// nothing adopted, let datapath clean policy rule flows of previous rounds
+		if _, _, err = r.DatapathManager.AdoptPolicyRules(nil); err != nil {
+			return err
+		}
+	}
+
 	// ignore not empty ruleCache for future cache inject
 	if r.ruleCache == nil {
 		r.ruleCache = policycache.NewCompleteRuleCache()
@@ -161,7 +237,9 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.groupCache == nil {
 		r.groupCache = policycache.NewGroupCache()
 	}
-	r.flowKeyReferenceMap = make(map[string]sets.String)
+	if r.flowKeyReferenceMap == nil {
+		r.flowKeyReferenceMap = make(map[string]sets.String)
+	}
 
 	if policyController, err = controller.New("policy-controller", mgr, controller.Options{
 		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
@@ -215,7 +293,23 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return nil
+	if restored {
+		// remove rules of policies deleted when agent not running
+		if err = mgr.Add(manager.RunnableFunc(r.syncRestoredPolicies(mgr))); err != nil {
+			return err
+		}
+	}
+	if r.CheckpointFile != "" {
+		if err = mgr.Add(manager.RunnableFunc(r.runCheckpoint)); err != nil {
+			return err
+		}
+	}
+
+	err = metrics.Registry.Register(r)
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
 }
 
 func (r *Reconciler) addPatch(e event.CreateEvent, q workqueue.RateLimitingInterface) {
@@ -477,14 +571,21 @@ func (r *Reconciler) syncPolicyRulesUntilSuccess(oldRuleList, newRuleList []poli
 	}
 }
 
+// compareAndApplyPolicyRulesChanges commits all rule changes into datapath in one bundle,
+// flowKey references would not change unless the bundle committed.
 func (r *Reconciler) compareAndApplyPolicyRulesChanges(oldRuleList, newRuleList []policycache.PolicyRule) error {
 	var (
-		errList    []error
 		newRuleMap = toRuleMap(newRuleList)
 		oldRuleMap = toRuleMap(oldRuleList)
 		allRuleSet = sets.StringKeySet(newRuleMap).Union(sets.StringKeySet(oldRuleMap))
+		bundle     = datapath.NewPolicyRuleBundle()
+		// references map flowKey to policyRule names after bundle committed, nil means removed
+		references = make(map[string]sets.String)
 	)
 
+	r.flowKeyReferenceMapLock.Lock()
+	defer r.flowKeyReferenceMapLock.Unlock()
+
 	for ruleName := range allRuleSet {
 		oldRule, oldExist := oldRuleMap[ruleName]
 		newRule, newExist := newRuleMap[ruleName]
@@ -494,84 +595,95 @@ func (r *Reconciler) compareAndApplyPolicyRulesChanges(oldRuleList, newRuleList
 				continue
 			}
 			klog.Infof("create policyRule: %v", newRule)
-			errList = append(errList,
-				r.processPolicyRuleAdd(newRule),
-			)
+			r.processPolicyRuleAdd(bundle, references, newRule)
 
 		} else if oldExist {
 			klog.Infof("remove policyRule: %v", oldRule)
-			errList = append(errList,
-				r.processPolicyRuleDelete(oldRule.Name),
-			)
+			r.processPolicyRuleDelete(bundle, references, oldRule.Name)
 		}
 	}
 
-	return errors.NewAggregate(errList)
+	if err := r.DatapathManager.CommitPolicyRuleBundle(bundle); err != nil {
+		return err
+	}
+
+	for flowKey, ruleNames := range references {
+		if ruleNames.Len() == 0 {
+			delete(r.flowKeyReferenceMap, flowKey)
+			continue
+		}
+		r.flowKeyReferenceMap[flowKey] = ruleNames
+	}
+	if bundle.Len() != 0 {
+		r.notifyCheckpoint()
+	}
+	return nil
 }
 
-func (r *Reconciler) processPolicyRuleDelete(ruleName string) error {
-	r.flowKeyReferenceMapLock.Lock()
-	defer r.flowKeyReferenceMapLock.Unlock()
+// flowKeyReferences returns a copy of policyRule names reference the flowKey, the copy would
+// save in references, and return directly on next call.
+func (r *Reconciler) flowKeyReferences(references map[string]sets.String, flowKey string) sets.String {
+	if ruleNames, ok := references[flowKey]; ok {
+		return ruleNames
+	}
+	if ruleNames, ok := r.flowKeyReferenceMap[flowKey]; ok {
+		references[flowKey] = sets.NewString(ruleNames.UnsortedList()...)
+	} else {
+		references[flowKey] = nil
+	}
+	return references[flowKey]
+}
 
+func (r *Reconciler) processPolicyRuleDelete(bundle *datapath.PolicyRuleBundle, references map[string]sets.String, ruleName string) {
 	var flowKey = flowKeyFromRuleName(ruleName)
-	if r.flowKeyReferenceMap[flowKey] == nil {
+	var ruleNames = r.flowKeyReferences(references, flowKey)
+	if ruleNames == nil {
 		// already deleted
-		return nil
+		return
 	}
 
-	switch r.flowKeyReferenceMap[flowKey].Len() {
+	switch ruleNames.Len() {
 	case 0:
-		delete(r.flowKeyReferenceMap, flowKey)
+		references[flowKey] = nil
 
 	case 1:
-		if r.flowKeyReferenceMap[flowKey].Has(ruleName) {
+		if ruleNames.Has(ruleName) {
 			klog.Infof("remove rule %s from datapath", flowKey)
-			if err := r.deletePolicyRuleFromDatapath(flowKey); err != nil {
-				return err
-			}
-			delete(r.flowKeyReferenceMap, flowKey)
+			r.deletePolicyRuleFromDatapath(bundle, flowKey)
+			references[flowKey] = nil
 		} else {
 			// this should never happen
-			klog.Warningf("rule %s with flowkey %s not found in reference map %+v", ruleName, flowKey, r.flowKeyReferenceMap)
+			klog.Warningf("rule %s with flowkey %s not found in reference map %+v", ruleName, flowKey, ruleNames)
 		}
 
 	default:
-		r.flowKeyReferenceMap[flowKey].Delete(ruleName)
+		ruleNames.Delete(ruleName)
 	}
-	return nil
 }
 
-func (r *Reconciler) processPolicyRuleAdd(policyRule *policycache.PolicyRule) error {
-	r.flowKeyReferenceMapLock.Lock()
-	defer r.flowKeyReferenceMapLock.Unlock()
-
+func (r *Reconciler) processPolicyRuleAdd(bundle *datapath.PolicyRuleBundle, references map[string]sets.String, policyRule *policycache.PolicyRule) {
 	var flowKey = flowKeyFromRuleName(policyRule.Name)
+	var ruleNames = r.flowKeyReferences(references, flowKey)
 
-	if r.flowKeyReferenceMap[flowKey] == nil {
-		r.flowKeyReferenceMap[flowKey] = sets.NewString()
+	if ruleNames == nil {
+		ruleNames = sets.NewString()
+		references[flowKey] = ruleNames
 	}
 	klog.Infof("add rule %s to datapath", flowKey)
-	if err := r.addPolicyRuleToDatapath(flowKey, policyRule); err != nil {
-		return err
-	}
+	r.addPolicyRuleToDatapath(bundle, flowKey, policyRule)
 
-	r.flowKeyReferenceMap[flowKey].Insert(policyRule.Name)
-	return nil
+	ruleNames.Insert(policyRule.Name)
 }
 
-func (r *Reconciler) deletePolicyRuleFromDatapath(flowKey string) error {
-	ERPolicyRule := &datapath.EveroutePolicyRule{
-		RuleID: flowKey,
-	}
-
-	return r.DatapathManager.RemoveEveroutePolicyRule(ERPolicyRule)
+func (r *Reconciler) deletePolicyRuleFromDatapath(bundle *datapath.PolicyRuleBundle, flowKey string) {
+	bundle.RemoveRule(flowKey)
 }
 
-func (r *Reconciler) addPolicyRuleToDatapath(ruleID string, rule *policycache.PolicyRule) error {
+func (r *Reconciler) addPolicyRuleToDatapath(bundle *datapath.PolicyRuleBundle, ruleID string, rule *policycache.PolicyRule) {
 	// Process PolicyRule: convert it to everoutePolicyRule, filter illegal PolicyRule; install everoutePolicyRule flow
 	everoutePolicyRule := toEveroutePolicyRule(ruleID, rule)
 	ruleDirection := getRuleDirection(rule.Direction)
 	ruleTier := getRuleTier(rule.Tier)
 
-	return r.DatapathManager.AddEveroutePolicyRule(everoutePolicyRule, ruleDirection, ruleTier)
+	bundle.AddRule(everoutePolicyRule, ruleDirection, ruleTier)
 }
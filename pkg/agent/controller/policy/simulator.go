@@ -0,0 +1,354 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	clientsetscheme "github.com/everoute/everoute/pkg/client/clientset_generated/clientset/scheme"
+	"github.com/everoute/everoute/pkg/constants"
+	groupctrl "github.com/everoute/everoute/pkg/controller/group"
+	ctrlpolicy "github.com/everoute/everoute/pkg/controller/policy"
+)
+
+// SimulatorInput contains the resources policies compiled from.
+type SimulatorInput struct {
+	SecurityPolicies []securityv1alpha1.SecurityPolicy
+	GlobalPolicies   []securityv1alpha1.GlobalPolicy
+	Endpoints        []securityv1alpha1.Endpoint
+	EndpointGroups   []groupv1alpha1.EndpointGroup
+	Namespaces       []corev1.Namespace
+}
+
+// SimulatePacket is the 5-tuple evaluated by the simulator, empty protocol means ICMP.
+type SimulatePacket struct {
+	SrcIP    net.IP
+	DstIP    net.IP
+	Protocol securityv1alpha1.Protocol
+	SrcPort  uint16
+	DstPort  uint16
+}
+
+// SimulateVerdict is the policy decision in a direction. Tier and RuleID are empty
+// when no rule matches the packet.
+type SimulateVerdict struct {
+	Direction   policycache.RuleDirection `json:"direction"`
+	Action      policycache.RuleAction    `json:"action"`
+	Tier        string                    `json:"tier,omitempty"`
+	RuleID      string                    `json:"ruleID,omitempty"`
+	PolicyRules []string                  `json:"policyRules,omitempty"`
+}
+
+// SimulateResult is the final verdict of the packet and the verdicts of each direction.
+type SimulateResult struct {
+	Action   policycache.RuleAction `json:"action"`
+	Verdicts []SimulateVerdict      `json:"verdicts"`
+}
+
+// Simulator compiles policies the same way as the agent Reconciler into an in-memory
+// model of the policy tier tables, then evaluates packets without OVS.
+type Simulator struct {
+	// rules are the datapath rules, map flowKey to the rule entry
+	rules map[string]*datapath.EveroutePolicyRuleEntry
+	// flowKeyReferenceMap map flowKey to policyRule names
+	flowKeyReferenceMap map[string]sets.String
+}
+
+// NewSimulator calculates group members and compiles policies from the input.
+func NewSimulator(input *SimulatorInput) (*Simulator, error) {
+	scheme := runtime.NewScheme()
+	if err := clientsetscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	var objects []runtime.Object
+	for item := range input.SecurityPolicies {
+		objects = append(objects, &input.SecurityPolicies[item])
+	}
+	for item := range input.GlobalPolicies {
+		objects = append(objects, &input.GlobalPolicies[item])
+	}
+	for item := range input.Endpoints {
+		objects = append(objects, &input.Endpoints[item])
+	}
+	for item := range input.Namespaces {
+		objects = append(objects, &input.Namespaces[item])
+	}
+	fakeClient := fake.NewFakeClientWithScheme(scheme, objects...)
+
+	r := &Reconciler{
+		Client:              fakeClient,
+		Scheme:              scheme,
+		ruleCache:           policycache.NewCompleteRuleCache(),
+		globalRuleCache:     policycache.NewGlobalRuleCache(),
+		groupCache:          policycache.NewGroupCache(),
+		flowKeyReferenceMap: make(map[string]sets.String),
+	}
+
+	groupReconciler := &groupctrl.GroupReconciler{Client: fakeClient, Scheme: scheme}
+	for _, group := range simulateEndpointGroups(input) {
+		members, err := groupReconciler.FetchCurrGroupMembers(context.Background(), group)
+		if err != nil {
+			return nil, fmt.Errorf("calculate group %s members: %s", group.Name, err)
+		}
+		members.Name = group.Name
+		r.groupCache.AddGroupMembership(members)
+	}
+
+	var policyRuleList []policycache.PolicyRule
+	for item := range input.SecurityPolicies {
+		policy := &input.SecurityPolicies[item]
+		switch policy.Spec.Tier {
+		case constants.Tier0, constants.Tier1, constants.Tier2:
+		default:
+			return nil, fmt.Errorf("policy %s/%s has unsupported tier %s", policy.Namespace, policy.Name, policy.Spec.Tier)
+		}
+
+		completeRules, err := r.completePolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("flatten policy %s/%s: %s", policy.Namespace, policy.Name, err)
+		}
+		for _, completeRule := range completeRules {
+			policyRuleList = append(policyRuleList, completeRule.ListRules()...)
+		}
+	}
+
+	globalRuleList, err := r.calculateExpectGlobalPolicyRules()
+	if err != nil {
+		return nil, err
+	}
+	policyRuleList = append(policyRuleList, globalRuleList...)
+
+	simulator := &Simulator{
+		rules:               make(map[string]*datapath.EveroutePolicyRuleEntry),
+		flowKeyReferenceMap: make(map[string]sets.String),
+	}
+	for item := range policyRuleList {
+		if err = simulator.addPolicyRule(&policyRuleList[item]); err != nil {
+			return nil, err
+		}
+	}
+
+	return simulator, nil
+}
+
+// Simulate evaluates the packet in egress direction then ingress direction, the packet
+// would be dropped if dropped in any direction.
+func (s *Simulator) Simulate(packet *SimulatePacket) *SimulateResult {
+	var result = &SimulateResult{Action: policycache.RuleActionAllow}
+	var tracePacket = &datapath.TraceflowPacket{
+		SrcIP:      packet.SrcIP,
+		DstIP:      packet.DstIP,
+		IPProtocol: protocolToInt(string(packet.Protocol)),
+		SrcPort:    packet.SrcPort,
+		DstPort:    packet.DstPort,
+	}
+	if packet.Protocol == "" {
+		tracePacket.IPProtocol = protocolToInt(string(securityv1alpha1.ProtocolICMP))
+	}
+
+	for _, direction := range []policycache.RuleDirection{policycache.RuleDirectionOut, policycache.RuleDirectionIn} {
+		observations := datapath.TracePolicyRules("", s.rules, getRuleDirection(direction), tracePacket)
+		verdict := s.toVerdict(direction, observations)
+		result.Verdicts = append(result.Verdicts, verdict)
+
+		if verdict.Action == policycache.RuleActionDrop {
+			result.Action = policycache.RuleActionDrop
+			break
+		}
+	}
+
+	return result
+}
+
+// ListPolicyRules returns names of policy rules compiled into the datapath rule.
+func (s *Simulator) ListPolicyRules(ruleID string) []string {
+	return s.flowKeyReferenceMap[ruleID].List()
+}
+
+func (s *Simulator) addPolicyRule(rule *policycache.PolicyRule) error {
+	switch rule.IPProtocol {
+	case "", string(securityv1alpha1.ProtocolTCP), string(securityv1alpha1.ProtocolUDP), string(securityv1alpha1.ProtocolICMP):
+	default:
+		return fmt.Errorf("policy rule %s has unsupported protocol %s", rule.Name, rule.IPProtocol)
+	}
+
+	flowKey := flowKeyFromRuleName(rule.Name)
+	if s.flowKeyReferenceMap[flowKey] == nil {
+		s.flowKeyReferenceMap[flowKey] = sets.NewString()
+	}
+	s.flowKeyReferenceMap[flowKey].Insert(rule.Name)
+
+	s.rules[flowKey] = &datapath.EveroutePolicyRuleEntry{
+		EveroutePolicyRule: toEveroutePolicyRule(flowKey, rule),
+		Direction:          getRuleDirection(rule.Direction),
+		Tier:               getRuleTier(rule.Tier),
+	}
+	return nil
+}
+
+// toVerdict finds the rule makes the decision, observations are in the order of tiers.
+func (s *Simulator) toVerdict(direction policycache.RuleDirection, observations []datapath.TraceflowObservation) SimulateVerdict {
+	var tiers = []string{constants.Tier0, constants.Tier1, constants.Tier2}
+	var verdict = SimulateVerdict{Direction: direction, Action: policycache.RuleActionAllow}
+
+	for index, observation := range observations {
+		if observation.RuleID == "" {
+			continue
+		}
+		verdict.Tier = tiers[index]
+		verdict.RuleID = observation.RuleID
+		verdict.PolicyRules = s.ListPolicyRules(observation.RuleID)
+		if observation.Action == agentv1alpha1.TraceflowActionDropped {
+			verdict.Action = policycache.RuleActionDrop
+		}
+	}
+
+	return verdict
+}
+
+// simulateEndpointGroups returns the input groups and groups generated from policies peers.
+func simulateEndpointGroups(input *SimulatorInput) []*groupv1alpha1.EndpointGroup {
+	var groups = make(map[string]*groupv1alpha1.EndpointGroup)
+
+	for item := range input.EndpointGroups {
+		groups[input.EndpointGroups[item].Name] = &input.EndpointGroups[item]
+	}
+
+	addPeerGroup := func(namespace string, peer securityv1alpha1.SecurityPolicyPeer) {
+		if group := ctrlpolicy.PeerAsEndpointGroup(namespace, peer); group != nil {
+			groups[group.Name] = group
+		}
+	}
+	for _, policy := range input.SecurityPolicies {
+		for _, appliedTo := range policy.Spec.AppliedTo {
+			addPeerGroup(policy.Namespace, ctrlpolicy.AppliedAsSecurityPeer(policy.Namespace, appliedTo))
+		}
+		for _, rule := range policy.Spec.IngressRules {
+			for _, peer := range rule.From {
+				addPeerGroup(policy.Namespace, peer)
+			}
+		}
+		for _, rule := range policy.Spec.EgressRules {
+			for _, peer := range rule.To {
+				addPeerGroup(policy.Namespace, peer)
+			}
+		}
+	}
+
+	var groupList = make([]*groupv1alpha1.EndpointGroup, 0, len(groups))
+	for _, group := range groups {
+		groupList = append(groupList, group)
+	}
+	return groupList
+}
+
+// DecodeSimulatorInput decodes multi-document YAML or JSON into the input, kind List
+// is also supported, unknown kinds would be ignored.
+func DecodeSimulatorInput(input *SimulatorInput, data []byte) error {
+	scheme := runtime.NewScheme()
+	if err := clientsetscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	deserializer := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw.Raw), []byte("null")) {
+			continue
+		}
+
+		obj, _, err := deserializer.Decode(raw.Raw, nil, nil)
+		if runtime.IsNotRegisteredError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = input.addObject(obj, deserializer); err != nil {
+			return err
+		}
+	}
+}
+
+func (input *SimulatorInput) addObject(obj runtime.Object, deserializer runtime.Decoder) error {
+	switch o := obj.(type) {
+	case *securityv1alpha1.SecurityPolicy:
+		input.SecurityPolicies = append(input.SecurityPolicies, *o)
+	case *securityv1alpha1.SecurityPolicyList:
+		input.SecurityPolicies = append(input.SecurityPolicies, o.Items...)
+	case *securityv1alpha1.GlobalPolicy:
+		input.GlobalPolicies = append(input.GlobalPolicies, *o)
+	case *securityv1alpha1.GlobalPolicyList:
+		input.GlobalPolicies = append(input.GlobalPolicies, o.Items...)
+	case *securityv1alpha1.Endpoint:
+		input.Endpoints = append(input.Endpoints, *o)
+	case *securityv1alpha1.EndpointList:
+		input.Endpoints = append(input.Endpoints, o.Items...)
+	case *groupv1alpha1.EndpointGroup:
+		input.EndpointGroups = append(input.EndpointGroups, *o)
+	case *groupv1alpha1.EndpointGroupList:
+		input.EndpointGroups = append(input.EndpointGroups, o.Items...)
+	case *corev1.Namespace:
+		input.Namespaces = append(input.Namespaces, *o)
+	case *corev1.NamespaceList:
+		input.Namespaces = append(input.Namespaces, o.Items...)
+	case *corev1.List:
+		for _, item := range o.Items {
+			itemObj, _, err := deserializer.Decode(item.Raw, nil, nil)
+			if runtime.IsNotRegisteredError(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err = input.addObject(itemObj, deserializer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
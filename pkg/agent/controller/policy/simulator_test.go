@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+const simulatorInputYAML = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+---
+apiVersion: security.everoute.io/v1alpha1
+kind: Endpoint
+metadata:
+  name: web
+  namespace: default
+  labels:
+    app: web
+spec:
+  reference:
+    externalIDName: iface-id
+    externalIDValue: web
+status:
+  ips: ["10.0.0.1"]
+---
+apiVersion: security.everoute.io/v1alpha1
+kind: Endpoint
+metadata:
+  name: db
+  namespace: default
+  labels:
+    app: db
+spec:
+  reference:
+    externalIDName: iface-id
+    externalIDValue: db
+status:
+  ips: ["10.0.0.2"]
+---
+apiVersion: security.everoute.io/v1alpha1
+kind: GlobalPolicy
+metadata:
+  name: default
+spec:
+  defaultAction: Allow
+---
+apiVersion: security.everoute.io/v1alpha1
+kind: SecurityPolicy
+metadata:
+  name: db
+  namespace: default
+spec:
+  tier: tier2
+  appliedTo:
+  - endpointSelector:
+      matchLabels:
+        app: db
+  ingressRules:
+  - name: mysql
+    from:
+    - endpointSelector:
+        matchLabels:
+          app: web
+    ports:
+    - protocol: TCP
+      portRange: "3306"
+  defaultRule: drop
+  policyTypes: ["Ingress"]
+`
+
+func TestSimulator(t *testing.T) {
+	var input SimulatorInput
+	if err := DecodeSimulatorInput(&input, []byte(simulatorInputYAML)); err != nil {
+		t.Fatalf("unexpect decode error: %s", err)
+	}
+	if len(input.Namespaces) != 1 || len(input.Endpoints) != 2 || len(input.GlobalPolicies) != 1 || len(input.SecurityPolicies) != 1 {
+		t.Fatalf("unexpect decoded input %+v", input)
+	}
+
+	simulator, err := NewSimulator(&input)
+	if err != nil {
+		t.Fatalf("unexpect compile error: %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		packet       SimulatePacket
+		expectAction policycache.RuleAction
+		expectTier   string
+		expectRules  []string
+	}{
+		{
+			name:         "web access db mysql",
+			packet:       SimulatePacket{SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), Protocol: securityv1alpha1.ProtocolTCP, SrcPort: 40000, DstPort: 3306},
+			expectAction: policycache.RuleActionAllow,
+			expectTier:   constants.Tier2,
+			expectRules:  []string{"default/db/ingress.mysql"},
+		},
+		{
+			name:         "web access db ssh",
+			packet:       SimulatePacket{SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2"), Protocol: securityv1alpha1.ProtocolTCP, SrcPort: 40000, DstPort: 22},
+			expectAction: policycache.RuleActionDrop,
+			expectTier:   constants.Tier2,
+			expectRules:  []string{"default/db/default.ingress"},
+		},
+		{
+			name:         "db access web",
+			packet:       SimulatePacket{SrcIP: net.ParseIP("10.0.0.2"), DstIP: net.ParseIP("10.0.0.1")},
+			expectAction: policycache.RuleActionAllow,
+			expectTier:   constants.Tier2,
+			expectRules:  []string{"global-"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := simulator.Simulate(&tt.packet)
+			if result.Action != tt.expectAction {
+				t.Fatalf("expect action %s, got %+v", tt.expectAction, result)
+			}
+
+			verdict := result.Verdicts[len(result.Verdicts)-1]
+			if verdict.Tier != tt.expectTier || len(verdict.PolicyRules) != len(tt.expectRules) {
+				t.Fatalf("expect tier %s rules %v, got %+v", tt.expectTier, tt.expectRules, verdict)
+			}
+			for index, rule := range tt.expectRules {
+				if !strings.HasPrefix(verdict.PolicyRules[index], rule) {
+					t.Errorf("expect policy rule with prefix %s, got %s", rule, verdict.PolicyRules[index])
+				}
+			}
+		})
+	}
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package policy_test
 
 import (
-	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -113,7 +112,7 @@ var _ = BeforeSuite(func() {
 	Expect(datapath.ExcuteCommand(datapath.SetupBridgeChain, brName)).ToNot(HaveOccurred())
 
 	stopCh := ctrl.SetupSignalHandler()
-	updateChan := make(chan map[string]net.IP, 10)
+	updateChan := make(chan map[string]datapath.LearnedIPAddress, 10)
 	datapathManager := datapath.NewDatapathManager(&datapath.Config{ManagedVDSMap: map[string]string{
 		brName: brName,
 	}}, updateChan)
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spoofguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+var droppedPacketsDesc = prometheus.NewDesc(
+	"everoute_agent_spoof_guard_dropped_packets_total",
+	"Number of packets dropped by spoof guard of local endpoint.",
+	[]string{"interface"}, nil,
+)
+
+// Reconciler watch endpoints and sync their spoof guard policy to datapath
+type Reconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	DatapathManager *datapath.DpManager
+}
+
+// Reconcile receive endpoint from work queue, update spoof guard policy of the endpoint
+func (r *Reconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	klog.V(4).Infof("SpoofGuardReconciler received endpoint %s reconcile", req.NamespacedName)
+
+	var endpoint securityv1alpha1.Endpoint
+	err := r.Get(context.Background(), req.NamespacedName, &endpoint)
+	if client.IgnoreNotFound(err) != nil {
+		klog.Errorf("unable to fetch endpoint %s: %s", req.NamespacedName, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	if apierrors.IsNotFound(err) || endpoint.Status.MacAddress == "" {
+		err = r.DatapathManager.RemoveSpoofGuardPolicy(req.NamespacedName.String())
+	} else {
+		err = r.DatapathManager.SetSpoofGuardPolicy(req.NamespacedName.String(), toSpoofGuardPolicy(&endpoint))
+	}
+	if err != nil {
+		klog.Errorf("failed to sync endpoint %s spoof guard policy: %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager create and add spoof guard controller to the manager, and
+// register spoof guard dropped packets metric.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+
+	c, err := controller.New("spoofguard-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &securityv1alpha1.Endpoint{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	err = metrics.Registry.Register(r)
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
+
+// Describe implements prometheus.Collector
+func (r *Reconciler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- droppedPacketsDesc
+}
+
+// Collect implements prometheus.Collector
+func (r *Reconciler) Collect(ch chan<- prometheus.Metric) {
+	dropStats, err := r.DatapathManager.GetSpoofGuardDropStats()
+	if err != nil {
+		klog.Errorf("failed to get spoof guard drop stats: %s", err)
+		return
+	}
+
+	for interfaceName, packets := range dropStats {
+		ch <- prometheus.MustNewConstMetric(droppedPacketsDesc, prometheus.CounterValue, float64(packets), interfaceName)
+	}
+}
+
+func toSpoofGuardPolicy(endpoint *securityv1alpha1.Endpoint) *datapath.SpoofGuardPolicy {
+	policy := &datapath.SpoofGuardPolicy{
+		MacAddrStr: endpoint.Status.MacAddress,
+		Disabled:   endpoint.GetAnnotations()[constants.DisableSpoofGuardAnnotation] == "true",
+	}
+
+	// ips of dynamic endpoint are learned from its packets and never trusted, only ips of static
+	// endpoint, e.g. pod ips allocated by ipam, are trusted
+	if endpoint.Spec.Type == securityv1alpha1.EndpointStatic {
+		for _, ip := range endpoint.Status.IPs {
+			if parsedIP := net.ParseIP(string(ip)); parsedIP != nil {
+				policy.StaticIPs = append(policy.StaticIPs, parsedIP)
+			}
+		}
+	}
+
+	return policy
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spoofguard
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+func TestToSpoofGuardPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint *securityv1alpha1.Endpoint
+		expect   *datapath.SpoofGuardPolicy
+	}{
+		{
+			name:     "dynamic endpoint",
+			endpoint: newEndpoint(securityv1alpha1.EndpointDynamic, nil, "10.0.0.1"),
+			expect:   &datapath.SpoofGuardPolicy{MacAddrStr: "00:00:aa:aa:aa:aa"},
+		},
+		{
+			name:     "static endpoint",
+			endpoint: newEndpoint(securityv1alpha1.EndpointStatic, nil, "10.0.0.1", "fe80::1", "invalid"),
+			expect: &datapath.SpoofGuardPolicy{
+				MacAddrStr: "00:00:aa:aa:aa:aa",
+				StaticIPs:  []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1")},
+			},
+		},
+		{
+			name:     "endpoint disable spoof guard",
+			endpoint: newEndpoint(securityv1alpha1.EndpointDynamic, map[string]string{constants.DisableSpoofGuardAnnotation: "true"}),
+			expect:   &datapath.SpoofGuardPolicy{MacAddrStr: "00:00:aa:aa:aa:aa", Disabled: true},
+		},
+		{
+			name:     "endpoint with invalid annotation value",
+			endpoint: newEndpoint(securityv1alpha1.EndpointDynamic, map[string]string{constants.DisableSpoofGuardAnnotation: "yes"}),
+			expect:   &datapath.SpoofGuardPolicy{MacAddrStr: "00:00:aa:aa:aa:aa"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := toSpoofGuardPolicy(tt.endpoint)
+			if !reflect.DeepEqual(policy, tt.expect) {
+				t.Errorf("expect policy %+v, got %+v", tt.expect, policy)
+			}
+		})
+	}
+}
+
+func newEndpoint(endpointType securityv1alpha1.EndpointType, annotations map[string]string, ips ...string) *securityv1alpha1.Endpoint {
+	endpoint := &securityv1alpha1.Endpoint{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "endpoint01",
+			Annotations: annotations,
+		},
+		Spec: securityv1alpha1.EndpointSpec{
+			Type: endpointType,
+		},
+		Status: securityv1alpha1.EndpointStatus{
+			MacAddress: "00:00:aa:aa:aa:aa",
+		},
+	}
+	for _, ip := range ips {
+		endpoint.Status.IPs = append(endpoint.Status.IPs, types.IPAddress(ip))
+	}
+	return endpoint
+}
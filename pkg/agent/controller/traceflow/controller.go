@@ -0,0 +1,339 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package traceflow
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/contiv/libOpenflow/protocol"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+const (
+	// injectDelay waits agents on the destination install observation flows before inject packet.
+	injectDelay = time.Second
+	// collectTimeout is the time agents wait for the packet after observation flows installed.
+	collectTimeout = 5 * time.Second
+)
+
+// Reconciler watch traceflows, inject packet if the source endpoint is located on
+// this agent, and report the packet observed by this agent.
+type Reconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	DatapathManager *datapath.DpManager
+	AgentName       string
+
+	sessionLock sync.Mutex
+	// sessions contains traceflows handled by this agent, map traceflow name to its tag
+	sessions map[string]int32
+}
+
+// Reconcile receive traceflow from work queue, start tracing the packet if the traceflow running.
+func (r *Reconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	klog.V(4).Infof("TraceflowReconciler received traceflow %s reconcile", req.Name)
+
+	var traceflow agentv1alpha1.Traceflow
+	if err := r.Get(context.Background(), req.NamespacedName, &traceflow); client.IgnoreNotFound(err) != nil {
+		klog.Errorf("unable to fetch traceflow %s: %s", req.Name, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+
+	tag, ok := r.sessions[req.Name]
+	if traceflow.Status.Phase != agentv1alpha1.TraceflowPhaseRunning || traceflow.Status.Tag != tag {
+		if ok {
+			// the traceflow has finished or deleted, make sure flows have been removed
+			r.DatapathManager.StopTraceflow(uint8(tag))
+			delete(r.sessions, req.Name)
+			ok = false
+		}
+	}
+
+	if !ok && traceflow.Status.Phase == agentv1alpha1.TraceflowPhaseRunning && traceflow.Status.Tag != 0 {
+		r.sessions[req.Name] = traceflow.Status.Tag
+		go r.runTraceflow(traceflow.DeepCopy())
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager create and add traceflow controller to the manager.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+	r.sessions = make(map[string]int32)
+
+	c, err := controller.New("traceflow-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(&source.Kind{Type: &agentv1alpha1.Traceflow{}}, &handler.EnqueueRequestForObject{})
+}
+
+func (r *Reconciler) runTraceflow(traceflow *agentv1alpha1.Traceflow) {
+	var tag = uint8(traceflow.Status.Tag)
+
+	packet, srcEndpoint, dstEndpoint, err := r.buildPacket(traceflow)
+	if err != nil {
+		klog.Errorf("failed to build packet for traceflow %s: %s", traceflow.Name, err)
+		return
+	}
+	isSender := hasAgent(srcEndpoint, r.AgentName)
+	isReceiver := hasAgent(dstEndpoint, r.AgentName)
+	if !isSender && !isReceiver {
+		return
+	}
+
+	if err = r.DatapathManager.StartTraceflow(tag, packet); err != nil {
+		klog.Errorf("failed to start traceflow %s: %s", traceflow.Name, err)
+		return
+	}
+	start := time.Now()
+
+	var injected *datapath.TraceflowObservation
+	if isSender {
+		time.Sleep(injectDelay)
+		if injected, err = r.DatapathManager.InjectTraceflowPacket(tag, packet); err != nil {
+			klog.Errorf("failed to inject packet for traceflow %s: %s", traceflow.Name, err)
+		}
+	}
+
+	time.Sleep(collectTimeout - time.Since(start))
+	observed := r.DatapathManager.StopTraceflow(tag)
+
+	var results []agentv1alpha1.TraceflowNodeResult
+	if isSender && injected != nil {
+		observations := append([]datapath.TraceflowObservation{*injected}, observed...)
+		results = append(results, agentv1alpha1.TraceflowNodeResult{
+			Agent:        r.AgentName,
+			Role:         agentv1alpha1.TraceflowRoleSender,
+			Timestamp:    metav1.Now(),
+			Observations: toObservations(datapath.POLICY_DIRECTION_OUT, observations),
+		})
+	}
+	if isReceiver {
+		observations := toObservations(datapath.POLICY_DIRECTION_IN, observed)
+		// packet has not arrived this agent, the sender would report where it dropped
+		if len(observations) != 0 {
+			results = append(results, agentv1alpha1.TraceflowNodeResult{
+				Agent:        r.AgentName,
+				Role:         agentv1alpha1.TraceflowRoleReceiver,
+				Timestamp:    metav1.Now(),
+				Observations: observations,
+			})
+		}
+	}
+
+	if err = r.updateResults(traceflow.Name, traceflow.Status.Tag, results); err != nil {
+		klog.Errorf("failed to update traceflow %s results: %s", traceflow.Name, err)
+	}
+}
+
+// buildPacket returns the packet of traceflow, and the source and destination endpoints.
+// The destination endpoint would be nil if no endpoint has the destination ip.
+func (r *Reconciler) buildPacket(traceflow *agentv1alpha1.Traceflow) (*datapath.TraceflowPacket, *securityv1alpha1.Endpoint, *securityv1alpha1.Endpoint, error) {
+	var srcEndpoint, dstEndpoint securityv1alpha1.Endpoint
+	var err error
+
+	srcKey := types.NamespacedName{Namespace: traceflow.Spec.Source.Namespace, Name: traceflow.Spec.Source.Endpoint}
+	if err = r.Get(context.Background(), srcKey, &srcEndpoint); err != nil {
+		return nil, nil, nil, err
+	}
+
+	packet := &datapath.TraceflowPacket{
+		DstMac:  net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		SrcPort: uint16(traceflow.Spec.Packet.SrcPort),
+		DstPort: uint16(traceflow.Spec.Packet.DstPort),
+	}
+	if packet.SrcMac, err = net.ParseMAC(srcEndpoint.Status.MacAddress); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid source endpoint mac %s: %s", srcEndpoint.Status.MacAddress, err)
+	}
+	if packet.SrcIP = endpointIPv4(&srcEndpoint); packet.SrcIP == nil {
+		return nil, nil, nil, fmt.Errorf("source endpoint %s has no ipv4 address", srcKey)
+	}
+
+	switch traceflow.Spec.Packet.Protocol {
+	case string(securityv1alpha1.ProtocolTCP):
+		packet.IPProtocol = protocol.Type_TCP
+	case string(securityv1alpha1.ProtocolUDP):
+		packet.IPProtocol = protocol.Type_UDP
+	default:
+		packet.IPProtocol = protocol.Type_ICMP
+	}
+	if packet.SrcPort == 0 && packet.IPProtocol != protocol.Type_ICMP {
+		// the receiver identifies the packet by its addresses, both agents must choose the same port
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(traceflow.UID))
+		packet.SrcPort = uint16(32768 + hash.Sum32()%28232)
+	}
+
+	if traceflow.Spec.Destination.Endpoint != "" {
+		dstKey := types.NamespacedName{Namespace: traceflow.Spec.Destination.Namespace, Name: traceflow.Spec.Destination.Endpoint}
+		if err = r.Get(context.Background(), dstKey, &dstEndpoint); err != nil {
+			return nil, nil, nil, err
+		}
+		packet.DstIP = endpointIPv4(&dstEndpoint)
+	} else {
+		packet.DstIP = net.ParseIP(string(traceflow.Spec.Destination.IP))
+		var endpointList securityv1alpha1.EndpointList
+		if err = r.List(context.Background(), &endpointList); err != nil {
+			return nil, nil, nil, err
+		}
+		for _, endpoint := range endpointList.Items {
+			for _, ip := range endpoint.Status.IPs {
+				if packet.DstIP != nil && packet.DstIP.Equal(net.ParseIP(string(ip))) {
+					dstEndpoint = endpoint
+				}
+			}
+		}
+	}
+	if packet.DstIP.To4() == nil {
+		return nil, nil, nil, fmt.Errorf("traceflow destination has no ipv4 address")
+	}
+
+	if dstEndpoint.Name == "" {
+		return packet, &srcEndpoint, nil, nil
+	}
+	if dstMac, err := net.ParseMAC(dstEndpoint.Status.MacAddress); err == nil {
+		packet.DstMac = dstMac
+	}
+	return packet, &srcEndpoint, &dstEndpoint, nil
+}
+
+// updateResults replaces results of this agent in traceflow status.
+func (r *Reconciler) updateResults(name string, tag int32, results []agentv1alpha1.TraceflowNodeResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var traceflow agentv1alpha1.Traceflow
+		if err := r.Get(context.Background(), types.NamespacedName{Name: name}, &traceflow); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if traceflow.Status.Phase != agentv1alpha1.TraceflowPhaseRunning || traceflow.Status.Tag != tag {
+			return nil
+		}
+
+		var newResults []agentv1alpha1.TraceflowNodeResult
+		for _, result := range traceflow.Status.Results {
+			if result.Agent != r.AgentName {
+				newResults = append(newResults, result)
+			}
+		}
+		traceflow.Status.Results = append(newResults, results...)
+
+		return r.Status().Update(context.Background(), &traceflow)
+	})
+}
+
+// toObservations build observations in the direction from the packet observed in datapath. If
+// the packet arrived policy bridge but neither forwarded nor dropped by a rule, it's dropped by
+// the table where it arrived, e.g. invalid conntrack state.
+func toObservations(direction uint8, observed []datapath.TraceflowObservation) []agentv1alpha1.TraceflowObservation {
+	var observations []agentv1alpha1.TraceflowObservation
+	var arrivedPolicy, leftPolicy bool
+
+	for _, observation := range observed {
+		if observation.Direction != direction {
+			continue
+		}
+		switch {
+		case observation.TableID == datapath.DIRECTION_SELECTION_TABLE:
+			arrivedPolicy = true
+		case observation.TableID == datapath.CT_COMMIT_TABLE:
+			leftPolicy = true
+		case observation.Action == agentv1alpha1.TraceflowActionDropped:
+			leftPolicy = true
+		}
+		observations = append(observations, toObservation(observation))
+	}
+
+	switch {
+	case arrivedPolicy && !leftPolicy:
+		// packet arrived policy bridge, but not forwarded or dropped by rules
+		dropped := observations[len(observations)-1]
+		dropped.Action = agentv1alpha1.TraceflowActionDropped
+		observations = append(observations, dropped)
+	case direction == datapath.POLICY_DIRECTION_OUT && !arrivedPolicy && len(observations) != 0:
+		// packet injected but not arrived policy bridge, dropped in the local bridge
+		dropped := observations[0]
+		dropped.Action = agentv1alpha1.TraceflowActionDropped
+		observations = append(observations, dropped)
+	}
+
+	return observations
+}
+
+func toObservation(observation datapath.TraceflowObservation) agentv1alpha1.TraceflowObservation {
+	return agentv1alpha1.TraceflowObservation{
+		Bridge:     observation.Bridge,
+		TableID:    int32(observation.TableID),
+		TableName:  observation.TableName,
+		Action:     observation.Action,
+		PolicyRule: observation.RuleID,
+	}
+}
+
+func hasAgent(endpoint *securityv1alpha1.Endpoint, agentName string) bool {
+	if endpoint == nil {
+		return false
+	}
+	for _, agent := range endpoint.Status.Agents {
+		if agent == agentName {
+			return true
+		}
+	}
+	return false
+}
+
+func endpointIPv4(endpoint *securityv1alpha1.Endpoint) net.IP {
+	for _, ip := range endpoint.Status.IPs {
+		if parsedIP := net.ParseIP(string(ip)); parsedIP.To4() != nil {
+			return parsedIP
+		}
+	}
+	return nil
+}
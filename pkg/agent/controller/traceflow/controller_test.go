@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package traceflow
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+)
+
+var (
+	injected = datapath.TraceflowObservation{
+		Bridge: "ovsbr0", TableID: datapath.VLAN_INPUT_TABLE, TableName: "VlanInput",
+		Direction: datapath.POLICY_DIRECTION_OUT, Action: agentv1alpha1.TraceflowActionInjected,
+	}
+	egressArrived = datapath.TraceflowObservation{
+		Bridge: "ovsbr0-policy", TableID: datapath.DIRECTION_SELECTION_TABLE, TableName: "DirectionSelection",
+		Direction: datapath.POLICY_DIRECTION_OUT,
+	}
+	egressCommitted = datapath.TraceflowObservation{
+		Bridge: "ovsbr0-policy", TableID: datapath.CT_COMMIT_TABLE, TableName: "CtCommit",
+		Direction: datapath.POLICY_DIRECTION_OUT, Action: agentv1alpha1.TraceflowActionForwarded,
+	}
+	egressTier0 = datapath.TraceflowObservation{
+		Bridge: "ovsbr0-policy", TableID: datapath.EGRESS_TIER0_TABLE, TableName: "EgressTier0",
+		Direction: datapath.POLICY_DIRECTION_OUT, Action: agentv1alpha1.TraceflowActionForwarded, RuleID: "rule0",
+	}
+	egressTier1Denied = datapath.TraceflowObservation{
+		Bridge: "ovsbr0-policy", TableID: datapath.EGRESS_TIER1_TABLE, TableName: "EgressTier1",
+		Direction: datapath.POLICY_DIRECTION_OUT, Action: agentv1alpha1.TraceflowActionDropped, RuleID: "rule1",
+	}
+)
+
+func TestToObservations(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed []datapath.TraceflowObservation
+		expect   []agentv1alpha1.TraceflowObservation
+	}{
+		{
+			name:     "packet forwarded by policy",
+			observed: []datapath.TraceflowObservation{injected, egressArrived, egressTier0, egressCommitted},
+			expect: []agentv1alpha1.TraceflowObservation{
+				toObservation(injected), toObservation(egressArrived), toObservation(egressTier0), toObservation(egressCommitted),
+			},
+		},
+		{
+			name:     "packet dropped by policy",
+			observed: []datapath.TraceflowObservation{injected, egressArrived, egressTier0, egressTier1Denied},
+			expect: []agentv1alpha1.TraceflowObservation{
+				toObservation(injected), toObservation(egressArrived), toObservation(egressTier0), toObservation(egressTier1Denied),
+			},
+		},
+		{
+			name:     "packet dropped in policy bridge without rule",
+			observed: []datapath.TraceflowObservation{injected, egressArrived},
+			expect: []agentv1alpha1.TraceflowObservation{
+				toObservation(injected), toObservation(egressArrived), {
+					Bridge: "ovsbr0-policy", TableID: datapath.DIRECTION_SELECTION_TABLE, TableName: "DirectionSelection",
+					Action: agentv1alpha1.TraceflowActionDropped,
+				},
+			},
+		},
+		{
+			name:     "packet dropped in local bridge",
+			observed: []datapath.TraceflowObservation{injected},
+			expect: []agentv1alpha1.TraceflowObservation{
+				toObservation(injected), {
+					Bridge: "ovsbr0", TableID: datapath.VLAN_INPUT_TABLE, TableName: "VlanInput",
+					Action: agentv1alpha1.TraceflowActionDropped,
+				},
+			},
+		},
+		{
+			name:     "packet not arrived receiver",
+			observed: []datapath.TraceflowObservation{injected, egressArrived},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			direction := datapath.POLICY_DIRECTION_OUT
+			if tt.expect == nil {
+				direction = datapath.POLICY_DIRECTION_IN
+			}
+			observations := toObservations(uint8(direction), tt.observed)
+			if !reflect.DeepEqual(observations, tt.expect) {
+				t.Errorf("expect observations %+v, got %+v", tt.expect, observations)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// external id of qos and queues created for bandwidth limit, the value is the limit applied
+const bandwidthExternalID = "everoute-bandwidth"
+
+// Bandwidth limits traffic of an ovs interface, rates are in bits per second and bursts are in bits.
+// Zero rate means unlimited in the direction, zero burst means the default of ovs.
+type Bandwidth struct {
+	// IngressRate limits traffic sent to the interface by linux-htb qos of the port
+	IngressRate  uint64
+	IngressBurst uint64
+	// EgressRate limits traffic received from the interface by ingress policing of the interface
+	EgressRate  uint64
+	EgressBurst uint64
+}
+
+// NewBandwidth returns the bandwidth limit of rates and bursts, negative values are taken as zero.
+func NewBandwidth(ingressRate, ingressBurst, egressRate, egressBurst int64) *Bandwidth {
+	nonNegative := func(value int64) uint64 {
+		if value < 0 {
+			return 0
+		}
+		return uint64(value)
+	}
+	return &Bandwidth{
+		IngressRate:  nonNegative(ingressRate),
+		IngressBurst: nonNegative(ingressBurst),
+		EgressRate:   nonNegative(egressRate),
+		EgressBurst:  nonNegative(egressBurst),
+	}
+}
+
+// ovsQoS is a qos record created for bandwidth limit.
+type ovsQoS struct {
+	UUID      string
+	Queues    []string
+	Bandwidth string
+}
+
+// SetInterfaceBandwidth sets bandwidth limit of the ovs interface, the limit would be removed if
+// bandwidth is nil. The port of the interface must have the same name as the interface.
+func SetInterfaceBandwidth(ifaceName string, bandwidth *Bandwidth) error {
+	current, err := portBandwidthQoS(ifaceName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = ovsVsctl(bandwidthCommand(ifaceName, bandwidth, current)...); err != nil {
+		return fmt.Errorf("failed to set bandwidth of interface %s, error: %v", ifaceName, err)
+	}
+	return nil
+}
+
+// bandwidthCommand returns ovs-vsctl args applying the bandwidth to the interface. The qos of the port
+// is replaced only if the ingress limit changed, the current qos and its queues are destroyed then.
+func bandwidthCommand(ifaceName string, bandwidth *Bandwidth, current *ovsQoS) []string {
+	if bandwidth == nil {
+		bandwidth = &Bandwidth{}
+	}
+
+	// ingress policing is in kbps and kb
+	args := []string{"--", "--if-exists", "set", "Interface", ifaceName,
+		fmt.Sprintf("ingress_policing_rate=%d", (bandwidth.EgressRate+999)/1000),
+		fmt.Sprintf("ingress_policing_burst=%d", bandwidth.EgressBurst/1000)}
+
+	var desired string
+	if bandwidth.IngressRate != 0 {
+		desired = fmt.Sprintf("%d/%d", bandwidth.IngressRate, bandwidth.IngressBurst)
+	}
+	if (current == nil && desired == "") || (current != nil && current.Bandwidth == desired) {
+		return args
+	}
+
+	if desired == "" {
+		args = append(args, "--", "--if-exists", "clear", "Port", ifaceName, "qos")
+	} else {
+		maxRate := fmt.Sprintf("other-config:max-rate=%d", bandwidth.IngressRate)
+		externalID := fmt.Sprintf("external-ids:%s=%q", bandwidthExternalID, desired)
+		args = append(args, "--", "set", "Port", ifaceName, "qos=@qos",
+			"--", "--id=@qos", "create", "QoS", "type=linux-htb", maxRate, externalID, "queues:0=@queue",
+			"--", "--id=@queue", "create", "Queue", maxRate, externalID)
+		if bandwidth.IngressBurst != 0 {
+			args = append(args, fmt.Sprintf("other-config:burst=%d", bandwidth.IngressBurst))
+		}
+	}
+	if current != nil {
+		args = append(args, destroyQoSCommand(*current)...)
+	}
+	return args
+}
+
+func destroyQoSCommand(qos ovsQoS) []string {
+	args := []string{"--", "--if-exists", "destroy", "QoS", qos.UUID}
+	for _, queue := range qos.Queues {
+		args = append(args, "--", "--if-exists", "destroy", "Queue", queue)
+	}
+	return args
+}
+
+// portBandwidthQoS returns the qos created for bandwidth limit of the port, nil if the port not found
+// or its qos is not created for bandwidth limit.
+func portBandwidthQoS(portName string) (*ovsQoS, error) {
+	portRecords, err := ovsVsctlListRecords("Port", []string{portName}, "qos")
+	if err != nil {
+		return nil, err
+	}
+	if len(portRecords) == 0 || portRecords[0][0] == "" {
+		return nil, nil
+	}
+	qosRecords, err := ovsVsctlListRecords("QoS", []string{portRecords[0][0]}, "_uuid", "queues", "external_ids")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range qosRecords {
+		if qos, ok := parseBandwidthQoS(record); ok {
+			return &qos, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseBandwidthQoS parses qos record of columns _uuid, queues and external_ids, returns false if
+// the qos is not created for bandwidth limit.
+func parseBandwidthQoS(record []string) (ovsQoS, bool) {
+	bandwidth, ok := parseOvsBareMap(record[2])[bandwidthExternalID]
+	if !ok {
+		return ovsQoS{}, false
+	}
+	qos := ovsQoS{UUID: record[0], Bandwidth: bandwidth}
+	for _, queue := range parseOvsBareMap(record[1]) {
+		qos.Queues = append(qos.Queues, queue)
+	}
+	return qos, true
+}
+
+// listBandwidthQoS returns qos records created for bandwidth limit map uuid to the record,
+// and qos of all ports map port name to qos uuid.
+func listBandwidthQoS() (map[string]ovsQoS, map[string]string, error) {
+	qosRecords, err := ovsVsctlList("QoS", "_uuid", "queues", "external_ids")
+	if err != nil {
+		return nil, nil, err
+	}
+	portRecords, err := ovsVsctlList("Port", "name", "qos")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	qosMap := make(map[string]ovsQoS)
+	for _, record := range qosRecords {
+		if qos, ok := parseBandwidthQoS(record); ok {
+			qosMap[qos.UUID] = qos
+		}
+	}
+	portQoS := make(map[string]string)
+	for _, record := range portRecords {
+		if record[1] != "" {
+			portQoS[record[0]] = record[1]
+		}
+	}
+	return qosMap, portQoS, nil
+}
+
+// parseOvsBareMap parses map column in bare format, e.g. "key1=value1 key2=value2".
+func parseOvsBareMap(data string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range strings.Fields(data) {
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			m[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return m
+}
+
+// CleanOrphanBandwidth destroys qos and queues created for bandwidth limit, which are left by
+// ports deleted.
+func CleanOrphanBandwidth() error {
+	qosRecords, portQoS, err := listBandwidthQoS()
+	if err != nil {
+		return err
+	}
+
+	usedQoS := make(map[string]bool, len(portQoS))
+	for _, qosUUID := range portQoS {
+		usedQoS[qosUUID] = true
+	}
+	var args []string
+	for qosUUID, qos := range qosRecords {
+		if !usedQoS[qosUUID] {
+			args = append(args, destroyQoSCommand(qos)...)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	log.Infof("Destroy orphan qos of bandwidth limit: %v", args)
+	_, err = ovsVsctl(args...)
+	return err
+}
+
+// InterfacesWithExternalID returns names of ovs interfaces with the external id.
+func InterfacesWithExternalID(name, value string) ([]string, error) {
+	out, err := ovsVsctl("--format=csv", "--data=bare", "--no-headings", "--columns=name",
+		"find", "Interface", fmt.Sprintf("external_ids:%s=%q", name, value))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
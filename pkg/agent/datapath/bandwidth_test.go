@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBandwidthCommand(t *testing.T) {
+	RegisterTestingT(t)
+
+	current := &ovsQoS{UUID: "qos-uuid", Queues: []string{"queue-uuid"}, Bandwidth: "10000000/0"}
+	tests := []struct {
+		name      string
+		bandwidth *Bandwidth
+		current   *ovsQoS
+		want      string
+	}{
+		{
+			name: "should only reset ingress policing without limit",
+			want: "-- --if-exists set Interface veth0 ingress_policing_rate=0 ingress_policing_burst=0",
+		},
+		{
+			name:      "should set ingress policing of egress limit",
+			bandwidth: &Bandwidth{EgressRate: 1500, EgressBurst: 20000},
+			want:      "-- --if-exists set Interface veth0 ingress_policing_rate=2 ingress_policing_burst=20",
+		},
+		{
+			name:      "should create qos of ingress limit",
+			bandwidth: &Bandwidth{IngressRate: 10000000, IngressBurst: 1000000},
+			want: "-- --if-exists set Interface veth0 ingress_policing_rate=0 ingress_policing_burst=0 " +
+				"-- set Port veth0 qos=@qos " +
+				`-- --id=@qos create QoS type=linux-htb other-config:max-rate=10000000 external-ids:everoute-bandwidth="10000000/1000000" queues:0=@queue ` +
+				`-- --id=@queue create Queue other-config:max-rate=10000000 external-ids:everoute-bandwidth="10000000/1000000" other-config:burst=1000000`,
+		},
+		{
+			name:      "should keep qos of ingress limit unchanged",
+			bandwidth: &Bandwidth{IngressRate: 10000000},
+			current:   current,
+			want:      "-- --if-exists set Interface veth0 ingress_policing_rate=0 ingress_policing_burst=0",
+		},
+		{
+			name:      "should replace qos of ingress limit changed",
+			bandwidth: &Bandwidth{IngressRate: 20000000},
+			current:   current,
+			want: "-- --if-exists set Interface veth0 ingress_policing_rate=0 ingress_policing_burst=0 " +
+				"-- set Port veth0 qos=@qos " +
+				`-- --id=@qos create QoS type=linux-htb other-config:max-rate=20000000 external-ids:everoute-bandwidth="20000000/0" queues:0=@queue ` +
+				`-- --id=@queue create Queue other-config:max-rate=20000000 external-ids:everoute-bandwidth="20000000/0" ` +
+				"-- --if-exists destroy QoS qos-uuid -- --if-exists destroy Queue queue-uuid",
+		},
+		{
+			name:    "should remove qos of ingress limit",
+			current: current,
+			want: "-- --if-exists set Interface veth0 ingress_policing_rate=0 ingress_policing_burst=0 " +
+				"-- --if-exists clear Port veth0 qos " +
+				"-- --if-exists destroy QoS qos-uuid -- --if-exists destroy Queue queue-uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Expect(strings.Join(bandwidthCommand("veth0", tt.bandwidth, tt.current), " ")).Should(Equal(tt.want))
+		})
+	}
+}
+
+func TestParseOvsBareMap(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(parseOvsBareMap("")).Should(BeEmpty())
+	Expect(parseOvsBareMap(`everoute-bandwidth="100/0" owner=foo`)).Should(Equal(map[string]string{
+		bandwidthExternalID: "100/0",
+		"owner":             "foo",
+	}))
+}
+
+func TestParseBandwidthQoS(t *testing.T) {
+	RegisterTestingT(t)
+
+	qos, ok := parseBandwidthQoS([]string{"qos-uuid", "0=queue-uuid", `everoute-bandwidth="100/0"`})
+	Expect(ok).Should(BeTrue())
+	Expect(qos).Should(Equal(ovsQoS{UUID: "qos-uuid", Queues: []string{"queue-uuid"}, Bandwidth: "100/0"}))
+
+	// qos not created for bandwidth limit
+	_, ok = parseBandwidthQoS([]string{"qos-uuid", "0=queue-uuid", "owner=foo"})
+	Expect(ok).Should(BeFalse())
+}
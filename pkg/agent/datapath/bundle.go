@@ -0,0 +1,367 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// bundleIDs allocates id of OpenFlow bundles opened by the agent.
+var bundleIDs uint32
+
+type policyRuleOperation struct {
+	rule      *EveroutePolicyRule
+	direction uint8
+	tier      uint8
+	isDelete  bool
+}
+
+// PolicyRuleBundle collects policy rule adds and deletes, the rule flows would be committed
+// into each policy bridge atomically with OpenFlow bundle.
+type PolicyRuleBundle struct {
+	operations []policyRuleOperation
+}
+
+func NewPolicyRuleBundle() *PolicyRuleBundle {
+	return &PolicyRuleBundle{}
+}
+
+// AddRule adds the rule into the bundle, replace the rule flow if rule with same RuleID exists.
+func (b *PolicyRuleBundle) AddRule(rule *EveroutePolicyRule, direction uint8, tier uint8) {
+	b.operations = append(b.operations, policyRuleOperation{rule: rule, direction: direction, tier: tier})
+}
+
+// RemoveRule removes the rule of the ruleID in the bundle.
+func (b *PolicyRuleBundle) RemoveRule(ruleID string) {
+	b.operations = append(b.operations, policyRuleOperation{rule: &EveroutePolicyRule{RuleID: ruleID}, isDelete: true})
+}
+
+// Len returns the number of rule adds and deletes in the bundle.
+func (b *PolicyRuleBundle) Len() int {
+	return len(b.operations)
+}
+
+// CommitPolicyRuleBundle applies rule flow adds and deletes of the bundle in order. Flows of each
+// policy bridge are committed in one OpenFlow bundle, if any flow fails, the whole bundle rolls back,
+// bundles already committed to other policy bridges are reverted with the flow mods the original
+// rule flows installed with, and datapath rules keep unchanged.
+func (datapathManager *DpManager) CommitPolicyRuleBundle(bundle *PolicyRuleBundle) (err error) {
+	if bundle.Len() == 0 {
+		return nil
+	}
+	defer func(start time.Time) { observeRuleOperation(ruleOperationCommit, start, err) }(time.Now())
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if !datapathManager.IsBridgesConnected() {
+		datapathManager.WaitForBridgeConnected()
+	}
+
+	// pendingRules map ruleID to rule entry after bundle committed, nil entry means rule deleted
+	pendingRules := make(map[string]*EveroutePolicyRuleEntry)
+	// flowMods map vdsID to flow mods of its policy bridge
+	flowMods := make(map[string][]*openflow13.FlowMod)
+	// rollbackFlowMods map vdsID to flow mods revert the committed flowMods, in reverse order
+	rollbackFlowMods := make(map[string][]*openflow13.FlowMod)
+
+	for _, operation := range bundle.operations {
+		ruleID := operation.rule.RuleID
+		oldEntry, ok := pendingRules[ruleID]
+		if !ok {
+			oldEntry = datapathManager.Rules[ruleID]
+		}
+
+		if operation.isDelete {
+			if oldEntry == nil {
+				return fmt.Errorf("rule %v not found when deleting", operation.rule)
+			}
+			for vdsID, flowEntry := range oldEntry.RuleFlowMap {
+				flowMods[vdsID] = append(flowMods[vdsID], deleteFlowMod(flowEntry))
+				rollbackFlowMods[vdsID] = append([]*openflow13.FlowMod{flowEntry.flowMod}, rollbackFlowMods[vdsID]...)
+			}
+			pendingRules[ruleID] = nil
+			continue
+		}
+
+		if oldEntry != nil && RuleIsSame(oldEntry.EveroutePolicyRule, operation.rule) {
+			log.Infof("Rule already exists. new rule: {%+v}, old rule: {%+v}", operation.rule, oldEntry.EveroutePolicyRule)
+			continue
+		}
+
+		log.Infof("Received AddRule: %+v", operation.rule)
+		ruleFlowMap := make(map[string]*FlowEntry)
+		for vdsID, bridgeChain := range datapathManager.BridgeChainMap {
+			ruleFlow, nextElem, err := bridgeChain[POLICY_BRIDGE_KEYWORD].(*PolicyBridge).newMicroSegmentRuleFlow(operation.rule, operation.direction, operation.tier)
+			if err != nil {
+				return fmt.Errorf("failed to add microsegment rule %s to vds %s: %v", ruleID, vdsID, err)
+			}
+			flowEntry, err := bridgeChain[POLICY_BRIDGE_KEYWORD].(*PolicyBridge).newRuleFlowEntry(ruleFlow, nextElem)
+			if err != nil {
+				return fmt.Errorf("failed to add microsegment rule %s to vds %s: %v", ruleID, vdsID, err)
+			}
+			ruleFlowMap[vdsID] = flowEntry
+			rollbackFlowMod := []*openflow13.FlowMod{deleteFlowMod(flowEntry)}
+
+			// old rule flow may have different match with the new one, remove it explicitly
+			if oldEntry != nil && oldEntry.RuleFlowMap[vdsID] != nil {
+				flowMods[vdsID] = append(flowMods[vdsID], deleteFlowMod(oldEntry.RuleFlowMap[vdsID]))
+				rollbackFlowMod = append(rollbackFlowMod, oldEntry.RuleFlowMap[vdsID].flowMod)
+			}
+			flowMods[vdsID] = append(flowMods[vdsID], flowEntry.flowMod)
+			rollbackFlowMods[vdsID] = append(rollbackFlowMod, rollbackFlowMods[vdsID]...)
+		}
+
+		pendingRules[ruleID] = &EveroutePolicyRuleEntry{
+			EveroutePolicyRule: operation.rule,
+			Direction:          operation.direction,
+			Tier:               operation.tier,
+			RuleFlowMap:        ruleFlowMap,
+		}
+	}
+
+	vdsIDs := make([]string, 0, len(flowMods))
+	for vdsID := range flowMods {
+		vdsIDs = append(vdsIDs, vdsID)
+	}
+	sort.Strings(vdsIDs)
+
+	for index, vdsID := range vdsIDs {
+		policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+		if err := policyBridge.commitFlowModBundle(flowMods[vdsID]); err != nil {
+			log.Errorf("Failed to commit policy rule bundle to vds %s: %v", vdsID, err)
+			// the bundle failed may have been committed if it's not confirmed in time, revert it as well
+			if rollbackErr := datapathManager.rollbackPolicyRuleBundle(vdsIDs[:index+1], rollbackFlowMods); rollbackErr != nil {
+				return fmt.Errorf("%v, and failed to rollback: %v", err, rollbackErr)
+			}
+			return err
+		}
+	}
+
+	for ruleID, ruleEntry := range pendingRules {
+		if ruleEntry == nil {
+			delete(datapathManager.Rules, ruleID)
+			continue
+		}
+		datapathManager.Rules[ruleID] = ruleEntry
+	}
+
+	return nil
+}
+
+// rollbackPolicyRuleBundle reverts bundles committed to policy bridges of the vdsIDs. If the revert
+// fails, the policy bridges are left with flows of the bundle, they would be healed by flow drift check.
+func (datapathManager *DpManager) rollbackPolicyRuleBundle(vdsIDs []string, rollbackFlowMods map[string][]*openflow13.FlowMod) error {
+	var errs []error
+	for _, vdsID := range vdsIDs {
+		policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+		if err := policyBridge.commitFlowModBundle(rollbackFlowMods[vdsID]); err != nil {
+			log.Errorf("Failed to rollback policy rule bundle of vds %s: %v", vdsID, err)
+			errs = append(errs, fmt.Errorf("vds %s: %v", vdsID, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// commitFlowModBundle commits flow mods to the policy bridge in an OpenFlow atomic bundle, over the
+// connection of the switch. Replies and errors of bundle are not dispatched by ofnet, the commit is
+// confirmed by the aggregate stats requested after the bundle: flows added exist, flows deleted not.
+func (p *PolicyBridge) commitFlowModBundle(flowMods []*openflow13.FlowMod) error {
+	sw := p.OfSwitch
+	if sw == nil {
+		return fmt.Errorf("bridge %s disconnected", p.name)
+	}
+
+	id := atomic.AddUint32(&bundleIDs, 1)
+	flags := openflow13.OFPBCT_ATOMIC | openflow13.OFPBCT_ORDERED
+	sw.Send(openflow13.NewBundleControl(&openflow13.BundleControl{BundleID: id, Type: openflow13.OFPBCT_OPEN_REQUEST, Flags: flags}))
+
+	// expectFlows map flow cookie to whether it exists after the bundle committed
+	expectFlows := make(map[uint64]bool, len(flowMods))
+	for _, flowMod := range flowMods {
+		// xid of the message must be the same as the bundle add message
+		message := *flowMod
+		bundleAdd := openflow13.NewBundleAdd(&openflow13.BundleAdd{BundleID: id, Flags: flags, Message: &message})
+		message.Header.Xid = bundleAdd.Header.Xid
+		sw.Send(bundleAdd)
+		expectFlows[flowMod.Cookie] = flowMod.Command != openflow13.FC_DELETE
+	}
+
+	sw.Send(openflow13.NewBundleControl(&openflow13.BundleControl{BundleID: id, Type: openflow13.OFPBCT_COMMIT_REQUEST, Flags: flags}))
+
+	flowIDs := make([]uint64, 0, len(expectFlows))
+	for flowID := range expectFlows {
+		flowIDs = append(flowIDs, flowID)
+	}
+	flowStats, err := p.multipartReplies.getAggregateStats(sw, openflow13.OFPTT_ALL, flowIDs)
+	if err != nil {
+		return fmt.Errorf("failed to confirm bundle %d to bridge %s: %v", id, p.name, err)
+	}
+	for flowID, exist := range expectFlows {
+		if (flowStats[flowID].FlowCount != 0) != exist {
+			return fmt.Errorf("bundle %d to bridge %s not committed, flow %#x exist %t", id, p.name, flowID, !exist)
+		}
+	}
+	return nil
+}
+
+// newRuleFlowEntry returns the entry of the rule flow and the flow mod adds it with its cookie, the
+// cookie is recorded as allocated for rule flows. Actions of the flow are not supported, the flow
+// must go to the next table or drop.
+func (p *PolicyBridge) newRuleFlowEntry(flow *ofctrl.Flow, nextElem ofctrl.FgraphElem) (*FlowEntry, error) {
+	flowMod := openflow13.NewFlowMod()
+	flowMod.Command = openflow13.FC_ADD
+	flowMod.TableId = flow.Table.TableId
+	flowMod.Priority = flow.Match.Priority
+	flowMod.Cookie = flow.FlowID
+	flowMod.CookieMask = ^uint64(0)
+	flowMod.Match = flowModMatch(&flow.Match)
+
+	switch nextElem.Type() {
+	case "table":
+		flowMod.AddInstruction(openflow13.NewInstrGotoTable(nextElem.(*ofctrl.Table).TableId))
+	case "output":
+		if nextElem.GetFlowInstr() != nil {
+			return nil, fmt.Errorf("unsupported output action %+v in bundle", nextElem)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported next element type %s in bundle", nextElem.Type())
+	}
+
+	p.ruleFlows[flow.FlowID] = flow.Table.TableId
+	return &FlowEntry{
+		Table:    flow.Table,
+		Priority: flow.Match.Priority,
+		FlowID:   flow.FlowID,
+		flowMod:  flowMod,
+	}, nil
+}
+
+// deleteFlowMod returns the flow mod deletes the flow of the entry by its cookie, as ofnet does.
+func deleteFlowMod(flowEntry *FlowEntry) *openflow13.FlowMod {
+	return deleteFlowModByCookie(flowEntry.Table.TableId, flowEntry.FlowID)
+}
+
+func deleteFlowModByCookie(tableID uint8, flowID uint64) *openflow13.FlowMod {
+	flowMod := openflow13.NewFlowMod()
+	flowMod.Command = openflow13.FC_DELETE
+	flowMod.TableId = tableID
+	flowMod.Cookie = flowID
+	flowMod.CookieMask = ^uint64(0)
+	flowMod.OutPort = openflow13.P_ANY
+	flowMod.OutGroup = openflow13.OFPG_ANY
+	return flowMod
+}
+
+// flowModMatch translates the flow match into OpenFlow 1.3 match fields, the same as ofnet does when
+// installs the flow, so the flow committed in bundle is identical to the one installed by ofnet.
+func flowModMatch(match *ofctrl.FlowMatch) openflow13.Match {
+	ofMatch := openflow13.NewMatch()
+
+	if match.InputPort != 0 {
+		ofMatch.AddField(*openflow13.NewInPortField(match.InputPort))
+	}
+	if match.MacDa != nil {
+		ofMatch.AddField(*openflow13.NewEthDstField(*match.MacDa, match.MacDaMask))
+	}
+	if match.MacSa != nil {
+		ofMatch.AddField(*openflow13.NewEthSrcField(*match.MacSa, match.MacSaMask))
+	}
+	if match.Ethertype != 0 {
+		ofMatch.AddField(*openflow13.NewEthTypeField(match.Ethertype))
+	}
+	if match.VlanId != 0 {
+		ofMatch.AddField(*openflow13.NewVlanIdField(match.VlanId, match.VlanIdMask))
+	}
+	if match.ArpOper != 0 {
+		ofMatch.AddField(*openflow13.NewArpOperField(match.ArpOper))
+	}
+	if match.ArpTpa != nil {
+		arpTpaField := openflow13.NewArpTpaField(*match.ArpTpa)
+		ofctrl.AddArpTpaMask(arpTpaField, match.ArpTpaMask)
+		ofMatch.AddField(*arpTpaField)
+	}
+	if match.IpDa != nil {
+		ofMatch.AddField(*openflow13.NewIpv4DstField(*match.IpDa, match.IpDaMask))
+	}
+	if match.IpSa != nil {
+		ofMatch.AddField(*openflow13.NewIpv4SrcField(*match.IpSa, match.IpSaMask))
+	}
+	if match.Ipv6Da != nil {
+		ofMatch.AddField(*openflow13.NewIpv6DstField(*match.Ipv6Da, match.Ipv6DaMask))
+	}
+	if match.Ipv6Sa != nil {
+		ofMatch.AddField(*openflow13.NewIpv6SrcField(*match.Ipv6Sa, match.Ipv6SaMask))
+	}
+	if match.IpProto != 0 {
+		ofMatch.AddField(*openflow13.NewIpProtoField(match.IpProto))
+	}
+	if match.IpDscp != 0 {
+		ofMatch.AddField(*openflow13.NewIpDscpField(match.IpDscp))
+	}
+
+	type portMatch struct {
+		proto        uint8
+		port, mask   uint16
+		newPortField func(port uint16) *openflow13.MatchField
+	}
+	for _, port := range []portMatch{
+		{ofctrl.IP_PROTO_TCP, match.TcpSrcPort, match.TcpSrcPortMask, openflow13.NewTcpSrcField},
+		{ofctrl.IP_PROTO_TCP, match.TcpDstPort, match.TcpDstPortMask, openflow13.NewTcpDstField},
+		{ofctrl.IP_PROTO_UDP, match.UdpSrcPort, match.UdpSrcPortMask, openflow13.NewUdpSrcField},
+		{ofctrl.IP_PROTO_UDP, match.UdpDstPort, match.UdpDstPortMask, openflow13.NewUdpDstField},
+	} {
+		if match.IpProto == port.proto && port.port != 0 {
+			portField := port.newPortField(port.port)
+			ofctrl.AddPortMask(portField, port.mask)
+			ofMatch.AddField(*portField)
+		}
+	}
+	if match.IpProto == ofctrl.IP_PROTO_TCP && match.TcpFlags != nil {
+		ofMatch.AddField(*openflow13.NewTcpFlagsField(*match.TcpFlags, match.TcpFlagsMask))
+	}
+
+	if match.Metadata != nil {
+		ofMatch.AddField(*openflow13.NewMetadataField(*match.Metadata, match.MetadataMask))
+	}
+	if match.TunnelId != 0 {
+		ofMatch.AddField(*openflow13.NewTunnelIdField(match.TunnelId))
+	}
+	if match.CtStates != nil {
+		ofMatch.AddField(*openflow13.NewCTStateMatchField(match.CtStates))
+	}
+	if match.PktMark != 0 {
+		pktMarkField, _ := openflow13.FindFieldHeaderByName("NXM_NX_PKT_MARK", match.PktMarkMask != nil)
+		pktMarkField.Value = &openflow13.Uint32Message{Data: match.PktMark}
+		if match.PktMarkMask != nil {
+			pktMarkField.Mask = &openflow13.Uint32Message{Data: *match.PktMarkMask}
+		}
+		ofMatch.AddField(*pktMarkField)
+	}
+	for _, reg := range match.Regs {
+		ofMatch.AddField(*openflow13.NewRegMatchField(reg.RegID, reg.Data, reg.Range))
+	}
+
+	return *ofMatch
+}
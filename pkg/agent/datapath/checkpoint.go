@@ -0,0 +1,232 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+	"github.com/contiv/ofnet/ofctrl/cookie"
+)
+
+// PolicyRuleCheckpoint is the persisted state of a policy rule and its flows in each vds.
+type PolicyRuleCheckpoint struct {
+	Rule      *EveroutePolicyRule       `json:"rule"`
+	Direction uint8                     `json:"direction"`
+	Tier      uint8                     `json:"tier"`
+	Flows     map[string]FlowCheckpoint `json:"flows"` // map vds to the rule flow
+}
+
+// FlowCheckpoint is the persisted state of a flow installed in policy bridge.
+type FlowCheckpoint struct {
+	TableID  uint8  `json:"tableID"`
+	Priority uint16 `json:"priority"`
+	FlowID   uint64 `json:"flowID"`
+}
+
+// ListPolicyRuleCheckpoints returns checkpoints of all rules in datapath.
+func (datapathManager *DpManager) ListPolicyRuleCheckpoints() []PolicyRuleCheckpoint {
+	datapathManager.flowReplayMutex.RLock()
+	defer datapathManager.flowReplayMutex.RUnlock()
+
+	checkpoints := make([]PolicyRuleCheckpoint, 0, len(datapathManager.Rules))
+	for _, ruleEntry := range datapathManager.Rules {
+		rule := *ruleEntry.EveroutePolicyRule
+		checkpoint := PolicyRuleCheckpoint{
+			Rule:      &rule,
+			Direction: ruleEntry.Direction,
+			Tier:      ruleEntry.Tier,
+			Flows:     make(map[string]FlowCheckpoint, len(ruleEntry.RuleFlowMap)),
+		}
+		for vdsID, flowEntry := range ruleEntry.RuleFlowMap {
+			checkpoint.Flows[vdsID] = FlowCheckpoint{
+				TableID:  flowEntry.Table.TableId,
+				Priority: flowEntry.Priority,
+				FlowID:   flowEntry.FlowID,
+			}
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	return checkpoints
+}
+
+// AdoptPolicyRules restores datapath rules from checkpoints. Rule flow still in the policy bridge is
+// adopted by replacing it with the flow of the rule in an atomic bundle, so the flow keeps its cookie
+// and has the full match and actions of the rule, restart never interrupts the traffic. Missing flows
+// would be installed again. It should be called once before any rule added, stale flows of previous
+// rounds in policy bridge are not cleaned until it returns.
+func (datapathManager *DpManager) AdoptPolicyRules(checkpoints []PolicyRuleCheckpoint) (adopted, installed int, err error) {
+	defer datapathManager.policyRulesAdoptOnce.Do(func() { close(datapathManager.policyRulesAdopted) })
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if !datapathManager.IsBridgesConnected() {
+		datapathManager.WaitForBridgeConnected()
+	}
+
+	// rollback on failure, flows not adopted would be removed as stale flows of previous rounds
+	var restoredRules []string
+	var installedFlowEntries []*FlowEntry
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, flowEntry := range installedFlowEntries {
+			if err := ofctrl.DeleteFlow(flowEntry.Table, flowEntry.Priority, flowEntry.FlowID); err != nil {
+				log.Errorf("Failed to rollback flow %#x: %v", flowEntry.FlowID, err)
+			}
+		}
+		for _, ruleID := range restoredRules {
+			delete(datapathManager.Rules, ruleID)
+		}
+	}()
+
+	// installedFlows map vds to flows in its policy bridge
+	installedFlows := make(map[string]map[uint64]uint8)
+	// adoptFlowMods map vds to flow mods replace the installed flows with flows of rules
+	adoptFlowMods := make(map[string][]*openflow13.FlowMod)
+	for vdsID := range datapathManager.BridgeChainMap {
+		policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+		installedFlows[vdsID], err = dumpOwnedFlows(policyBridge.name, func(uint64) bool { return true })
+		if err != nil {
+			return adopted, installed, err
+		}
+	}
+
+	for _, checkpoint := range checkpoints {
+		ruleEntry := &EveroutePolicyRuleEntry{
+			EveroutePolicyRule: checkpoint.Rule,
+			Direction:          checkpoint.Direction,
+			Tier:               checkpoint.Tier,
+			RuleFlowMap:        make(map[string]*FlowEntry),
+		}
+
+		for vdsID := range datapathManager.BridgeChainMap {
+			policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+
+			flow, ok := checkpoint.Flows[vdsID]
+			if _, exist := installedFlows[vdsID][flow.FlowID]; ok && exist {
+				ruleFlow, nextElem, newErr := policyBridge.newMicroSegmentRuleFlow(checkpoint.Rule, checkpoint.Direction, checkpoint.Tier)
+				if newErr != nil {
+					err = fmt.Errorf("failed to adopt rule %s flow of vds %s: %v", checkpoint.Rule.RuleID, vdsID, newErr)
+					return adopted, installed, err
+				}
+				ruleFlow.FlowID = flow.FlowID
+				if flowEntry, newErr := policyBridge.newRuleFlowEntry(ruleFlow, nextElem); newErr == nil {
+					// delete the flow in any table with the cookie, and add the flow of the rule back
+					adoptFlowMods[vdsID] = append(adoptFlowMods[vdsID],
+						deleteFlowModByCookie(openflow13.OFPTT_ALL, flow.FlowID), flowEntry.flowMod)
+					ruleEntry.RuleFlowMap[vdsID] = flowEntry
+					adopted++
+					continue
+				}
+			}
+
+			flowEntry, addErr := policyBridge.AddMicroSegmentRule(checkpoint.Rule, checkpoint.Direction, checkpoint.Tier)
+			if addErr != nil {
+				err = fmt.Errorf("failed to install rule %s flow to vds %s: %v", checkpoint.Rule.RuleID, vdsID, addErr)
+				return adopted, installed, err
+			}
+			ruleEntry.RuleFlowMap[vdsID] = flowEntry
+			installedFlowEntries = append(installedFlowEntries, flowEntry)
+			installed++
+		}
+
+		datapathManager.Rules[checkpoint.Rule.RuleID] = ruleEntry
+		restoredRules = append(restoredRules, checkpoint.Rule.RuleID)
+	}
+
+	for vdsID, flowMods := range adoptFlowMods {
+		policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+		if err = policyBridge.commitFlowModBundle(flowMods); err != nil {
+			err = fmt.Errorf("failed to adopt rule flows of vds %s: %v", vdsID, err)
+			return adopted, installed, err
+		}
+	}
+
+	log.Infof("Restore %d rules from checkpoint, adopted %d flows, installed %d flows", len(checkpoints), adopted, installed)
+	return adopted, installed, nil
+}
+
+// waitPolicyRulesAdopted blocks until policy rules adopted from checkpoint, returns false if stopped.
+func (datapathManager *DpManager) waitPolicyRulesAdopted(stopChan <-chan struct{}) bool {
+	select {
+	case <-datapathManager.policyRulesAdopted:
+		return true
+	case <-stopChan:
+		return false
+	}
+}
+
+// cleanPolicyBridgeStaleFlows removes flows with cookie of previous rounds from the vds policy bridge,
+// except rule flows adopted from checkpoint.
+func (datapathManager *DpManager) cleanPolicyBridgeStaleFlows(vdsID string, roundInfo *RoundInfo) error {
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+
+	adoptedFlows := make(map[uint64]struct{})
+	for _, ruleEntry := range datapathManager.Rules {
+		if flowEntry := ruleEntry.RuleFlowMap[vdsID]; flowEntry != nil {
+			adoptedFlows[flowEntry.FlowID] = struct{}{}
+		}
+	}
+
+	policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+	staleFlows, err := dumpOwnedFlows(policyBridge.name, func(flowCookie uint64) bool {
+		_, adopted := adoptedFlows[flowCookie]
+		round := cookie.ID(flowCookie).Round()
+		return !adopted && round != 0 && round != roundInfo.curRoundNum
+	})
+	if err != nil {
+		return err
+	}
+
+	var flowMods []*openflow13.FlowMod
+	for flowCookie, tableID := range staleFlows {
+		flowMods = append(flowMods, deleteFlowModByCookie(tableID, flowCookie))
+	}
+	if len(flowMods) == 0 {
+		return nil
+	}
+	return policyBridge.commitFlowModBundle(flowMods)
+}
+
+// reinstallAdoptedRuleFlows deletes all flows of previous round from the vds policy bridge, and
+// reinstalls rule flows adopted from checkpoint with cookie of current round.
+func (datapathManager *DpManager) reinstallAdoptedRuleFlows(vdsID string, roundInfo *RoundInfo) error {
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+
+	policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+	policyBridge.OfSwitch.DeleteFlowByRoundInfo(roundInfo.previousRoundNum)
+
+	for ruleID, ruleEntry := range datapathManager.Rules {
+		flowEntry := ruleEntry.RuleFlowMap[vdsID]
+		if flowEntry == nil || cookie.ID(flowEntry.FlowID).Round() == roundInfo.curRoundNum {
+			continue
+		}
+		flowEntry, err := policyBridge.AddMicroSegmentRule(ruleEntry.EveroutePolicyRule, ruleEntry.Direction, ruleEntry.Tier)
+		if err != nil {
+			return fmt.Errorf("failed to reinstall rule %s flow: %v", ruleID, err)
+		}
+		ruleEntry.RuleFlowMap[vdsID] = flowEntry
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/ofnet/ovsdbDriver"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// UpdateDatapathConfig applies config changes at runtime. VDS added into ManagedVDSMap would be
+// initialized, VDS removed would be torn down, and rules of InternalIPs would be updated
+// incrementally. Other config changes take effect after agent restart.
+func (datapathManager *DpManager) UpdateDatapathConfig(newConfig *Config, stopChan <-chan struct{}) error {
+	datapathManager.configMutex.Lock()
+	defer datapathManager.configMutex.Unlock()
+
+	var errs []error
+	oldConfig := datapathManager.datapathConfig
+
+	for vdsID, ovsbrname := range oldConfig.ManagedVDSMap {
+		if newOvsbrname, ok := newConfig.ManagedVDSMap[vdsID]; !ok || newOvsbrname != ovsbrname {
+			log.Infof("Remove vds %s bridge %s from datapath", vdsID, ovsbrname)
+			if err := datapathManager.removeVDS(vdsID); err != nil {
+				errs = append(errs, fmt.Errorf("remove vds %s: %v", vdsID, err))
+			}
+		}
+	}
+
+	for vdsID, ovsbrname := range newConfig.ManagedVDSMap {
+		if oldOvsbrname, ok := oldConfig.ManagedVDSMap[vdsID]; !ok || oldOvsbrname != ovsbrname {
+			log.Infof("Add vds %s bridge %s into datapath", vdsID, ovsbrname)
+			if err := datapathManager.addVDS(vdsID, ovsbrname, stopChan); err != nil {
+				errs = append(errs, fmt.Errorf("add vds %s: %v", vdsID, err))
+			}
+		}
+	}
+
+	if err := datapathManager.updateInternalIPRules(oldConfig.InternalIPs, newConfig.InternalIPs); err != nil {
+		errs = append(errs, fmt.Errorf("update internal ips: %v", err))
+	} else {
+		datapathManager.setInternalIPs(newConfig.InternalIPs)
+	}
+
+	if oldConfig.EnableDHCPSnooping != newConfig.EnableDHCPSnooping || oldConfig.EnableSpoofGuard != newConfig.EnableSpoofGuard ||
+		oldConfig.FlowDriftCheckInterval != newConfig.FlowDriftCheckInterval {
+		log.Warningf("Config enableDHCPSnooping, enableSpoofGuard and flowDriftCheckInterval take effect after agent restart")
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// addVDS creates bridge chain of the vds, installs base flows and replays all policy rules.
+// Bridges of the vds would be created if not exist. The partial vds would be removed on failure.
+func (datapathManager *DpManager) addVDS(vdsID, ovsbrname string, stopChan <-chan struct{}) (err error) {
+	if err = createVDSBridges(ovsbrname); err != nil {
+		return err
+	}
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rollbackErr := datapathManager.deleteVDS(vdsID, ovsbrname); rollbackErr != nil {
+			err = fmt.Errorf("%v, and failed to rollback: %v", err, rollbackErr)
+		}
+	}()
+
+	if err = NewVDSForConfig(datapathManager, vdsID, ovsbrname); err != nil {
+		return err
+	}
+	if err = datapathManager.waitForVDSConnected(vdsID); err != nil {
+		return err
+	}
+	if err = InitializeVDS(datapathManager, vdsID, datapathManager.newVDSStopChan(vdsID, stopChan)); err != nil {
+		return err
+	}
+	datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].BridgeInitCNI()
+	datapathManager.BridgeChainMap[vdsID][UPLINK_BRIDGE_KEYWORD].BridgeInitCNI()
+
+	if err = datapathManager.ReplayVDSMicroSegmentFlow(vdsID); err != nil {
+		return err
+	}
+
+	datapathManager.setManagedVDS(vdsID, ovsbrname)
+	datapathManager.watchVDSReconnect(vdsID)
+	return nil
+}
+
+// waitForVDSConnected waits for bridges of the vds connected, returns error on timeout.
+func (datapathManager *DpManager) waitForVDSConnected(vdsID string) error {
+	err := wait.PollImmediate(time.Second, vdsConnectTimeout, func() (bool, error) {
+		for _, bridge := range datapathManager.BridgeChainMap[vdsID] {
+			if !bridge.IsSwitchConnected() {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("bridge chain of vds %s failed to connect in %s: %v", vdsID, vdsConnectTimeout, err)
+	}
+	return nil
+}
+
+// removeVDS stops goroutines of the vds, disconnects from the bridges, and restores the vds to
+// a plain learning bridge as teardown does.
+func (datapathManager *DpManager) removeVDS(vdsID string) error {
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+
+	return datapathManager.deleteVDS(vdsID, datapathManager.managedVDSMap()[vdsID])
+}
+
+// deleteVDS removes the vds from datapath and tears down its bridges, the caller should hold
+// flowReplayMutex.
+func (datapathManager *DpManager) deleteVDS(vdsID, ovsbrname string) error {
+	datapathManager.setManagedVDS(vdsID, "")
+	datapathManager.stopVDS(vdsID)
+
+	for _, controller := range datapathManager.ControllerMap[vdsID] {
+		controller.Delete()
+	}
+	for _, driver := range datapathManager.OvsdbDriverMap[vdsID] {
+		closeOvsdbDriver(driver)
+	}
+	_, err := teardownVDS(vdsID, ovsbrname, false)
+
+	for _, ruleEntry := range datapathManager.Rules {
+		delete(ruleEntry.RuleFlowMap, vdsID)
+	}
+	for endpointObj := range datapathManager.localEndpointDB.IterBuffered() {
+		if endpointObj.Val.(*Endpoint).BridgeName == ovsbrname {
+			datapathManager.localEndpointDB.Remove(endpointObj.Key)
+		}
+	}
+	delete(datapathManager.flowDriftBaseFlows, vdsID)
+	datapathManager.setVDSBridgeChain(vdsID, nil, nil, nil)
+
+	return err
+}
+
+// closeOvsdbDriver closes the ovsdb connection of the driver. OvsDriver.Delete deletes the bridge
+// named OvsBridgeName before disconnecting, clear the name, so no bridge would be deleted.
+func closeOvsdbDriver(driver *ovsdbDriver.OvsDriver) {
+	if driver == nil {
+		return
+	}
+	driver.OvsBridgeName = ""
+	_ = driver.Delete()
+}
+
+// setManagedVDS sets vds into a copy of ManagedVDSMap, so goroutines ranging over the old map
+// would not be affected. Empty ovsbrname removes the vds.
+func (datapathManager *DpManager) setManagedVDS(vdsID, ovsbrname string) {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+
+	managedVDSMap := make(map[string]string, len(datapathManager.datapathConfig.ManagedVDSMap)+1)
+	for id, name := range datapathManager.datapathConfig.ManagedVDSMap {
+		managedVDSMap[id] = name
+	}
+	if ovsbrname == "" {
+		delete(managedVDSMap, vdsID)
+	} else {
+		managedVDSMap[vdsID] = ovsbrname
+	}
+	datapathManager.datapathConfig.ManagedVDSMap = managedVDSMap
+}
+
+// setInternalIPs sets a copy of internalIPs into config, the slice of the caller would never be shared.
+func (datapathManager *DpManager) setInternalIPs(internalIPs []string) {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+
+	datapathManager.datapathConfig.InternalIPs = append([]string(nil), internalIPs...)
+}
+
+// updateInternalIPRules adds rules of internal ips added, and removes rules of internal ips removed.
+func (datapathManager *DpManager) updateInternalIPRules(oldInternalIPs, newInternalIPs []string) error {
+	oldIPSet, newIPSet := sets.NewString(oldInternalIPs...), sets.NewString(newInternalIPs...)
+
+	for _, internalIP := range oldIPSet.Difference(newIPSet).List() {
+		if err := datapathManager.RemoveEveroutePolicyRule(newInternalIngressRule(internalIP)); err != nil {
+			return err
+		}
+		if err := datapathManager.RemoveEveroutePolicyRule(newInternalEgressRule(internalIP)); err != nil {
+			return err
+		}
+	}
+
+	for _, internalIP := range newIPSet.Difference(oldIPSet).List() {
+		// internal ingress rule
+		if err := datapathManager.AddEveroutePolicyRule(newInternalIngressRule(internalIP), POLICY_DIRECTION_IN, POLICY_TIER2); err != nil {
+			return err
+		}
+		// internal egress rule
+		if err := datapathManager.AddEveroutePolicyRule(newInternalEgressRule(internalIP), POLICY_DIRECTION_OUT, POLICY_TIER2); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func vdsBridgeNames(ovsbrname string) []string {
+	return []string{
+		ovsbrname,
+		fmt.Sprintf("%s-%s", ovsbrname, POLICY_BRIDGE_KEYWORD),
+		fmt.Sprintf("%s-%s", ovsbrname, CLS_BRIDGE_KEYWORD),
+		fmt.Sprintf("%s-%s", ovsbrname, UPLINK_BRIDGE_KEYWORD),
+	}
+}
+
+// createVDSBridges creates bridges of the vds chain which not exist, and patch ports connect
+// the created bridges to their neighbours.
+func createVDSBridges(ovsbrname string) error {
+	bridgeNames := vdsBridgeNames(ovsbrname)
+	created := make(map[string]bool, len(bridgeNames))
+
+	var args []string
+	for _, bridgeName := range bridgeNames {
+		if bridgeExists(bridgeName) {
+			continue
+		}
+		created[bridgeName] = true
+		args = append(args, "--", "add-br", bridgeName, "--", "set", "bridge", bridgeName, "fail_mode=secure")
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	// patch ports between neighbour bridges: local-policy, policy-cls, cls-uplink
+	bridgeKeywords := []string{LOCAL_BRIDGE_KEYWORD, POLICY_BRIDGE_KEYWORD, CLS_BRIDGE_KEYWORD, UPLINK_BRIDGE_KEYWORD}
+	patchOfports := [][2]int{
+		{LOCAL_TO_POLICY_PORT, POLICY_TO_LOCAL_PORT},
+		{POLICY_TO_CLS_PORT, CLS_TO_POLICY_PORT},
+		{CLS_TO_UPLINK_PORT, UPLINK_TO_CLS_PORT},
+	}
+	for index, ofports := range patchOfports {
+		bridge, peerBridge := bridgeNames[index], bridgeNames[index+1]
+		if !created[bridge] && !created[peerBridge] {
+			continue
+		}
+		port := fmt.Sprintf("%s-%s-to-%s", ovsbrname, bridgeKeywords[index], bridgeKeywords[index+1])
+		peerPort := fmt.Sprintf("%s-%s-to-%s", ovsbrname, bridgeKeywords[index+1], bridgeKeywords[index])
+		args = append(args,
+			"--", "--may-exist", "add-port", bridge, port,
+			"--", "set", "interface", port, "type=patch", "options:peer="+peerPort, fmt.Sprintf("ofport_request=%d", ofports[0]),
+			"--", "--may-exist", "add-port", peerBridge, peerPort,
+			"--", "set", "interface", peerPort, "type=patch", "options:peer="+port, fmt.Sprintf("ofport_request=%d", ofports[1]),
+		)
+	}
+
+	log.Infof("Create bridges of vds bridge %s", ovsbrname)
+	if out, err := exec.Command("ovs-vsctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create bridges of vds bridge %s: %s, error: %v", ovsbrname, string(out), err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// RuleFlows is the ovs flows of a datapath rule on a vds.
+type RuleFlows struct {
+	VDS      string   `json:"vds"`
+	Bridge   string   `json:"bridge"`
+	TableID  uint8    `json:"tableID"`
+	Priority uint16   `json:"priority"`
+	FlowID   uint64   `json:"flowID"`
+	Flows    []string `json:"flows"`
+}
+
+// ListLocalEndpoints return a copy of all local endpoints, used for debug.
+func (datapathManager *DpManager) ListLocalEndpoints() []Endpoint {
+	var endpoints []Endpoint
+	for endpointObj := range datapathManager.localEndpointDB.IterBuffered() {
+		endpoints = append(endpoints, *endpointObj.Val.(*Endpoint))
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].InterfaceName < endpoints[j].InterfaceName
+	})
+	return endpoints
+}
+
+// ListRules return a copy of all rules in datapath, used for debug.
+func (datapathManager *DpManager) ListRules() []EveroutePolicyRuleEntry {
+	datapathManager.flowReplayMutex.RLock()
+	defer datapathManager.flowReplayMutex.RUnlock()
+
+	rules := make([]EveroutePolicyRuleEntry, 0, len(datapathManager.Rules))
+	for _, ruleEntry := range datapathManager.Rules {
+		rule := *ruleEntry.EveroutePolicyRule
+		rules = append(rules, EveroutePolicyRuleEntry{
+			EveroutePolicyRule: &rule,
+			Direction:          ruleEntry.Direction,
+			Tier:               ruleEntry.Tier,
+		})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].EveroutePolicyRule.RuleID < rules[j].EveroutePolicyRule.RuleID
+	})
+	return rules
+}
+
+// GetRuleFlows return ovs flows of the rule on each vds, used for debug.
+func (datapathManager *DpManager) GetRuleFlows(ruleID string) ([]RuleFlows, error) {
+	datapathManager.flowReplayMutex.RLock()
+	defer datapathManager.flowReplayMutex.RUnlock()
+
+	ruleEntry, ok := datapathManager.Rules[ruleID]
+	if !ok {
+		return nil, fmt.Errorf("rule %s not found in datapath", ruleID)
+	}
+
+	var ruleFlowsList []RuleFlows
+	for vdsID, flowEntry := range ruleEntry.RuleFlowMap {
+		if flowEntry == nil {
+			continue
+		}
+		policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+		ruleFlows := RuleFlows{
+			VDS:      vdsID,
+			Bridge:   policyBridge.name,
+			TableID:  flowEntry.Table.TableId,
+			Priority: flowEntry.Priority,
+			FlowID:   flowEntry.FlowID,
+		}
+
+		cmdStr := fmt.Sprintf("ovs-ofctl -O Openflow13 dump-flows %s cookie=%#x/-1", policyBridge.name, flowEntry.FlowID)
+		out, err := exec.Command("/bin/sh", "-c", cmdStr).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump flows of rule %s: %s, error: %v", ruleID, string(out), err)
+		}
+		// skip the reply header
+		for _, flow := range strings.Split(string(out), "\n")[1:] {
+			if flow = strings.TrimSpace(flow); flow != "" {
+				ruleFlows.Flows = append(ruleFlows.Flows, flow)
+			}
+		}
+		ruleFlowsList = append(ruleFlowsList, ruleFlows)
+	}
+
+	sort.Slice(ruleFlowsList, func(i, j int) bool {
+		return ruleFlowsList[i].VDS < ruleFlowsList[j].VDS
+	})
+	return ruleFlowsList, nil
+}
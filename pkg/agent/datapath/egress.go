@@ -0,0 +1,223 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+)
+
+const (
+	// pkt_mark[16..31] is the id of egress rule of the traffic, pkt_mark[0] is used by local gateway,
+	// pkt_mark[1] is used by node port, pkt_mark[8..13] is used by traceflow
+	egressMarkShift        = 16
+	EgressMarkMask  uint32 = 0xffff0000
+)
+
+// EgressRule marks traffic from local pods to outside of the cluster with its id, the marked traffic
+// is routed to the egress node by kernel. In overlay mode, the marked traffic routed back to gateway
+// would be encapsulated to TunnelDst, the node ip of the egress node.
+type EgressRule struct {
+	ID        uint16
+	PodIPs    []net.IP
+	TunnelDst net.IP
+}
+
+// EgressMark returns the pkt_mark of traffic matching the egress rule of id.
+func EgressMark(id uint16) uint32 {
+	return uint32(id) << egressMarkShift
+}
+
+// SyncEgressRules replaces all egress rules, map egress policy namespaced name to its rule. Flows of
+// rules removed or changed would be updated on local bridges, and uplink bridges in overlay mode.
+func (datapathManager *DpManager) SyncEgressRules(rules map[string]*EgressRule) error {
+	if !datapathManager.AgentInfo.EnableCNI {
+		return nil
+	}
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if !datapathManager.IsBridgesConnected() {
+		datapathManager.WaitForBridgeConnected()
+	}
+
+	datapathManager.egressMutex.Lock()
+	datapathManager.egressRules = rules
+	datapathManager.egressMutex.Unlock()
+
+	for vdsID := range datapathManager.managedVDSMap() {
+		vdsBridgeMap, ok := datapathManager.BridgeChainMap[vdsID]
+		if !ok {
+			continue
+		}
+		localBridge := vdsBridgeMap[LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+		if err := localBridge.syncEgressFlows(rules); err != nil {
+			return fmt.Errorf("failed to sync egress rules of bridge %s, error: %v", localBridge.name, err)
+		}
+		if datapathManager.AgentInfo.TunnelType == "" {
+			continue
+		}
+		uplinkBridge := vdsBridgeMap[UPLINK_BRIDGE_KEYWORD].(*UplinkBridge)
+		if err := uplinkBridge.syncEgressFlows(rules); err != nil {
+			return fmt.Errorf("failed to sync egress rules of bridge %s, error: %v", uplinkBridge.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (datapathManager *DpManager) getEgressRules() map[string]*EgressRule {
+	datapathManager.egressMutex.Lock()
+	defer datapathManager.egressMutex.Unlock()
+	return datapathManager.egressRules
+}
+
+// egressFlowSet is the egress rules installed on a bridge and their flows.
+type egressFlowSet struct {
+	rules map[string]*EgressRule    // map egress policy to its rule installed
+	flows map[string][]*ofctrl.Flow // map egress policy to flows of its rule
+}
+
+func newEgressFlowSet() *egressFlowSet {
+	return &egressFlowSet{
+		rules: make(map[string]*EgressRule),
+		flows: make(map[string][]*ofctrl.Flow),
+	}
+}
+
+// sync removes flows of rules removed or changed, and adds flows of rules new or changed by addFlows.
+func (s *egressFlowSet) sync(rules map[string]*EgressRule, bridge string,
+	addFlows func(rule *EgressRule) ([]*ofctrl.Flow, error)) error {
+	for name, rule := range s.rules {
+		if newRule, ok := rules[name]; ok && reflect.DeepEqual(newRule, rule) {
+			continue
+		}
+		for _, flow := range s.flows[name] {
+			if err := flow.Delete(); err != nil {
+				return err
+			}
+		}
+		delete(s.flows, name)
+		delete(s.rules, name)
+		log.Infof("Remove egress rule %s from bridge %s", name, bridge)
+	}
+
+	for name, rule := range rules {
+		if _, ok := s.rules[name]; ok {
+			continue
+		}
+		flows, err := addFlows(rule)
+		if err != nil {
+			for _, flow := range flows {
+				_ = flow.Delete()
+			}
+			return fmt.Errorf("failed to add egress rule %s, error: %v", name, err)
+		}
+		s.flows[name] = flows
+		s.rules[name] = rule
+		log.Infof("Add egress rule %s id %d pods %v to bridge %s", name, rule.ID, rule.PodIPs, bridge)
+	}
+
+	return nil
+}
+
+// initEgress installs flows of known egress rules again.
+func (l *LocalBridge) initEgress() error {
+	l.egressFlowSet = newEgressFlowSet()
+	return l.syncEgressFlows(l.datapathManager.getEgressRules())
+}
+
+func (l *LocalBridge) syncEgressFlows(rules map[string]*EgressRule) error {
+	return l.egressFlowSet.sync(rules, l.name, l.addEgressFlows)
+}
+
+// addEgressFlows marks traffic from the pods of the rule, which is not sent to local gateway as traffic
+// inside the cluster, or selected by service load balancing.
+func (l *LocalBridge) addEgressFlows(rule *EgressRule) ([]*ofctrl.Flow, error) {
+	var flows []*ofctrl.Flow
+	outputPortPolicy, _ := l.OfSwitch.OutputPort(LOCAL_TO_POLICY_PORT)
+
+	for i := range rule.PodIPs {
+		podIP := rule.PodIPs[i]
+		match := ofctrl.FlowMatch{
+			Priority:  MID_MATCH_FLOW_PRIORITY + FLOW_MATCH_OFFSET,
+			Ethertype: PROTOCOL_IP,
+			IpSa:      &podIP,
+		}
+		if podIP.To4() == nil {
+			match.Ethertype, match.IpSa, match.Ipv6Sa = PROTOCOL_IPV6, nil, &podIP
+		}
+
+		egressMarkFlow, _ := l.fromLocalRedirectTable.NewFlow(match)
+		if err := egressMarkFlow.LoadField("nxm_nx_pkt_mark", uint64(rule.ID),
+			openflow13.NewNXRange(egressMarkShift, 31)); err != nil {
+			return flows, err
+		}
+		if err := egressMarkFlow.Next(outputPortPolicy); err != nil {
+			return flows, fmt.Errorf("failed to install egressMarkFlow flow, error: %v", err)
+		}
+		flows = append(flows, egressMarkFlow)
+	}
+
+	return flows, nil
+}
+
+// initEgress installs flows of known egress rules again.
+func (u *UplinkBridge) initEgress() error {
+	u.egressFlowSet = newEgressFlowSet()
+	return u.syncEgressFlows(u.datapathManager.getEgressRules())
+}
+
+func (u *UplinkBridge) syncEgressFlows(rules map[string]*EgressRule) error {
+	return u.egressFlowSet.sync(rules, u.name, u.addEgressFlows)
+}
+
+// addEgressFlows encapsulates the marked traffic routed to gateway to the egress node. The traffic
+// to pod cidrs of peers is matched by tunnel peer flows with higher priority.
+func (u *UplinkBridge) addEgressFlows(rule *EgressRule) ([]*ofctrl.Flow, error) {
+	if rule.TunnelDst == nil {
+		return nil, nil
+	}
+	tunnelDst := rule.TunnelDst.To4()
+	if tunnelDst == nil {
+		return nil, fmt.Errorf("tunnel dst %s is not ipv4, only ipv4 underlay supported", rule.TunnelDst)
+	}
+
+	egressMarkMask := EgressMarkMask
+	toTunnelFlow, _ := u.defaultTable.NewFlow(ofctrl.FlowMatch{
+		Priority:    NORMAL_MATCH_FLOW_PRIORITY,
+		InputPort:   uint32(UPLINK_GATEWAY_PORT),
+		PktMark:     EgressMark(rule.ID),
+		PktMarkMask: &egressMarkMask,
+	})
+	if err := toTunnelFlow.LoadField("nxm_nx_tun_ipv4_dst", uint64(binary.BigEndian.Uint32(tunnelDst)),
+		openflow13.NewNXRange(0, 31)); err != nil {
+		return nil, err
+	}
+	outputPortTunnel, _ := u.OfSwitch.OutputPort(UPLINK_TUNNEL_PORT)
+	if err := toTunnelFlow.Next(outputPortTunnel); err != nil {
+		return []*ofctrl.Flow{toTunnelFlow}, fmt.Errorf("failed to install egress toTunnelFlow flow, error: %v", err)
+	}
+
+	return []*ofctrl.Flow{toTunnelFlow}, nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl/cookie"
+)
+
+var (
+	dumpFlowCookieRegexp = regexp.MustCompile(`cookie=(0x[0-9a-f]+)`)
+	dumpFlowTableRegexp  = regexp.MustCompile(`table=([0-9]+)`)
+)
+
+const (
+	flowDriftMissingRuleFlow = "missing_rule_flow"
+	flowDriftMissingBaseFlow = "missing_base_flow"
+	flowDriftOrphanFlow      = "orphan_flow"
+)
+
+// FlowDrift is the difference between flows expected by datapath and flows installed in the policy bridge.
+type FlowDrift struct {
+	Bridge           string
+	MissingRuleFlows int // policy rule flows not found in the bridge
+	MissingBaseFlows int // bridge base flows not found in the bridge
+	OrphanFlows      int // rule flows allocated by the agent but not belong to any policy rules
+}
+
+// IsEmpty returns true when no drift found.
+func (d *FlowDrift) IsEmpty() bool {
+	return d.MissingRuleFlows == 0 && d.MissingBaseFlows == 0 && d.OrphanFlows == 0
+}
+
+func (d *FlowDrift) String() string {
+	return fmt.Sprintf("bridge %s missing %d rule flows, missing %d base flows, found %d orphan flows",
+		d.Bridge, d.MissingRuleFlows, d.MissingBaseFlows, d.OrphanFlows)
+}
+
+// FlowDriftHandler would be called when drift found, err is the error when heal the drift.
+type FlowDriftHandler func(drift *FlowDrift, err error)
+
+// RunFlowDriftCheck periodically checks flows in policy bridges against DpManager.Rules and base flows
+// of the bridges, reinstalls missing flows and removes orphan flows.
+func (datapathManager *DpManager) RunFlowDriftCheck(handler FlowDriftHandler, stopChan <-chan struct{}) {
+	interval := datapathManager.datapathConfig.FlowDriftCheckInterval
+	if interval <= 0 {
+		log.Infof("Flow drift check disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			for vdsID := range datapathManager.managedVDSMap() {
+				drift, err := datapathManager.healVDSFlowDrift(vdsID)
+				if err != nil {
+					log.Errorf("Failed to heal flow drift of vds %s: %v", vdsID, err)
+				}
+				if drift == nil || drift.IsEmpty() {
+					continue
+				}
+				log.Warningf("Found flow drift of vds %s: %s", vdsID, drift)
+				if handler != nil {
+					handler(drift, err)
+				}
+			}
+		}
+	}
+}
+
+// healVDSFlowDrift finds and heals flow drift of the vds policy bridge. Only flows missing are
+// reinstalled, and only rule flows allocated by the agent but no longer expected are removed as
+// orphan flows, flows installed by others are never touched.
+func (datapathManager *DpManager) healVDSFlowDrift(vdsID string) (*FlowDrift, error) {
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if _, ok := datapathManager.BridgeChainMap[vdsID]; !ok || !datapathManager.IsBridgesConnected() {
+		return nil, nil
+	}
+
+	policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+	baseFlows := datapathManager.flowDriftBaseFlows[vdsID]
+
+	// expectFlows contains cookies of rule flows expected in the bridge
+	expectFlows := make(map[uint64]struct{})
+	for _, ruleEntry := range datapathManager.Rules {
+		if flowEntry := ruleEntry.RuleFlowMap[vdsID]; flowEntry != nil {
+			expectFlows[flowEntry.FlowID] = struct{}{}
+		}
+	}
+
+	installedFlows, err := dumpOwnedFlowStrings(policyBridge.name, func(flowCookie uint64) bool {
+		_, expect := expectFlows[flowCookie]
+		_, allocated := policyBridge.ruleFlows[flowCookie]
+		_, base := baseFlows[flowCookie]
+		return expect || allocated || base
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var missingRules []string
+	for ruleID, ruleEntry := range datapathManager.Rules {
+		flowEntry := ruleEntry.RuleFlowMap[vdsID]
+		if flowEntry == nil {
+			continue
+		}
+		if _, ok := installedFlows[flowEntry.FlowID]; !ok {
+			missingRules = append(missingRules, ruleID)
+		}
+	}
+
+	// base flows are recorded as dumped when first found in the bridge, then they could be
+	// reinstalled as is when missing
+	drift := &FlowDrift{Bridge: policyBridge.name, MissingRuleFlows: len(missingRules)}
+	var missingBaseFlows []string
+	var unrecordedBaseFlows int
+	for flowCookie, baseFlow := range baseFlows {
+		installedFlow, ok := installedFlows[flowCookie]
+		switch {
+		case ok && baseFlow == "":
+			baseFlows[flowCookie] = installedFlow
+		case !ok && baseFlow == "":
+			drift.MissingBaseFlows++
+			unrecordedBaseFlows++
+		case !ok:
+			drift.MissingBaseFlows++
+			missingBaseFlows = append(missingBaseFlows, baseFlow)
+		}
+	}
+
+	var orphanFlows []*openflow13.FlowMod
+	for flowCookie, tableID := range policyBridge.ruleFlows {
+		if _, expect := expectFlows[flowCookie]; expect {
+			continue
+		}
+		if _, ok := installedFlows[flowCookie]; !ok {
+			// the rule flow has been removed
+			delete(policyBridge.ruleFlows, flowCookie)
+			continue
+		}
+		orphanFlows = append(orphanFlows, deleteFlowModByCookie(tableID, flowCookie))
+	}
+	drift.OrphanFlows = len(orphanFlows)
+
+	flowDrifts.WithLabelValues(drift.Bridge, flowDriftMissingRuleFlow).Add(float64(drift.MissingRuleFlows))
+	flowDrifts.WithLabelValues(drift.Bridge, flowDriftMissingBaseFlow).Add(float64(drift.MissingBaseFlows))
+	flowDrifts.WithLabelValues(drift.Bridge, flowDriftOrphanFlow).Add(float64(drift.OrphanFlows))
+
+	if len(orphanFlows) != 0 {
+		if err := policyBridge.commitFlowModBundle(orphanFlows); err != nil {
+			return drift, fmt.Errorf("failed to remove orphan flows: %v", err)
+		}
+		for _, flowMod := range orphanFlows {
+			delete(policyBridge.ruleFlows, flowMod.Cookie)
+		}
+	}
+
+	if len(missingBaseFlows) != 0 {
+		if err := addFlowStrings(policyBridge.name, missingBaseFlows); err != nil {
+			return drift, fmt.Errorf("failed to reinstall base flows: %v", err)
+		}
+	}
+
+	for _, ruleID := range missingRules {
+		ruleEntry := datapathManager.Rules[ruleID]
+		flowEntry, err := policyBridge.AddMicroSegmentRule(ruleEntry.EveroutePolicyRule, ruleEntry.Direction, ruleEntry.Tier)
+		if err != nil {
+			return drift, fmt.Errorf("failed to reinstall rule %s flow: %v", ruleID, err)
+		}
+		ruleEntry.RuleFlowMap[vdsID] = flowEntry
+	}
+
+	if unrecordedBaseFlows != 0 {
+		// base flows never found in the bridge, they would be installed when the bridge replayed
+		return drift, fmt.Errorf("%d base flows missing before recorded", unrecordedBaseFlows)
+	}
+	return drift, nil
+}
+
+// recordingCookieAllocator records cookies allocated by the switch.
+type recordingCookieAllocator struct {
+	cookie.Allocator
+	lock    sync.Mutex
+	cookies []uint64
+}
+
+func (a *recordingCookieAllocator) RequestCookie(flowID uint64) cookie.ID {
+	id := a.Allocator.RequestCookie(flowID)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.cookies = append(a.cookies, id.RawId())
+	return id
+}
+
+// initPolicyBridge initializes the vds policy bridge, flows installed are recorded as its base flows.
+func (datapathManager *DpManager) initPolicyBridge(vdsID string) {
+	policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+	allocator := &recordingCookieAllocator{Allocator: policyBridge.OfSwitch.CookieAllocator}
+
+	policyBridge.OfSwitch.CookieAllocator = allocator
+	policyBridge.BridgeInit()
+	policyBridge.BridgeInitCNI()
+	policyBridge.OfSwitch.CookieAllocator = allocator.Allocator
+
+	baseFlows := make(map[uint64]string, len(allocator.cookies))
+	for _, flowCookie := range allocator.cookies {
+		baseFlows[flowCookie] = ""
+	}
+	datapathManager.flowDriftBaseFlows[vdsID] = baseFlows
+}
+
+// dumpOwnedFlows returns flows with cookie owned, map flow cookie to its table.
+func dumpOwnedFlows(bridgeName string, owned func(flowCookie uint64) bool) (map[uint64]uint8, error) {
+	flowStrings, err := dumpOwnedFlowStrings(bridgeName, owned)
+	if err != nil {
+		return nil, err
+	}
+
+	flows := make(map[uint64]uint8, len(flowStrings))
+	for flowCookie, flow := range flowStrings {
+		var tableID uint64
+		if tableMatch := dumpFlowTableRegexp.FindStringSubmatch(flow); tableMatch != nil {
+			tableID, _ = strconv.ParseUint(tableMatch[1], 10, 8)
+		}
+		flows[flowCookie] = uint8(tableID)
+	}
+	return flows, nil
+}
+
+// dumpOwnedFlowStrings returns flows with cookie owned, map flow cookie to the flow dumped, which
+// could be added back to the bridge as is.
+func dumpOwnedFlowStrings(bridgeName string, owned func(flowCookie uint64) bool) (map[uint64]string, error) {
+	out, err := exec.Command("ovs-ofctl", "-O", openflowProtorolVersion13, "--no-stats", "--no-names", "dump-flows", bridgeName).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump flows of bridge %s: %s, error: %v", bridgeName, string(out), err)
+	}
+	return parseOwnedFlows(string(out), owned), nil
+}
+
+func parseOwnedFlows(dumpFlows string, owned func(flowCookie uint64) bool) map[uint64]string {
+	flows := make(map[uint64]string)
+	for _, flow := range strings.Split(dumpFlows, "\n") {
+		cookieMatch := dumpFlowCookieRegexp.FindStringSubmatch(flow)
+		if cookieMatch == nil {
+			continue
+		}
+		flowCookie, err := strconv.ParseUint(cookieMatch[1], 0, 64)
+		if err != nil || !owned(flowCookie) {
+			continue
+		}
+		flows[flowCookie] = strings.TrimSpace(flow)
+	}
+	return flows
+}
+
+// addFlowStrings adds flows dumped back to the bridge.
+func addFlowStrings(bridgeName string, flows []string) error {
+	cmd := exec.Command("ovs-ofctl", "-O", openflowProtorolVersion13, "add-flows", bridgeName, "-")
+	cmd.Stdin = strings.NewReader(strings.Join(flows, "\n"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add flows to bridge %s: %s, error: %v", bridgeName, string(out), err)
+	}
+	return nil
+}
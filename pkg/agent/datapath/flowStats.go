@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+)
+
+// flowStatsTimeout is the max time to wait for flow stats replied by the switch.
+const flowStatsTimeout = 5 * time.Second
+
+// multipartReplies dispatches multipart replies received from the switch to the requests
+// waiting for them, by the xid of the request.
+type multipartReplies struct {
+	lock    sync.Mutex
+	waiters map[uint32]chan *openflow13.MultipartReply
+}
+
+func newMultipartReplies() *multipartReplies {
+	return &multipartReplies{
+		waiters: make(map[uint32]chan *openflow13.MultipartReply),
+	}
+}
+
+func (m *multipartReplies) wait(xid uint32) <-chan *openflow13.MultipartReply {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	waiter := make(chan *openflow13.MultipartReply, 1)
+	m.waiters[xid] = waiter
+	return waiter
+}
+
+func (m *multipartReplies) cancel(xid uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.waiters, xid)
+}
+
+// dispatch sends the reply to the request waiting for it, replies without waiter are dropped.
+func (m *multipartReplies) dispatch(rep *openflow13.MultipartReply) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	waiter, ok := m.waiters[rep.Xid]
+	if !ok {
+		return
+	}
+	delete(m.waiters, rep.Xid)
+	waiter <- rep
+}
+
+// getFlowPackets returns packets matched by each flow in the table, map flow id to its packets.
+// Flow is identified by its cookie, aggregate stats is requested to avoid decoding flow actions.
+func (m *multipartReplies) getFlowPackets(sw *ofctrl.OFSwitch, tableID uint8, flowIDs []uint64) (map[uint64]uint64, error) {
+	flowStats, err := m.getAggregateStats(sw, tableID, flowIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	packets := make(map[uint64]uint64, len(flowStats))
+	for flowID, stats := range flowStats {
+		packets[flowID] = stats.PacketCount
+	}
+	return packets, nil
+}
+
+// getAggregateStats returns aggregate stats of flows with each cookie in the table, map flow id to
+// its stats. Table OFPTT_ALL means flows in all tables.
+func (m *multipartReplies) getAggregateStats(sw *ofctrl.OFSwitch, tableID uint8, flowIDs []uint64) (map[uint64]*openflow13.AggregateStats, error) {
+	if sw == nil {
+		return nil, fmt.Errorf("switch not connected")
+	}
+
+	waiters := make(map[uint64]<-chan *openflow13.MultipartReply, len(flowIDs))
+	for _, flowID := range flowIDs {
+		statsReq := openflow13.NewAggregateStatsRequest()
+		statsReq.TableId = tableID
+		statsReq.OutPort = openflow13.P_ANY
+		statsReq.OutGroup = openflow13.OFPG_ANY
+		statsReq.Cookie = flowID
+		statsReq.CookieMask = ^uint64(0)
+
+		req := &openflow13.MultipartRequest{
+			Header: openflow13.NewOfp13Header(),
+			Type:   openflow13.MultipartType_Aggregate,
+			Body:   statsReq,
+		}
+		req.Header.Type = openflow13.Type_MultiPartRequest
+
+		waiters[flowID] = m.wait(req.Xid)
+		defer m.cancel(req.Xid)
+		sw.Send(req)
+	}
+
+	flowStats := make(map[uint64]*openflow13.AggregateStats, len(flowIDs))
+	timeout := time.After(flowStatsTimeout)
+	for flowID, waiter := range waiters {
+		select {
+		case rep := <-waiter:
+			flowStats[flowID] = openflow13.NewAggregateStats()
+			for _, body := range rep.Body {
+				if stats, ok := body.(*openflow13.AggregateStats); ok {
+					flowStats[flowID].PacketCount += stats.PacketCount
+					flowStats[flowID].ByteCount += stats.ByteCount
+					flowStats[flowID].FlowCount += stats.FlowCount
+				}
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("timeout waiting for stats of flows in table %d", tableID)
+		}
+	}
+	return flowStats, nil
+}
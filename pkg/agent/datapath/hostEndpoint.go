@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+// HostEndpointPort is a port of the node, traffic to the port is allowed regardless of policies
+// of host endpoint, so the node can't be locked out.
+type HostEndpointPort struct {
+	Protocol uint8
+	Port     uint16
+}
+
+// DefaultHostEndpointSafePorts are ports of control plane essentials: ssh, kube-apiserver, etcd and kubelet.
+var DefaultHostEndpointSafePorts = []HostEndpointPort{
+	{Protocol: PROTOCOL_TCP, Port: 22},
+	{Protocol: PROTOCOL_TCP, Port: 6443},
+	{Protocol: PROTOCOL_TCP, Port: 2379},
+	{Protocol: PROTOCOL_TCP, Port: 2380},
+	{Protocol: PROTOCOL_TCP, Port: 10250},
+}
+
+// ParseHostEndpointPort parses port in format "<protocol>/<port>", protocol is "tcp" or "udp", e.g. "tcp/22".
+func ParseHostEndpointPort(s string) (HostEndpointPort, error) {
+	items := strings.Split(s, "/")
+	if len(items) != 2 {
+		return HostEndpointPort{}, fmt.Errorf("invalid host endpoint port %s, should be <protocol>/<port>", s)
+	}
+
+	var hostEndpointPort HostEndpointPort
+	switch strings.ToLower(items[0]) {
+	case "tcp":
+		hostEndpointPort.Protocol = PROTOCOL_TCP
+	case "udp":
+		hostEndpointPort.Protocol = PROTOCOL_UDP
+	default:
+		return HostEndpointPort{}, fmt.Errorf("invalid protocol of host endpoint port %s, should be tcp or udp", s)
+	}
+	port, err := strconv.ParseUint(items[1], 10, 16)
+	if err != nil || port == 0 {
+		return HostEndpointPort{}, fmt.Errorf("invalid port of host endpoint port %s", s)
+	}
+	hostEndpointPort.Port = uint16(port)
+
+	return hostEndpointPort, nil
+}
+
+// HostIPs returns addresses of the node: NodeIPs, gateway ips and local gateway ip.
+func (a *AgentConf) HostIPs() []net.IP {
+	var hostIPs []net.IP
+	for _, hostIP := range append(append([]net.IP{}, a.NodeIPs...), a.GatewayIP, a.GatewayIPv6, a.LocalGwIP) {
+		if hostIP == nil {
+			continue
+		}
+		duplicate := false
+		for _, item := range hostIPs {
+			duplicate = duplicate || item.Equal(hostIP)
+		}
+		if !duplicate {
+			hostIPs = append(hostIPs, hostIP)
+		}
+	}
+	return hostIPs
+}
+
+// hostEndpointSafeRules returns rules allow traffic to safe ports of the node, and traffic from local
+// gateway to pods, e.g. probes of kubelet. Only ipv4 rules are supported by policy bridge.
+func hostEndpointSafeRules(agentInfo *AgentConf) []*EveroutePolicyRule {
+	var rules []*EveroutePolicyRule
+	for _, hostIP := range agentInfo.HostIPs() {
+		if hostIP.To4() == nil {
+			continue
+		}
+		for _, safePort := range agentInfo.HostEndpointSafePorts {
+			rules = append(rules, &EveroutePolicyRule{
+				RuleID:      fmt.Sprintf("host-endpoint-safe-%s-%d-%d", hostIP, safePort.Protocol, safePort.Port),
+				Priority:    constants.InternalWhitelistPriority,
+				DstIPAddr:   hostIP.String(),
+				IPProtocol:  safePort.Protocol,
+				DstPort:     safePort.Port,
+				DstPortMask: 0xffff,
+				Action:      "allow",
+			})
+		}
+	}
+	if agentInfo.LocalGwIP.To4() != nil {
+		rules = append(rules, &EveroutePolicyRule{
+			RuleID:    fmt.Sprintf("host-endpoint-local-gateway-%s", agentInfo.LocalGwIP),
+			Priority:  constants.InternalWhitelistPriority,
+			SrcIPAddr: agentInfo.LocalGwIP.String(),
+			Action:    "allow",
+		})
+	}
+	return rules
+}
+
+// addHostEndpointSafeRules adds rules of safe ports into ingress table of policy bridges.
+func (datapathManager *DpManager) addHostEndpointSafeRules() error {
+	for _, rule := range hostEndpointSafeRules(datapathManager.AgentInfo) {
+		if err := datapathManager.AddEveroutePolicyRule(rule, POLICY_DIRECTION_IN, POLICY_TIER2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostIPFlowMatch returns match of traffic from inPort, with source or destination ip of hostIP.
+func hostIPFlowMatch(priority uint16, inPort uint32, hostIP net.IP, matchSrc bool) ofctrl.FlowMatch {
+	match := ofctrl.FlowMatch{
+		Priority:  priority,
+		Ethertype: PROTOCOL_IP,
+		InputPort: inPort,
+	}
+	hostIPv4 := hostIP.To4()
+	switch {
+	case hostIPv4 != nil && matchSrc:
+		match.IpSa = &hostIPv4
+	case hostIPv4 != nil:
+		match.IpDa = &hostIPv4
+	case matchSrc:
+		match.Ethertype, match.Ipv6Sa = PROTOCOL_IPV6, &hostIP
+	default:
+		match.Ethertype, match.Ipv6Da = PROTOCOL_IPV6, &hostIP
+	}
+	return match
+}
+
+// initHostEndpointFlow sends traffic from the node to local pods through policy bridge, marked as
+// traffic from local gateway. Other traffic from local gateway still bypasses policy bridge.
+func (l *LocalBridge) initHostEndpointFlow(sw *ofctrl.OFSwitch) error {
+	outputPortPolicy, _ := sw.OutputPort(LOCAL_TO_POLICY_PORT)
+	for _, hostIP := range l.datapathManager.AgentInfo.HostIPs() {
+		hostToPolicy, _ := l.vlanInputTable.NewFlow(hostIPFlowMatch(MID_MATCH_FLOW_PRIORITY+FLOW_MATCH_OFFSET,
+			uint32(LOCAL_GATEWAY_PORT), hostIP, true))
+		if err := hostToPolicy.LoadField("nxm_nx_pkt_mark", 0x1, openflow13.NewNXRange(0, 0)); err != nil {
+			return err
+		}
+		if err := hostToPolicy.Next(outputPortPolicy); err != nil {
+			return fmt.Errorf("failed to install hostToPolicy flow, error: %v", err)
+		}
+	}
+	return nil
+}
+
+// initHostEndpoint sends traffic to the node back to cls bridge, instead of uplink gateway, so it
+// goes through ingress tables of policy bridge, and reaches the node by local gateway. Traffic from
+// local pods is sent back by the port it comes from, and decapsulated traffic from pods on other
+// nodes is sent to cls bridge in overlay mode. Source mac is replaced by gateway mac, to avoid cls
+// bridge learning mac of local pods from uplink.
+//
+// Traffic from outside to ipv4 NodeIPs is sent to cls bridge too, except encapsulated traffic of
+// tunnel and node ports balanced by service proxy with higher priority. Traffic from the node to
+// outside is committed to the conntrack zone of policy bridge, the replies are established there.
+func (u *UplinkBridge) initHostEndpoint() error {
+	outputPortInPort, _ := u.OfSwitch.OutputPort(openflow13.P_IN_PORT)
+	outputPortCls, _ := u.OfSwitch.OutputPort(UPLINK_TO_CLS_PORT)
+
+	for _, hostIP := range u.datapathManager.AgentInfo.HostIPs() {
+		fromLocalToHost, _ := u.defaultTable.NewFlow(hostIPFlowMatch(HIGH_MATCH_FLOW_PRIORITY,
+			uint32(UPLINK_TO_CLS_PORT), hostIP, false))
+		if err := fromLocalToHost.SetMacSa(u.datapathManager.AgentInfo.GatewayMac); err != nil {
+			return err
+		}
+		if err := fromLocalToHost.Next(outputPortInPort); err != nil {
+			return fmt.Errorf("failed to install fromLocalToHost flow, error: %v", err)
+		}
+
+		if u.datapathManager.AgentInfo.TunnelType == "" {
+			continue
+		}
+		fromTunnelToHost, _ := u.defaultTable.NewFlow(hostIPFlowMatch(HIGH_MATCH_FLOW_PRIORITY+FLOW_MATCH_OFFSET,
+			uint32(UPLINK_TUNNEL_PORT), hostIP, false))
+		if err := fromTunnelToHost.SetMacSa(u.datapathManager.AgentInfo.GatewayMac); err != nil {
+			return err
+		}
+		if err := fromTunnelToHost.Next(outputPortCls); err != nil {
+			return fmt.Errorf("failed to install fromTunnelToHost flow, error: %v", err)
+		}
+	}
+
+	for _, nodeIP := range u.datapathManager.AgentInfo.NodeIPs {
+		if nodeIP.To4() == nil {
+			continue
+		}
+		if err := u.initNodeIPHostEndpointFlows(nodeIP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initNodeIPHostEndpointFlows installs flows of traffic between outside and the node ip, only ipv4
+// is supported by policy bridge.
+func (u *UplinkBridge) initNodeIPHostEndpointFlows(nodeIP net.IP) error {
+	outputPortCls, _ := u.OfSwitch.OutputPort(UPLINK_TO_CLS_PORT)
+
+	fromUplinkToHost, _ := u.defaultTable.NewFlow(hostIPFlowMatch(MID_MATCH_FLOW_PRIORITY+FLOW_MATCH_OFFSET,
+		0, nodeIP, false))
+	if err := fromUplinkToHost.Next(outputPortCls); err != nil {
+		return fmt.Errorf("failed to install fromUplinkToHost flow, error: %v", err)
+	}
+
+	if tunnelType := u.datapathManager.AgentInfo.TunnelType; tunnelType != "" {
+		tunnelMatch := hostIPFlowMatch(MID_MATCH_FLOW_PRIORITY+2*FLOW_MATCH_OFFSET, 0, nodeIP, false)
+		tunnelMatch.IpProto, tunnelMatch.UdpDstPort = PROTOCOL_UDP, tunnelDstPort(tunnelType)
+		fromUplinkTunnelToHost, _ := u.defaultTable.NewFlow(tunnelMatch)
+		if err := fromUplinkTunnelToHost.Next(u.OfSwitch.NormalLookup()); err != nil {
+			return fmt.Errorf("failed to install fromUplinkTunnelToHost flow, error: %v", err)
+		}
+	}
+
+	untrackedState := openflow13.NewCTStates()
+	untrackedState.UnsetTrk()
+	fromHostMatch := hostIPFlowMatch(HIGH_MATCH_FLOW_PRIORITY, uint32(UPLINK_GATEWAY_PORT), nodeIP, true)
+	fromHostMatch.CtStates = untrackedState
+	fromHostToUplink, _ := u.defaultTable.NewFlow(fromHostMatch)
+	ctZone, tableID := policyConntrackZone, u.defaultTable.TableId
+	if err := fromHostToUplink.SetConntrack(ofctrl.NewConntrackAction(true, false, &tableID, &ctZone)); err != nil {
+		return fmt.Errorf("failed to install fromHostToUplink flow, error: %v", err)
+	}
+	return nil
+}
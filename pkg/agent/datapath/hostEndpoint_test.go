@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+func TestParseHostEndpointPort(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name    string
+		port    string
+		want    HostEndpointPort
+		wantErr bool
+	}{
+		{name: "should parse tcp port", port: "tcp/22", want: HostEndpointPort{Protocol: PROTOCOL_TCP, Port: 22}},
+		{name: "should parse udp port in upper case", port: "UDP/53", want: HostEndpointPort{Protocol: PROTOCOL_UDP, Port: 53}},
+		{name: "should not parse port without protocol", port: "22", wantErr: true},
+		{name: "should not parse unknown protocol", port: "sctp/22", wantErr: true},
+		{name: "should not parse zero port", port: "tcp/0", wantErr: true},
+		{name: "should not parse port out of range", port: "tcp/65536", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHostEndpointPort(tt.port)
+			if tt.wantErr {
+				Expect(err).Should(HaveOccurred())
+				return
+			}
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(got).Should(Equal(tt.want))
+		})
+	}
+}
+
+func TestHostEndpointSafeRules(t *testing.T) {
+	RegisterTestingT(t)
+
+	agentInfo := &AgentConf{
+		NodeIPs:               []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")},
+		GatewayIP:             net.ParseIP("10.0.1.1"),
+		LocalGwIP:             net.ParseIP("192.168.1.10"),
+		HostEndpointSafePorts: []HostEndpointPort{{Protocol: PROTOCOL_TCP, Port: 22}},
+	}
+	Expect(agentInfo.HostIPs()).Should(Equal([]net.IP{
+		net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10"), net.ParseIP("10.0.1.1"),
+	}))
+	Expect(hostEndpointSafeRules(agentInfo)).Should(ConsistOf(
+		&EveroutePolicyRule{
+			RuleID:      "host-endpoint-safe-192.168.1.10-6-22",
+			Priority:    constants.InternalWhitelistPriority,
+			DstIPAddr:   "192.168.1.10",
+			IPProtocol:  PROTOCOL_TCP,
+			DstPort:     22,
+			DstPortMask: 0xffff,
+			Action:      "allow",
+		},
+		&EveroutePolicyRule{
+			RuleID:      "host-endpoint-safe-10.0.1.1-6-22",
+			Priority:    constants.InternalWhitelistPriority,
+			DstIPAddr:   "10.0.1.1",
+			IPProtocol:  PROTOCOL_TCP,
+			DstPort:     22,
+			DstPortMask: 0xffff,
+			Action:      "allow",
+		},
+		&EveroutePolicyRule{
+			RuleID:    "host-endpoint-local-gateway-192.168.1.10",
+			Priority:  constants.InternalWhitelistPriority,
+			SrcIPAddr: "192.168.1.10",
+			Action:    "allow",
+		},
+	))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package datapath
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -24,29 +25,40 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 	"github.com/contiv/libOpenflow/openflow13"
 	"github.com/contiv/libOpenflow/protocol"
 	"github.com/contiv/ofnet/ofctrl"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
 )
 
 //nolint
 const (
 	VLAN_INPUT_TABLE                   = 0
+	FROM_LOCAL_SPOOF_GUARD_TABLE       = 2
 	L2_FORWARDING_TABLE                = 5
 	L2_LEARNING_TABLE                  = 10
 	FROM_LOCAL_REDIRECT_TABLE          = 15
 	FROM_LOCAL_ARP_PASS_TABLE          = 20
 	FROM_LOCAL_ARP_TO_CONTROLLER_TABLE = 25
+	SERVICE_AFFINITY_TABLE             = 30
+	SERVICE_LB_TABLE                   = 35
+	SERVICE_DNAT_TABLE                 = 40
 	FACK_MAC                           = "ee:ee:ee:ee:ee:ee"
 	P_NONE                             = 0xffff
 )
 
+// reg5[0] marks the dhcp reply has been sent to of controller
+const dhcpSnoopedReg = 5
+
 type LocalBridge struct {
 	name            string
 	OfSwitch        *ofctrl.OFSwitch
 	datapathManager *DpManager
 
 	vlanInputTable                 *ofctrl.Table // Table 0
+	spoofGuardTable                *ofctrl.Table // Table 2
 	localEndpointL2ForwardingTable *ofctrl.Table // Table 5
 	localEndpointL2LearningTable   *ofctrl.Table // table 10
 	fromLocalRedirectTable         *ofctrl.Table // Table 15
@@ -55,10 +67,25 @@ type LocalBridge struct {
 
 	// Table 0
 	fromLocalEndpointFlow map[uint32]*ofctrl.Flow // map local endpoint interface ofport to its fromLocalEndpointFlow
+	// Table 2
+	spoofGuardMutex      sync.Mutex
+	spoofGuardFlows      map[uint32][]*ofctrl.Flow       // map local endpoint ofport to its spoof guard check and drop flows
+	spoofGuardAllowFlows map[uint32][]*ofctrl.Flow       // map local endpoint ofport to its spoof guard allow flows
+	spoofGuardBindings   map[string]map[string]time.Time // map local endpoint mac to its bound ips and expire time
+	spoofGuardArpDrops   map[uint32]uint64               // map local endpoint ofport to arp dropped by controller
+
+	multipartReplies *multipartReplies
 	// Table 5
 	localToLocalBUMFlow      map[uint32]*ofctrl.Flow
 	learnedIPAddressMapMutex sync.RWMutex
 	learnedIPAddressMap      map[string]IPAddressReference
+	// map ip to its binding, only used in dhcp snooping mode
+	dhcpBindingsMutex sync.RWMutex
+	dhcpBindings      map[string]DHCPBinding
+	// Table 30, 35, 40
+	serviceLB *serviceLB
+	// Table 15
+	egressFlowSet *egressFlowSet
 
 	localSwitchStatusMuxtex sync.RWMutex
 	isLocalSwitchConnected  bool
@@ -69,6 +96,12 @@ type IPAddressReference struct {
 	updateTimes    int
 }
 
+type DHCPBinding struct {
+	ofPort     uint32
+	macAddr    net.HardwareAddr
+	expireTime time.Time
+}
+
 func NewLocalBridge(brName string, datapathManager *DpManager) *LocalBridge {
 	localBridge := new(LocalBridge)
 	localBridge.name = brName
@@ -76,6 +109,13 @@ func NewLocalBridge(brName string, datapathManager *DpManager) *LocalBridge {
 	localBridge.fromLocalEndpointFlow = make(map[uint32]*ofctrl.Flow)
 	localBridge.localToLocalBUMFlow = make(map[uint32]*ofctrl.Flow)
 	localBridge.learnedIPAddressMap = make(map[string]IPAddressReference)
+	localBridge.dhcpBindings = make(map[string]DHCPBinding)
+	localBridge.spoofGuardFlows = make(map[uint32][]*ofctrl.Flow)
+	localBridge.spoofGuardAllowFlows = make(map[uint32][]*ofctrl.Flow)
+	localBridge.spoofGuardBindings = make(map[string]map[string]time.Time)
+	localBridge.spoofGuardArpDrops = make(map[uint32]uint64)
+	localBridge.multipartReplies = newMultipartReplies()
+	localBridge.serviceLB = newServiceLB(brName)
 
 	return localBridge
 }
@@ -123,8 +163,12 @@ func (l *LocalBridge) WaitForSwitchConnection() {
 }
 
 func (l *LocalBridge) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
+	if l.datapathManager.processTraceflowPacketIn(l.name, pkt) {
+		return
+	}
+
 	switch pkt.Data.Ethertype {
-	case PROTOCOL_ARP:
+	case PROTOCOL_ARP, PROTOCOL_IPV6, PROTOCOL_IP:
 		if (pkt.Match.Type == openflow13.MatchType_OXM) &&
 			(pkt.Match.Fields[0].Class == openflow13.OXM_CLASS_OPENFLOW_BASIC) &&
 			(pkt.Match.Fields[0].Field == openflow13.OXM_FIELD_IN_PORT) {
@@ -133,36 +177,216 @@ func (l *LocalBridge) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
 			case *openflow13.InPortField:
 				var inPortFld openflow13.InPortField
 				inPortFld = *t
-				l.processArp(pkt.Data, inPortFld.InPort)
+				switch pkt.Data.Ethertype {
+				case PROTOCOL_ARP:
+					if pkt.TableId == FROM_LOCAL_SPOOF_GUARD_TABLE && !l.checkSpoofGuardArp(pkt, inPortFld.InPort) {
+						return
+					}
+					l.processArp(pkt.Data, inPortFld.InPort)
+				case PROTOCOL_IPV6:
+					if inPortFld.InPort == uint32(LOCAL_GATEWAY_PORT) {
+						l.processLocalGwNdp(pkt.Data)
+						return
+					}
+					l.processNdp(pkt.Data, inPortFld.InPort)
+				case PROTOCOL_IP:
+					if !l.datapathManager.datapathConfig.EnableDHCPSnooping {
+						// other type of packet that must processing by controller
+						log.Errorf("controller received non arp packet error.")
+						return
+					}
+					l.processDHCP(pkt.Data)
+				}
 			default:
 				log.Errorf("error inport filed")
 			}
 		}
-	case protocol.IPv4_MSG: // other type of packet that must processing by controller
-		log.Errorf("controller received non arp packet error.")
-		return
 	}
 }
 
 func (l *LocalBridge) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply) {
+	l.multipartReplies.dispatch(rep)
 }
 
 func (l *LocalBridge) processArp(pkt protocol.Ethernet, inPort uint32) {
 	switch t := pkt.Data.(type) {
 	case *protocol.ARP:
 		var arpIn protocol.ARP = *t
+		if l.datapathManager.datapathConfig.EnableDHCPSnooping {
+			// in dhcp snooping mode, only refresh ip which bound to the port
+			if !l.isDHCPBound(arpIn.IPSrc, arpIn.HWSrc, inPort) {
+				log.Debugf("ignore ip %s from arp on port %d, not bound by dhcp", arpIn.IPSrc, inPort)
+				return
+			}
+			l.learnIPAddress(arpIn.IPSrc, inPort, agentv1alpha1.IPOriginDHCP)
+			return
+		}
+		l.learnIPAddress(arpIn.IPSrc, inPort, agentv1alpha1.IPOriginARP)
+	default:
+		log.Infof("error pkt type")
+	}
+}
 
-		l.learnedIPAddressMapMutex.Lock()
-		defer l.learnedIPAddressMapMutex.Unlock()
-		ipReference, ok := l.learnedIPAddressMap[arpIn.IPSrc.String()]
-		if !ok {
-			l.processLocalEndpointUpdate(arpIn, inPort)
-		} else if ok && ipReference.updateTimes > 0 {
-			l.processLocalEndpointUpdate(arpIn, inPort)
+// processNdp learns endpoint ipv6 address from neighbor solicitation (include
+// duplicate address detection) and neighbor advertisement.
+func (l *LocalBridge) processNdp(pkt protocol.Ethernet, inPort uint32) {
+	ipv6In, ok := pkt.Data.(*protocol.IPv6)
+	if !ok {
+		log.Infof("error pkt type")
+		return
+	}
+	icmpIn, ok := ipv6In.Data.(*protocol.ICMP)
+	if !ok {
+		// other type of ipv6 multicast packet, ignore it
+		return
+	}
+
+	// both neighbor solicitation and advertisement begin with 4 bytes
+	// reserved (or flags) and 16 bytes target address
+	if len(icmpIn.Data) < 4+net.IPv6len {
+		return
+	}
+	targetAddr := net.IP(icmpIn.Data[4 : 4+net.IPv6len])
+
+	var learnedIP net.IP
+	switch icmpIn.Type {
+	case ICMPV6_NEIGHBOR_SOLICITATION:
+		learnedIP = ipv6In.NWSrc
+		if learnedIP.IsUnspecified() {
+			// duplicate address detection, the target is the tentative address of the sender
+			learnedIP = targetAddr
 		}
+	case ICMPV6_NEIGHBOR_ADVERTISEMENT:
+		learnedIP = targetAddr
 	default:
+		return
+	}
+
+	// link local address is unique only on the link, learn global address only
+	if learnedIP.IsLinkLocalUnicast() || learnedIP.IsUnspecified() || learnedIP.IsMulticast() {
+		return
+	}
+	// dhcpv6 is not snooped, learn ipv6 address from ndp even in dhcp snooping mode
+	l.learnIPAddress(learnedIP, inPort, agentv1alpha1.IPOriginNDP)
+}
+
+// processLocalGwNdp answers neighbor solicitation from local gateway for address out of
+// ipv6 pod cidr with gateway mac address, other packets would be flooded as normal.
+func (l *LocalBridge) processLocalGwNdp(pkt protocol.Ethernet) {
+	sw := l.OfSwitch
+	if sw == nil {
+		return
+	}
+
+	pktOut := openflow13.NewPacketOut()
+	pktOut.InPort = uint32(LOCAL_GATEWAY_PORT)
+
+	podCIDR := l.datapathManager.AgentInfo.PodCIDROfFamily(true)
+	targetAddr := neighborSolicitationTarget(pkt)
+	if targetAddr == nil || podCIDR == nil || (*net.IPNet)(podCIDR).Contains(targetAddr) {
+		pktOut.Data = &pkt
+		pktOut.AddAction(openflow13.NewActionOutput(openflow13.P_FLOOD))
+		sw.Send(pktOut)
+		return
+	}
+
+	fakeMac, _ := net.ParseMAC(FACK_MAC)
+	reply, err := buildNeighborAdvertisement(pkt, targetAddr, fakeMac, l.datapathManager.AgentInfo.GatewayMac)
+	if err != nil {
+		log.Errorf("failed to build neighbor advertisement for %s: %s", targetAddr, err)
+		return
+	}
+	pktOut.Data = reply
+	pktOut.AddAction(openflow13.NewActionOutput(openflow13.P_IN_PORT))
+	sw.Send(pktOut)
+}
+
+func (l *LocalBridge) learnIPAddress(ip net.IP, inPort uint32, origin agentv1alpha1.IPOrigin) {
+	l.learnedIPAddressMapMutex.Lock()
+	defer l.learnedIPAddressMapMutex.Unlock()
+	ipReference, ok := l.learnedIPAddressMap[ip.String()]
+	if !ok {
+		l.processLocalEndpointUpdate(ip, inPort, origin)
+	} else if ok && ipReference.updateTimes > 0 {
+		l.processLocalEndpointUpdate(ip, inPort, origin)
+	}
+}
+
+// processDHCP binds ip to local endpoint from dhcp ack sent to the endpoint.
+func (l *LocalBridge) processDHCP(pkt protocol.Ethernet) {
+	ipIn, ok := pkt.Data.(*protocol.IPv4)
+	if !ok {
 		log.Infof("error pkt type")
+		return
+	}
+	udpIn, ok := ipIn.Data.(*protocol.UDP)
+	if !ok || udpIn.PortSrc != DHCP_SERVER_PORT || udpIn.PortDst != DHCP_CLIENT_PORT {
+		return
+	}
+
+	dhcpIn := new(protocol.DHCP)
+	if _, err := dhcpIn.Write(udpIn.Data); err != nil {
+		log.Errorf("failed to parse dhcp packet: %s", err)
+		return
 	}
+
+	var messageType byte
+	var leaseTime uint32
+	for _, opt := range dhcpIn.Options {
+		switch opt.OptionType() {
+		case protocol.DHCP_OPT_MESSAGE_TYPE:
+			if len(opt.Bytes()) == 1 {
+				messageType = opt.Bytes()[0]
+			}
+		case protocol.DHCP_OPT_LEASE_TIME:
+			if len(opt.Bytes()) == 4 {
+				leaseTime = binary.BigEndian.Uint32(opt.Bytes())
+			}
+		}
+	}
+	if messageType != byte(protocol.DHCP_MSG_ACK) || dhcpIn.YourIP.IsUnspecified() || leaseTime == 0 {
+		return
+	}
+
+	ofPort, ok := l.getOfPortByMac(dhcpIn.ClientHWAddr)
+	if !ok {
+		// the dhcp client is not a local endpoint
+		return
+	}
+
+	l.dhcpBindingsMutex.Lock()
+	l.dhcpBindings[dhcpIn.YourIP.String()] = DHCPBinding{
+		ofPort:     ofPort,
+		macAddr:    dhcpIn.ClientHWAddr,
+		expireTime: time.Now().Add(time.Duration(leaseTime) * time.Second),
+	}
+	l.dhcpBindingsMutex.Unlock()
+
+	log.Infof("bind ip %s to port %d mac %s by dhcp, lease time %ds", dhcpIn.YourIP, ofPort, dhcpIn.ClientHWAddr, leaseTime)
+	l.bindSpoofGuardIP(dhcpIn.YourIP, ofPort, time.Now().Add(time.Duration(leaseTime)*time.Second))
+	l.notifyLocalEndpointUpdate(dhcpIn.YourIP, agentv1alpha1.IPOriginDHCP, ofPort)
+}
+
+func (l *LocalBridge) isDHCPBound(ip net.IP, mac net.HardwareAddr, ofPort uint32) bool {
+	l.dhcpBindingsMutex.RLock()
+	defer l.dhcpBindingsMutex.RUnlock()
+
+	binding, ok := l.dhcpBindings[ip.String()]
+	return ok && binding.ofPort == ofPort && bytes.Equal(binding.macAddr, mac) && time.Now().Before(binding.expireTime)
+}
+
+func (l *LocalBridge) getOfPortByMac(mac net.HardwareAddr) (uint32, bool) {
+	for endpointObj := range l.datapathManager.localEndpointDB.IterBuffered() {
+		endpoint := endpointObj.Val.(*Endpoint)
+		if endpoint.BridgeName != l.name {
+			continue
+		}
+		if endpointMac, err := net.ParseMAC(endpoint.MacAddrStr); err == nil && bytes.Equal(endpointMac, mac) {
+			return endpoint.PortNo, true
+		}
+	}
+
+	return 0, false
 }
 
 func (l *LocalBridge) cleanLocalIPAddressCacheWorker(cycle, timeout int, stopChan <-chan struct{}) {
@@ -184,24 +408,50 @@ func (l *LocalBridge) cleanLocalIPAddressCache(timeout int) {
 		ipExpiredTime := t.lastUpdateTime.Add(time.Duration(timeout) * time.Second)
 		if time.Now().After(ipExpiredTime) {
 			delete(l.learnedIPAddressMap, ip)
+			learnedIPExpirations.Inc()
+		}
+	}
+
+	l.cleanDHCPBindings()
+	l.cleanSpoofGuardBindings()
+}
+
+// cleanDHCPBindings removes bindings which lease expired, and withdraws the ips from their endpoints.
+func (l *LocalBridge) cleanDHCPBindings() {
+	var expiredIPs = make(map[string]DHCPBinding)
+
+	l.dhcpBindingsMutex.Lock()
+	for ip, binding := range l.dhcpBindings {
+		if time.Now().After(binding.expireTime) {
+			delete(l.dhcpBindings, ip)
+			expiredIPs[ip] = binding
 		}
 	}
+	l.dhcpBindingsMutex.Unlock()
+
+	for ip, binding := range expiredIPs {
+		log.Infof("dhcp lease of ip %s on port %d expired, withdraw it", ip, binding.ofPort)
+		l.learnedIPAddressMapMutex.Lock()
+		delete(l.learnedIPAddressMap, ip)
+		l.learnedIPAddressMapMutex.Unlock()
+		l.notifyLocalEndpointWithdraw(net.ParseIP(ip), binding.ofPort)
+	}
 }
 
-func (l *LocalBridge) processLocalEndpointUpdate(arpIn protocol.ARP, inPort uint32) {
+func (l *LocalBridge) processLocalEndpointUpdate(ip net.IP, inPort uint32, origin agentv1alpha1.IPOrigin) {
 	if !l.isOfPortExists(inPort) {
 		return
 	}
 
-	l.notifyLocalEndpointUpdate(arpIn, inPort)
-	ipReference, ok := l.learnedIPAddressMap[arpIn.IPSrc.String()]
+	l.notifyLocalEndpointUpdate(ip, origin, inPort)
+	ipReference, ok := l.learnedIPAddressMap[ip.String()]
 	if !ok {
-		l.learnedIPAddressMap[arpIn.IPSrc.String()] = IPAddressReference{
+		l.learnedIPAddressMap[ip.String()] = IPAddressReference{
 			lastUpdateTime: time.Now(),
 			updateTimes:    MaxIPAddressLearningFrenquency,
 		}
 	} else {
-		l.learnedIPAddressMap[arpIn.IPSrc.String()] = IPAddressReference{
+		l.learnedIPAddressMap[ip.String()] = IPAddressReference{
 			lastUpdateTime: ipReference.lastUpdateTime,
 			updateTimes:    ipReference.updateTimes - 1,
 		}
@@ -219,9 +469,16 @@ func (l *LocalBridge) isOfPortExists(inPort uint32) bool {
 	return false
 }
 
-func (l *LocalBridge) notifyLocalEndpointUpdate(arpIn protocol.ARP, ofPort uint32) {
-	updatedOfPortInfo := make(map[string]net.IP)
-	updatedOfPortInfo[fmt.Sprintf("%s-%d", l.name, ofPort)] = arpIn.IPSrc
+func (l *LocalBridge) notifyLocalEndpointUpdate(ip net.IP, origin agentv1alpha1.IPOrigin, ofPort uint32) {
+	updatedOfPortInfo := make(map[string]LearnedIPAddress)
+	updatedOfPortInfo[fmt.Sprintf("%s-%d", l.name, ofPort)] = LearnedIPAddress{IP: ip, Origin: origin}
+	learnedIPUpdates.WithLabelValues(string(origin)).Inc()
+	l.datapathManager.ofPortIPAddressUpdateChan <- updatedOfPortInfo
+}
+
+func (l *LocalBridge) notifyLocalEndpointWithdraw(ip net.IP, ofPort uint32) {
+	updatedOfPortInfo := make(map[string]LearnedIPAddress)
+	updatedOfPortInfo[fmt.Sprintf("%s-%d", l.name, ofPort)] = LearnedIPAddress{IP: ip, Origin: agentv1alpha1.IPOriginDHCP, Withdrawn: true}
 	l.datapathManager.ofPortIPAddressUpdateChan <- updatedOfPortInfo
 }
 
@@ -230,6 +487,7 @@ func (l *LocalBridge) BridgeInit() {
 	sw := l.OfSwitch
 
 	l.vlanInputTable = sw.DefaultTable()
+	l.spoofGuardTable, _ = sw.NewTable(FROM_LOCAL_SPOOF_GUARD_TABLE)
 	l.localEndpointL2ForwardingTable, _ = sw.NewTable(L2_FORWARDING_TABLE)
 	l.localEndpointL2LearningTable, _ = sw.NewTable(L2_LEARNING_TABLE)
 	l.fromLocalRedirectTable, _ = sw.NewTable(FROM_LOCAL_REDIRECT_TABLE)
@@ -266,6 +524,12 @@ func (l *LocalBridge) BridgeInitCNI() {
 }
 
 func (l *LocalBridge) initLocalGwArpFlow(sw *ofctrl.OFSwitch) error {
+	podCIDR := l.datapathManager.AgentInfo.PodCIDROfFamily(false)
+	if podCIDR == nil {
+		// ipv4 not enabled on the node
+		return nil
+	}
+
 	// arp response flow
 
 	// target for local pod
@@ -273,8 +537,8 @@ func (l *LocalBridge) initLocalGwArpFlow(sw *ofctrl.OFSwitch) error {
 		Priority:   HIGH_MATCH_FLOW_PRIORITY,
 		InputPort:  uint32(LOCAL_GATEWAY_PORT),
 		Ethertype:  PROTOCOL_ARP,
-		ArpTpa:     &l.datapathManager.AgentInfo.PodCIDR[0].IP,
-		ArpTpaMask: (*net.IP)(&l.datapathManager.AgentInfo.PodCIDR[0].Mask),
+		ArpTpa:     &podCIDR.IP,
+		ArpTpaMask: (*net.IP)(&podCIDR.Mask),
 	})
 	flood, _ := sw.OutputPort(openflow13.P_FLOOD)
 	if err := arpPodFlow.Next(flood); err != nil {
@@ -346,58 +610,112 @@ func (l *LocalBridge) initLocalGwArpFlow(sw *ofctrl.OFSwitch) error {
 	return nil
 }
 
+// initLocalGwNdpFlow sends neighbor discovery packets from local gateway to the controller.
+// Like arp, neighbor solicitation for address out of ipv6 pod cidr would be answered with
+// gateway mac address, see processLocalGwNdp.
+func (l *LocalBridge) initLocalGwNdpFlow(sw *ofctrl.OFSwitch) error {
+	if l.datapathManager.AgentInfo.PodCIDROfFamily(true) == nil {
+		// ipv6 not enabled on the node
+		return nil
+	}
+
+	ndpMatch := newNdpFlowMatch(HIGH_MATCH_FLOW_PRIORITY + FLOW_MATCH_OFFSET)
+	ndpMatch.InputPort = uint32(LOCAL_GATEWAY_PORT)
+	localGwNdpFlow, _ := l.vlanInputTable.NewFlow(ndpMatch)
+	sendToControllerAct := localGwNdpFlow.NewControllerAction(sw.ControllerID, 0)
+	_ = localGwNdpFlow.SendToController(sendToControllerAct)
+	if err := localGwNdpFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+		return fmt.Errorf("failed to install local gateway ndp flow, error: %v", err)
+	}
+	return nil
+}
+
 func (l *LocalBridge) initToLocalGwFlow(sw *ofctrl.OFSwitch) error {
-	localToLocalGw, _ := l.fromLocalRedirectTable.NewFlow(ofctrl.FlowMatch{
-		Priority:  HIGH_MATCH_FLOW_PRIORITY,
-		Ethertype: PROTOCOL_IP,
-		IpDa:      &l.datapathManager.AgentInfo.ClusterCIDR.IP,
-		IpDaMask:  (*net.IP)(&l.datapathManager.AgentInfo.ClusterCIDR.Mask),
-	})
-	_ = localToLocalGw.LoadField("nxm_of_eth_dst", ParseMacToUint64(l.datapathManager.AgentInfo.LocalGwMac),
-		openflow13.NewNXRange(0, 47))
-	_ = localToLocalGw.LoadField("nxm_nx_pkt_mark", 0x1,
-		openflow13.NewNXRange(0, 0))
 	outputPortLocalGateWay, _ := sw.OutputPort(LOCAL_GATEWAY_PORT)
-	if err := localToLocalGw.Next(outputPortLocalGateWay); err != nil {
-		return fmt.Errorf("failed to install from localToLocalGw flow, error: %v", err)
-	}
 
-	pktMarkMask := uint32(0x01)
-	outToLocalGwBypassLocal, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
-		Priority:    HIGH_MATCH_FLOW_PRIORITY + FLOW_MATCH_OFFSET,
-		Ethertype:   PROTOCOL_IP,
-		InputPort:   uint32(LOCAL_TO_POLICY_PORT),
-		PktMark:     0x01,
-		PktMarkMask: &pktMarkMask,
-	})
-	if err := outToLocalGwBypassLocal.Resubmit(nil, &l.localEndpointL2ForwardingTable.TableId); err != nil {
-		return fmt.Errorf("failed to install outToLocalGwBypassLocal flow, error: %v", err)
+	for _, clusterCIDR := range []*cnitypes.IPNet{l.datapathManager.AgentInfo.ClusterCIDR, l.datapathManager.AgentInfo.ClusterCIDRv6} {
+		if clusterCIDR == nil {
+			continue
+		}
+		match := ofctrl.FlowMatch{
+			Priority:  HIGH_MATCH_FLOW_PRIORITY,
+			Ethertype: PROTOCOL_IP,
+			IpDa:      &clusterCIDR.IP,
+			IpDaMask:  (*net.IP)(&clusterCIDR.Mask),
+		}
+		if clusterCIDR.IP.To4() == nil {
+			match.Ethertype, match.IpDa, match.IpDaMask = PROTOCOL_IPV6, nil, nil
+			match.Ipv6Da, match.Ipv6DaMask = &clusterCIDR.IP, (*net.IP)(&clusterCIDR.Mask)
+		}
+		localToLocalGw, _ := l.fromLocalRedirectTable.NewFlow(match)
+		_ = localToLocalGw.LoadField("nxm_of_eth_dst", ParseMacToUint64(l.datapathManager.AgentInfo.LocalGwMac),
+			openflow13.NewNXRange(0, 47))
+		_ = localToLocalGw.LoadField("nxm_nx_pkt_mark", 0x1,
+			openflow13.NewNXRange(0, 0))
+		if err := localToLocalGw.Next(outputPortLocalGateWay); err != nil {
+			return fmt.Errorf("failed to install from localToLocalGw flow, error: %v", err)
+		}
 	}
-	if err := outToLocalGwBypassLocal.Next(ofctrl.NewEmptyElem()); err != nil {
-		return fmt.Errorf("failed to install outToLocalGwBypassLocal flow, error: %v", err)
+
+	for _, ethertype := range l.podEthertypes() {
+		pktMarkMask := uint32(0x01)
+		outToLocalGwBypassLocal, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
+			Priority:    HIGH_MATCH_FLOW_PRIORITY + FLOW_MATCH_OFFSET,
+			Ethertype:   ethertype,
+			InputPort:   uint32(LOCAL_TO_POLICY_PORT),
+			PktMark:     0x01,
+			PktMarkMask: &pktMarkMask,
+		})
+		if err := outToLocalGwBypassLocal.Resubmit(nil, &l.localEndpointL2ForwardingTable.TableId); err != nil {
+			return fmt.Errorf("failed to install outToLocalGwBypassLocal flow, error: %v", err)
+		}
+		if err := outToLocalGwBypassLocal.Next(ofctrl.NewEmptyElem()); err != nil {
+			return fmt.Errorf("failed to install outToLocalGwBypassLocal flow, error: %v", err)
+		}
+
+		outToLocalGw, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
+			Priority:  HIGH_MATCH_FLOW_PRIORITY,
+			Ethertype: ethertype,
+			InputPort: uint32(LOCAL_TO_POLICY_PORT),
+		})
+		if err := outToLocalGw.LoadField("nxm_of_eth_dst", ParseMacToUint64(l.datapathManager.AgentInfo.LocalGwMac),
+			openflow13.NewNXRange(0, 47)); err != nil {
+			return err
+		}
+		if err := outToLocalGw.Next(outputPortLocalGateWay); err != nil {
+			return fmt.Errorf("failed to install from outToLocalGw flow, error: %v", err)
+		}
 	}
 
-	outToLocalGw, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
-		Priority:  HIGH_MATCH_FLOW_PRIORITY,
-		Ethertype: PROTOCOL_IP,
-		InputPort: uint32(LOCAL_TO_POLICY_PORT),
-	})
-	if err := outToLocalGw.LoadField("nxm_of_eth_dst", ParseMacToUint64(l.datapathManager.AgentInfo.LocalGwMac),
-		openflow13.NewNXRange(0, 47)); err != nil {
-		return err
+	return nil
+}
+
+// podEthertypes returns ethertypes of ip families enabled on the node.
+func (l *LocalBridge) podEthertypes() []uint16 {
+	var ethertypes []uint16
+	if l.datapathManager.AgentInfo.PodCIDROfFamily(false) != nil {
+		ethertypes = append(ethertypes, PROTOCOL_IP)
 	}
-	if err := outToLocalGw.Next(outputPortLocalGateWay); err != nil {
-		return fmt.Errorf("failed to install from outToLocalGw flow, error: %v", err)
+	if l.datapathManager.AgentInfo.PodCIDROfFamily(true) != nil {
+		ethertypes = append(ethertypes, PROTOCOL_IPV6)
 	}
+	return ethertypes
+}
 
+func (l *LocalBridge) initFromLocalGwFlow(sw *ofctrl.OFSwitch) error {
+	for _, ethertype := range l.podEthertypes() {
+		if err := l.initFromLocalGwFlowOfEthertype(sw, ethertype); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (l *LocalBridge) initFromLocalGwFlow(sw *ofctrl.OFSwitch) error {
+func (l *LocalBridge) initFromLocalGwFlowOfEthertype(sw *ofctrl.OFSwitch, ethertype uint16) error {
 	pktMarkMask := uint32(0x01)
 	localGwToPolicy, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
 		Priority:    HIGH_MATCH_FLOW_PRIORITY,
-		Ethertype:   PROTOCOL_IP,
+		Ethertype:   ethertype,
 		InputPort:   uint32(LOCAL_GATEWAY_PORT),
 		PktMark:     0x01,
 		PktMarkMask: &pktMarkMask,
@@ -413,7 +731,7 @@ func (l *LocalBridge) initFromLocalGwFlow(sw *ofctrl.OFSwitch) error {
 
 	localGwToLocal, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
 		Priority:  MID_MATCH_FLOW_PRIORITY,
-		Ethertype: PROTOCOL_IP,
+		Ethertype: ethertype,
 		InputPort: uint32(LOCAL_GATEWAY_PORT),
 	})
 	if err := localGwToLocal.LoadField("nxm_of_eth_src", ParseMacToUint64(l.datapathManager.AgentInfo.LocalGwMac),
@@ -434,6 +752,9 @@ func (l *LocalBridge) initCniRelatedFlow(sw *ofctrl.OFSwitch) error {
 	if err := l.initLocalGwArpFlow(sw); err != nil {
 		return err
 	}
+	if err := l.initLocalGwNdpFlow(sw); err != nil {
+		return err
+	}
 
 	// traffic into local gateway
 	if err := l.initToLocalGwFlow(sw); err != nil {
@@ -445,6 +766,25 @@ func (l *LocalBridge) initCniRelatedFlow(sw *ofctrl.OFSwitch) error {
 		return err
 	}
 
+	// traffic from the node to local pods
+	if l.datapathManager.AgentInfo.EnableHostEndpointPolicy {
+		if err := l.initHostEndpointFlow(sw); err != nil {
+			return err
+		}
+	}
+
+	// service load balancing
+	if l.datapathManager.AgentInfo.EnableServiceProxy {
+		if err := l.initServiceProxy(sw); err != nil {
+			return err
+		}
+	}
+
+	// traffic from pods selected by egress policies
+	if err := l.initEgress(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -499,6 +839,38 @@ func (l *LocalBridge) initVlanInputTable(sw *ofctrl.OFSwitch) error {
 		return fmt.Errorf("failed to install from upstream flow, error: %v", err)
 	}
 
+	if l.datapathManager.datapathConfig.EnableDHCPSnooping {
+		// dhcp reply from upstream or local gateway, send one to of controller to bind ip; mark it as
+		// snooped and resubmit to this table, then it would be forwarded as other packets from the port.
+		// Dhcp reply from local endpoint is snooped after it comes back from upstream.
+		for _, inPort := range []uint32{LOCAL_TO_POLICY_PORT, LOCAL_GATEWAY_PORT} {
+			dhcpSnoopFlow, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
+				Priority:   HIGH_MATCH_FLOW_PRIORITY + 3*FLOW_MATCH_OFFSET,
+				InputPort:  inPort,
+				Ethertype:  PROTOCOL_IP,
+				IpProto:    PROTOCOL_UDP,
+				UdpSrcPort: DHCP_SERVER_PORT,
+				UdpDstPort: DHCP_CLIENT_PORT,
+				Regs: []*ofctrl.NXRegister{{
+					RegID: dhcpSnoopedReg,
+					Data:  0,
+					Range: openflow13.NewNXRange(0, 0),
+				}},
+			})
+			sendToControllerAct := dhcpSnoopFlow.NewControllerAction(sw.ControllerID, 0)
+			_ = dhcpSnoopFlow.SendToController(sendToControllerAct)
+			if err := dhcpSnoopFlow.LoadField(fmt.Sprintf("nxm_nx_reg%d", dhcpSnoopedReg), 1, openflow13.NewNXRange(0, 0)); err != nil {
+				return err
+			}
+			if err := dhcpSnoopFlow.Resubmit(nil, &l.vlanInputTable.TableId); err != nil {
+				return err
+			}
+			if err := dhcpSnoopFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+				return fmt.Errorf("failed to install dhcp snoop flow, error: %v", err)
+			}
+		}
+	}
+
 	vlanInputTableDefaultFlow, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
 		Priority: DEFAULT_FLOW_MISS_PRIORITY,
 	})
@@ -567,6 +939,18 @@ func (l *LocalBridge) initFromLocalRedirectTable(sw *ofctrl.OFSwitch) error {
 		return fmt.Errorf("failed to install from local arp redirect flow, error: %v", err)
 	}
 
+	// from local ipv6 neighbor discovery, same as arp
+	fromLocalNdpFlow, _ := l.fromLocalRedirectTable.NewFlow(newNdpFlowMatch(HIGH_MATCH_FLOW_PRIORITY))
+	if err := fromLocalNdpFlow.Resubmit(nil, &l.fromLocalArpPassTable.TableId); err != nil {
+		return err
+	}
+	if err := fromLocalNdpFlow.Resubmit(nil, &l.fromLocalArpSendToCtrlTable.TableId); err != nil {
+		return err
+	}
+	if err := fromLocalNdpFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+		return fmt.Errorf("failed to install from local ndp redirect flow, error: %v", err)
+	}
+
 	// from local other protocol type, send to local to policy port
 	fromLocalOtherRedirectFlow, _ := l.fromLocalRedirectTable.NewFlow(ofctrl.FlowMatch{
 		Priority: MID_MATCH_FLOW_PRIORITY,
@@ -589,6 +973,11 @@ func (l *LocalBridge) initFromLocalArpPassTable(sw *ofctrl.OFSwitch) error {
 		return fmt.Errorf("failed to install from local arp pass flow, error: %v", err)
 	}
 
+	fromLocalNdpPassFlow, _ := l.fromLocalArpPassTable.NewFlow(newNdpFlowMatch(HIGH_MATCH_FLOW_PRIORITY))
+	if err := fromLocalNdpPassFlow.Next(outputPort); err != nil {
+		return fmt.Errorf("failed to install from local ndp pass flow, error: %v", err)
+	}
+
 	return nil
 }
 
@@ -603,37 +992,42 @@ func (l *LocalBridge) initFromLocalArpSendToCtrlTable(sw *ofctrl.OFSwitch) error
 		return fmt.Errorf("failed to install from local arp send to controller flow, error: %v", err)
 	}
 
+	fromLocalNdpSendToCtrlFlow, _ := l.fromLocalArpSendToCtrlTable.NewFlow(newNdpFlowMatch(HIGH_MATCH_FLOW_PRIORITY))
+	sendToControllerAct = fromLocalNdpSendToCtrlFlow.NewControllerAction(sw.ControllerID, 0)
+	_ = fromLocalNdpSendToCtrlFlow.SendToController(sendToControllerAct)
+	if err := fromLocalNdpSendToCtrlFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+		return fmt.Errorf("failed to install from local ndp send to controller flow, error: %v", err)
+	}
+
 	return nil
 }
 
+// newNdpFlowMatch matches multicast icmpv6 packets. Neighbor solicitation, duplicate
+// address detection and unsolicited neighbor advertisement are all sent to multicast
+// address, the icmpv6 type would be checked when the controller receives the packet.
+func newNdpFlowMatch(priority uint16) ofctrl.FlowMatch {
+	multicastAddr := net.ParseIP("ff00::")
+	multicastMask := net.ParseIP("ff00::")
+	return ofctrl.FlowMatch{
+		Priority:   priority,
+		Ethertype:  PROTOCOL_IPV6,
+		IpProto:    protocol.Type_IPv6ICMP,
+		Ipv6Da:     &multicastAddr,
+		Ipv6DaMask: &multicastMask,
+	}
+}
+
 func (l *LocalBridge) BridgeReset() {
 }
 
 func (l *LocalBridge) AddLocalEndpoint(endpoint *Endpoint) error {
-	// Table 0, from local endpoint
-	var vlanIDMask uint16 = 0x1fff
-	if endpoint.VlanID != 0 {
-		vlanInputTableFromLocalFlow, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
-			Priority:  MID_MATCH_FLOW_PRIORITY,
-			InputPort: endpoint.PortNo,
-		})
-		if err := vlanInputTableFromLocalFlow.SetVlan(endpoint.VlanID); err != nil {
-			return err
-		}
-		if err := vlanInputTableFromLocalFlow.Resubmit(nil, &l.localEndpointL2LearningTable.TableId); err != nil {
-			return err
-		}
-		if err := vlanInputTableFromLocalFlow.Resubmit(nil, &l.fromLocalRedirectTable.TableId); err != nil {
-			return err
-		}
-		if err := vlanInputTableFromLocalFlow.Next(ofctrl.NewEmptyElem()); err != nil {
-			return err
-		}
-		log.Infof("add from local endpoint flow: %v", vlanInputTableFromLocalFlow)
-		l.fromLocalEndpointFlow[endpoint.PortNo] = vlanInputTableFromLocalFlow
+	// Table 0 and Table 2, from local endpoint
+	if err := l.addFromLocalEndpointFlow(endpoint); err != nil {
+		return err
 	}
 
 	// Table 1, from local to local bum redirect flow
+	var vlanIDMask uint16 = 0x1fff
 	endpointMac, _ := net.ParseMAC(endpoint.MacAddrStr)
 	localToLocalBUMFlow, _ := l.localEndpointL2ForwardingTable.NewFlow(ofctrl.FlowMatch{
 		Priority:   MID_MATCH_FLOW_PRIORITY,
@@ -657,13 +1051,9 @@ func (l *LocalBridge) AddLocalEndpoint(endpoint *Endpoint) error {
 }
 
 func (l *LocalBridge) RemoveLocalEndpoint(endpoint *Endpoint) error {
-	// remove table 0 from local endpoing flow
-	log.Infof("remove from local endpoint flow: %v", l.fromLocalEndpointFlow[endpoint.PortNo])
-	if endpoint.VlanID != 0 {
-		if err := l.fromLocalEndpointFlow[endpoint.PortNo].Delete(); err != nil {
-			return err
-		}
-		delete(l.fromLocalEndpointFlow, endpoint.PortNo)
+	// remove table 0 and table 2 from local endpoing flow
+	if err := l.removeFromLocalEndpointFlow(endpoint); err != nil {
+		return err
 	}
 
 	// remote table 1 local to local bum redirect flow
@@ -676,6 +1066,103 @@ func (l *LocalBridge) RemoveLocalEndpoint(endpoint *Endpoint) error {
 	return nil
 }
 
+// addFromLocalEndpointFlow set vlan of packets from local endpoint, and check them by spoof guard
+// table if spoof guard enabled on the endpoint.
+func (l *LocalBridge) addFromLocalEndpointFlow(endpoint *Endpoint) error {
+	spoofGuardEnabled := l.isSpoofGuardEnabled(endpoint)
+	if endpoint.VlanID == 0 && !spoofGuardEnabled {
+		return nil
+	}
+	if spoofGuardEnabled {
+		// install spoof guard flows before sending traffic to spoof guard table
+		if err := l.addSpoofGuardFlows(endpoint); err != nil {
+			return err
+		}
+	}
+
+	vlanInputTableFromLocalFlow, _ := l.vlanInputTable.NewFlow(ofctrl.FlowMatch{
+		Priority:  MID_MATCH_FLOW_PRIORITY,
+		InputPort: endpoint.PortNo,
+	})
+	if endpoint.VlanID != 0 {
+		if err := vlanInputTableFromLocalFlow.SetVlan(endpoint.VlanID); err != nil {
+			return err
+		}
+	}
+	if spoofGuardEnabled {
+		if err := vlanInputTableFromLocalFlow.Next(l.spoofGuardTable); err != nil {
+			return err
+		}
+	} else {
+		if err := vlanInputTableFromLocalFlow.Resubmit(nil, &l.localEndpointL2LearningTable.TableId); err != nil {
+			return err
+		}
+		if err := vlanInputTableFromLocalFlow.Resubmit(nil, &l.fromLocalRedirectTable.TableId); err != nil {
+			return err
+		}
+		if err := vlanInputTableFromLocalFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+			return err
+		}
+	}
+	log.Infof("add from local endpoint flow: %v", vlanInputTableFromLocalFlow)
+	l.fromLocalEndpointFlow[endpoint.PortNo] = vlanInputTableFromLocalFlow
+
+	return nil
+}
+
+// updateFromLocalEndpointFlow updates from local endpoint flows when spoof guard policy of the
+// endpoint changes. Flows are deleted by cookie, the new flow replaces the old one with the same
+// match when added, so the old flows are removed after the new ones added, traffic of the endpoint
+// is never dropped or bypasses spoof guard in the meantime.
+func (l *LocalBridge) updateFromLocalEndpointFlow(endpoint *Endpoint) error {
+	spoofGuardEnabled := l.isSpoofGuardEnabled(endpoint)
+	l.spoofGuardMutex.Lock()
+	_, spoofGuardInstalled := l.spoofGuardFlows[endpoint.PortNo]
+	l.spoofGuardMutex.Unlock()
+
+	if spoofGuardEnabled && spoofGuardInstalled {
+		// only allowed ips of the endpoint changes
+		l.spoofGuardMutex.Lock()
+		defer l.spoofGuardMutex.Unlock()
+		return l.installSpoofGuardAllowFlows(endpoint)
+	}
+	if !spoofGuardEnabled && !spoofGuardInstalled {
+		return nil
+	}
+
+	oldFlow, ok := l.fromLocalEndpointFlow[endpoint.PortNo]
+	delete(l.fromLocalEndpointFlow, endpoint.PortNo)
+	if err := l.addFromLocalEndpointFlow(endpoint); err != nil {
+		if ok {
+			l.fromLocalEndpointFlow[endpoint.PortNo] = oldFlow
+		}
+		return err
+	}
+	if ok {
+		log.Infof("remove from local endpoint flow: %v", oldFlow)
+		if err := oldFlow.Delete(); err != nil {
+			return err
+		}
+	}
+
+	if spoofGuardInstalled {
+		return l.removeSpoofGuardFlows(endpoint.PortNo)
+	}
+	return nil
+}
+
+func (l *LocalBridge) removeFromLocalEndpointFlow(endpoint *Endpoint) error {
+	if fromLocalEndpointFlow, ok := l.fromLocalEndpointFlow[endpoint.PortNo]; ok {
+		log.Infof("remove from local endpoint flow: %v", fromLocalEndpointFlow)
+		if err := fromLocalEndpointFlow.Delete(); err != nil {
+			return err
+		}
+		delete(l.fromLocalEndpointFlow, endpoint.PortNo)
+	}
+
+	return l.removeSpoofGuardFlows(endpoint.PortNo)
+}
+
 func (l *LocalBridge) AddMicroSegmentRule(rule *EveroutePolicyRule, direction uint8, tier uint8) (*FlowEntry, error) {
 	return nil, nil
 }
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ruleOperationAdd    = "add"
+	ruleOperationDelete = "delete"
+	ruleOperationCommit = "commit"
+)
+
+var (
+	policyRulesDesc = prometheus.NewDesc(
+		"everoute_agent_datapath_policy_rules",
+		"Number of policy rules installed in datapath.",
+		nil, nil,
+	)
+	policyRuleFlowsDesc = prometheus.NewDesc(
+		"everoute_agent_datapath_policy_rule_flows",
+		"Number of policy rule flows installed per bridge and tier.",
+		[]string{"bridge", "tier"}, nil,
+	)
+
+	ruleOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "everoute_agent_datapath_rule_operation_duration_seconds",
+		Help: "Latency of adding or deleting policy rule in datapath.",
+	}, []string{"operation"})
+	ruleOperationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "everoute_agent_datapath_rule_operation_failures_total",
+		Help: "Number of failures when adding or deleting policy rule in datapath.",
+	}, []string{"operation"})
+	flowReplayDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "everoute_agent_datapath_flow_replay_duration_seconds",
+		Help:    "Duration of replaying flows after bridge reconnected, the count is the number of replays.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"bridge"})
+	openflowReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "everoute_agent_openflow_reconnects_total",
+		Help: "Number of OpenFlow reconnects of bridges.",
+	}, []string{"bridge"})
+	ovsdbReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "everoute_agent_ovsdb_reconnects_total",
+		Help: "Number of ovsdb reconnects.",
+	})
+	learnedIPUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "everoute_agent_learned_ip_updates_total",
+		Help: "Number of local endpoint ip address updates learned from packets.",
+	}, []string{"origin"})
+	learnedIPExpirations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "everoute_agent_learned_ip_expirations_total",
+		Help: "Number of learned local endpoint ip addresses expired.",
+	})
+	flowDrifts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "everoute_agent_datapath_flow_drifts_total",
+		Help: "Number of flows found drifted from expected in policy bridge, by drift type.",
+	}, []string{"bridge", "type"})
+
+	datapathCollectors = []prometheus.Collector{
+		ruleOperationDuration, ruleOperationFailures, flowReplayDuration,
+		openflowReconnects, ovsdbReconnects, learnedIPUpdates, learnedIPExpirations,
+		flowDrifts,
+	}
+)
+
+var policyTierNames = map[uint8]string{
+	POLICY_TIER0: "tier0",
+	POLICY_TIER1: "tier1",
+	POLICY_TIER2: "tier2",
+}
+
+// Describe implements prometheus.Collector
+func (datapathManager *DpManager) Describe(ch chan<- *prometheus.Desc) {
+	ch <- policyRulesDesc
+	ch <- policyRuleFlowsDesc
+	for _, collector := range datapathCollectors {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (datapathManager *DpManager) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range datapathCollectors {
+		collector.Collect(ch)
+	}
+
+	datapathManager.flowReplayMutex.RLock()
+	defer datapathManager.flowReplayMutex.RUnlock()
+
+	datapathManager.DpManagerMutex.Lock()
+	bridgeChainMap := datapathManager.BridgeChainMap
+	datapathManager.DpManagerMutex.Unlock()
+
+	// map bridge name to tier name to flow numbers
+	flows := make(map[string]map[string]int)
+	for _, ruleEntry := range datapathManager.Rules {
+		for vdsID := range ruleEntry.RuleFlowMap {
+			policyBridge, ok := bridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+			if !ok {
+				// vds has been removed
+				continue
+			}
+			bridge := policyBridge.name
+			if flows[bridge] == nil {
+				flows[bridge] = make(map[string]int)
+			}
+			flows[bridge][policyTierNames[ruleEntry.Tier]]++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(policyRulesDesc, prometheus.GaugeValue, float64(len(datapathManager.Rules)))
+	for bridge, tierFlows := range flows {
+		for tier, num := range tierFlows {
+			ch <- prometheus.MustNewConstMetric(policyRuleFlowsDesc, prometheus.GaugeValue, float64(num), bridge, tier)
+		}
+	}
+}
+
+// observeRuleOperation records latency of the operation, and the failure if err not nil.
+func observeRuleOperation(operation string, start time.Time, err error) {
+	ruleOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ruleOperationFailures.WithLabelValues(operation).Inc()
+	}
+}
+
+// metricsBridgeName returns the bridge name of the keyword in the vds, used as metrics label.
+func metricsBridgeName(ovsbrname, bridgeKeyword string) string {
+	if bridgeKeyword == LOCAL_BRIDGE_KEYWORD {
+		return ovsbrname
+	}
+	return fmt.Sprintf("%s-%s", ovsbrname, bridgeKeyword)
+}
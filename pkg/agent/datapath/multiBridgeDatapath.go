@@ -42,6 +42,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
 	"github.com/everoute/everoute/pkg/constants"
 )
 
@@ -64,6 +65,8 @@ const (
 	CLS_TO_UPLINK_PORT   = 301
 	UPLINK_TO_CLS_PORT   = 302
 	LOCAL_GATEWAY_PORT   = 10
+	UPLINK_GATEWAY_PORT  = 10
+	UPLINK_TUNNEL_PORT   = 20
 )
 
 //nolint
@@ -87,8 +90,23 @@ const (
 
 //nolint
 const (
-	PROTOCOL_ARP = 0x0806
-	PROTOCOL_IP  = 0x0800
+	PROTOCOL_ARP  = 0x0806
+	PROTOCOL_IP   = 0x0800
+	PROTOCOL_IPV6 = 0x86DD
+	PROTOCOL_TCP  = 0x06
+	PROTOCOL_UDP  = 0x11
+)
+
+//nolint
+const (
+	DHCP_SERVER_PORT = 67
+	DHCP_CLIENT_PORT = 68
+)
+
+//nolint
+const (
+	ICMPV6_NEIGHBOR_SOLICITATION  = 135
+	ICMPV6_NEIGHBOR_ADVERTISEMENT = 136
 )
 
 //nolint
@@ -109,6 +127,7 @@ const (
 	openflowProtorolVersion11 string = "OpenFlow11"
 	openflowProtorolVersion12 string = "OpenFlow12"
 	openflowProtorolVersion13 string = "OpenFlow13"
+	openflowProtorolVersion14 string = "OpenFlow14"
 
 	IPAddressTimeout                        = 60
 	IPAddressCacheUpdateInterval            = 5
@@ -117,6 +136,9 @@ const (
 	ClsBridgeL2ForwardingTableHardTimeout   = 300
 	ClsBridgeL2ForwardingTableIdleTimeout   = 300
 	MaxIPAddressLearningFrenquency          = 5
+
+	policyBridgeStaleFlowCleanTimeout = 30 * time.Second
+	vdsConnectTimeout                 = 40 * time.Second
 )
 
 type Bridge interface {
@@ -159,13 +181,38 @@ type DpManager struct {
 
 	controllerIDSets          sets.String
 	localEndpointDB           cmap.ConcurrentMap     // list of local endpoint map
-	ofPortIPAddressUpdateChan chan map[string]net.IP // map bridgename-ofport to endpoint ips
+	ofPortIPAddressUpdateChan chan map[string]LearnedIPAddress // map bridgename-ofport to endpoint ips
 	datapathConfig            *Config
 	Rules                     map[string]*EveroutePolicyRuleEntry // rules database
 	flowReplayChan            chan struct{}
 	flowReplayMutex           sync.RWMutex
 	ovsdbReconnectChan        chan struct{}
 
+	policyRulesAdoptOnce sync.Once
+	policyRulesAdopted   chan struct{} // closed after policy rules adopted from checkpoint
+
+	spoofGuardPolicyMutex sync.RWMutex
+	spoofGuardPolicies    map[string]*SpoofGuardPolicy // map endpoint to its spoof guard policy
+
+	traceflowMutex    sync.Mutex
+	traceflowSessions map[uint8]*traceflowSession          // map traceflow tag to its session
+	traceflowPoints   map[traceflowPointKey]*traceflowPoint // map traceflow flow to its observation point
+
+	flowDriftBaseFlows map[string]map[uint64]string // map vds to base flows of its policy bridge, map cookie to the flow dumped
+
+	vdsStopChans map[string]chan struct{} // map vds to channel stops its goroutines, protected by DpManagerMutex
+
+	configMutex sync.Mutex // serialize datapath config updates
+
+	tunnelPeerMutex sync.Mutex
+	tunnelPeers     map[string]*TunnelPeer // map node name to tunnel peer
+
+	serviceMutex sync.Mutex
+	services     map[string][]*ServicePort // map service namespaced name to its ports
+
+	egressMutex sync.Mutex
+	egressRules map[string]*EgressRule // map egress policy namespaced name to its rule
+
 	AgentInfo *AgentConf
 }
 
@@ -176,20 +223,64 @@ type AgentConf struct {
 	PodCIDR    []cnitypes.IPNet
 	BridgeName string
 
-	ClusterCIDR *cnitypes.IPNet
+	// ClusterCIDR and ClusterCIDRv6 are service cidrs of each ip family, nil if
+	// the family not enabled in the cluster.
+	ClusterCIDR   *cnitypes.IPNet
+	ClusterCIDRv6 *cnitypes.IPNet
 
 	LocalGwName string
 	LocalGwIP   net.IP
 	LocalGwMac  net.HardwareAddr
 
+	// GatewayIP and GatewayIPv6 are the first ip in pod cidr of each ip family,
+	// nil if the family not enabled on the node.
 	GatewayName string
 	GatewayIP   net.IP
+	GatewayIPv6 net.IP
 	GatewayMac  net.HardwareAddr
+
+	// TunnelType is the tunnel type of overlay mode, traffic to pods on other nodes would be
+	// encapsulated on uplink bridge. Empty means the traffic is routed by underlay.
+	TunnelType string
+
+	// EnableServiceProxy load balances traffic from local pods to service cluster ips and node ports
+	// of NodeIPs on local bridge, and traffic from outside to node ports on uplink bridge, which is
+	// sent to the node by gateway after dnat. Kube-proxy is not needed.
+	EnableServiceProxy bool
+
+	// EnableHostEndpointPolicy sends traffic to the node from pods, and from outside by uplink bridge
+	// to ipv4 NodeIPs, through policy bridge, so policies of host endpoint could be enforced. Traffic
+	// to HostEndpointSafePorts of the node is always allowed.
+	EnableHostEndpointPolicy bool
+	NodeIPs                  []net.IP
+	HostEndpointSafePorts    []HostEndpointPort
+}
+
+// PodCIDROfFamily returns the first pod cidr of the ip family, nil if not found.
+func (a *AgentConf) PodCIDROfFamily(ipv6 bool) *cnitypes.IPNet {
+	for i := range a.PodCIDR {
+		if (a.PodCIDR[i].IP.To4() == nil) == ipv6 {
+			return &a.PodCIDR[i]
+		}
+	}
+	return nil
 }
 
 type Config struct {
 	ManagedVDSMap map[string]string // map vds to ovsbr-name
 	InternalIPs   []string          // internal IPs
+
+	// EnableDHCPSnooping learns endpoint ipv4 address only from dhcp ack,
+	// ip learned from arp would be ignored unless it's bound by dhcp. Ipv6
+	// address is still learned from ndp.
+	EnableDHCPSnooping bool
+
+	// EnableSpoofGuard drops packets from local endpoint with source mac, arp sender or source ip
+	// not belongs to the endpoint.
+	EnableSpoofGuard bool
+
+	// FlowDriftCheckInterval is the interval of checking policy bridge flows drift, zero disables the check.
+	FlowDriftCheckInterval time.Duration
 }
 
 type Endpoint struct {
@@ -202,6 +293,14 @@ type Endpoint struct {
 	BridgeName    string // bridge name that endpoint attached to
 }
 
+// LearnedIPAddress is an ip address learned from packets of a local endpoint.
+type LearnedIPAddress struct {
+	IP     net.IP
+	Origin agentv1alpha1.IPOrigin
+	// Withdrawn means the ip no longer belongs to the endpoint, e.g. its dhcp lease expired.
+	Withdrawn bool
+}
+
 type EveroutePolicyRule struct {
 	RuleID      string // Unique identifier for the rule
 	Priority    int    // Priority for the rule (1..100. 100 is highest)
@@ -219,6 +318,8 @@ type FlowEntry struct {
 	Table    *ofctrl.Table
 	Priority uint16
 	FlowID   uint64
+
+	flowMod *openflow13.FlowMod // flow mod adds the flow, it restores the flow when rollback
 }
 
 type EveroutePolicyRuleEntry struct {
@@ -236,7 +337,7 @@ type RoundInfo struct {
 // Datapath manager act as openflow controller:
 // 1. event driven local endpoint info crud and related flow update,
 // 2. collect local endpoint ip learned from different ovsbr(1 per vds), and sync it to management plane
-func NewDatapathManager(datapathConfig *Config, ofPortIPAddressUpdateChan chan map[string]net.IP) *DpManager {
+func NewDatapathManager(datapathConfig *Config, ofPortIPAddressUpdateChan chan map[string]LearnedIPAddress) *DpManager {
 	datapathManager := new(DpManager)
 	datapathManager.BridgeChainMap = make(map[string]map[string]Bridge)
 	datapathManager.OvsdbDriverMap = make(map[string]map[string]*ovsdbDriver.OvsDriver)
@@ -250,13 +351,24 @@ func NewDatapathManager(datapathConfig *Config, ofPortIPAddressUpdateChan chan m
 	datapathManager.flowReplayChan = make(chan struct{})
 	datapathManager.flowReplayMutex = sync.RWMutex{}
 	datapathManager.ovsdbReconnectChan = make(chan struct{})
+	datapathManager.policyRulesAdopted = make(chan struct{})
+	datapathManager.spoofGuardPolicies = make(map[string]*SpoofGuardPolicy)
+	datapathManager.traceflowSessions = make(map[uint8]*traceflowSession)
+	datapathManager.traceflowPoints = make(map[traceflowPointKey]*traceflowPoint)
+	datapathManager.flowDriftBaseFlows = make(map[string]map[uint64]string)
+	datapathManager.vdsStopChans = make(map[string]chan struct{})
+	datapathManager.tunnelPeers = make(map[string]*TunnelPeer)
+	datapathManager.services = make(map[string][]*ServicePort)
+	datapathManager.egressRules = make(map[string]*EgressRule)
 
 	var wg sync.WaitGroup
 	for vdsID, ovsbrname := range datapathConfig.ManagedVDSMap {
 		wg.Add(1)
 		go func(vdsID, ovsbrname string) {
 			defer wg.Done()
-			NewVDSForConfig(datapathManager, vdsID, ovsbrname)
+			if err := NewVDSForConfig(datapathManager, vdsID, ovsbrname); err != nil {
+				log.Fatalf("Failed to create vds %s: %v", vdsID, err)
+			}
 		}(vdsID, ovsbrname)
 	}
 	wg.Wait()
@@ -272,64 +384,134 @@ func (datapathManager *DpManager) InitializeDatapath(stopChan <-chan struct{}) {
 	}
 
 	var wg sync.WaitGroup
-	for vdsID := range datapathManager.datapathConfig.ManagedVDSMap {
+	for vdsID := range datapathManager.managedVDSMap() {
 		wg.Add(1)
 		go func(vdsID string) {
 			defer wg.Done()
-			InitializeVDS(datapathManager, vdsID, stopChan)
+			if err := InitializeVDS(datapathManager, vdsID, datapathManager.newVDSStopChan(vdsID, stopChan)); err != nil {
+				log.Fatalf("Failed to initialize vds %s: %v", vdsID, err)
+			}
 		}(vdsID)
 	}
 	wg.Wait()
 
 	// add rules for internalIP
-	for _, internalIP := range datapathManager.datapathConfig.InternalIPs {
-		// internal ingress rule
-		err := datapathManager.AddEveroutePolicyRule(newInternalIngressRule(internalIP), POLICY_DIRECTION_IN, POLICY_TIER2)
-		if err != nil {
-			log.Fatalf("Failed to add internal whitelist: %v", err)
-		}
-		// internal egress rule
-		err = datapathManager.AddEveroutePolicyRule(newInternalEgressRule(internalIP), POLICY_DIRECTION_OUT, POLICY_TIER2)
-		if err != nil {
-			log.Fatalf("Failed to add internal whitelist: %v", err)
-		}
+	if err := datapathManager.updateInternalIPRules(nil, datapathManager.datapathConfig.InternalIPs); err != nil {
+		log.Fatalf("Failed to add internal whitelist: %v", err)
 	}
 
 	go watchFile(ovsdbDomainSock, stopChan, datapathManager.ovsdbReconnectChan)
 
 	go func() {
 		for range datapathManager.ovsdbReconnectChan {
+			ovsdbReconnects.Inc()
 			if err := datapathManager.ovsdbConnectionReset(); err != nil {
 				log.Fatalf("Failed to reset ovsbd connection while ovsdb recovery")
 			}
 		}
 	}()
 
+	for vdsID := range datapathManager.managedVDSMap() {
+		datapathManager.watchVDSReconnect(vdsID)
+	}
+}
+
+// watchVDSReconnect replays bridge flows when bridges of the vds reconnected, until the vds stopped.
+// Events of vds no longer managed are ignored.
+func (datapathManager *DpManager) watchVDSReconnect(vdsID string) {
+	vdsStopChan := datapathManager.getVDSStopChan(vdsID)
 	bridgeKeywordList := []string{LOCAL_BRIDGE_KEYWORD, POLICY_BRIDGE_KEYWORD, CLS_BRIDGE_KEYWORD, UPLINK_BRIDGE_KEYWORD}
-	for vdsID := range datapathManager.datapathConfig.ManagedVDSMap {
-		for _, bridgeKeyword := range bridgeKeywordList {
-			go func(vdsID, bridgeKeyword string) {
-				for range datapathManager.ControllerMap[vdsID][bridgeKeyword].DisconnChan {
-					log.Infof("Received vds %v bridge %v reconnect event", vdsID, bridgeKeyword)
-					if err := datapathManager.replayVDSFlow(vdsID, bridgeKeyword); err != nil {
-						log.Fatalf("Failed to replay vds %v, %v flow, error: %v", vdsID, bridgeKeyword, err)
-					}
+	for _, bridgeKeyword := range bridgeKeywordList {
+		go func(vdsID, bridgeKeyword string, disconnChan chan bool) {
+			for {
+				select {
+				case <-vdsStopChan:
+					return
+				case <-disconnChan:
 				}
-			}(vdsID, bridgeKeyword)
+				ovsbrname, ok := datapathManager.managedVDSMap()[vdsID]
+				if !ok {
+					continue
+				}
+				log.Infof("Received vds %v bridge %v reconnect event", vdsID, bridgeKeyword)
+				openflowReconnects.WithLabelValues(metricsBridgeName(ovsbrname, bridgeKeyword)).Inc()
+				if err := datapathManager.replayVDSFlow(vdsID, bridgeKeyword); err != nil {
+					log.Fatalf("Failed to replay vds %v, %v flow, error: %v", vdsID, bridgeKeyword, err)
+				}
+			}
+		}(vdsID, bridgeKeyword, datapathManager.ControllerMap[vdsID][bridgeKeyword].DisconnChan)
+	}
+}
+
+// newVDSStopChan returns channel of the vds, it would be closed when stopChan closed or the vds stopped.
+func (datapathManager *DpManager) newVDSStopChan(vdsID string, stopChan <-chan struct{}) <-chan struct{} {
+	vdsStopChan := make(chan struct{})
+	datapathManager.DpManagerMutex.Lock()
+	datapathManager.vdsStopChans[vdsID] = vdsStopChan
+	datapathManager.DpManagerMutex.Unlock()
+
+	go func() {
+		select {
+		case <-stopChan:
+			datapathManager.stopVDS(vdsID)
+		case <-vdsStopChan:
 		}
+	}()
+	return vdsStopChan
+}
+
+func (datapathManager *DpManager) getVDSStopChan(vdsID string) <-chan struct{} {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+	return datapathManager.vdsStopChans[vdsID]
+}
+
+// stopVDS stops goroutines of the vds.
+func (datapathManager *DpManager) stopVDS(vdsID string) {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+
+	if vdsStopChan, ok := datapathManager.vdsStopChans[vdsID]; ok {
+		close(vdsStopChan)
+		delete(datapathManager.vdsStopChans, vdsID)
 	}
 }
 
+// managedVDSMap returns the managed vds map. The map is replaced instead of modified when vds
+// added or removed, so it's safe to range over the returned map.
+func (datapathManager *DpManager) managedVDSMap() map[string]string {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+	return datapathManager.datapathConfig.ManagedVDSMap
+}
+
 func (datapathManager *DpManager) InitializeCNI() {
 	var wg sync.WaitGroup
-	for vdsID := range datapathManager.datapathConfig.ManagedVDSMap {
+	for vdsID := range datapathManager.managedVDSMap() {
 		wg.Add(1)
 		go func(vdsID string) {
 			defer wg.Done()
 			datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].BridgeInitCNI()
+			datapathManager.BridgeChainMap[vdsID][UPLINK_BRIDGE_KEYWORD].BridgeInitCNI()
 		}(vdsID)
 	}
 	wg.Wait()
+
+	// rules are replayed with other policy rules when bridges reconnected
+	if datapathManager.AgentInfo.EnableCNI && datapathManager.AgentInfo.EnableHostEndpointPolicy {
+		if err := datapathManager.addHostEndpointSafeRules(); err != nil {
+			log.Fatalf("Failed to add host endpoint safe rules: %v", err)
+		}
+	}
+}
+
+// LocalBridgeOvsdbDriver returns ovsdb driver of local bridge of the managed vds, nil if the vds
+// not managed.
+func (datapathManager *DpManager) LocalBridgeOvsdbDriver(vdsID string) *ovsdbDriver.OvsDriver {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+
+	return datapathManager.OvsdbDriverMap[vdsID][LOCAL_BRIDGE_KEYWORD]
 }
 
 func (datapathManager *DpManager) GenerateControllerID() uint16 {
@@ -351,7 +533,7 @@ func (datapathManager *DpManager) GenerateControllerID() uint16 {
 	}
 }
 
-func NewVDSForConfig(datapathManager *DpManager, vdsID, ovsbrname string) {
+func NewVDSForConfig(datapathManager *DpManager, vdsID, ovsbrname string) error {
 	// initialize vds bridge chain
 	localBridge := NewLocalBridge(ovsbrname, datapathManager)
 	policyBridge := NewPolicyBridge(ovsbrname, datapathManager)
@@ -391,43 +573,72 @@ func NewVDSForConfig(datapathManager *DpManager, vdsID, ovsbrname string) {
 	}
 	wg.Wait()
 
-	// datapathManager config: write once, read many times, only agent initialize procedure would write this map,
-	// thus lock it while write
-	datapathManager.DpManagerMutex.Lock()
-	datapathManager.BridgeChainMap[vdsID] = vdsBridgeMap
-	datapathManager.ControllerMap[vdsID] = vdsOfControllerMap
-	datapathManager.OvsdbDriverMap[vdsID] = vdsOvsdbDriverMap
-	datapathManager.DpManagerMutex.Unlock()
+	// datapathManager config: write once, read many times, only agent initialize procedure and config reload
+	// would write this map, thus lock it while write
+	datapathManager.setVDSBridgeChain(vdsID, vdsBridgeMap, vdsOfControllerMap, vdsOvsdbDriverMap)
 
-	// setbridge work with openflow10 ~ openflow13
+	// setbridge work with openflow10 ~ openflow14, openflow14 is required by bundle
 	protocols := map[string][]string{
 		"protocols": {
 			openflowProtorolVersion10, openflowProtorolVersion11, openflowProtorolVersion12, openflowProtorolVersion13,
+			openflowProtorolVersion14,
 		},
 	}
 	if err := vdsOvsdbDriverMap[LOCAL_BRIDGE_KEYWORD].UpdateBridge(protocols); err != nil {
-		log.Fatalf("Failed to set local bridge: %v protocols, error: %v", vdsID, err)
+		return fmt.Errorf("failed to set local bridge: %v protocols, error: %v", vdsID, err)
 	}
 	if err := vdsOvsdbDriverMap[POLICY_BRIDGE_KEYWORD].UpdateBridge(protocols); err != nil {
-		log.Fatalf("Failed to set policy bridge: %v protocols, error: %v", vdsID, err)
+		return fmt.Errorf("failed to set policy bridge: %v protocols, error: %v", vdsID, err)
 	}
 	if err := vdsOvsdbDriverMap[CLS_BRIDGE_KEYWORD].UpdateBridge(protocols); err != nil {
-		log.Fatalf("Failed to set cls bridge: %v protocols, error: %v", vdsID, err)
+		return fmt.Errorf("failed to set cls bridge: %v protocols, error: %v", vdsID, err)
 	}
 	if err := vdsOvsdbDriverMap[UPLINK_BRIDGE_KEYWORD].UpdateBridge(protocols); err != nil {
-		log.Fatalf("Failed to set uplink bridge: %v protocols, error: %v", vdsID, err)
+		return fmt.Errorf("failed to set uplink bridge: %v protocols, error: %v", vdsID, err)
 	}
 
 	go vdsOfControllerMap[LOCAL_BRIDGE_KEYWORD].Connect(fmt.Sprintf("%s/%s.%s", ovsVswitchdUnixDomainSockPath, localBridge.name, ovsVswitchdUnixDomainSockSuffix))
 	go vdsOfControllerMap[POLICY_BRIDGE_KEYWORD].Connect(fmt.Sprintf("%s/%s.%s", ovsVswitchdUnixDomainSockPath, policyBridge.name, ovsVswitchdUnixDomainSockSuffix))
 	go vdsOfControllerMap[CLS_BRIDGE_KEYWORD].Connect(fmt.Sprintf("%s/%s.%s", ovsVswitchdUnixDomainSockPath, clsBridge.name, ovsVswitchdUnixDomainSockSuffix))
 	go vdsOfControllerMap[UPLINK_BRIDGE_KEYWORD].Connect(fmt.Sprintf("%s/%s.%s", ovsVswitchdUnixDomainSockPath, uplinkBridge.name, ovsVswitchdUnixDomainSockSuffix))
+	return nil
+}
+
+// setVDSBridgeChain sets bridge chain of the vds into copies of the maps, so goroutines ranging over
+// the old maps would not be affected. Nil vdsBridgeMap removes the vds.
+func (datapathManager *DpManager) setVDSBridgeChain(vdsID string, vdsBridgeMap map[string]Bridge,
+	vdsOfControllerMap map[string]*ofctrl.Controller, vdsOvsdbDriverMap map[string]*ovsdbDriver.OvsDriver) {
+	datapathManager.DpManagerMutex.Lock()
+	defer datapathManager.DpManagerMutex.Unlock()
+
+	bridgeChainMap := make(map[string]map[string]Bridge, len(datapathManager.BridgeChainMap)+1)
+	controllerMap := make(map[string]map[string]*ofctrl.Controller, len(datapathManager.ControllerMap)+1)
+	ovsdbDriverMap := make(map[string]map[string]*ovsdbDriver.OvsDriver, len(datapathManager.OvsdbDriverMap)+1)
+	for id := range datapathManager.BridgeChainMap {
+		bridgeChainMap[id] = datapathManager.BridgeChainMap[id]
+		controllerMap[id] = datapathManager.ControllerMap[id]
+		ovsdbDriverMap[id] = datapathManager.OvsdbDriverMap[id]
+	}
+
+	if vdsBridgeMap == nil {
+		delete(bridgeChainMap, vdsID)
+		delete(controllerMap, vdsID)
+		delete(ovsdbDriverMap, vdsID)
+	} else {
+		bridgeChainMap[vdsID] = vdsBridgeMap
+		controllerMap[vdsID] = vdsOfControllerMap
+		ovsdbDriverMap[vdsID] = vdsOvsdbDriverMap
+	}
+
+	datapathManager.BridgeChainMap = bridgeChainMap
+	datapathManager.ControllerMap = controllerMap
+	datapathManager.OvsdbDriverMap = ovsdbDriverMap
 }
 
-func InitializeVDS(datapathManager *DpManager, vdsID string, stopChan <-chan struct{}) {
+func InitializeVDS(datapathManager *DpManager, vdsID string, stopChan <-chan struct{}) error {
 	roundInfo, err := getRoundInfo(datapathManager.OvsdbDriverMap[vdsID][LOCAL_BRIDGE_KEYWORD])
 	if err != nil {
-		log.Fatalf("Failed to get Roundinfo from ovsdb: %v", err)
+		return fmt.Errorf("failed to get Roundinfo from ovsdb: %v", err)
 	}
 
 	// Delete flow with curRoundNum cookie, for case: failed when restart process flow install.
@@ -445,7 +656,7 @@ func InitializeVDS(datapathManager *DpManager, vdsID string, stopChan <-chan str
 	datapathManager.BridgeChainMap[vdsID][UPLINK_BRIDGE_KEYWORD].(*UplinkBridge).OfSwitch.CookieAllocator = cookieAllocator
 
 	datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].BridgeInit()
-	datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].BridgeInit()
+	datapathManager.initPolicyBridge(vdsID)
 	datapathManager.BridgeChainMap[vdsID][CLS_BRIDGE_KEYWORD].BridgeInit()
 	datapathManager.BridgeChainMap[vdsID][UPLINK_BRIDGE_KEYWORD].BridgeInit()
 
@@ -454,7 +665,7 @@ func InitializeVDS(datapathManager *DpManager, vdsID string, stopChan <-chan str
 
 	if err := SetPortNoFlood(datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].(*LocalBridge).name,
 		LOCAL_TO_POLICY_PORT); err != nil {
-		log.Fatalf("Failed to set local to policy port with no flood port mode, %v", err)
+		return fmt.Errorf("failed to set local to policy port with no flood port mode, %v", err)
 	}
 
 	// Delete flow with previousRoundNum cookie, and then persistent curRoundNum to ovsdb. We need to wait for long
@@ -463,22 +674,46 @@ func InitializeVDS(datapathManager *DpManager, vdsID string, stopChan <-chan str
 	// non-determined.
 	// TODO  Implement a deterministic mechanism to control outdated flow flush procedure
 	go func(vdsID string) {
-		time.Sleep(time.Second * 15)
+		select {
+		case <-stopChan:
+			// vds has been removed or agent stopped
+			return
+		case <-time.After(time.Second * 15):
+		}
 
 		datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].(*LocalBridge).OfSwitch.DeleteFlowByRoundInfo(roundInfo.previousRoundNum)
-		datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge).OfSwitch.DeleteFlowByRoundInfo(roundInfo.previousRoundNum)
 		datapathManager.BridgeChainMap[vdsID][CLS_BRIDGE_KEYWORD].(*ClsBridge).OfSwitch.DeleteFlowByRoundInfo(roundInfo.previousRoundNum)
 		datapathManager.BridgeChainMap[vdsID][UPLINK_BRIDGE_KEYWORD].(*UplinkBridge).OfSwitch.DeleteFlowByRoundInfo(roundInfo.previousRoundNum)
+		// policy rule flows adopted from checkpoint keep their cookie of previous rounds, clean stale
+		// flows after adopted, or they would be deleted and reinstalled
+		if !datapathManager.waitPolicyRulesAdopted(stopChan) {
+			return
+		}
+		err := wait.PollImmediate(time.Second, policyBridgeStaleFlowCleanTimeout, func() (bool, error) {
+			if err := datapathManager.cleanPolicyBridgeStaleFlows(vdsID, roundInfo); err != nil {
+				log.Errorf("Failed to clean policy bridge stale flows, retry later: %v", err)
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			log.Errorf("Failed to clean policy bridge stale flows, fallback to delete all flows of previous round and reinstall adopted rule flows")
+			if err := datapathManager.reinstallAdoptedRuleFlows(vdsID, roundInfo); err != nil {
+				log.Errorf("Failed to reinstall adopted rule flows of vds %s: %v", vdsID, err)
+			}
+		}
 
-		err := persistentRoundInfo(roundInfo.curRoundNum, datapathManager.OvsdbDriverMap[vdsID][LOCAL_BRIDGE_KEYWORD])
+		err = persistentRoundInfo(roundInfo.curRoundNum, datapathManager.OvsdbDriverMap[vdsID][LOCAL_BRIDGE_KEYWORD])
 		if err != nil {
-			log.Fatalf("Failed to persistent roundInfo into ovsdb: %v", err)
+			log.Errorf("Failed to persistent roundInfo into ovsdb: %v", err)
 		}
 	}(vdsID)
+
+	return nil
 }
 
 func (datapathManager *DpManager) ovsdbConnectionReset() error {
-	for vdsID := range datapathManager.datapathConfig.ManagedVDSMap {
+	for vdsID := range datapathManager.managedVDSMap() {
 		if err := datapathManager.OvsdbDriverMap[vdsID][LOCAL_BRIDGE_KEYWORD].ReConnectOvsdb(); err != nil {
 			return fmt.Errorf("failed to reconnect vds %v localBridge ovsdb, error: %v", vdsID, err)
 		}
@@ -499,6 +734,20 @@ func (datapathManager *DpManager) ovsdbConnectionReset() error {
 func (datapathManager *DpManager) replayVDSFlow(vdsID, bridgeKeyword string) error {
 	datapathManager.flowReplayMutex.Lock()
 	defer datapathManager.flowReplayMutex.Unlock()
+	if _, ok := datapathManager.BridgeChainMap[vdsID]; !ok {
+		// vds has been removed from config
+		return nil
+	}
+	return datapathManager.replayVDSFlowLocked(vdsID, bridgeKeyword)
+}
+
+// replayVDSFlowLocked replays flows of the vds bridge, flowReplayMutex must be held by the caller.
+func (datapathManager *DpManager) replayVDSFlowLocked(vdsID, bridgeKeyword string) error {
+
+	defer func(start time.Time) {
+		bridge := metricsBridgeName(datapathManager.managedVDSMap()[vdsID], bridgeKeyword)
+		flowReplayDuration.WithLabelValues(bridge).Observe(time.Since(start).Seconds())
+	}(time.Now())
 
 	if !datapathManager.IsBridgesConnected() {
 		// 1 second retry interval is too long
@@ -521,8 +770,13 @@ func (datapathManager *DpManager) replayVDSFlow(vdsID, bridgeKeyword string) err
 	case UPLINK_BRIDGE_KEYWORD:
 		datapathManager.BridgeChainMap[vdsID][bridgeKeyword].(*UplinkBridge).OfSwitch.CookieAllocator = cookieAllocator
 	}
-	datapathManager.BridgeChainMap[vdsID][bridgeKeyword].BridgeInit()
-	datapathManager.BridgeChainMap[vdsID][bridgeKeyword].BridgeInitCNI()
+	if bridgeKeyword == POLICY_BRIDGE_KEYWORD {
+		// base flows are reinstalled with new cookies, record them again
+		datapathManager.initPolicyBridge(vdsID)
+	} else {
+		datapathManager.BridgeChainMap[vdsID][bridgeKeyword].BridgeInit()
+		datapathManager.BridgeChainMap[vdsID][bridgeKeyword].BridgeInitCNI()
+	}
 
 	// replay local endpoint flow
 	if bridgeKeyword == LOCAL_BRIDGE_KEYWORD {
@@ -542,7 +796,7 @@ func (datapathManager *DpManager) replayVDSFlow(vdsID, bridgeKeyword string) err
 }
 
 func (datapathManager *DpManager) ReplayVDSLocalEndpointFlow(vdsID string) error {
-	ovsbrname := datapathManager.datapathConfig.ManagedVDSMap[vdsID]
+	ovsbrname := datapathManager.managedVDSMap()[vdsID]
 	for endpointObj := range datapathManager.localEndpointDB.IterBuffered() {
 		endpoint := endpointObj.Val.(*Endpoint)
 		if ovsbrname != endpoint.BridgeName {
@@ -616,7 +870,7 @@ func (datapathManager *DpManager) AddLocalEndpoint(endpoint *Endpoint) error {
 		datapathManager.WaitForBridgeConnected()
 	}
 
-	for vdsID, ovsbrname := range datapathManager.datapathConfig.ManagedVDSMap {
+	for vdsID, ovsbrname := range datapathManager.managedVDSMap() {
 		if ovsbrname == endpoint.BridgeName {
 			if ep, _ := datapathManager.localEndpointDB.Get(endpoint.InterfaceName); ep != nil {
 				log.Errorf("Already added local endpoint: %v", ep)
@@ -648,7 +902,7 @@ func (datapathManager *DpManager) UpdateLocalEndpoint(newEndpoint, oldEndpoint *
 	}
 	var err error
 
-	for vdsID, ovsbrname := range datapathManager.datapathConfig.ManagedVDSMap {
+	for vdsID, ovsbrname := range datapathManager.managedVDSMap() {
 		if ovsbrname == newEndpoint.BridgeName {
 			oldEP, _ := datapathManager.localEndpointDB.Get(oldEndpoint.InterfaceName)
 			if oldEP == nil {
@@ -694,7 +948,7 @@ func (datapathManager *DpManager) RemoveLocalEndpoint(endpoint *Endpoint) error
 	}
 	cachedEP := ep.(*Endpoint)
 
-	for vdsID, ovsbrname := range datapathManager.datapathConfig.ManagedVDSMap {
+	for vdsID, ovsbrname := range datapathManager.managedVDSMap() {
 		if ovsbrname == cachedEP.BridgeName {
 			// Same as addLocalEndpoint routine, keep datapath endpointDB is consistent with ovsdb
 			datapathManager.localEndpointDB.Remove(endpoint.InterfaceName)
@@ -710,7 +964,9 @@ func (datapathManager *DpManager) RemoveLocalEndpoint(endpoint *Endpoint) error
 	return nil
 }
 
-func (datapathManager *DpManager) AddEveroutePolicyRule(rule *EveroutePolicyRule, direction uint8, tier uint8) error {
+func (datapathManager *DpManager) AddEveroutePolicyRule(rule *EveroutePolicyRule, direction uint8, tier uint8) (err error) {
+	defer func(start time.Time) { observeRuleOperation(ruleOperationAdd, start, err) }(time.Now())
+
 	datapathManager.flowReplayMutex.Lock()
 	defer datapathManager.flowReplayMutex.Unlock()
 	if !datapathManager.IsBridgesConnected() {
@@ -747,11 +1003,14 @@ func (datapathManager *DpManager) AddEveroutePolicyRule(rule *EveroutePolicyRule
 		RuleFlowMap:        ruleFlowMap,
 	}
 	datapathManager.Rules[rule.RuleID] = &pRule
+	datapathManager.syncTraceflowRule(rule.RuleID)
 
 	return nil
 }
 
-func (datapathManager *DpManager) RemoveEveroutePolicyRule(rule *EveroutePolicyRule) error {
+func (datapathManager *DpManager) RemoveEveroutePolicyRule(rule *EveroutePolicyRule) (err error) {
+	defer func(start time.Time) { observeRuleOperation(ruleOperationDelete, start, err) }(time.Now())
+
 	datapathManager.flowReplayMutex.Lock()
 	defer datapathManager.flowReplayMutex.Unlock()
 	if !datapathManager.IsBridgesConnected() {
@@ -771,6 +1030,7 @@ func (datapathManager *DpManager) RemoveEveroutePolicyRule(rule *EveroutePolicyR
 	}
 
 	delete(datapathManager.Rules, rule.RuleID)
+	datapathManager.syncTraceflowRule(rule.RuleID)
 
 	return nil
 }
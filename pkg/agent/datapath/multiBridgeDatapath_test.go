@@ -26,7 +26,10 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/protocol"
 	. "github.com/onsi/gomega"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
 )
 
 const (
@@ -57,6 +60,27 @@ var (
 		BridgeName:    "ovsbr0",
 		VlanID:        uint16(1),
 	}
+	ep2 = &Endpoint{
+		InterfaceName: "ep2",
+		PortNo:        uint32(13),
+		MacAddrStr:    "00:00:aa:aa:aa:ab",
+		BridgeName:    "ovsbr0",
+		VlanID:        uint16(1),
+	}
+	ep3 = &Endpoint{
+		InterfaceName: "ep3",
+		PortNo:        uint32(14),
+		MacAddrStr:    "00:00:aa:aa:aa:ac",
+		BridgeName:    "ovsbr0",
+		VlanID:        uint16(1),
+	}
+	ep4 = &Endpoint{
+		InterfaceName: "ep4",
+		PortNo:        uint32(15),
+		MacAddrStr:    "00:00:aa:aa:aa:ad",
+		BridgeName:    "ovsbr0",
+		VlanID:        uint16(1),
+	}
 	newep1 = &Endpoint{
 		InterfaceName: "ep1",
 		PortNo:        uint32(12),
@@ -86,7 +110,7 @@ var (
 )
 
 func TestMain(m *testing.M) {
-	ipAddressChan := make(chan map[string]net.IP, 100)
+	ipAddressChan := make(chan map[string]LearnedIPAddress, 100)
 	if err := ExcuteCommand(SetupBridgeChain, "ovsbr0"); err != nil {
 		log.Fatalf("Failed to setup bridgechain, error: %v", err)
 	}
@@ -108,7 +132,14 @@ func TestDpManager(t *testing.T) {
 
 	testLocalEndpoint(t)
 	testERPolicyRule(t)
+	testPolicyRuleBundle(t)
+	testIPv6AddressLearning(t)
+	testDHCPSnooping(t)
+	testSpoofGuard(t)
+	testTraceflow(t)
+	testFlowDrift(t)
 	testFlowReplay(t)
+	testHostEndpoint(t)
 }
 
 func testLocalEndpoint(t *testing.T) {
@@ -168,6 +199,532 @@ func testERPolicyRule(t *testing.T) {
 	})
 }
 
+func testPolicyRuleBundle(t *testing.T) {
+	t.Run("commit policy rule bundle", func(t *testing.T) {
+		bundle := NewPolicyRuleBundle()
+		bundle.AddRule(rule1, POLICY_DIRECTION_IN, POLICY_TIER1)
+		if err := datapathManager.CommitPolicyRuleBundle(bundle); err != nil {
+			t.Errorf("Failed to commit bundle with rule %v, error: %v", rule1, err)
+		}
+		if _, ok := datapathManager.Rules[rule1.RuleID]; !ok {
+			t.Errorf("Failed to commit bundle, not found %v in cache", rule1)
+		}
+
+		bundle = NewPolicyRuleBundle()
+		bundle.RemoveRule(rule1.RuleID)
+		if err := datapathManager.CommitPolicyRuleBundle(bundle); err != nil {
+			t.Errorf("Failed to commit bundle remove rule %v, error: %v", rule1, err)
+		}
+		if _, ok := datapathManager.Rules[rule1.RuleID]; ok {
+			t.Errorf("Failed to commit bundle, rule %v in cache", rule1)
+		}
+	})
+
+	t.Run("rollback policy rule bundle", func(t *testing.T) {
+		invalidRule := &EveroutePolicyRule{RuleID: "invalid", Action: "invalid"}
+		bundle := NewPolicyRuleBundle()
+		bundle.AddRule(rule1, POLICY_DIRECTION_IN, POLICY_TIER1)
+		bundle.AddRule(invalidRule, POLICY_DIRECTION_IN, POLICY_TIER1)
+		if err := datapathManager.CommitPolicyRuleBundle(bundle); err == nil {
+			t.Errorf("Expect commit bundle with invalid rule %v failed", invalidRule)
+		}
+		if _, ok := datapathManager.Rules[rule1.RuleID]; ok {
+			t.Errorf("Expect bundle rolled back, but rule %v in cache", rule1)
+		}
+	})
+}
+
+func testIPv6AddressLearning(t *testing.T) {
+	localBridge := datapathManager.BridgeChainMap["ovsbr0"][LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+	ofPortKey := fmt.Sprintf("%s-%d", ep2.BridgeName, ep2.PortNo)
+
+	if err := datapathManager.AddLocalEndpoint(ep2); err != nil {
+		t.Fatalf("Failed to add local endpoint %v, error: %v", ep2, err)
+	}
+	defer func() {
+		if err := datapathManager.RemoveLocalEndpoint(ep2); err != nil {
+			t.Errorf("Failed to remove local endpoint %v, error: %v", ep2, err)
+		}
+	}()
+
+	testCases := map[string]struct {
+		icmpType uint8
+		srcIP    string
+		targetIP string
+		expectIP string
+	}{
+		"should learn source address from neighbor solicitation": {
+			icmpType: ICMPV6_NEIGHBOR_SOLICITATION,
+			srcIP:    "fd00::1",
+			targetIP: "fd00::100",
+			expectIP: "fd00::1",
+		},
+		"should learn target address from duplicate address detection": {
+			icmpType: ICMPV6_NEIGHBOR_SOLICITATION,
+			srcIP:    "::",
+			targetIP: "fd00::2",
+			expectIP: "fd00::2",
+		},
+		"should learn target address from neighbor advertisement": {
+			icmpType: ICMPV6_NEIGHBOR_ADVERTISEMENT,
+			srcIP:    "fd00::3",
+			targetIP: "fd00::3",
+			expectIP: "fd00::3",
+		},
+		"should not learn link local address": {
+			icmpType: ICMPV6_NEIGHBOR_SOLICITATION,
+			srcIP:    "fe80::4",
+			targetIP: "fe80::100",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pkt := protocol.Ethernet{
+				Ethertype: PROTOCOL_IPV6,
+				Data: &protocol.IPv6{
+					NextHeader: protocol.Type_IPv6ICMP,
+					NWSrc:      net.ParseIP(tc.srcIP),
+					NWDst:      net.ParseIP("ff02::1"),
+					Data: &protocol.ICMP{
+						Type: tc.icmpType,
+						Data: append(make([]byte, 4), net.ParseIP(tc.targetIP).To16()...),
+					},
+				},
+			}
+			localBridge.processNdp(pkt, ep2.PortNo)
+
+			select {
+			case ipUpdate := <-datapathManager.ofPortIPAddressUpdateChan:
+				if !ipUpdate[ofPortKey].IP.Equal(net.ParseIP(tc.expectIP)) || ipUpdate[ofPortKey].Origin != agentv1alpha1.IPOriginNDP {
+					t.Errorf("expect learn ip %s on %s, but got %v", tc.expectIP, ofPortKey, ipUpdate)
+				}
+			case <-time.After(time.Second):
+				if tc.expectIP != "" {
+					t.Errorf("expect learn ip %s on %s, but got nothing", tc.expectIP, ofPortKey)
+				}
+			}
+		})
+	}
+}
+
+func testDHCPSnooping(t *testing.T) {
+	localBridge := datapathManager.BridgeChainMap["ovsbr0"][LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+	ofPortKey := fmt.Sprintf("%s-%d", ep3.BridgeName, ep3.PortNo)
+	ep3Mac, _ := net.ParseMAC(ep3.MacAddrStr)
+	boundIP := net.ParseIP("10.0.0.14").To4()
+	unboundIP := net.ParseIP("10.0.0.15").To4()
+
+	datapathConfig.EnableDHCPSnooping = true
+	if err := datapathManager.AddLocalEndpoint(ep3); err != nil {
+		t.Fatalf("Failed to add local endpoint %v, error: %v", ep3, err)
+	}
+	defer func() {
+		datapathConfig.EnableDHCPSnooping = false
+		if err := datapathManager.RemoveLocalEndpoint(ep3); err != nil {
+			t.Errorf("Failed to remove local endpoint %v, error: %v", ep3, err)
+		}
+	}()
+
+	newArpPacket := func(ip net.IP) protocol.Ethernet {
+		return protocol.Ethernet{
+			Ethertype: PROTOCOL_ARP,
+			Data:      &protocol.ARP{HWSrc: ep3Mac, IPSrc: ip},
+		}
+	}
+	expectLearnedIP := func(t *testing.T, expectIP net.IP) {
+		select {
+		case ipUpdate := <-datapathManager.ofPortIPAddressUpdateChan:
+			if expectIP == nil {
+				t.Errorf("expect learn nothing, but got %v", ipUpdate)
+			} else if !ipUpdate[ofPortKey].IP.Equal(expectIP) || ipUpdate[ofPortKey].Origin != agentv1alpha1.IPOriginDHCP {
+				t.Errorf("expect learn ip %s from dhcp on %s, but got %v", expectIP, ofPortKey, ipUpdate)
+			}
+		case <-time.After(time.Second):
+			if expectIP != nil {
+				t.Errorf("expect learn ip %s on %s, but got nothing", expectIP, ofPortKey)
+			}
+		}
+	}
+
+	t.Run("should ignore arp ip not bound by dhcp", func(t *testing.T) {
+		localBridge.processArp(newArpPacket(boundIP), ep3.PortNo)
+		expectLearnedIP(t, nil)
+	})
+
+	t.Run("should bind ip from dhcp ack", func(t *testing.T) {
+		dhcpAck, _ := protocol.NewDHCPAck(0, ep3Mac)
+		dhcpAck.YourIP = boundIP
+		dhcpAck.Options = append(dhcpAck.Options, protocol.DHCPNewOption(protocol.DHCP_OPT_LEASE_TIME, []byte{0, 0, 0x0e, 0x10}))
+		dhcpData := make([]byte, dhcpAck.Len())
+		if _, err := dhcpAck.Read(dhcpData); err != nil {
+			t.Fatalf("failed to marshal dhcp ack: %s", err)
+		}
+
+		localBridge.processDHCP(protocol.Ethernet{
+			Ethertype: PROTOCOL_IP,
+			Data: &protocol.IPv4{
+				Protocol: protocol.Type_UDP,
+				Data: &protocol.UDP{
+					PortSrc: DHCP_SERVER_PORT,
+					PortDst: DHCP_CLIENT_PORT,
+					Data:    dhcpData,
+				},
+			},
+		})
+		expectLearnedIP(t, boundIP)
+	})
+
+	t.Run("should learn bound ip from arp", func(t *testing.T) {
+		localBridge.processArp(newArpPacket(boundIP), ep3.PortNo)
+		expectLearnedIP(t, boundIP)
+	})
+
+	t.Run("should ignore arp ip conflict with binding", func(t *testing.T) {
+		localBridge.processArp(newArpPacket(boundIP), ep2.PortNo)
+		expectLearnedIP(t, nil)
+		localBridge.processArp(newArpPacket(unboundIP), ep3.PortNo)
+		expectLearnedIP(t, nil)
+	})
+
+	t.Run("should learn ipv6 ip from ndp", func(t *testing.T) {
+		localBridge.processNdp(protocol.Ethernet{
+			HWSrc:     ep3Mac,
+			Ethertype: PROTOCOL_IPV6,
+			Data: &protocol.IPv6{
+				NextHeader: protocol.Type_IPv6ICMP,
+				NWSrc:      net.ParseIP("fd00::14"),
+				NWDst:      net.ParseIP("ff02::1"),
+				Data: &protocol.ICMP{
+					Type: ICMPV6_NEIGHBOR_SOLICITATION,
+					Data: append(make([]byte, 4), net.ParseIP("fd00::100").To16()...),
+				},
+			},
+		}, ep3.PortNo)
+		select {
+		case ipUpdate := <-datapathManager.ofPortIPAddressUpdateChan:
+			if !ipUpdate[ofPortKey].IP.Equal(net.ParseIP("fd00::14")) || ipUpdate[ofPortKey].Origin != agentv1alpha1.IPOriginNDP {
+				t.Errorf("expect learn ip fd00::14 from ndp on %s, but got %v", ofPortKey, ipUpdate)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expect learn ip fd00::14 on %s, but got nothing", ofPortKey)
+		}
+	})
+
+	t.Run("should withdraw ip when dhcp lease expired", func(t *testing.T) {
+		localBridge.dhcpBindingsMutex.Lock()
+		binding := localBridge.dhcpBindings[boundIP.String()]
+		binding.expireTime = time.Now().Add(-time.Second)
+		localBridge.dhcpBindings[boundIP.String()] = binding
+		localBridge.dhcpBindingsMutex.Unlock()
+
+		go localBridge.cleanDHCPBindings()
+		select {
+		case ipUpdate := <-datapathManager.ofPortIPAddressUpdateChan:
+			if !ipUpdate[ofPortKey].IP.Equal(boundIP) || !ipUpdate[ofPortKey].Withdrawn {
+				t.Errorf("expect withdraw ip %s on %s, but got %v", boundIP, ofPortKey, ipUpdate)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expect withdraw ip %s on %s, but got nothing", boundIP, ofPortKey)
+		}
+		if localBridge.isDHCPBound(boundIP, ep3Mac, ep3.PortNo) {
+			t.Errorf("expect binding of ip %s removed", boundIP)
+		}
+	})
+}
+
+func testSpoofGuard(t *testing.T) {
+	localBridge := datapathManager.BridgeChainMap["ovsbr0"][LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+	ep4Mac, _ := net.ParseMAC(ep4.MacAddrStr)
+	learnedIP := net.ParseIP("10.0.0.16").To4()
+	dhcpIP := net.ParseIP("10.0.0.17").To4()
+	staticIP := net.ParseIP("10.0.0.18").To4()
+
+	datapathConfig.EnableSpoofGuard = true
+	if err := datapathManager.AddLocalEndpoint(ep4); err != nil {
+		t.Fatalf("Failed to add local endpoint %v, error: %v", ep4, err)
+	}
+	defer func() {
+		if err := datapathManager.RemoveLocalEndpoint(ep4); err != nil {
+			t.Errorf("Failed to remove local endpoint %v, error: %v", ep4, err)
+		}
+		datapathConfig.EnableSpoofGuard = false
+	}()
+
+	learnIP := func(ip net.IP) {
+		localBridge.processArp(protocol.Ethernet{
+			Ethertype: PROTOCOL_ARP,
+			Data:      &protocol.ARP{HWSrc: ep4Mac, IPSrc: ip},
+		}, ep4.PortNo)
+		select {
+		case <-datapathManager.ofPortIPAddressUpdateChan:
+		case <-time.After(time.Second):
+		}
+	}
+	expectAllowedIPs := func(t *testing.T, expectIPs ...net.IP) {
+		localBridge.spoofGuardMutex.Lock()
+		allowedIPs, _ := localBridge.getSpoofGuardAllowedIPs(ep4)
+		localBridge.spoofGuardMutex.Unlock()
+		if len(allowedIPs) != len(expectIPs) {
+			t.Fatalf("expect allowed ips %v, but got %v", expectIPs, allowedIPs)
+		}
+		for _, expectIP := range expectIPs {
+			var found bool
+			for _, ip := range allowedIPs {
+				found = found || ip.Equal(expectIP)
+			}
+			if !found {
+				t.Errorf("expect allowed ips %v, but got %v", expectIPs, allowedIPs)
+			}
+		}
+	}
+
+	t.Run("should install spoof guard flows", func(t *testing.T) {
+		if len(localBridge.spoofGuardFlows[ep4.PortNo]) == 0 || len(localBridge.spoofGuardAllowFlows[ep4.PortNo]) == 0 {
+			t.Errorf("expect spoof guard flows installed for endpoint %s", ep4.InterfaceName)
+		}
+		expectAllowedIPs(t)
+	})
+
+	t.Run("should not bind learned ip", func(t *testing.T) {
+		learnIP(learnedIP)
+		expectAllowedIPs(t)
+	})
+
+	t.Run("should bind ip acked by dhcp", func(t *testing.T) {
+		localBridge.bindSpoofGuardIP(dhcpIP, ep4.PortNo, time.Now().Add(time.Hour))
+		expectAllowedIPs(t, dhcpIP)
+	})
+
+	t.Run("should allow static ip", func(t *testing.T) {
+		err := datapathManager.SetSpoofGuardPolicy("ep4", &SpoofGuardPolicy{
+			MacAddrStr: ep4.MacAddrStr,
+			StaticIPs:  []net.IP{staticIP},
+		})
+		if err != nil {
+			t.Fatalf("Failed to set spoof guard policy, error: %v", err)
+		}
+		expectAllowedIPs(t, dhcpIP, staticIP)
+	})
+
+	t.Run("should skip policy with the same static ips", func(t *testing.T) {
+		allowFlows := localBridge.spoofGuardAllowFlows[ep4.PortNo]
+		err := datapathManager.SetSpoofGuardPolicy("ep4", &SpoofGuardPolicy{
+			MacAddrStr: ep4.MacAddrStr,
+			StaticIPs:  []net.IP{staticIP},
+		})
+		if err != nil {
+			t.Fatalf("Failed to set spoof guard policy, error: %v", err)
+		}
+		if len(allowFlows) == 0 || &allowFlows[0] != &localBridge.spoofGuardAllowFlows[ep4.PortNo][0] {
+			t.Errorf("expect spoof guard allow flows of endpoint %s unchanged", ep4.InterfaceName)
+		}
+	})
+
+	t.Run("should unbind expired ip", func(t *testing.T) {
+		localBridge.spoofGuardMutex.Lock()
+		localBridge.spoofGuardBindings[ep4Mac.String()][dhcpIP.String()] = time.Now()
+		localBridge.spoofGuardMutex.Unlock()
+		localBridge.cleanSpoofGuardBindings()
+		expectAllowedIPs(t, staticIP)
+	})
+
+	t.Run("should skip endpoint disable spoof guard", func(t *testing.T) {
+		err := datapathManager.SetSpoofGuardPolicy("ep4", &SpoofGuardPolicy{
+			MacAddrStr: ep4.MacAddrStr,
+			Disabled:   true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to set spoof guard policy, error: %v", err)
+		}
+		if _, ok := localBridge.spoofGuardFlows[ep4.PortNo]; ok {
+			t.Errorf("expect no spoof guard flows for endpoint %s", ep4.InterfaceName)
+		}
+
+		if err := datapathManager.RemoveSpoofGuardPolicy("ep4"); err != nil {
+			t.Fatalf("Failed to remove spoof guard policy, error: %v", err)
+		}
+		if _, ok := localBridge.spoofGuardFlows[ep4.PortNo]; !ok {
+			t.Errorf("expect spoof guard flows installed for endpoint %s", ep4.InterfaceName)
+		}
+	})
+
+	t.Run("should get drop stats", func(t *testing.T) {
+		dropStats, err := datapathManager.GetSpoofGuardDropStats()
+		if err != nil {
+			t.Fatalf("Failed to get spoof guard drop stats, error: %v", err)
+		}
+		if _, ok := dropStats[ep4.InterfaceName]; !ok {
+			t.Errorf("expect drop stats of endpoint %s, but got %v", ep4.InterfaceName, dropStats)
+		}
+	})
+}
+
+func testTraceflow(t *testing.T) {
+	var tag uint8 = 5
+	packet := &TraceflowPacket{
+		SrcMac:     net.HardwareAddr{0x00, 0x00, 0xaa, 0xaa, 0xaa, 0xaa},
+		DstMac:     net.HardwareAddr{0x00, 0x00, 0xaa, 0xaa, 0xaa, 0xab},
+		SrcIP:      net.ParseIP("10.100.100.1"),
+		DstIP:      net.ParseIP("10.100.100.2"),
+		IPProtocol: protocol.Type_UDP,
+		SrcPort:    12345,
+		DstPort:    53,
+	}
+
+	t.Run("should install and remove traceflow flows", func(t *testing.T) {
+		if err := datapathManager.StartTraceflow(tag, packet); err != nil {
+			t.Fatalf("Failed to start traceflow, error: %v", err)
+		}
+		// observation flows of the packet path, and of each rule flow
+		expectFlows := 6 * len(datapathManager.BridgeChainMap)
+		for _, ruleEntry := range datapathManager.Rules {
+			expectFlows += len(ruleEntry.RuleFlowMap)
+		}
+		if len(datapathManager.traceflowSessions[tag].flows) != expectFlows || len(datapathManager.traceflowPoints) != expectFlows {
+			t.Errorf("expect %d traceflow flows installed, got %d", expectFlows, len(datapathManager.traceflowSessions[tag].flows))
+		}
+		flows, err := dumpAllFlows()
+		if err != nil {
+			t.Fatalf("Failed to dump flows, error: %v", err)
+		}
+		var commitFlowFound bool
+		for _, flow := range flows {
+			commitFlowFound = commitFlowFound || strings.Contains(flow, "table=70, priority=303,") &&
+				strings.Contains(flow, "in_port=102") && strings.Contains(flow, "pkt_mark=0x500/0x3f00")
+		}
+		if !commitFlowFound {
+			t.Errorf("expect traceflow flow installed in ct commit table")
+		}
+
+		datapathManager.StopTraceflow(tag)
+		if len(datapathManager.traceflowSessions) != 0 || len(datapathManager.traceflowPoints) != 0 {
+			t.Errorf("expect traceflow flows removed")
+		}
+	})
+
+	t.Run("should build packet with valid checksum", func(t *testing.T) {
+		pkt, err := buildTraceflowPacket(tag, packet)
+		if err != nil {
+			t.Fatalf("Failed to build traceflow packet, error: %v", err)
+		}
+		ipData, _ := pkt.Data.MarshalBinary()
+		if ipData[1]>>2 != tag {
+			t.Errorf("expect dscp %d, got %d", tag, ipData[1]>>2)
+		}
+		if checksum(ipData[:20]) != 0 {
+			t.Errorf("invalid ip header checksum")
+		}
+	})
+
+	t.Run("should trace packet denied by policy rule", func(t *testing.T) {
+		rules := map[string]*EveroutePolicyRuleEntry{
+			rule1.RuleID: {EveroutePolicyRule: rule1, Direction: POLICY_DIRECTION_IN, Tier: POLICY_TIER2},
+			rule2.RuleID: {EveroutePolicyRule: rule2, Direction: POLICY_DIRECTION_OUT, Tier: POLICY_TIER0},
+		}
+		observations := TracePolicyRules("ovsbr0-policy", rules, POLICY_DIRECTION_OUT, packet)
+		if len(observations) != 1 || observations[0].RuleID != rule2.RuleID ||
+			observations[0].Action != agentv1alpha1.TraceflowActionDropped {
+			t.Errorf("expect packet dropped by %s, got %+v", rule2.RuleID, observations)
+		}
+
+		observations = TracePolicyRules("ovsbr0-policy", rules, POLICY_DIRECTION_IN, packet)
+		if len(observations) != 3 || observations[2].RuleID != "" {
+			t.Errorf("expect packet hit no rule in ingress tables, got %+v", observations)
+		}
+	})
+}
+
+func testFlowDrift(t *testing.T) {
+	RegisterTestingT(t)
+
+	if err := datapathManager.AddEveroutePolicyRule(rule1, POLICY_DIRECTION_IN, POLICY_TIER2); err != nil {
+		t.Fatalf("Failed to add ER policy rule: %v, error: %v", rule1, err)
+	}
+	defer func() {
+		if err := datapathManager.RemoveEveroutePolicyRule(rule1); err != nil {
+			t.Errorf("Failed to remove ER policy rule: %v, error: %v", rule1, err)
+		}
+	}()
+
+	Eventually(func() error {
+		_, err := datapathManager.healVDSFlowDrift("ovsbr0")
+		return err
+	}, timeout, interval).Should(Succeed())
+
+	t.Run("record base flows when bridge initialized", func(t *testing.T) {
+		Expect(datapathManager.flowDriftBaseFlows["ovsbr0"]).ShouldNot(BeEmpty())
+	})
+
+	t.Run("reinstall missing rule flow", func(t *testing.T) {
+		flowEntry := datapathManager.Rules[rule1.RuleID].RuleFlowMap["ovsbr0"]
+		cmdStr := fmt.Sprintf("ovs-ofctl del-flows ovsbr0-policy cookie=%#x/-1", flowEntry.FlowID)
+		if _, err := excuteCommand(cmdStr); err != nil {
+			t.Fatalf("Failed to delete rule flow, error: %v", err)
+		}
+
+		drift, err := datapathManager.healVDSFlowDrift("ovsbr0")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(drift.MissingRuleFlows).Should(Equal(1))
+		Eventually(func() error {
+			return flowValidator([]string{rule1Flow})
+		}, timeout, interval).Should(Succeed())
+	})
+
+	t.Run("remove orphan flow allocated by agent only", func(t *testing.T) {
+		roundNum := datapathManager.BridgeChainMap["ovsbr0"][POLICY_BRIDGE_KEYWORD].(*PolicyBridge).OfSwitch.CookieAllocator.RequestCookie(0).Round()
+		foreignCookie := roundNum<<48 | 0xffff
+		cmdStr := fmt.Sprintf("ovs-ofctl add-flow ovsbr0-policy table=%d,cookie=%#x,priority=300,ip,nw_src=10.200.200.1,actions=drop", INGRESS_TIER2_TABLE, foreignCookie)
+		if _, err := excuteCommand(cmdStr); err != nil {
+			t.Fatalf("Failed to add foreign flow, error: %v", err)
+		}
+		defer func() {
+			_, _ = excuteCommand(fmt.Sprintf("ovs-ofctl del-flows ovsbr0-policy cookie=%#x/-1", foreignCookie))
+		}()
+
+		// rule flow left in the bridge after the rule removed from datapath
+		orphanRule := &EveroutePolicyRule{RuleID: "orphan-rule", Priority: 300, SrcIPAddr: "10.200.200.2", Action: "deny"}
+		if err := datapathManager.AddEveroutePolicyRule(orphanRule, POLICY_DIRECTION_IN, POLICY_TIER2); err != nil {
+			t.Fatalf("Failed to add ER policy rule: %v, error: %v", orphanRule, err)
+		}
+		datapathManager.flowReplayMutex.Lock()
+		delete(datapathManager.Rules, orphanRule.RuleID)
+		datapathManager.flowReplayMutex.Unlock()
+
+		drift, err := datapathManager.healVDSFlowDrift("ovsbr0")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(drift.OrphanFlows).Should(Equal(1))
+		Expect(drift.MissingBaseFlows).Should(Equal(0))
+		foreignFlows, err := excuteCommand(fmt.Sprintf("ovs-ofctl dump-flows ovsbr0-policy cookie=%#x/-1", foreignCookie))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(foreignFlows)).Should(ContainSubstring("nw_src=10.200.200.1"))
+	})
+
+	t.Run("reinstall missing base flow only", func(t *testing.T) {
+		ruleFlowID := datapathManager.Rules[rule1.RuleID].RuleFlowMap["ovsbr0"].FlowID
+		cmdStr := fmt.Sprintf("ovs-ofctl del-flows ovsbr0-policy table=%d", CT_COMMIT_TABLE)
+		if _, err := excuteCommand(cmdStr); err != nil {
+			t.Fatalf("Failed to delete base flow, error: %v", err)
+		}
+
+		drift, err := datapathManager.healVDSFlowDrift("ovsbr0")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(drift.MissingBaseFlows).ShouldNot(BeZero())
+		Expect(drift.MissingRuleFlows).Should(BeZero())
+		Expect(datapathManager.Rules[rule1.RuleID].RuleFlowMap["ovsbr0"].FlowID).Should(Equal(ruleFlowID))
+		Eventually(func() error {
+			return flowValidator([]string{rule1Flow})
+		}, timeout, interval).Should(Succeed())
+		baseFlows, err := excuteCommand(fmt.Sprintf("ovs-ofctl dump-flows ovsbr0-policy table=%d", CT_COMMIT_TABLE))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(baseFlows)).Should(ContainSubstring("priority="))
+
+		drift, err = datapathManager.healVDSFlowDrift("ovsbr0")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(drift.IsEmpty()).Should(BeTrue())
+	})
+}
+
 func testFlowReplay(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -207,6 +764,64 @@ func testFlowReplay(t *testing.T) {
 	})
 }
 
+func testHostEndpoint(t *testing.T) {
+	agentInfo := datapathManager.AgentInfo
+	nodeIPs, tunnelType, gatewayMac := agentInfo.NodeIPs, agentInfo.TunnelType, agentInfo.GatewayMac
+	agentInfo.NodeIPs = []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")}
+	agentInfo.TunnelType, agentInfo.GatewayMac = TunnelTypeGeneve, net.HardwareAddr{0x00, 0x00, 0xaa, 0xbb, 0xcc, 0xdd}
+	defer func() {
+		agentInfo.NodeIPs, agentInfo.TunnelType, agentInfo.GatewayMac = nodeIPs, tunnelType, gatewayMac
+	}()
+
+	uplinkBridge := datapathManager.BridgeChainMap["ovsbr0"][UPLINK_BRIDGE_KEYWORD].(*UplinkBridge)
+	if err := uplinkBridge.initHostEndpoint(); err != nil {
+		t.Fatalf("Failed to init host endpoint, error: %v", err)
+	}
+
+	t.Run("should send traffic from outside to node ip through policy bridge", func(t *testing.T) {
+		if !flowFieldsExist(t, "table=0, priority=203,ip,nw_dst=192.168.1.10 ", "actions=output:302") {
+			t.Errorf("expect traffic to node ip sent to cls bridge")
+		}
+	})
+
+	t.Run("should not send tunnel traffic to node ip through policy bridge", func(t *testing.T) {
+		if !flowFieldsExist(t, "table=0, priority=206,udp,nw_dst=192.168.1.10,tp_dst=6081 ", "actions=NORMAL") {
+			t.Errorf("expect tunnel traffic to node ip forwarded normally")
+		}
+	})
+
+	t.Run("should commit traffic from node ip to conntrack zone of policy bridge", func(t *testing.T) {
+		if !flowFieldsExist(t, "table=0, priority=300,", "ct_state=-trk", "in_port=10", "nw_src=192.168.1.10 ",
+			"actions=ct(commit,table=0,zone=65520)") {
+			t.Errorf("expect traffic from node ip committed to policy conntrack zone")
+		}
+	})
+
+	t.Run("should not send traffic to ipv6 node ip from outside through policy bridge", func(t *testing.T) {
+		if flowFieldsExist(t, "priority=203,ipv6,ipv6_dst=fd00::10 ") {
+			t.Errorf("expect traffic to ipv6 node ip not sent to policy bridge")
+		}
+	})
+}
+
+// flowFieldsExist returns whether there is a flow contains all the fields.
+func flowFieldsExist(t *testing.T, fields ...string) bool {
+	flows, err := dumpAllFlows()
+	if err != nil {
+		t.Fatalf("Failed to dump flows, error: %v", err)
+	}
+	for _, flow := range flows {
+		found := true
+		for _, field := range fields {
+			found = found && strings.Contains(flow, field)
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
 func flowValidator(expectedFlows []string) error {
 	var currentFlowList []string
 	var err error
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/contiv/libOpenflow/protocol"
+)
+
+const (
+	// neighbor advertisement flags
+	ndpFlagSolicited = 0x40000000
+	ndpFlagOverride  = 0x20000000
+	// option type of target link-layer address
+	ndpOptionTargetLinkLayerAddress = 2
+	// hop limit of neighbor discovery packets must be 255
+	ndpHopLimit = 255
+)
+
+var ipv6AllNodesAddr = net.ParseIP("ff02::1")
+
+// neighborSolicitationTarget returns the target address of the neighbor solicitation,
+// nil if the packet is not a neighbor solicitation.
+func neighborSolicitationTarget(pkt protocol.Ethernet) net.IP {
+	ipv6In, ok := pkt.Data.(*protocol.IPv6)
+	if !ok {
+		return nil
+	}
+	icmpIn, ok := ipv6In.Data.(*protocol.ICMP)
+	if !ok || icmpIn.Type != ICMPV6_NEIGHBOR_SOLICITATION || len(icmpIn.Data) < 4+net.IPv6len {
+		return nil
+	}
+	return net.IP(icmpIn.Data[4 : 4+net.IPv6len])
+}
+
+// buildNeighborAdvertisement answers the neighbor solicitation with the link-layer address.
+// The advertisement would be sent to all nodes if the solicitation is for duplicate address
+// detection.
+func buildNeighborAdvertisement(solicitation protocol.Ethernet, targetAddr net.IP,
+	srcMac, linkLayerAddr net.HardwareAddr) (*protocol.Ethernet, error) {
+	ipv6In := solicitation.Data.(*protocol.IPv6)
+
+	flags := uint32(ndpFlagSolicited | ndpFlagOverride)
+	dstAddr := ipv6In.NWSrc
+	if dstAddr.IsUnspecified() {
+		flags = ndpFlagOverride
+		dstAddr = ipv6AllNodesAddr
+	}
+
+	icmpData := make([]byte, 4+net.IPv6len+8)
+	binary.BigEndian.PutUint32(icmpData[0:4], flags)
+	copy(icmpData[4:4+net.IPv6len], targetAddr.To16())
+	icmpData[4+net.IPv6len] = ndpOptionTargetLinkLayerAddress
+	icmpData[4+net.IPv6len+1] = 1 // length in units of 8 octets
+	copy(icmpData[4+net.IPv6len+2:], linkLayerAddr)
+
+	icmpPacket := protocol.NewICMP()
+	icmpPacket.Type = ICMPV6_NEIGHBOR_ADVERTISEMENT
+	icmpPacket.Data = icmpData
+	l4Data, err := icmpPacket.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// icmpv6 checksum covers the ipv6 pseudo header
+	pseudoHeader := make([]byte, 40, 40+len(l4Data))
+	copy(pseudoHeader[0:16], targetAddr.To16())
+	copy(pseudoHeader[16:32], dstAddr.To16())
+	binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(l4Data)))
+	pseudoHeader[39] = protocol.Type_IPv6ICMP
+	binary.BigEndian.PutUint16(l4Data[2:4], checksum(append(pseudoHeader, l4Data...)))
+
+	ipPacket := &protocol.IPv6{
+		Version:    6,
+		NextHeader: protocol.Type_IPv6ICMP,
+		HopLimit:   ndpHopLimit,
+		NWSrc:      targetAddr.To16(),
+		NWDst:      dstAddr.To16(),
+		Data:       (*rawPayload)(&l4Data),
+	}
+	ipPacket.Length = uint16(len(l4Data))
+
+	return &protocol.Ethernet{
+		HWDst:     solicitation.HWSrc,
+		HWSrc:     srcMac,
+		Ethertype: PROTOCOL_IPV6,
+		Data:      ipPacket,
+	}, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/contiv/libOpenflow/protocol"
+	. "github.com/onsi/gomega"
+)
+
+func newNeighborSolicitation(srcMac net.HardwareAddr, srcAddr, targetAddr net.IP) protocol.Ethernet {
+	icmpPacket := protocol.NewICMP()
+	icmpPacket.Type = ICMPV6_NEIGHBOR_SOLICITATION
+	icmpPacket.Data = make([]byte, 4+net.IPv6len)
+	copy(icmpPacket.Data[4:], targetAddr.To16())
+
+	return protocol.Ethernet{
+		HWSrc:     srcMac,
+		Ethertype: PROTOCOL_IPV6,
+		Data: &protocol.IPv6{
+			Version:    6,
+			NextHeader: protocol.Type_IPv6ICMP,
+			HopLimit:   ndpHopLimit,
+			NWSrc:      srcAddr.To16(),
+			NWDst:      net.ParseIP("ff02::1:ff00:1"),
+			Data:       icmpPacket,
+		},
+	}
+}
+
+func TestBuildNeighborAdvertisement(t *testing.T) {
+	RegisterTestingT(t)
+
+	srcMac, _ := net.ParseMAC("00:00:00:00:00:01")
+	fakeMac, _ := net.ParseMAC(FACK_MAC)
+	gatewayMac, _ := net.ParseMAC("00:00:00:00:00:02")
+	targetAddr := net.ParseIP("fd00:1::1")
+
+	solicitation := newNeighborSolicitation(srcMac, net.ParseIP("fd00::2"), targetAddr)
+	Expect(neighborSolicitationTarget(solicitation).Equal(targetAddr)).Should(BeTrue())
+
+	reply, err := buildNeighborAdvertisement(solicitation, targetAddr, fakeMac, gatewayMac)
+	Expect(err).ShouldNot(HaveOccurred())
+	data, err := reply.MarshalBinary()
+	Expect(err).ShouldNot(HaveOccurred())
+
+	var advertisement protocol.Ethernet
+	Expect(advertisement.UnmarshalBinary(data)).Should(Succeed())
+	Expect(advertisement.HWDst).Should(Equal(srcMac))
+	Expect(advertisement.HWSrc).Should(Equal(fakeMac))
+
+	ipPacket := advertisement.Data.(*protocol.IPv6)
+	Expect(ipPacket.NWSrc.Equal(targetAddr)).Should(BeTrue())
+	Expect(ipPacket.NWDst.Equal(net.ParseIP("fd00::2"))).Should(BeTrue())
+	Expect(ipPacket.HopLimit).Should(Equal(uint8(ndpHopLimit)))
+
+	icmpPacket := ipPacket.Data.(*protocol.ICMP)
+	Expect(icmpPacket.Type).Should(Equal(uint8(ICMPV6_NEIGHBOR_ADVERTISEMENT)))
+	Expect(binary.BigEndian.Uint32(icmpPacket.Data[0:4])).Should(Equal(uint32(ndpFlagSolicited | ndpFlagOverride)))
+	Expect(net.IP(icmpPacket.Data[4 : 4+net.IPv6len]).Equal(targetAddr)).Should(BeTrue())
+	Expect(net.HardwareAddr(icmpPacket.Data[4+net.IPv6len+2:])).Should(Equal(gatewayMac))
+
+	// checksum over the pseudo header and icmp message must be zero
+	l4Data, _ := icmpPacket.MarshalBinary()
+	pseudoHeader := make([]byte, 40)
+	copy(pseudoHeader[0:16], ipPacket.NWSrc)
+	copy(pseudoHeader[16:32], ipPacket.NWDst)
+	binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(l4Data)))
+	pseudoHeader[39] = protocol.Type_IPv6ICMP
+	Expect(checksum(append(pseudoHeader, l4Data...))).Should(BeZero())
+}
@@ -28,6 +28,10 @@ const (
 	POLICY_FORWARDING_TABLE   = 90
 )
 
+// policyConntrackZone is the conntrack zone of policy enforcement, the uplink bridge commits traffic
+// from the node to it, so replies to the node from outside are established in policy bridge.
+const policyConntrackZone uint16 = 65520
+
 type PolicyBridge struct {
 	name            string
 	OfSwitch        *ofctrl.OFSwitch
@@ -48,12 +52,19 @@ type PolicyBridge struct {
 
 	policySwitchStatusMutex sync.RWMutex
 	isPolicySwitchConnected bool
+
+	multipartReplies *multipartReplies
+	// ruleFlows contains rule flows allocated by the agent, map cookie to its table. Rule flows not
+	// expected by datapath rules would be removed as orphan flows.
+	ruleFlows map[uint64]uint8
 }
 
 func NewPolicyBridge(brName string, datapathManager *DpManager) *PolicyBridge {
 	policyBridge := new(PolicyBridge)
 	policyBridge.name = fmt.Sprintf("%s-policy", brName)
 	policyBridge.datapathManager = datapathManager
+	policyBridge.multipartReplies = newMultipartReplies()
+	policyBridge.ruleFlows = make(map[uint64]uint8)
 	return policyBridge
 }
 
@@ -99,9 +110,11 @@ func (p *PolicyBridge) WaitForSwitchConnection() {
 }
 
 func (p *PolicyBridge) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
+	p.datapathManager.processTraceflowPacketIn(p.name, pkt)
 }
 
 func (p *PolicyBridge) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply) {
+	p.multipartReplies.dispatch(rep)
 }
 
 func (p *PolicyBridge) BridgeInit() {
@@ -158,8 +171,8 @@ func (p *PolicyBridge) initDirectionSelectionTable() error {
 
 func (p *PolicyBridge) initInputTable(sw *ofctrl.OFSwitch) error {
 	var ctStateTableID uint8 = CT_STATE_TABLE
-	var policyConntrackZone uint16 = 65520
-	ctAction := ofctrl.NewConntrackAction(false, false, &ctStateTableID, &policyConntrackZone)
+	var ctZone = policyConntrackZone
+	ctAction := ofctrl.NewConntrackAction(false, false, &ctStateTableID, &ctZone)
 	inputIPRedirectFlow, _ := p.inputTable.NewFlow(ofctrl.FlowMatch{
 		Priority:  HIGH_MATCH_FLOW_PRIORITY,
 		Ethertype: PROTOCOL_IP,
@@ -198,7 +211,7 @@ func (p *PolicyBridge) initInputTable(sw *ofctrl.OFSwitch) error {
 }
 
 func (p *PolicyBridge) initCTFlow(sw *ofctrl.OFSwitch) error {
-	var policyConntrackZone uint16 = 65520
+	var ctZone = policyConntrackZone
 	// Table 1, ctState table, est state flow
 	// FIXME. should add ctEst flow and ctInv flow with same priority. With different, it have no side effect to flow intent.
 	ctEstState := openflow13.NewCTStates()
@@ -243,7 +256,7 @@ func (p *PolicyBridge) initCTFlow(sw *ofctrl.OFSwitch) error {
 		CtStates:  ctTrkState,
 	})
 	var sfcPolicyTable uint8 = SFC_POLICY_TABLE
-	ctCommitAction := ofctrl.NewConntrackAction(true, false, &sfcPolicyTable, &policyConntrackZone)
+	ctCommitAction := ofctrl.NewConntrackAction(true, false, &sfcPolicyTable, &ctZone)
 	_ = ctCommitFlow.SetConntrack(ctCommitAction)
 
 	ctCommitTableDefaultFlow, _ := p.ctCommitTable.NewFlow(ofctrl.FlowMatch{
@@ -397,22 +410,44 @@ func (p *PolicyBridge) GetTierTable(direction uint8, tier uint8) (*ofctrl.Table,
 }
 
 func (p *PolicyBridge) AddMicroSegmentRule(rule *EveroutePolicyRule, direction uint8, tier uint8) (*FlowEntry, error) {
+	// make sure switch is connected
+	if !p.IsSwitchConnected() {
+		p.WaitForSwitchConnection()
+	}
+
+	ruleFlow, nextElem, err := p.newMicroSegmentRuleFlow(rule, direction, tier)
+	if err != nil {
+		return nil, err
+	}
+
+	flowEntry, err := p.newRuleFlowEntry(ruleFlow, nextElem)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ruleFlow.Next(nextElem)
+	if err != nil {
+		log.Errorf("Failed to install flow {%+v}. Err: %v", ruleFlow, err)
+		return nil, err
+	}
+
+	return flowEntry, nil
+}
+
+// newMicroSegmentRuleFlow allocates the rule flow in the tier table and returns it with
+// the next element the flow point to, the flow has not been installed yet.
+func (p *PolicyBridge) newMicroSegmentRuleFlow(rule *EveroutePolicyRule, direction uint8, tier uint8) (*ofctrl.Flow, ofctrl.FgraphElem, error) {
 	var ipDa *net.IP = nil
 	var ipDaMask *net.IP = nil
 	var ipSa *net.IP = nil
 	var ipSaMask *net.IP = nil
 	var err error
 
-	// make sure switch is connected
-	if !p.IsSwitchConnected() {
-		p.WaitForSwitchConnection()
-	}
-
 	// Different tier have different nextTable select strategy:
 	policyTable, nextTable, e := p.GetTierTable(direction, tier)
 	if e != nil {
 		log.Errorf("Failed to get policy table tier %v", tier)
-		return nil, errors.New("failed get policy table")
+		return nil, nil, errors.New("failed get policy table")
 	}
 
 	// Parse dst ip
@@ -420,7 +455,7 @@ func (p *PolicyBridge) AddMicroSegmentRule(rule *EveroutePolicyRule, direction u
 		ipDa, ipDaMask, err = ParseIPAddrMaskString(rule.DstIPAddr)
 		if err != nil {
 			log.Errorf("Failed to parse dst ip %s. Err: %v", rule.DstIPAddr, err)
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -429,7 +464,7 @@ func (p *PolicyBridge) AddMicroSegmentRule(rule *EveroutePolicyRule, direction u
 		ipSa, ipSaMask, err = ParseIPAddrMaskString(rule.SrcIPAddr)
 		if err != nil {
 			log.Errorf("Failed to parse src ip %s. Err: %v", rule.SrcIPAddr, err)
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -453,32 +488,19 @@ func (p *PolicyBridge) AddMicroSegmentRule(rule *EveroutePolicyRule, direction u
 	})
 	if err != nil {
 		log.Errorf("Failed to add flow for rule {%v}. Err: %v", rule, err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	switch rule.Action {
 	case "allow":
-		err = ruleFlow.Next(nextTable)
-		if err != nil {
-			log.Errorf("Failed to install flow {%+v}. Err: %v", ruleFlow, err)
-			return nil, err
-		}
+		return ruleFlow, nextTable, nil
 	case "deny":
-		// Point it to next table
-		err = ruleFlow.Next(p.OfSwitch.DropAction())
-		if err != nil {
-			log.Errorf("Failed to install flow {%+v}. Err: %v", ruleFlow, err)
-			return nil, err
-		}
+		// Point it to drop action
+		return ruleFlow, p.OfSwitch.DropAction(), nil
 	default:
 		log.Errorf("Unknown action in rule {%+v}", rule)
-		return nil, errors.New("unknown action in rule")
+		return nil, nil, errors.New("unknown action in rule")
 	}
-	return &FlowEntry{
-		Table:    policyTable,
-		Priority: ruleFlow.Match.Priority,
-		FlowID:   ruleFlow.FlowID,
-	}, nil
 }
 
 func (p *PolicyBridge) RemoveMicroSegmentRule(rule *EveroutePolicyRule) error {
@@ -0,0 +1,821 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+)
+
+const (
+	// conntrack zone of service dnat on local bridge
+	serviceConntrackZone uint16 = 65505
+	// conntrack zone of node port dnat on uplink bridge, kept apart from zone of local bridge, so replies
+	// from local backends to outside are not translated before they reach the node
+	nodePortConntrackZone uint16 = 65504
+	// select group ids of services start from here, lower ids are left to flood groups
+	serviceGroupIDBase uint32 = 1 << 16
+	// reg4[0..15] is id of the backend selected, reg4[16] marks the backend is selected by session affinity
+	serviceBackendReg          = 4
+	serviceAffinityFlag uint32 = 1 << 16
+	serviceBucketWeight        = 100
+
+	// NodePortMark is pkt_mark of node port traffic from outside sent to the node after dnat, the node
+	// masquerades it if routed to backends on other nodes, so replies come back to the node.
+	NodePortMark uint32 = 0x2
+)
+
+// ServicePort is a port of service cluster ip, new connections to it from local pods would be
+// balanced to the backends. If NodePort is set, new connections to the node port of node ips are
+// balanced as well, from local pods on local bridge, and from outside on uplink bridge.
+type ServicePort struct {
+	ClusterIP net.IP
+	Protocol  uint8 // PROTOCOL_TCP or PROTOCOL_UDP
+	Port      uint16
+	// NodePort is the port of the service on node ips of the same ip family as ClusterIP, zero if
+	// the service is not exposed on nodes.
+	NodePort uint16
+	// AffinityTimeout is the timeout of client ip session affinity in seconds, zero if disabled.
+	AffinityTimeout uint16
+	Backends        []ServiceBackend
+}
+
+// ServiceBackend is an endpoint of service port.
+type ServiceBackend struct {
+	IP   net.IP
+	Port uint16
+}
+
+func (p *ServicePort) Key() string {
+	return fmt.Sprintf("%s/%d/%d", p.ClusterIP, p.Protocol, p.Port)
+}
+
+func (b ServiceBackend) key() string {
+	return net.JoinHostPort(b.IP.String(), strconv.Itoa(int(b.Port)))
+}
+
+// serviceFrontend is an address of service port which new connections are balanced from.
+type serviceFrontend struct {
+	IP   net.IP
+	Port uint16
+	// connections to node port enter load balancing by flows of the frontend, instead of flows
+	// of cluster cidrs
+	nodePort bool
+}
+
+// serviceGroup is the select group of service port, flows to it would output to the group.
+type serviceGroup struct {
+	groupID uint32
+}
+
+func (g *serviceGroup) Type() string {
+	return "output"
+}
+
+func (g *serviceGroup) GetFlowInstr() openflow13.Instruction {
+	instr := openflow13.NewInstrApplyActions()
+	_ = instr.AddAction(openflow13.NewActionGroup(g.groupID), false)
+	return instr
+}
+
+// SyncService replaces all ports of the service, map namespaced name to ports. The service would be
+// removed if ports is empty.
+func (datapathManager *DpManager) SyncService(name string, ports []*ServicePort) error {
+	if !datapathManager.AgentInfo.EnableCNI || !datapathManager.AgentInfo.EnableServiceProxy {
+		return nil
+	}
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if !datapathManager.IsBridgesConnected() {
+		datapathManager.WaitForBridgeConnected()
+	}
+
+	datapathManager.serviceMutex.Lock()
+	oldPorts := datapathManager.services[name]
+	if len(ports) == 0 {
+		delete(datapathManager.services, name)
+	} else {
+		datapathManager.services[name] = ports
+	}
+	datapathManager.serviceMutex.Unlock()
+
+	newPortKeys := make(map[string]bool, len(ports))
+	for _, port := range ports {
+		newPortKeys[port.Key()] = true
+	}
+
+	for vdsID := range datapathManager.managedVDSMap() {
+		vdsBridgeMap, ok := datapathManager.BridgeChainMap[vdsID]
+		if !ok {
+			continue
+		}
+		for _, lb := range []*serviceLB{
+			vdsBridgeMap[LOCAL_BRIDGE_KEYWORD].(*LocalBridge).serviceLB,
+			vdsBridgeMap[UPLINK_BRIDGE_KEYWORD].(*UplinkBridge).serviceLB,
+		} {
+			for _, port := range oldPorts {
+				if !newPortKeys[port.Key()] {
+					lb.removeServicePort(port.Key())
+				}
+			}
+			for _, port := range ports {
+				if err := lb.addServicePort(port); err != nil {
+					return fmt.Errorf("failed to add service %s port %s to bridge %s, error: %v", name, port.Key(), lb.bridge, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (datapathManager *DpManager) getServicePorts() []*ServicePort {
+	datapathManager.serviceMutex.Lock()
+	defer datapathManager.serviceMutex.Unlock()
+
+	var ports []*ServicePort
+	for _, servicePorts := range datapathManager.services {
+		ports = append(ports, servicePorts...)
+	}
+	return ports
+}
+
+// serviceLB load balances service ports on a bridge. Connections to frontends of the ports are sent
+// to conntrack zone of the bridge from entry table, and resubmitted to next table, where new ones check
+// session affinity and output to select group of the port. The group selects a backend and resubmits
+// to dnat table, which commits the connection translated to the backend, and resubmits to next table.
+type serviceLB struct {
+	bridge string
+	sw     *ofctrl.OFSwitch
+	zone   uint16
+	// cluster ips are balanced if set, connections to them enter by flows of cluster cidrs
+	clusterIP bool
+	// node ports of the ips are balanced
+	nodeIPs []net.IP
+
+	entryTable    *ofctrl.Table
+	nextTable     *ofctrl.Table
+	affinityTable *ofctrl.Table // Table 30
+	lbTable       *ofctrl.Table // Table 35
+	dnatTable     *ofctrl.Table // Table 40
+	// replies from backends in the tables are sent to conntrack zone
+	replyInputs []serviceReplyInput
+
+	mutex      sync.Mutex
+	ports      map[string]*ServicePort      // map service port key to the port installed
+	flows      map[string][]*ofctrl.Flow    // map service port key to its entry, lb and dnat flows
+	groupIDs   map[string]uint32            // map service port key to its select group id
+	backendIDs map[string]map[string]uint16 // map service port key to ids of its backends
+	replyFlows map[string][]*ofctrl.Flow    // map protocol and backend to its reply conntrack flows
+	replyRefs  map[string]int               // map protocol and backend to number of ports refer to it
+	nextGroup  uint32
+}
+
+// serviceReplyInput is a table and input port replies from backends come from, zero port means any.
+type serviceReplyInput struct {
+	table  *ofctrl.Table
+	inPort uint32
+}
+
+func newServiceLB(bridge string) *serviceLB {
+	return &serviceLB{
+		bridge:     bridge,
+		ports:      make(map[string]*ServicePort),
+		flows:      make(map[string][]*ofctrl.Flow),
+		groupIDs:   make(map[string]uint32),
+		backendIDs: make(map[string]map[string]uint16),
+		replyFlows: make(map[string][]*ofctrl.Flow),
+		replyRefs:  make(map[string]int),
+	}
+}
+
+// initServiceProxy installs conntrack flows of service dnat, and flows selecting backends for new
+// connections to cluster ips and node ports from local pods. Known service ports would be installed again.
+func (l *LocalBridge) initServiceProxy(sw *ofctrl.OFSwitch) error {
+	lb := l.serviceLB
+	lb.zone, lb.clusterIP, lb.nodeIPs = serviceConntrackZone, true, l.datapathManager.AgentInfo.NodeIPs
+	lb.entryTable, lb.nextTable = l.fromLocalRedirectTable, l.fromLocalRedirectTable
+	lb.replyInputs = []serviceReplyInput{
+		{table: l.fromLocalRedirectTable},
+		{table: l.vlanInputTable, inPort: LOCAL_GATEWAY_PORT},
+		{table: l.vlanInputTable, inPort: LOCAL_TO_POLICY_PORT},
+	}
+	if err := lb.init(sw); err != nil {
+		return err
+	}
+
+	untrackedState := openflow13.NewCTStates()
+	untrackedState.UnsetTrk()
+	newState := openflow13.NewCTStates()
+	newState.SetNew()
+	newState.SetTrk()
+
+	for _, clusterCIDR := range l.clusterCIDRs() {
+		// traffic from local pods to cluster ips, established connections are translated by conntrack,
+		// replies from service backends are translated by flows of each backend
+		fromLocalCtFlow, _ := l.fromLocalRedirectTable.NewFlow(clusterCIDRMatch(clusterCIDR, HIGH_MATCH_FLOW_PRIORITY+2*FLOW_MATCH_OFFSET, untrackedState))
+		if err := fromLocalCtFlow.SetConntrack(newServiceConntrackAction(lb.zone, l.fromLocalRedirectTable.TableId)); err != nil {
+			return fmt.Errorf("failed to install fromLocalCtFlow flow, error: %v", err)
+		}
+
+		// new connections to cluster ips, check session affinity before load balancing
+		toServiceFlow, _ := l.fromLocalRedirectTable.NewFlow(clusterCIDRMatch(clusterCIDR, HIGH_MATCH_FLOW_PRIORITY+FLOW_MATCH_OFFSET, newState))
+		if err := toServiceFlow.Resubmit(nil, &lb.affinityTable.TableId); err != nil {
+			return err
+		}
+		if err := toServiceFlow.Next(lb.lbTable); err != nil {
+			return fmt.Errorf("failed to install toServiceFlow flow, error: %v", err)
+		}
+	}
+
+	return lb.addServicePorts(l.datapathManager.getServicePorts())
+}
+
+// initServiceProxy installs flows selecting backends for new connections to node ports from outside.
+// Connections translated are marked and sent to the node by gateway, which routes them to the backends,
+// replies routed back by gateway are translated by conntrack. Known service ports would be installed again.
+func (u *UplinkBridge) initServiceProxy() error {
+	sw := u.OfSwitch
+	outputTable, _ := sw.NewTable(UPLINK_SERVICE_OUTPUT_TABLE)
+
+	lb := u.serviceLB
+	lb.zone, lb.clusterIP, lb.nodeIPs = nodePortConntrackZone, false, u.datapathManager.AgentInfo.NodeIPs
+	lb.entryTable, lb.nextTable = u.defaultTable, outputTable
+	lb.replyInputs = []serviceReplyInput{{table: u.defaultTable, inPort: UPLINK_GATEWAY_PORT}}
+	if err := lb.init(sw); err != nil {
+		return err
+	}
+
+	// connections translated go to the node, marked to be masqueraded if routed to other nodes
+	toGatewayFlow, _ := outputTable.NewFlow(ofctrl.FlowMatch{
+		Priority: DEFAULT_FLOW_MISS_PRIORITY,
+	})
+	if err := toGatewayFlow.LoadField("nxm_nx_pkt_mark", 0x1, openflow13.NewNXRange(1, 1)); err != nil {
+		return err
+	}
+	if err := toGatewayFlow.SetMacDa(u.datapathManager.AgentInfo.GatewayMac); err != nil {
+		return err
+	}
+	outputPortGateway, _ := sw.OutputPort(UPLINK_GATEWAY_PORT)
+	if err := toGatewayFlow.Next(outputPortGateway); err != nil {
+		return fmt.Errorf("failed to install node port toGatewayFlow flow, error: %v", err)
+	}
+
+	return lb.addServicePorts(u.datapathManager.getServicePorts())
+}
+
+// init creates tables of load balancing on the switch and installs their default flows. Service ports
+// installed on the switch before are forgotten.
+func (lb *serviceLB) init(sw *ofctrl.OFSwitch) error {
+	lb.sw = sw
+	lb.affinityTable, _ = sw.NewTable(SERVICE_AFFINITY_TABLE)
+	lb.lbTable, _ = sw.NewTable(SERVICE_LB_TABLE)
+	lb.dnatTable, _ = sw.NewTable(SERVICE_DNAT_TABLE)
+
+	// backend selected by session affinity
+	affinityFlow, _ := lb.lbTable.NewFlow(ofctrl.FlowMatch{
+		Priority: HIGH_MATCH_FLOW_PRIORITY,
+		Regs: []*ofctrl.NXRegister{{
+			RegID: serviceBackendReg,
+			Data:  serviceAffinityFlag,
+			Range: openflow13.NewNXRange(16, 16),
+		}},
+	})
+	if err := affinityFlow.Next(lb.dnatTable); err != nil {
+		return fmt.Errorf("failed to install service affinity flow, error: %v", err)
+	}
+
+	// service ports without backends, same as kube-proxy, connections to them are not accepted
+	lbDefaultFlow, _ := lb.lbTable.NewFlow(ofctrl.FlowMatch{
+		Priority: DEFAULT_FLOW_MISS_PRIORITY,
+	})
+	if err := lbDefaultFlow.Next(sw.DropAction()); err != nil {
+		return fmt.Errorf("failed to install service lb default flow, error: %v", err)
+	}
+
+	// backend selected by session affinity has been removed, select again
+	dnatDefaultFlow, _ := lb.dnatTable.NewFlow(ofctrl.FlowMatch{
+		Priority: DEFAULT_FLOW_MISS_PRIORITY,
+		Regs: []*ofctrl.NXRegister{{
+			RegID: serviceBackendReg,
+			Data:  serviceAffinityFlag,
+			Range: openflow13.NewNXRange(16, 16),
+		}},
+	})
+	if err := dnatDefaultFlow.LoadField(fmt.Sprintf("nxm_nx_reg%d", serviceBackendReg), 0, openflow13.NewNXRange(0, 16)); err != nil {
+		return err
+	}
+	if err := dnatDefaultFlow.Resubmit(nil, &lb.lbTable.TableId); err != nil {
+		return err
+	}
+	if err := dnatDefaultFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+		return fmt.Errorf("failed to install service dnat default flow, error: %v", err)
+	}
+
+	lb.mutex.Lock()
+	lb.ports = make(map[string]*ServicePort)
+	lb.flows = make(map[string][]*ofctrl.Flow)
+	lb.replyFlows = make(map[string][]*ofctrl.Flow)
+	lb.replyRefs = make(map[string]int)
+	lb.mutex.Unlock()
+
+	return nil
+}
+
+func (lb *serviceLB) addServicePorts(ports []*ServicePort) error {
+	for _, port := range ports {
+		if err := lb.addServicePort(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clusterCIDRMatch(clusterCIDR *net.IPNet, priority uint16, ctStates *openflow13.CTStates) ofctrl.FlowMatch {
+	match := ofctrl.FlowMatch{
+		Priority:  priority,
+		Ethertype: PROTOCOL_IP,
+		IpDa:      &clusterCIDR.IP,
+		IpDaMask:  (*net.IP)(&clusterCIDR.Mask),
+		CtStates:  ctStates,
+	}
+	if clusterCIDR.IP.To4() == nil {
+		match.Ethertype, match.IpDa, match.IpDaMask = PROTOCOL_IPV6, nil, nil
+		match.Ipv6Da, match.Ipv6DaMask = &clusterCIDR.IP, (*net.IP)(&clusterCIDR.Mask)
+	}
+	return match
+}
+
+// newServiceConntrackAction sends the packet to the conntrack zone of service dnat, the connections
+// translated are translated again, then resubmits to the table.
+func newServiceConntrackAction(zone uint16, tableID uint8) *ofctrl.ConnTrackAction {
+	ctAction := ofctrl.NewConntrackAction(false, false, &tableID, &zone)
+	ctAction.Actions = []openflow13.Action{openflow13.NewNXActionCTNAT()}
+	return ctAction
+}
+
+func (l *LocalBridge) clusterCIDRs() []*net.IPNet {
+	var cidrs []*net.IPNet
+	for _, clusterCIDR := range []*net.IPNet{
+		(*net.IPNet)(l.datapathManager.AgentInfo.ClusterCIDR),
+		(*net.IPNet)(l.datapathManager.AgentInfo.ClusterCIDRv6),
+	} {
+		if clusterCIDR != nil {
+			cidrs = append(cidrs, clusterCIDR)
+		}
+	}
+	return cidrs
+}
+
+// frontendsOf returns frontends of the service port balanced on the bridge: its cluster ip, and node
+// ips of the same ip family with its node port.
+func (lb *serviceLB) frontendsOf(port *ServicePort) []serviceFrontend {
+	var frontends []serviceFrontend
+	if lb.clusterIP {
+		frontends = append(frontends, serviceFrontend{IP: port.ClusterIP, Port: port.Port})
+	}
+	if port.NodePort == 0 {
+		return frontends
+	}
+	for _, nodeIP := range lb.nodeIPs {
+		if (nodeIP.To4() == nil) == (port.ClusterIP.To4() == nil) {
+			frontends = append(frontends, serviceFrontend{IP: nodeIP, Port: port.NodePort, nodePort: true})
+		}
+	}
+	return frontends
+}
+
+// addServicePort installs or updates the select group and flows of the service port. Flows of the
+// new backends are installed before the group selects them, flows unchanged are replaced in place,
+// and flows of the removed backends are removed at last, so established connections are not broken.
+// Service port without backends or frontends on the bridge would be removed.
+func (lb *serviceLB) addServicePort(port *ServicePort) error {
+	key := port.Key()
+	if len(port.Backends) == 0 || len(lb.frontendsOf(port)) == 0 {
+		lb.removeServicePort(key)
+		return nil
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	oldPort, installed := lb.ports[key]
+	if installed && reflect.DeepEqual(oldPort, port) {
+		return nil
+	}
+
+	groupID, ok := lb.groupIDs[key]
+	if !ok {
+		groupID = serviceGroupIDBase + lb.nextGroup
+		lb.nextGroup++
+		lb.groupIDs[key] = groupID
+	}
+	backendIDs := allocateServiceBackendIDs(lb.backendIDs[key], port.Backends)
+	lb.backendIDs[key] = backendIDs
+
+	groupMod, err := newServiceGroupMod(groupID, port, backendIDs)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		// the group may be left by last agent
+		deleteGroupMod := openflow13.NewGroupMod()
+		deleteGroupMod.GroupId = groupID
+		deleteGroupMod.Command = openflow13.OFPGC_DELETE
+		lb.sw.Send(deleteGroupMod)
+		lb.sw.Send(groupMod)
+	}
+
+	for _, backend := range port.Backends {
+		if err := lb.addServiceReplyFlows(port.Protocol, backend); err != nil {
+			return err
+		}
+	}
+	// flows with the same match and priority as the installed ones replace them
+	flows, err := lb.addServicePortFlows(port, groupID, backendIDs)
+	if err != nil {
+		return err
+	}
+	if installed {
+		groupMod.Command = openflow13.OFPGC_MODIFY
+		lb.sw.Send(groupMod)
+	}
+
+	// flows replaced have been removed with their cookies, remove the stale ones left
+	for _, flow := range lb.flows[key] {
+		if err := flow.Delete(); err != nil {
+			return err
+		}
+	}
+	lb.flows[key] = flows
+	if installed {
+		for _, backend := range oldPort.Backends {
+			lb.removeServiceReplyFlows(oldPort.Protocol, backend)
+		}
+	}
+	lb.ports[key] = port
+	log.Infof("Add service port %s with %d backends to bridge %s", key, len(port.Backends), lb.bridge)
+
+	return nil
+}
+
+func (lb *serviceLB) removeServicePort(key string) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	groupID, ok := lb.groupIDs[key]
+	if !ok {
+		// never installed on the bridge
+		return
+	}
+	for _, flow := range lb.flows[key] {
+		if err := flow.Delete(); err != nil {
+			log.Errorf("Failed to delete flow of service port %s, error: %v", key, err)
+		}
+	}
+	if port, ok := lb.ports[key]; ok {
+		for _, backend := range port.Backends {
+			lb.removeServiceReplyFlows(port.Protocol, backend)
+		}
+	}
+	groupMod := openflow13.NewGroupMod()
+	groupMod.GroupId = groupID
+	groupMod.Command = openflow13.OFPGC_DELETE
+	lb.sw.Send(groupMod)
+
+	delete(lb.flows, key)
+	delete(lb.ports, key)
+	delete(lb.groupIDs, key)
+	delete(lb.backendIDs, key)
+	log.Infof("Remove service port %s from bridge %s", key, lb.bridge)
+}
+
+// addServiceReplyFlows sends replies from the backend to conntrack zone of service dnat, in each reply
+// input of the bridge. The flows are shared by service ports with the same backend, the caller must
+// hold the mutex.
+func (lb *serviceLB) addServiceReplyFlows(protocol uint8, backend ServiceBackend) (err error) {
+	key := fmt.Sprintf("%d/%s", protocol, backend.key())
+	if lb.replyRefs[key] > 0 {
+		lb.replyRefs[key]++
+		return nil
+	}
+
+	var flows []*ofctrl.Flow
+	defer func() {
+		if err != nil {
+			for _, flow := range flows {
+				_ = flow.Delete()
+			}
+		}
+	}()
+
+	untrackedState := openflow13.NewCTStates()
+	untrackedState.UnsetTrk()
+	for _, item := range lb.replyInputs {
+		match := serviceBackendMatch(protocol, backend, HIGH_MATCH_FLOW_PRIORITY+2*FLOW_MATCH_OFFSET)
+		match.InputPort = item.inPort
+		match.CtStates = untrackedState
+		replyFlow, _ := item.table.NewFlow(match)
+		if err := replyFlow.SetConntrack(newServiceConntrackAction(lb.zone, item.table.TableId)); err != nil {
+			return fmt.Errorf("failed to install service reply flow, error: %v", err)
+		}
+		flows = append(flows, replyFlow)
+	}
+
+	lb.replyFlows[key] = flows
+	lb.replyRefs[key] = 1
+	return nil
+}
+
+// removeServiceReplyFlows removes the reply flows of the backend if no service port refers to it,
+// the caller must hold the mutex.
+func (lb *serviceLB) removeServiceReplyFlows(protocol uint8, backend ServiceBackend) {
+	key := fmt.Sprintf("%d/%s", protocol, backend.key())
+	if lb.replyRefs[key]--; lb.replyRefs[key] > 0 {
+		return
+	}
+	for _, flow := range lb.replyFlows[key] {
+		if err := flow.Delete(); err != nil {
+			log.Errorf("Failed to delete reply flow of service backend %s, error: %v", key, err)
+		}
+	}
+	delete(lb.replyFlows, key)
+	delete(lb.replyRefs, key)
+}
+
+// addServicePortFlows outputs new connections to frontends of the service port to its select group,
+// and translates them to the backend selected. Connections to node ports are sent to conntrack zone
+// by flows of each frontend. Flows installed would be removed if failed.
+func (lb *serviceLB) addServicePortFlows(port *ServicePort, groupID uint32, backendIDs map[string]uint16) (flows []*ofctrl.Flow, err error) {
+	defer func() {
+		if err != nil {
+			for _, flow := range flows {
+				_ = flow.Delete()
+			}
+		}
+	}()
+
+	untrackedState := openflow13.NewCTStates()
+	untrackedState.UnsetTrk()
+	newState := openflow13.NewCTStates()
+	newState.SetNew()
+	newState.SetTrk()
+	zone := lb.zone
+	nextTable := lb.nextTable.TableId
+
+	for _, frontend := range lb.frontendsOf(port) {
+		if frontend.nodePort {
+			// connections to the node port, established ones are translated by conntrack
+			match := serviceFrontendMatch(port.Protocol, frontend, HIGH_MATCH_FLOW_PRIORITY+2*FLOW_MATCH_OFFSET)
+			match.CtStates = untrackedState
+			nodePortCtFlow, _ := lb.entryTable.NewFlow(match)
+			if err := nodePortCtFlow.SetConntrack(newServiceConntrackAction(zone, nextTable)); err != nil {
+				return flows, fmt.Errorf("failed to install nodePortCtFlow flow, error: %v", err)
+			}
+			flows = append(flows, nodePortCtFlow)
+
+			// new connections to the node port, check session affinity before load balancing
+			match = serviceFrontendMatch(port.Protocol, frontend, HIGH_MATCH_FLOW_PRIORITY+FLOW_MATCH_OFFSET)
+			match.CtStates = newState
+			toNodePortFlow, _ := lb.nextTable.NewFlow(match)
+			if err := toNodePortFlow.Resubmit(nil, &lb.affinityTable.TableId); err != nil {
+				return flows, err
+			}
+			if err := toNodePortFlow.Next(lb.lbTable); err != nil {
+				return flows, fmt.Errorf("failed to install toNodePortFlow flow, error: %v", err)
+			}
+			flows = append(flows, toNodePortFlow)
+		}
+
+		lbFlow, _ := lb.lbTable.NewFlow(serviceFrontendMatch(port.Protocol, frontend, MID_MATCH_FLOW_PRIORITY))
+		if err := lbFlow.Next(&serviceGroup{groupID: groupID}); err != nil {
+			return flows, fmt.Errorf("failed to install service lb flow, error: %v", err)
+		}
+		flows = append(flows, lbFlow)
+
+		for _, backend := range port.Backends {
+			match := serviceFrontendMatch(port.Protocol, frontend, MID_MATCH_FLOW_PRIORITY)
+			match.Regs = []*ofctrl.NXRegister{{
+				RegID: serviceBackendReg,
+				Data:  uint32(backendIDs[backend.key()]),
+				Range: openflow13.NewNXRange(0, 15),
+			}}
+			dnatFlow, _ := lb.dnatTable.NewFlow(match)
+			ctAction := ofctrl.NewConntrackAction(true, false, &nextTable, &zone)
+			ctAction.Actions = []openflow13.Action{newServiceDNATAction(backend)}
+			if err := dnatFlow.SetConntrack(ctAction); err != nil {
+				return flows, fmt.Errorf("failed to install service dnat flow, error: %v", err)
+			}
+			flows = append(flows, dnatFlow)
+		}
+	}
+
+	return flows, nil
+}
+
+// serviceFrontendMatch matches packets to the frontend port.
+func serviceFrontendMatch(protocol uint8, frontend serviceFrontend, priority uint16) ofctrl.FlowMatch {
+	frontendIP := frontend.IP
+	match := ofctrl.FlowMatch{
+		Priority:  priority,
+		Ethertype: PROTOCOL_IP,
+		IpDa:      &frontendIP,
+		IpProto:   protocol,
+	}
+	if frontendIP.To4() == nil {
+		match.Ethertype, match.IpDa, match.Ipv6Da = PROTOCOL_IPV6, nil, &frontendIP
+	}
+	switch protocol {
+	case PROTOCOL_TCP:
+		match.TcpDstPort = frontend.Port
+	case PROTOCOL_UDP:
+		match.UdpDstPort = frontend.Port
+	}
+	return match
+}
+
+// serviceBackendMatch matches packets from the backend port.
+func serviceBackendMatch(protocol uint8, backend ServiceBackend, priority uint16) ofctrl.FlowMatch {
+	backendIP := backend.IP
+	match := ofctrl.FlowMatch{
+		Priority:  priority,
+		Ethertype: PROTOCOL_IP,
+		IpSa:      &backendIP,
+		IpProto:   protocol,
+	}
+	if backendIP.To4() == nil {
+		match.Ethertype, match.IpSa, match.Ipv6Sa = PROTOCOL_IPV6, nil, &backendIP
+	}
+	switch protocol {
+	case PROTOCOL_TCP:
+		match.TcpSrcPort = backend.Port
+	case PROTOCOL_UDP:
+		match.UdpSrcPort = backend.Port
+	}
+	return match
+}
+
+// allocateServiceBackendIDs keeps ids of the backends already allocated, and allocates the smallest
+// unused ids from 1 for new backends.
+func allocateServiceBackendIDs(oldIDs map[string]uint16, backends []ServiceBackend) map[string]uint16 {
+	backendIDs := make(map[string]uint16, len(backends))
+	usedIDs := make(map[uint16]bool, len(backends))
+	for _, backend := range backends {
+		if id, ok := oldIDs[backend.key()]; ok {
+			backendIDs[backend.key()] = id
+			usedIDs[id] = true
+		}
+	}
+
+	var nextID uint16 = 1
+	for _, backend := range backends {
+		if _, ok := backendIDs[backend.key()]; ok {
+			continue
+		}
+		for usedIDs[nextID] {
+			nextID++
+		}
+		backendIDs[backend.key()] = nextID
+		usedIDs[nextID] = true
+	}
+
+	return backendIDs
+}
+
+// newServiceGroupMod returns the select group of service port, one bucket for each backend. The bucket
+// loads backend id and resubmits to dnat table, and learns the backend for the client if session
+// affinity enabled.
+func newServiceGroupMod(groupID uint32, port *ServicePort, backendIDs map[string]uint16) (*openflow13.GroupMod, error) {
+	groupMod := openflow13.NewGroupMod()
+	groupMod.GroupId = groupID
+	groupMod.Type = openflow13.OFPGT_SELECT
+
+	regField, err := openflow13.FindFieldHeaderByName(fmt.Sprintf("nxm_nx_reg%d", serviceBackendReg), false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, backend := range port.Backends {
+		backendID := backendIDs[backend.key()]
+		bkt := openflow13.NewBucket()
+		bkt.Weight = serviceBucketWeight
+		bkt.AddAction(openflow13.NewNXActionRegLoad(openflow13.NewNXRange(0, 15).ToOfsBits(), regField, uint64(backendID)))
+		if port.AffinityTimeout != 0 {
+			learnAction, err := newServiceAffinityLearnAction(port, backendID)
+			if err != nil {
+				return nil, err
+			}
+			bkt.AddAction(learnAction)
+		}
+		bkt.AddAction(openflow13.NewNXActionResubmitTableAction(openflow13.OFPP_IN_PORT, SERVICE_DNAT_TABLE))
+		groupMod.AddBucket(*bkt)
+	}
+
+	return groupMod, nil
+}
+
+// newServiceAffinityLearnAction learns a flow of the client and service port, loading the backend id
+// with affinity flag, into session affinity table.
+func newServiceAffinityLearnAction(port *ServicePort, backendID uint16) (*openflow13.NXActionLearn, error) {
+	ethertype, srcField, dstField, ipBits := uint16(PROTOCOL_IP), "nxm_of_ip_src", "nxm_of_ip_dst", uint16(32)
+	if port.ClusterIP.To4() == nil {
+		ethertype, srcField, dstField, ipBits = PROTOCOL_IPV6, "nxm_nx_ipv6_src", "nxm_nx_ipv6_dst", 128
+	}
+	portField := "nxm_of_tcp_dst"
+	if port.Protocol == PROTOCOL_UDP {
+		portField = "nxm_of_udp_dst"
+	}
+
+	ethertypeValue := make([]byte, 2)
+	binary.BigEndian.PutUint16(ethertypeValue, ethertype)
+	backendValue := make([]byte, 4)
+	binary.BigEndian.PutUint32(backendValue, serviceAffinityFlag|uint32(backendID))
+
+	var specs []*openflow13.NXLearnSpec
+	for _, item := range []struct {
+		field string
+		bits  uint16
+		value []byte
+	}{
+		{field: "nxm_of_eth_type", bits: 16, value: ethertypeValue},
+		{field: "nxm_of_ip_proto", bits: 8, value: []byte{0, port.Protocol}},
+		{field: srcField, bits: ipBits},
+		{field: dstField, bits: ipBits},
+		{field: portField, bits: 16},
+	} {
+		field, err := openflow13.FindFieldHeaderByName(item.field, false)
+		if err != nil {
+			return nil, err
+		}
+		if item.value != nil {
+			specs = append(specs, &openflow13.NXLearnSpec{
+				Header:   openflow13.NewLearnHeaderMatchFromValue(item.bits),
+				DstField: &openflow13.NXLearnSpecField{Field: field},
+				SrcValue: item.value,
+			})
+			continue
+		}
+		specs = append(specs, &openflow13.NXLearnSpec{
+			Header:   openflow13.NewLearnHeaderMatchFromField(item.bits),
+			DstField: &openflow13.NXLearnSpecField{Field: field},
+			SrcField: &openflow13.NXLearnSpecField{Field: field},
+		})
+	}
+
+	regField, err := openflow13.FindFieldHeaderByName(fmt.Sprintf("nxm_nx_reg%d", serviceBackendReg), false)
+	if err != nil {
+		return nil, err
+	}
+	specs = append(specs, &openflow13.NXLearnSpec{
+		Header:   openflow13.NewLearnHeaderLoadFromValue(17),
+		DstField: &openflow13.NXLearnSpecField{Field: regField},
+		SrcValue: backendValue,
+	})
+
+	learnAction := openflow13.NewNXActionLearn()
+	learnAction.IdleTimeout = port.AffinityTimeout
+	learnAction.Priority = NORMAL_MATCH_FLOW_PRIORITY
+	learnAction.TableID = SERVICE_AFFINITY_TABLE
+	learnAction.LearnSpecs = specs
+	return learnAction, nil
+}
+
+// newServiceDNATAction translates destination of the connection to the backend.
+func newServiceDNATAction(backend ServiceBackend) *openflow13.NXActionCTNAT {
+	natAction := openflow13.NewNXActionCTNAT()
+	_ = natAction.SetDNAT()
+	if backend.IP.To4() != nil {
+		natAction.SetRangeIPv4Min(backend.IP)
+		natAction.SetRangeIPv4Max(backend.IP)
+	} else {
+		natAction.SetRangeIPv6Min(backend.IP)
+		natAction.SetRangeIPv6Max(backend.IP)
+	}
+	backendPort := backend.Port
+	natAction.SetRangeProtoMin(&backendPort)
+	natAction.SetRangeProtoMax(&backendPort)
+	return natAction
+}
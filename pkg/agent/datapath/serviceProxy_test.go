@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"net"
+	"testing"
+
+	"github.com/contiv/libOpenflow/openflow13"
+	. "github.com/onsi/gomega"
+)
+
+func TestAllocateServiceBackendIDs(t *testing.T) {
+	RegisterTestingT(t)
+
+	backend1 := ServiceBackend{IP: net.ParseIP("10.0.0.1"), Port: 80}
+	backend2 := ServiceBackend{IP: net.ParseIP("10.0.0.2"), Port: 80}
+	backend3 := ServiceBackend{IP: net.ParseIP("10.0.0.3"), Port: 80}
+
+	ids := allocateServiceBackendIDs(nil, []ServiceBackend{backend1, backend2})
+	Expect(ids).Should(Equal(map[string]uint16{backend1.key(): 1, backend2.key(): 2}))
+
+	// ids of remained backends are kept, and ids of removed backends are reused
+	ids = allocateServiceBackendIDs(ids, []ServiceBackend{backend2, backend3})
+	Expect(ids).Should(Equal(map[string]uint16{backend2.key(): 2, backend3.key(): 1}))
+}
+
+func TestNewServiceGroupMod(t *testing.T) {
+	RegisterTestingT(t)
+
+	port := &ServicePort{
+		ClusterIP:       net.ParseIP("10.96.0.10"),
+		Protocol:        PROTOCOL_UDP,
+		Port:            53,
+		AffinityTimeout: 10800,
+		Backends: []ServiceBackend{
+			{IP: net.ParseIP("10.244.1.2"), Port: 53},
+			{IP: net.ParseIP("10.244.2.2"), Port: 53},
+		},
+	}
+	backendIDs := allocateServiceBackendIDs(nil, port.Backends)
+
+	groupMod, err := newServiceGroupMod(serviceGroupIDBase, port, backendIDs)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(groupMod.Type).Should(Equal(uint8(openflow13.OFPGT_SELECT)))
+	Expect(groupMod.Buckets).Should(HaveLen(2))
+	for _, bkt := range groupMod.Buckets {
+		// load backend id, learn session affinity and resubmit to dnat table
+		Expect(bkt.Actions).Should(HaveLen(3))
+		Expect(bkt.Actions[1]).Should(BeAssignableToTypeOf(&openflow13.NXActionLearn{}))
+	}
+
+	_, err = groupMod.MarshalBinary()
+	Expect(err).ShouldNot(HaveOccurred())
+}
+
+func TestServiceBackendMatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	match := serviceBackendMatch(PROTOCOL_TCP, ServiceBackend{IP: net.ParseIP("10.244.1.2"), Port: 8080}, MID_MATCH_FLOW_PRIORITY)
+	Expect(match.Ethertype).Should(Equal(uint16(PROTOCOL_IP)))
+	Expect(match.IpSa.String()).Should(Equal("10.244.1.2"))
+	Expect(match.TcpSrcPort).Should(Equal(uint16(8080)))
+	Expect(match.UdpSrcPort).Should(BeZero())
+
+	match = serviceBackendMatch(PROTOCOL_UDP, ServiceBackend{IP: net.ParseIP("fd00::2"), Port: 53}, MID_MATCH_FLOW_PRIORITY)
+	Expect(match.Ethertype).Should(Equal(uint16(PROTOCOL_IPV6)))
+	Expect(match.IpSa).Should(BeNil())
+	Expect(match.Ipv6Sa.String()).Should(Equal("fd00::2"))
+	Expect(match.UdpSrcPort).Should(Equal(uint16(53)))
+}
+
+func TestServiceFrontendsOf(t *testing.T) {
+	RegisterTestingT(t)
+
+	port := &ServicePort{
+		ClusterIP: net.ParseIP("10.96.0.10"),
+		Protocol:  PROTOCOL_TCP,
+		Port:      80,
+		NodePort:  30080,
+	}
+	nodeIPs := []net.IP{net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10")}
+
+	// cluster ip and node port of node ips of the same ip family on local bridge
+	lb := newServiceLB("local")
+	lb.clusterIP, lb.nodeIPs = true, nodeIPs
+	Expect(lb.frontendsOf(port)).Should(Equal([]serviceFrontend{
+		{IP: port.ClusterIP, Port: 80},
+		{IP: nodeIPs[0], Port: 30080, nodePort: true},
+	}))
+
+	// only node port on uplink bridge
+	lb = newServiceLB("uplink")
+	lb.nodeIPs = nodeIPs
+	Expect(lb.frontendsOf(port)).Should(Equal([]serviceFrontend{
+		{IP: nodeIPs[0], Port: 30080, nodePort: true},
+	}))
+	port.NodePort = 0
+	Expect(lb.frontendsOf(port)).Should(BeEmpty())
+}
+
+func TestServiceFrontendMatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	match := serviceFrontendMatch(PROTOCOL_TCP, serviceFrontend{IP: net.ParseIP("192.168.1.10"), Port: 30080}, MID_MATCH_FLOW_PRIORITY)
+	Expect(match.Ethertype).Should(Equal(uint16(PROTOCOL_IP)))
+	Expect(match.IpDa.String()).Should(Equal("192.168.1.10"))
+	Expect(match.TcpDstPort).Should(Equal(uint16(30080)))
+
+	match = serviceFrontendMatch(PROTOCOL_UDP, serviceFrontend{IP: net.ParseIP("fd00::10"), Port: 30053}, MID_MATCH_FLOW_PRIORITY)
+	Expect(match.Ethertype).Should(Equal(uint16(PROTOCOL_IPV6)))
+	Expect(match.IpDa).Should(BeNil())
+	Expect(match.Ipv6Da.String()).Should(Equal("fd00::10"))
+	Expect(match.UdpDstPort).Should(Equal(uint16(30053)))
+}
@@ -0,0 +1,564 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/libOpenflow/protocol"
+	"github.com/contiv/ofnet/ofctrl"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// SpoofGuardPolicy describes spoof guard setting of an endpoint, the policy is
+// applied to the local endpoint which has the same mac address.
+type SpoofGuardPolicy struct {
+	MacAddrStr string
+	// Disabled endpoint would skip spoof guard checking
+	Disabled bool
+	// StaticIPs always allowed as the source ip of the endpoint
+	StaticIPs []net.IP
+}
+
+func (datapathManager *DpManager) SetSpoofGuardPolicy(key string, policy *SpoofGuardPolicy) error {
+	datapathManager.spoofGuardPolicyMutex.Lock()
+	oldPolicy := datapathManager.spoofGuardPolicies[key]
+	datapathManager.spoofGuardPolicies[key] = policy
+	datapathManager.spoofGuardPolicyMutex.Unlock()
+
+	if oldPolicy != nil && isSameMacAddr(oldPolicy.MacAddrStr, policy.MacAddrStr) && isSameSpoofGuard(oldPolicy, policy) {
+		// neither the state nor the static ips changes
+		return nil
+	}
+	if oldPolicy != nil && !isSameMacAddr(oldPolicy.MacAddrStr, policy.MacAddrStr) {
+		if err := datapathManager.refreshSpoofGuard(oldPolicy.MacAddrStr); err != nil {
+			return err
+		}
+	}
+	return datapathManager.refreshSpoofGuard(policy.MacAddrStr)
+}
+
+func (datapathManager *DpManager) RemoveSpoofGuardPolicy(key string) error {
+	datapathManager.spoofGuardPolicyMutex.Lock()
+	policy, ok := datapathManager.spoofGuardPolicies[key]
+	delete(datapathManager.spoofGuardPolicies, key)
+	datapathManager.spoofGuardPolicyMutex.Unlock()
+
+	if !ok || isSameSpoofGuard(policy, nil) {
+		return nil
+	}
+	return datapathManager.refreshSpoofGuard(policy.MacAddrStr)
+}
+
+// isSameSpoofGuard returns true if the policies have the same state and static ips, nil policy
+// means spoof guard enabled without static ips.
+func isSameSpoofGuard(policy1, policy2 *SpoofGuardPolicy) bool {
+	var empty = &SpoofGuardPolicy{}
+	if policy1 == nil {
+		policy1 = empty
+	}
+	if policy2 == nil {
+		policy2 = empty
+	}
+	if policy1.Disabled != policy2.Disabled {
+		return false
+	}
+
+	staticIPs := sets.NewString()
+	for _, ip := range policy1.StaticIPs {
+		staticIPs.Insert(ip.String())
+	}
+	staticIPs2 := sets.NewString()
+	for _, ip := range policy2.StaticIPs {
+		staticIPs2.Insert(ip.String())
+	}
+	return staticIPs.Equal(staticIPs2)
+}
+
+// GetSpoofGuardDropStats returns the number of packets dropped by spoof guard, map local endpoint
+// interface name to its dropped packets.
+func (datapathManager *DpManager) GetSpoofGuardDropStats() (map[string]uint64, error) {
+	dropStats := make(map[string]uint64)
+	if !datapathManager.datapathConfig.EnableSpoofGuard {
+		return dropStats, nil
+	}
+
+	for vdsID := range datapathManager.managedVDSMap() {
+		// vds may have been removed by config reload
+		vdsBridgeMap, ok := datapathManager.BridgeChainMap[vdsID]
+		if !ok {
+			continue
+		}
+		localBridge := vdsBridgeMap[LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+		portDropStats, err := localBridge.getSpoofGuardDropStats()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get spoof guard drop stats of bridge %s, error: %v", localBridge.name, err)
+		}
+		for endpointObj := range datapathManager.localEndpointDB.IterBuffered() {
+			endpoint := endpointObj.Val.(*Endpoint)
+			if endpoint.BridgeName != localBridge.name {
+				continue
+			}
+			dropStats[endpoint.InterfaceName] = portDropStats[endpoint.PortNo]
+		}
+	}
+
+	return dropStats, nil
+}
+
+// refreshSpoofGuard updates from local endpoint flows of local endpoints with the mac address,
+// it should be called when spoof guard policy of the endpoint changes.
+func (datapathManager *DpManager) refreshSpoofGuard(macAddrStr string) error {
+	if !datapathManager.datapathConfig.EnableSpoofGuard {
+		return nil
+	}
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if !datapathManager.IsBridgesConnected() {
+		datapathManager.WaitForBridgeConnected()
+	}
+
+	for endpointObj := range datapathManager.localEndpointDB.IterBuffered() {
+		endpoint := endpointObj.Val.(*Endpoint)
+		if !isSameMacAddr(endpoint.MacAddrStr, macAddrStr) {
+			continue
+		}
+		for vdsID, ovsbrname := range datapathManager.managedVDSMap() {
+			if ovsbrname != endpoint.BridgeName {
+				continue
+			}
+			localBridge := datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+			if err := localBridge.updateFromLocalEndpointFlow(endpoint); err != nil {
+				return fmt.Errorf("failed to update local endpoint %v flow on bridge %v, error: %v", endpoint.MacAddrStr, ovsbrname, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (datapathManager *DpManager) getSpoofGuardPolicy(macAddrStr string) *SpoofGuardPolicy {
+	datapathManager.spoofGuardPolicyMutex.RLock()
+	defer datapathManager.spoofGuardPolicyMutex.RUnlock()
+
+	for _, policy := range datapathManager.spoofGuardPolicies {
+		if isSameMacAddr(policy.MacAddrStr, macAddrStr) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func (l *LocalBridge) isSpoofGuardEnabled(endpoint *Endpoint) bool {
+	if !l.datapathManager.datapathConfig.EnableSpoofGuard {
+		return false
+	}
+	policy := l.datapathManager.getSpoofGuardPolicy(endpoint.MacAddrStr)
+	return policy == nil || !policy.Disabled
+}
+
+// bindSpoofGuardIP allows local endpoint on ofPort send packets from ip until expireTime, it's called
+// with ip acked by dhcp server. Ips learned from arp or ndp are never bound, otherwise the first
+// spoofed ip would lock the endpoint. Ips allocated to the endpoint are trusted by static ips.
+func (l *LocalBridge) bindSpoofGuardIP(ip net.IP, ofPort uint32, expireTime time.Time) {
+	if !l.datapathManager.datapathConfig.EnableSpoofGuard {
+		return
+	}
+	endpoint, ok := l.getLocalEndpointByOfPort(ofPort)
+	if !ok {
+		return
+	}
+	macAddr, err := net.ParseMAC(endpoint.MacAddrStr)
+	if err != nil {
+		return
+	}
+
+	l.spoofGuardMutex.Lock()
+	defer l.spoofGuardMutex.Unlock()
+
+	boundIPs, ok := l.spoofGuardBindings[macAddr.String()]
+	if !ok {
+		boundIPs = make(map[string]time.Time)
+		l.spoofGuardBindings[macAddr.String()] = boundIPs
+	}
+	if _, ok := boundIPs[ip.String()]; ok {
+		if expireTime.After(boundIPs[ip.String()]) {
+			boundIPs[ip.String()] = expireTime
+		}
+		return
+	}
+
+	log.Infof("bind ip %s to port %d mac %s for spoof guard", ip, ofPort, macAddr)
+	boundIPs[ip.String()] = expireTime
+	if _, ok := l.spoofGuardFlows[ofPort]; !ok {
+		// spoof guard disabled on the endpoint
+		return
+	}
+	if err := l.installSpoofGuardAllowFlows(endpoint); err != nil {
+		log.Errorf("failed to install spoof guard allow flows for endpoint %s, error: %v", endpoint.InterfaceName, err)
+	}
+}
+
+// cleanSpoofGuardBindings unbind expired ips, and reinstall spoof guard flows of affected endpoints.
+func (l *LocalBridge) cleanSpoofGuardBindings() {
+	l.spoofGuardMutex.Lock()
+	defer l.spoofGuardMutex.Unlock()
+
+	for macAddrStr, boundIPs := range l.spoofGuardBindings {
+		var expired bool
+		for ip, expireTime := range boundIPs {
+			if time.Now().After(expireTime) {
+				log.Infof("unbind expired ip %s from mac %s for spoof guard", ip, macAddrStr)
+				delete(boundIPs, ip)
+				expired = true
+			}
+		}
+		if len(boundIPs) == 0 {
+			delete(l.spoofGuardBindings, macAddrStr)
+		}
+		if !expired {
+			continue
+		}
+
+		for endpointObj := range l.datapathManager.localEndpointDB.IterBuffered() {
+			endpoint := endpointObj.Val.(*Endpoint)
+			if endpoint.BridgeName != l.name || !isSameMacAddr(endpoint.MacAddrStr, macAddrStr) {
+				continue
+			}
+			if _, ok := l.spoofGuardFlows[endpoint.PortNo]; !ok {
+				continue
+			}
+			if err := l.installSpoofGuardAllowFlows(endpoint); err != nil {
+				log.Errorf("failed to install spoof guard allow flows for endpoint %s, error: %v", endpoint.InterfaceName, err)
+			}
+		}
+	}
+}
+
+// getSpoofGuardAllowedIPs returns static and bound ips of the endpoint, must be called with spoofGuardMutex held.
+func (l *LocalBridge) getSpoofGuardAllowedIPs(endpoint *Endpoint) (ipv4Addrs, ipv6Addrs []net.IP) {
+	var allowedIPs []net.IP
+	if policy := l.datapathManager.getSpoofGuardPolicy(endpoint.MacAddrStr); policy != nil {
+		allowedIPs = append(allowedIPs, policy.StaticIPs...)
+	}
+	if macAddr, err := net.ParseMAC(endpoint.MacAddrStr); err == nil {
+		for ip := range l.spoofGuardBindings[macAddr.String()] {
+			allowedIPs = append(allowedIPs, net.ParseIP(ip))
+		}
+	}
+
+	for _, ip := range allowedIPs {
+		if ip.To4() != nil {
+			ipv4Addrs = append(ipv4Addrs, ip.To4())
+		} else if ip.To16() != nil {
+			ipv6Addrs = append(ipv6Addrs, ip)
+		}
+	}
+	return ipv4Addrs, ipv6Addrs
+}
+
+// addSpoofGuardFlows install spoof guard flows of the endpoint. The endpoint could send packets
+// from its mac address and allowed ips. Without static or dhcp bound ip of the family, the endpoint
+// is in learning mode and could send packets from any ip of the family, except in dhcp snooping
+// mode, which ipv4 address must be acked by dhcp server.
+func (l *LocalBridge) addSpoofGuardFlows(endpoint *Endpoint) error {
+	l.spoofGuardMutex.Lock()
+	defer l.spoofGuardMutex.Unlock()
+
+	endpointMac, err := net.ParseMAC(endpoint.MacAddrStr)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint mac address %s, error: %v", endpoint.MacAddrStr, err)
+	}
+	var flows []*ofctrl.Flow
+
+	// arp from the endpoint, send to controller to check arp sender
+	arpCheckFlow, _ := l.spoofGuardTable.NewFlow(ofctrl.FlowMatch{
+		Priority:  MID_MATCH_FLOW_PRIORITY,
+		InputPort: endpoint.PortNo,
+		MacSa:     &endpointMac,
+		Ethertype: PROTOCOL_ARP,
+	})
+	if err := arpCheckFlow.Resubmit(nil, &l.localEndpointL2LearningTable.TableId); err != nil {
+		return err
+	}
+	sendToControllerAct := arpCheckFlow.NewControllerAction(l.OfSwitch.ControllerID, 0)
+	_ = arpCheckFlow.SendToController(sendToControllerAct)
+	if err := arpCheckFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+		return fmt.Errorf("failed to install spoof guard arp check flow, error: %v", err)
+	}
+	flows = append(flows, arpCheckFlow)
+
+	// ip from the endpoint which doesn't match any allow flow
+	for _, ethertype := range []uint16{PROTOCOL_IP, PROTOCOL_IPV6} {
+		ipDropFlow, _ := l.spoofGuardTable.NewFlow(ofctrl.FlowMatch{
+			Priority:  MID_MATCH_FLOW_PRIORITY,
+			InputPort: endpoint.PortNo,
+			MacSa:     &endpointMac,
+			Ethertype: ethertype,
+		})
+		if err := ipDropFlow.Next(l.OfSwitch.DropAction()); err != nil {
+			return fmt.Errorf("failed to install spoof guard ip drop flow, error: %v", err)
+		}
+		flows = append(flows, ipDropFlow)
+	}
+
+	// other protocol type from the endpoint mac
+	otherAllowFlow, err := l.newSpoofGuardAllowFlow(ofctrl.FlowMatch{
+		Priority:  NORMAL_MATCH_FLOW_PRIORITY,
+		InputPort: endpoint.PortNo,
+		MacSa:     &endpointMac,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to install spoof guard other protocol allow flow, error: %v", err)
+	}
+	flows = append(flows, otherAllowFlow)
+
+	// from the endpoint with spoofed mac address
+	macDropFlow, _ := l.spoofGuardTable.NewFlow(ofctrl.FlowMatch{
+		Priority:  DEFAULT_FLOW_MISS_PRIORITY,
+		InputPort: endpoint.PortNo,
+	})
+	if err := macDropFlow.Next(l.OfSwitch.DropAction()); err != nil {
+		return fmt.Errorf("failed to install spoof guard mac drop flow, error: %v", err)
+	}
+	flows = append(flows, macDropFlow)
+
+	log.Infof("add spoof guard flows for endpoint %s: %v", endpoint.InterfaceName, flows)
+	l.spoofGuardFlows[endpoint.PortNo] = flows
+
+	return l.installSpoofGuardAllowFlows(endpoint)
+}
+
+// installSpoofGuardAllowFlows (re)install allow flows of the endpoint from its allowed ips, must
+// be called with spoofGuardMutex held. New flows are added before the old ones removed, flows are
+// deleted by cookie, so an old flow replaced by the new one with the same match is not affected.
+func (l *LocalBridge) installSpoofGuardAllowFlows(endpoint *Endpoint) error {
+	oldFlows := l.spoofGuardAllowFlows[endpoint.PortNo]
+
+	endpointMac, err := net.ParseMAC(endpoint.MacAddrStr)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint mac address %s, error: %v", endpoint.MacAddrStr, err)
+	}
+	ipv4Addrs, ipv6Addrs := l.getSpoofGuardAllowedIPs(endpoint)
+	var matches []ofctrl.FlowMatch
+
+	if len(ipv4Addrs) == 0 && !l.datapathManager.datapathConfig.EnableDHCPSnooping {
+		// ipv4 learning mode
+		for _, ethertype := range []uint16{PROTOCOL_ARP, PROTOCOL_IP} {
+			matches = append(matches, ofctrl.FlowMatch{
+				Ethertype: ethertype,
+			})
+		}
+	}
+	for i := range ipv4Addrs {
+		matches = append(matches, ofctrl.FlowMatch{
+			Ethertype: PROTOCOL_IP,
+			IpSa:      &ipv4Addrs[i],
+		})
+	}
+	// dhcp discover and request before the endpoint has an ip address
+	unspecifiedIPv4 := net.IPv4zero.To4()
+	matches = append(matches, ofctrl.FlowMatch{
+		Ethertype:  PROTOCOL_IP,
+		IpSa:       &unspecifiedIPv4,
+		IpProto:    PROTOCOL_UDP,
+		UdpSrcPort: DHCP_CLIENT_PORT,
+		UdpDstPort: DHCP_SERVER_PORT,
+	})
+
+	if len(ipv6Addrs) == 0 {
+		// ipv6 learning mode
+		matches = append(matches, ofctrl.FlowMatch{
+			Ethertype: PROTOCOL_IPV6,
+		})
+	}
+	for i := range ipv6Addrs {
+		matches = append(matches, ofctrl.FlowMatch{
+			Ethertype: PROTOCOL_IPV6,
+			Ipv6Sa:    &ipv6Addrs[i],
+		})
+	}
+	// link local address and duplicate address detection
+	linkLocalAddr, linkLocalMask := net.ParseIP("fe80::"), net.ParseIP("ffc0::")
+	unspecifiedIPv6 := net.IPv6unspecified
+	matches = append(matches, ofctrl.FlowMatch{
+		Ethertype:  PROTOCOL_IPV6,
+		Ipv6Sa:     &linkLocalAddr,
+		Ipv6SaMask: &linkLocalMask,
+	}, ofctrl.FlowMatch{
+		Ethertype: PROTOCOL_IPV6,
+		Ipv6Sa:    &unspecifiedIPv6,
+	})
+
+	var flows []*ofctrl.Flow
+	for _, match := range matches {
+		match.Priority = HIGH_MATCH_FLOW_PRIORITY
+		match.InputPort = endpoint.PortNo
+		match.MacSa = &endpointMac
+		flow, err := l.newSpoofGuardAllowFlow(match)
+		if err != nil {
+			// keep track of all the flows, they would be removed on next install
+			l.spoofGuardAllowFlows[endpoint.PortNo] = append(oldFlows, flows...)
+			return fmt.Errorf("failed to install spoof guard allow flow, error: %v", err)
+		}
+		flows = append(flows, flow)
+	}
+
+	l.spoofGuardAllowFlows[endpoint.PortNo] = oldFlows
+	if err := l.removeSpoofGuardAllowFlows(endpoint.PortNo); err != nil {
+		return err
+	}
+	l.spoofGuardAllowFlows[endpoint.PortNo] = flows
+
+	return nil
+}
+
+func (l *LocalBridge) newSpoofGuardAllowFlow(match ofctrl.FlowMatch) (*ofctrl.Flow, error) {
+	allowFlow, _ := l.spoofGuardTable.NewFlow(match)
+	if err := allowFlow.Resubmit(nil, &l.localEndpointL2LearningTable.TableId); err != nil {
+		return nil, err
+	}
+	if err := allowFlow.Resubmit(nil, &l.fromLocalRedirectTable.TableId); err != nil {
+		return nil, err
+	}
+	if err := allowFlow.Next(ofctrl.NewEmptyElem()); err != nil {
+		return nil, err
+	}
+	return allowFlow, nil
+}
+
+func (l *LocalBridge) removeSpoofGuardFlows(ofPort uint32) error {
+	l.spoofGuardMutex.Lock()
+	defer l.spoofGuardMutex.Unlock()
+
+	if err := l.removeSpoofGuardAllowFlows(ofPort); err != nil {
+		return err
+	}
+	for _, flow := range l.spoofGuardFlows[ofPort] {
+		if err := flow.Delete(); err != nil {
+			return err
+		}
+	}
+	delete(l.spoofGuardFlows, ofPort)
+	delete(l.spoofGuardArpDrops, ofPort)
+
+	return nil
+}
+
+func (l *LocalBridge) removeSpoofGuardAllowFlows(ofPort uint32) error {
+	for _, flow := range l.spoofGuardAllowFlows[ofPort] {
+		if err := flow.Delete(); err != nil {
+			return err
+		}
+	}
+	delete(l.spoofGuardAllowFlows, ofPort)
+
+	return nil
+}
+
+// checkSpoofGuardArp checks arp sent to controller by spoof guard table, the valid arp would be
+// sent out to policy bridge.
+func (l *LocalBridge) checkSpoofGuardArp(pkt *ofctrl.PacketIn, inPort uint32) bool {
+	arpIn, ok := pkt.Data.Data.(*protocol.ARP)
+	if !ok {
+		return false
+	}
+	endpoint, ok := l.getLocalEndpointByOfPort(inPort)
+	if !ok {
+		return false
+	}
+	endpointMac, _ := net.ParseMAC(endpoint.MacAddrStr)
+
+	l.spoofGuardMutex.Lock()
+	defer l.spoofGuardMutex.Unlock()
+
+	allowed := bytes.Equal(arpIn.HWSrc, endpointMac)
+	if allowed && !arpIn.IPSrc.IsUnspecified() {
+		ipv4Addrs, _ := l.getSpoofGuardAllowedIPs(endpoint)
+		allowed = len(ipv4Addrs) == 0 && !l.datapathManager.datapathConfig.EnableDHCPSnooping
+		for _, ip := range ipv4Addrs {
+			allowed = allowed || ip.Equal(arpIn.IPSrc)
+		}
+	}
+	if !allowed {
+		log.Debugf("drop spoofed arp on port %d, sender %s %s", inPort, arpIn.HWSrc, arpIn.IPSrc)
+		l.spoofGuardArpDrops[inPort]++
+		return false
+	}
+
+	pktOut := openflow13.NewPacketOut()
+	pktOut.InPort = inPort
+	pktOut.Data = &pkt.Data
+	pktOut.AddAction(openflow13.NewActionOutput(LOCAL_TO_POLICY_PORT))
+	l.OfSwitch.Send(pktOut)
+
+	return true
+}
+
+// getSpoofGuardDropStats returns dropped packets of each port, include packets dropped by spoof
+// guard flows and arp dropped by controller.
+func (l *LocalBridge) getSpoofGuardDropStats() (map[uint32]uint64, error) {
+	l.spoofGuardMutex.Lock()
+	dropStats := make(map[uint32]uint64)
+	dropFlowPorts := make(map[uint64]uint32)
+	var dropFlowIDs []uint64
+	for inPort, flows := range l.spoofGuardFlows {
+		for _, flow := range flows {
+			if flow.NextElem == l.OfSwitch.DropAction() {
+				dropFlowPorts[flow.FlowID] = inPort
+				dropFlowIDs = append(dropFlowIDs, flow.FlowID)
+			}
+		}
+	}
+	for inPort, packets := range l.spoofGuardArpDrops {
+		dropStats[inPort] += packets
+	}
+	l.spoofGuardMutex.Unlock()
+
+	flowPackets, err := l.multipartReplies.getFlowPackets(l.OfSwitch, FROM_LOCAL_SPOOF_GUARD_TABLE, dropFlowIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spoof guard drop flows stats: %v", err)
+	}
+	for flowID, packets := range flowPackets {
+		dropStats[dropFlowPorts[flowID]] += packets
+	}
+
+	return dropStats, nil
+}
+
+func (l *LocalBridge) getLocalEndpointByOfPort(ofPort uint32) (*Endpoint, bool) {
+	for endpointObj := range l.datapathManager.localEndpointDB.IterBuffered() {
+		endpoint := endpointObj.Val.(*Endpoint)
+		if endpoint.BridgeName == l.name && endpoint.PortNo == ofPort {
+			return endpoint, true
+		}
+	}
+
+	return nil, false
+}
+
+func isSameMacAddr(macAddrStr1, macAddrStr2 string) bool {
+	macAddr1, err1 := net.ParseMAC(macAddrStr1)
+	macAddr2, err2 := net.ParseMAC(macAddrStr2)
+	return err1 == nil && err2 == nil && bytes.Equal(macAddr1, macAddr2)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TeardownStep is a command in the plan of datapath teardown.
+type TeardownStep struct {
+	Description string
+	Command     []string
+}
+
+func (s TeardownStep) String() string {
+	return fmt.Sprintf("%s: %s", s.Description, strings.Join(s.Command, " "))
+}
+
+type ovsInterface struct {
+	Name string
+	Type string
+}
+
+type ovsPort struct {
+	Name       string
+	Interfaces []ovsInterface
+	BondMode   string
+	LACP       string
+}
+
+func (p *ovsPort) isPatch() bool {
+	for _, iface := range p.Interfaces {
+		if iface.Type == "patch" {
+			return true
+		}
+	}
+	return false
+}
+
+// Teardown disconnects the datapath from all bridges, then restores each managed vds to a plain
+// learning bridge. DpManager could not be used after teardown.
+func (datapathManager *DpManager) Teardown(dryRun bool) ([]TeardownStep, error) {
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+
+	if !dryRun {
+		for vdsID := range datapathManager.ControllerMap {
+			for _, controller := range datapathManager.ControllerMap[vdsID] {
+				controller.Delete()
+			}
+		}
+	}
+
+	return TeardownDatapath(datapathManager.datapathConfig, dryRun)
+}
+
+// TeardownDatapath restores each managed vds in the config to a plain learning bridge: uplink ports
+// are moved back to the vds bridge, everoute ports, flows, bridges and ovsdb state are removed.
+// It returns the plan, and only returns the plan without executing when dryRun. Agent must not run
+// on the bridges, otherwise the flows would be installed again.
+func TeardownDatapath(config *Config, dryRun bool) ([]TeardownStep, error) {
+	var vdsIDs []string
+	for vdsID := range config.ManagedVDSMap {
+		vdsIDs = append(vdsIDs, vdsID)
+	}
+	sort.Strings(vdsIDs)
+
+	var plan []TeardownStep
+	for _, vdsID := range vdsIDs {
+		steps, err := teardownVDS(vdsID, config.ManagedVDSMap[vdsID], dryRun)
+		plan = append(plan, steps...)
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	return plan, nil
+}
+
+// teardownVDS restores the vds to a plain learning bridge, and returns the plan.
+func teardownVDS(vdsID, ovsbrname string, dryRun bool) ([]TeardownStep, error) {
+	if !bridgeExists(ovsbrname) {
+		log.Infof("Bridge %s of vds %s not found, skip teardown", ovsbrname, vdsID)
+		return nil, nil
+	}
+	localPorts, err := listBridgePorts(ovsbrname)
+	if err != nil {
+		return nil, err
+	}
+	uplinkPorts, err := listBridgePorts(fmt.Sprintf("%s-%s", ovsbrname, UPLINK_BRIDGE_KEYWORD))
+	if err != nil {
+		return nil, err
+	}
+
+	steps := teardownVDSSteps(ovsbrname, localPorts, uplinkPorts)
+	if dryRun {
+		return steps, nil
+	}
+
+	log.Infof("Teardown vds %s bridge %s", vdsID, ovsbrname)
+	for _, step := range steps {
+		if out, err := exec.Command(step.Command[0], step.Command[1:]...).CombinedOutput(); err != nil {
+			return steps, fmt.Errorf("failed to %s: %s, error: %v", step.Description, string(out), err)
+		}
+	}
+	return steps, nil
+}
+
+func teardownVDSSteps(ovsbrname string, localPorts, uplinkPorts []ovsPort) []TeardownStep {
+	uplinkBridgeName := fmt.Sprintf("%s-%s", ovsbrname, UPLINK_BRIDGE_KEYWORD)
+	steps := []TeardownStep{
+		{Description: "disconnect bridge " + ovsbrname, Command: []string{"ovs-vsctl", "del-controller", ovsbrname}},
+		{Description: "set bridge " + ovsbrname + " standalone", Command: []string{"ovs-vsctl", "set-fail-mode", ovsbrname, "standalone"}},
+		{Description: "remove flows of bridge " + ovsbrname, Command: []string{"ovs-ofctl", "del-flows", ovsbrname}},
+		{Description: "add normal flow to bridge " + ovsbrname, Command: []string{"ovs-ofctl", "add-flow", ovsbrname, "priority=0,actions=normal"}},
+	}
+
+	for _, port := range localPorts {
+		if port.isPatch() || port.Name == ovsbrname+"-gw-local" {
+			steps = append(steps, TeardownStep{
+				Description: "remove port " + port.Name,
+				Command:     []string{"ovs-vsctl", "del-port", ovsbrname, port.Name},
+			})
+		}
+	}
+
+	// move uplink ports in one transaction, gateway, tunnel and patch ports would be removed with the bridge
+	for _, port := range uplinkPorts {
+		if port.isPatch() || port.Name == ovsbrname+"-gw" || port.Name == tunnelPortName(ovsbrname) {
+			continue
+		}
+		command := []string{"ovs-vsctl", "--", "del-port", uplinkBridgeName, port.Name}
+		if len(port.Interfaces) > 1 {
+			command = append(command, "--", "add-bond", ovsbrname, port.Name)
+			for _, iface := range port.Interfaces {
+				command = append(command, iface.Name)
+			}
+			if port.BondMode != "" {
+				command = append(command, "--", "set", "Port", port.Name, "bond_mode="+port.BondMode)
+			}
+			if port.LACP != "" {
+				command = append(command, "--", "set", "Port", port.Name, "lacp="+port.LACP)
+			}
+		} else {
+			command = append(command, "--", "add-port", ovsbrname, port.Name)
+		}
+		steps = append(steps, TeardownStep{Description: "move uplink port " + port.Name + " to bridge " + ovsbrname, Command: command})
+	}
+
+	for _, bridgeName := range vdsBridgeNames(ovsbrname)[1:] {
+		steps = append(steps, TeardownStep{
+			Description: "remove bridge " + bridgeName,
+			Command:     []string{"ovs-vsctl", "--if-exists", "del-br", bridgeName},
+		})
+	}
+
+	steps = append(steps, TeardownStep{
+		Description: "remove round number of bridge " + ovsbrname,
+		Command:     []string{"ovs-vsctl", "remove", "Bridge", ovsbrname, "external_ids", datapathRestartRound},
+	})
+
+	return steps
+}
+
+// listBridgePorts returns ports of the bridge, or nil if the bridge not exists.
+func listBridgePorts(bridgeName string) ([]ovsPort, error) {
+	if !bridgeExists(bridgeName) {
+		return nil, nil
+	}
+
+	portNames, err := ovsVsctl("list-ports", bridgeName)
+	if err != nil {
+		return nil, err
+	}
+	portRecords, err := ovsVsctlList("Port", "name", "interfaces", "bond_mode", "lacp")
+	if err != nil {
+		return nil, err
+	}
+	ifaceRecords, err := ovsVsctlList("Interface", "_uuid", "name", "type")
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := make(map[string]ovsInterface, len(ifaceRecords))
+	for _, record := range ifaceRecords {
+		ifaces[record[0]] = ovsInterface{Name: record[1], Type: record[2]}
+	}
+	ports := make(map[string]ovsPort, len(portRecords))
+	for _, record := range portRecords {
+		port := ovsPort{Name: record[0], BondMode: record[2], LACP: record[3]}
+		for _, ifaceUUID := range strings.Fields(record[1]) {
+			port.Interfaces = append(port.Interfaces, ifaces[ifaceUUID])
+		}
+		ports[port.Name] = port
+	}
+
+	var bridgePorts []ovsPort
+	for _, portName := range strings.Fields(portNames) {
+		if port, ok := ports[portName]; ok {
+			bridgePorts = append(bridgePorts, port)
+		}
+	}
+	return bridgePorts, nil
+}
+
+func bridgeExists(bridgeName string) bool {
+	return exec.Command("ovs-vsctl", "br-exists", bridgeName).Run() == nil
+}
+
+func ovsVsctl(args ...string) (string, error) {
+	out, err := exec.Command("ovs-vsctl", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to run ovs-vsctl %s: %s, error: %v", strings.Join(args, " "), string(out), err)
+	}
+	return string(out), nil
+}
+
+// ovsVsctlList returns columns of all records in the table.
+func ovsVsctlList(table string, columns ...string) ([][]string, error) {
+	return ovsVsctlListRecords(table, nil, columns...)
+}
+
+// ovsVsctlListRecords lists columns of the records in the table, or all records if none given. Records
+// not found are ignored.
+func ovsVsctlListRecords(table string, records []string, columns ...string) ([][]string, error) {
+	args := []string{"--format=csv", "--data=bare", "--no-headings", "--columns=" + strings.Join(columns, ",")}
+	if len(records) != 0 {
+		args = append(args, "--if-exists")
+	}
+	out, err := ovsVsctl(append(append(args, "list", table), records...)...)
+	if err != nil {
+		return nil, err
+	}
+	return csv.NewReader(strings.NewReader(out)).ReadAll()
+}
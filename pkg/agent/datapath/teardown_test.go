@@ -0,0 +1,59 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestTeardownVDSSteps(t *testing.T) {
+	RegisterTestingT(t)
+
+	localPorts := []ovsPort{
+		{Name: "vnet0", Interfaces: []ovsInterface{{Name: "vnet0"}}},
+		{Name: "ovsbr1-gw-local", Interfaces: []ovsInterface{{Name: "ovsbr1-gw-local", Type: "internal"}}},
+		{Name: "local-to-policy", Interfaces: []ovsInterface{{Name: "local-to-policy", Type: "patch"}}},
+	}
+	uplinkPorts := []ovsPort{
+		{Name: "uplink-to-cls", Interfaces: []ovsInterface{{Name: "uplink-to-cls", Type: "patch"}}},
+		{Name: "ovsbr1-gw", Interfaces: []ovsInterface{{Name: "ovsbr1-gw", Type: "internal"}}},
+		{Name: "ovsbr1-tunnel", Interfaces: []ovsInterface{{Name: "ovsbr1-tunnel", Type: "geneve"}}},
+		{Name: "bond0", Interfaces: []ovsInterface{{Name: "eth0"}, {Name: "eth1"}}, BondMode: "balance-tcp", LACP: "active"},
+	}
+
+	var plan []string
+	for _, step := range teardownVDSSteps("ovsbr1", localPorts, uplinkPorts) {
+		plan = append(plan, strings.Join(step.Command, " "))
+	}
+
+	Expect(plan).Should(Equal([]string{
+		"ovs-vsctl del-controller ovsbr1",
+		"ovs-vsctl set-fail-mode ovsbr1 standalone",
+		"ovs-ofctl del-flows ovsbr1",
+		"ovs-ofctl add-flow ovsbr1 priority=0,actions=normal",
+		"ovs-vsctl del-port ovsbr1 ovsbr1-gw-local",
+		"ovs-vsctl del-port ovsbr1 local-to-policy",
+		"ovs-vsctl -- del-port ovsbr1-uplink bond0 -- add-bond ovsbr1 bond0 eth0 eth1 -- set Port bond0 bond_mode=balance-tcp -- set Port bond0 lacp=active",
+		"ovs-vsctl --if-exists del-br ovsbr1-policy",
+		"ovs-vsctl --if-exists del-br ovsbr1-cls",
+		"ovs-vsctl --if-exists del-br ovsbr1-uplink",
+		"ovs-vsctl remove Bridge ovsbr1 external_ids datapathRestartRound",
+	}))
+}
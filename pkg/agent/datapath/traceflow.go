@@ -0,0 +1,670 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/libOpenflow/protocol"
+	"github.com/contiv/ofnet/ofctrl"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+)
+
+//nolint
+const (
+	TRACEFLOW_OBSERVATION_PRIORITY = HIGH_MATCH_FLOW_PRIORITY + FLOW_MATCH_OFFSET
+	TRACEFLOW_MAX_TAG              = 63 // dscp is 6-bits long
+)
+
+const (
+	// pkt_mark[8..13] is the tag of traceflow, it's set only on the packet injected by traceflow,
+	// or the traceflow packet from other nodes arrived policy bridge.
+	traceflowMarkShift        = 8
+	TraceflowMarkMask  uint32 = 0x3f00
+)
+
+// TraceflowMark returns the pkt_mark of packet traced with the tag.
+func TraceflowMark(tag uint8) uint32 {
+	return uint32(tag) << traceflowMarkShift
+}
+
+var policyTableNames = map[uint8]string{
+	INPUT_TABLE:               "Input",
+	CT_STATE_TABLE:            "CtState",
+	DIRECTION_SELECTION_TABLE: "DirectionSelection",
+	EGRESS_TIER0_TABLE:        "EgressTier0",
+	EGRESS_TIER1_TABLE:        "EgressTier1",
+	EGRESS_TIER2_TABLE:        "EgressTier2",
+	INGRESS_TIER0_TABLE:       "IngressTier0",
+	INGRESS_TIER1_TABLE:       "IngressTier1",
+	INGRESS_TIER2_TABLE:       "IngressTier2",
+	CT_COMMIT_TABLE:           "CtCommit",
+	SFC_POLICY_TABLE:          "SfcPolicy",
+	POLICY_FORWARDING_TABLE:   "PolicyForwarding",
+}
+
+// TraceflowPacket is the synthetic packet injected by traceflow, only ipv4 is supported.
+type TraceflowPacket struct {
+	SrcMac     net.HardwareAddr
+	DstMac     net.HardwareAddr
+	SrcIP      net.IP
+	DstIP      net.IP
+	IPProtocol uint8
+	SrcPort    uint16
+	DstPort    uint16
+}
+
+// TraceflowObservation is a table hit by the traceflow packet.
+type TraceflowObservation struct {
+	Bridge    string
+	TableID   uint8
+	TableName string
+	Direction uint8
+	Action    agentv1alpha1.TraceflowAction
+	RuleID    string
+}
+
+type traceflowSession struct {
+	flows        []*ofctrl.Flow
+	ruleFlows    map[string][]*ofctrl.Flow // map rule id to observation flows of the rule
+	observations []TraceflowObservation
+}
+
+type traceflowPointKey struct {
+	bridge string
+	cookie uint64
+}
+
+type traceflowPoint struct {
+	tag         uint8
+	observation TraceflowObservation
+}
+
+// StartTraceflow installs observation flows for the packet traced with the tag. The packet would
+// be sent to controller when it arrives policy bridge, hits a policy rule, leaves policy bridge,
+// leaves uplink bridge or delivered to local endpoint.
+func (datapathManager *DpManager) StartTraceflow(tag uint8, packet *TraceflowPacket) error {
+	if tag == 0 || tag > TRACEFLOW_MAX_TAG {
+		return fmt.Errorf("invalid traceflow tag %d", tag)
+	}
+	if packet.SrcIP.To4() == nil || packet.DstIP.To4() == nil {
+		return fmt.Errorf("only ipv4 traceflow packet is supported, src %s dst %s", packet.SrcIP, packet.DstIP)
+	}
+
+	datapathManager.flowReplayMutex.RLock()
+	defer datapathManager.flowReplayMutex.RUnlock()
+	datapathManager.traceflowMutex.Lock()
+	defer datapathManager.traceflowMutex.Unlock()
+
+	if _, ok := datapathManager.traceflowSessions[tag]; ok {
+		return nil
+	}
+	session := &traceflowSession{ruleFlows: make(map[string][]*ofctrl.Flow)}
+	datapathManager.traceflowSessions[tag] = session
+
+	for vdsID := range datapathManager.BridgeChainMap {
+		if err := datapathManager.addTraceflowFlows(vdsID, tag, packet, session); err != nil {
+			datapathManager.removeTraceflowSession(tag)
+			return fmt.Errorf("failed to add traceflow flows to vds %s: %v", vdsID, err)
+		}
+	}
+
+	return nil
+}
+
+// StopTraceflow removes observation flows of the tag, and returns the observations collected.
+func (datapathManager *DpManager) StopTraceflow(tag uint8) []TraceflowObservation {
+	datapathManager.traceflowMutex.Lock()
+	defer datapathManager.traceflowMutex.Unlock()
+
+	return datapathManager.removeTraceflowSession(tag)
+}
+
+// InjectTraceflowPacket marks the packet with the tag, and sends it into local bridge as if
+// it was sent by the local endpoint which owns the source mac. The tag is set in both dscp
+// and pkt_mark of the packet, pkt_mark identifies the injected packet on this node, dscp is
+// carried to other nodes. It returns where the packet injected into.
+func (datapathManager *DpManager) InjectTraceflowPacket(tag uint8, packet *TraceflowPacket) (*TraceflowObservation, error) {
+	pkt, err := buildTraceflowPacket(tag, packet)
+	if err != nil {
+		return nil, err
+	}
+
+	datapathManager.flowReplayMutex.RLock()
+	defer datapathManager.flowReplayMutex.RUnlock()
+
+	endpoint, vdsID := datapathManager.getLocalEndpointByMac(packet.SrcMac)
+	if endpoint == nil {
+		return nil, fmt.Errorf("local endpoint with mac %s not found", packet.SrcMac)
+	}
+	localBridge := datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+	if localBridge.OfSwitch == nil {
+		return nil, fmt.Errorf("bridge %s disconnected", localBridge.name)
+	}
+
+	pktMarkField, err := openflow13.FindFieldHeaderByName("NXM_NX_PKT_MARK", false)
+	if err != nil {
+		return nil, err
+	}
+	pktOut := openflow13.NewPacketOut()
+	pktOut.InPort = endpoint.PortNo
+	pktOut.Data = pkt
+	pktOut.AddAction(openflow13.NewNXActionRegLoad(openflow13.NewNXRangeByOfsNBits(traceflowMarkShift, 6).ToOfsBits(),
+		pktMarkField, uint64(tag)))
+	pktOut.AddAction(openflow13.NewActionOutput(openflow13.P_TABLE))
+	localBridge.OfSwitch.Send(pktOut)
+
+	return &TraceflowObservation{
+		Bridge:    localBridge.name,
+		TableID:   VLAN_INPUT_TABLE,
+		TableName: "VlanInput",
+		Direction: POLICY_DIRECTION_OUT,
+		Action:    agentv1alpha1.TraceflowActionInjected,
+	}, nil
+}
+
+// TracePolicyRules evaluates the packet against the rules in the tier tables of the direction,
+// it's used to simulate policies without datapath.
+func TracePolicyRules(bridge string, rules map[string]*EveroutePolicyRuleEntry, direction uint8,
+	packet *TraceflowPacket) []TraceflowObservation {
+	var tiers = []uint8{POLICY_TIER0, POLICY_TIER1, POLICY_TIER2}
+	var tableIDs = []uint8{EGRESS_TIER0_TABLE, EGRESS_TIER1_TABLE, EGRESS_TIER2_TABLE}
+	if direction == POLICY_DIRECTION_IN {
+		tableIDs = []uint8{INGRESS_TIER0_TABLE, INGRESS_TIER1_TABLE, INGRESS_TIER2_TABLE}
+	}
+
+	var observations []TraceflowObservation
+	for index, tier := range tiers {
+		observation := TraceflowObservation{
+			Bridge:    bridge,
+			TableID:   tableIDs[index],
+			TableName: policyTableNames[tableIDs[index]],
+			Direction: direction,
+		}
+
+		rule := matchPolicyRule(rules, direction, tier, packet)
+		if rule == nil {
+			observations = append(observations, observation)
+			continue
+		}
+
+		observation.RuleID = rule.RuleID
+		if rule.Action == "deny" {
+			observation.Action = agentv1alpha1.TraceflowActionDropped
+			return append(observations, observation)
+		}
+		observation.Action = agentv1alpha1.TraceflowActionForwarded
+		observations = append(observations, observation)
+
+		// allowed in tier0 continue to tier1, allowed in other tiers goto ct commit table
+		if tier != POLICY_TIER0 {
+			break
+		}
+	}
+
+	return observations
+}
+
+// matchPolicyRule returns the highest priority rule matches the packet in the tier, rules with
+// the same priority are sorted by rule id to make the result stable.
+func matchPolicyRule(rules map[string]*EveroutePolicyRuleEntry, direction, tier uint8,
+	packet *TraceflowPacket) *EveroutePolicyRule {
+	var matchedRules []*EveroutePolicyRule
+	for _, ruleEntry := range rules {
+		if ruleEntry.Direction != direction || ruleEntry.Tier != tier {
+			continue
+		}
+		if isPolicyRuleMatch(ruleEntry.EveroutePolicyRule, packet) {
+			matchedRules = append(matchedRules, ruleEntry.EveroutePolicyRule)
+		}
+	}
+	if len(matchedRules) == 0 {
+		return nil
+	}
+
+	sort.Slice(matchedRules, func(i, j int) bool {
+		if matchedRules[i].Priority != matchedRules[j].Priority {
+			return matchedRules[i].Priority > matchedRules[j].Priority
+		}
+		return matchedRules[i].RuleID < matchedRules[j].RuleID
+	})
+	return matchedRules[0]
+}
+
+func isPolicyRuleMatch(rule *EveroutePolicyRule, packet *TraceflowPacket) bool {
+	if !isIPAddrMatch(rule.SrcIPAddr, packet.SrcIP) || !isIPAddrMatch(rule.DstIPAddr, packet.DstIP) {
+		return false
+	}
+	if rule.IPProtocol == 0 {
+		return true
+	}
+	if rule.IPProtocol != packet.IPProtocol {
+		return false
+	}
+	if rule.IPProtocol != protocol.Type_TCP && rule.IPProtocol != protocol.Type_UDP {
+		return true
+	}
+	return isPortMatch(rule.SrcPort, rule.SrcPortMask, packet.SrcPort) &&
+		isPortMatch(rule.DstPort, rule.DstPortMask, packet.DstPort)
+}
+
+func isIPAddrMatch(ipAddr string, ip net.IP) bool {
+	if ipAddr == "" {
+		return true
+	}
+	ruleIP, ruleIPMask, err := ParseIPAddrMaskString(ipAddr)
+	if err != nil || ip.To4() == nil {
+		return false
+	}
+	mask := net.IPMask(ruleIPMask.To4())
+	return ruleIP.To4().Mask(mask).Equal(ip.To4().Mask(mask))
+}
+
+func isPortMatch(port, portMask, packetPort uint16) bool {
+	if port == 0 {
+		return true
+	}
+	if portMask == 0 {
+		portMask = 0xffff
+	}
+	return port&portMask == packetPort&portMask
+}
+
+// processTraceflowPacketIn records the observation if the packet sent by traceflow flows,
+// returns false if the packet not belongs to any traceflow.
+func (datapathManager *DpManager) processTraceflowPacketIn(bridge string, pkt *ofctrl.PacketIn) bool {
+	datapathManager.traceflowMutex.Lock()
+	defer datapathManager.traceflowMutex.Unlock()
+
+	point, ok := datapathManager.traceflowPoints[traceflowPointKey{bridge: bridge, cookie: pkt.Cookie}]
+	if !ok {
+		return false
+	}
+	session, ok := datapathManager.traceflowSessions[point.tag]
+	if !ok {
+		return true
+	}
+
+	log.Debugf("traceflow %d observed on bridge %s table %d", point.tag, bridge, point.observation.TableID)
+	for _, observation := range session.observations {
+		if observation == point.observation {
+			return true
+		}
+	}
+	session.observations = append(session.observations, point.observation)
+	return true
+}
+
+// getLocalEndpointByMac returns the local endpoint and the vds it attached to.
+func (datapathManager *DpManager) getLocalEndpointByMac(mac net.HardwareAddr) (*Endpoint, string) {
+	for endpointObj := range datapathManager.localEndpointDB.IterBuffered() {
+		endpoint := endpointObj.Val.(*Endpoint)
+		if !isSameMacAddr(endpoint.MacAddrStr, mac.String()) {
+			continue
+		}
+		for vdsID, ovsbrname := range datapathManager.managedVDSMap() {
+			if ovsbrname == endpoint.BridgeName {
+				return endpoint, vdsID
+			}
+		}
+	}
+	return nil, ""
+}
+
+func (datapathManager *DpManager) removeTraceflowSession(tag uint8) []TraceflowObservation {
+	session, ok := datapathManager.traceflowSessions[tag]
+	if !ok {
+		return nil
+	}
+
+	for _, flow := range session.flows {
+		if err := flow.Delete(); err != nil {
+			log.Errorf("failed to delete traceflow %d flow %d: %v", tag, flow.FlowID, err)
+		}
+	}
+	for ruleID := range session.ruleFlows {
+		datapathManager.removeTraceflowRuleFlows(session, tag, ruleID)
+	}
+	for key, point := range datapathManager.traceflowPoints {
+		if point.tag == tag {
+			delete(datapathManager.traceflowPoints, key)
+		}
+	}
+	delete(datapathManager.traceflowSessions, tag)
+
+	return session.observations
+}
+
+func (datapathManager *DpManager) addTraceflowFlows(vdsID string, tag uint8, packet *TraceflowPacket, session *traceflowSession) error {
+	localBridge := datapathManager.BridgeChainMap[vdsID][LOCAL_BRIDGE_KEYWORD].(*LocalBridge)
+	policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+	uplinkBridge := datapathManager.BridgeChainMap[vdsID][UPLINK_BRIDGE_KEYWORD].(*UplinkBridge)
+
+	// packet injected arrives policy bridge, continue to egress policy tables
+	egressArrivedFlow, _ := policyBridge.directionSelectionTable.NewFlow(traceflowMarkMatch(POLICY_TO_LOCAL_PORT, tag))
+	err := datapathManager.installTraceflowFlow(session, tag, egressArrivedFlow, policyBridge.egressTier0PolicyTable,
+		TraceflowObservation{
+			Bridge:    policyBridge.name,
+			TableID:   DIRECTION_SELECTION_TABLE,
+			TableName: policyTableNames[DIRECTION_SELECTION_TABLE],
+			Direction: POLICY_DIRECTION_OUT,
+		})
+	if err != nil {
+		return err
+	}
+
+	// packet from other nodes has lost its pkt_mark, it's identified by the tag in dscp and its
+	// addresses, mark it again and continue to ingress policy tables
+	ingressArrivedFlow, _ := policyBridge.directionSelectionTable.NewFlow(traceflowPacketMatch(POLICY_TO_CLS_PORT, tag, packet))
+	if err := ingressArrivedFlow.LoadField("nxm_nx_pkt_mark", uint64(tag),
+		openflow13.NewNXRangeByOfsNBits(traceflowMarkShift, 6)); err != nil {
+		return err
+	}
+	err = datapathManager.installTraceflowFlow(session, tag, ingressArrivedFlow, policyBridge.ingressTier0PolicyTable,
+		TraceflowObservation{
+			Bridge:    policyBridge.name,
+			TableID:   DIRECTION_SELECTION_TABLE,
+			TableName: policyTableNames[DIRECTION_SELECTION_TABLE],
+			Direction: POLICY_DIRECTION_IN,
+		})
+	if err != nil {
+		return err
+	}
+
+	// packet hits policy rules, the verdict is reported by the rule flow the packet actually hits,
+	// observation flows of rules added or removed during the trace are synced by syncTraceflowRule
+	for _, ruleEntry := range datapathManager.Rules {
+		if ruleEntry.RuleFlowMap[vdsID] == nil {
+			continue
+		}
+		if err := datapathManager.addTraceflowRuleFlow(session, tag, policyBridge, ruleEntry); err != nil {
+			return err
+		}
+	}
+
+	// packet allowed by policy tables, skip conntrack commit for the synthetic packet
+	for inPort, direction := range map[uint32]uint8{POLICY_TO_LOCAL_PORT: POLICY_DIRECTION_OUT, POLICY_TO_CLS_PORT: POLICY_DIRECTION_IN} {
+		commitFlow, _ := policyBridge.ctCommitTable.NewFlow(traceflowMarkMatch(inPort, tag))
+		err := datapathManager.installTraceflowFlow(session, tag, commitFlow, policyBridge.sfcPolicyTable,
+			TraceflowObservation{
+				Bridge:    policyBridge.name,
+				TableID:   CT_COMMIT_TABLE,
+				TableName: policyTableNames[CT_COMMIT_TABLE],
+				Direction: direction,
+				Action:    agentv1alpha1.TraceflowActionForwarded,
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	// packet sent out from uplink bridge
+	uplinkFlow, _ := uplinkBridge.defaultTable.NewFlow(traceflowMarkMatch(UPLINK_TO_CLS_PORT, tag))
+	err = datapathManager.installTraceflowFlow(session, tag, uplinkFlow, uplinkBridge.OfSwitch.NormalLookup(),
+		TraceflowObservation{
+			Bridge:    uplinkBridge.name,
+			TableID:   uplinkBridge.defaultTable.TableId,
+			TableName: "Default",
+			Direction: POLICY_DIRECTION_OUT,
+			Action:    agentv1alpha1.TraceflowActionForwarded,
+		})
+	if err != nil {
+		return err
+	}
+
+	// packet arrives local bridge from policy bridge, the synthetic packet would not be sent to endpoint
+	deliveredFlow, _ := localBridge.vlanInputTable.NewFlow(traceflowMarkMatch(LOCAL_TO_POLICY_PORT, tag))
+	return datapathManager.installTraceflowFlow(session, tag, deliveredFlow, ofctrl.NewEmptyElem(),
+		TraceflowObservation{
+			Bridge:    localBridge.name,
+			TableID:   VLAN_INPUT_TABLE,
+			TableName: "VlanInput",
+			Direction: POLICY_DIRECTION_IN,
+			Action:    agentv1alpha1.TraceflowActionDelivered,
+		})
+}
+
+// addTraceflowRuleFlow adds observation flow of the rule, it has the same match as the rule flow
+// for the traced packet. Priorities of observation flows are raised by the same offset, so the
+// packet hits the observation flow of the rule which it would hit without traceflow.
+func (datapathManager *DpManager) addTraceflowRuleFlow(session *traceflowSession, tag uint8, policyBridge *PolicyBridge,
+	ruleEntry *EveroutePolicyRuleEntry) error {
+	rule := ruleEntry.EveroutePolicyRule
+	ruleFlow, next, err := policyBridge.newMicroSegmentRuleFlow(rule, ruleEntry.Direction, ruleEntry.Tier)
+	if err != nil {
+		return err
+	}
+	traceflowMarkMask := TraceflowMarkMask
+	ruleFlow.Match.Priority = TRACEFLOW_OBSERVATION_PRIORITY + uint16(rule.Priority)
+	ruleFlow.Match.PktMark = TraceflowMark(tag)
+	ruleFlow.Match.PktMarkMask = &traceflowMarkMask
+
+	action := agentv1alpha1.TraceflowActionForwarded
+	if rule.Action == "deny" {
+		// the packet has been sent to controller, drop it
+		action, next = agentv1alpha1.TraceflowActionDropped, ofctrl.NewEmptyElem()
+	}
+	return datapathManager.installTraceflowFlow(session, tag, ruleFlow, next, TraceflowObservation{
+		Bridge:    policyBridge.name,
+		TableID:   ruleFlow.Table.TableId,
+		TableName: policyTableNames[ruleFlow.Table.TableId],
+		Direction: ruleEntry.Direction,
+		Action:    action,
+		RuleID:    rule.RuleID,
+	})
+}
+
+// syncTraceflowRule replaces observation flows of the rule in all running traceflows with the
+// rule currently installed, so the packet observed is reported with the rule it actually hits
+// even if the rule is added, updated or removed during the trace. It must be called with the
+// flowReplayMutex held.
+func (datapathManager *DpManager) syncTraceflowRule(ruleID string) {
+	datapathManager.traceflowMutex.Lock()
+	defer datapathManager.traceflowMutex.Unlock()
+
+	for tag, session := range datapathManager.traceflowSessions {
+		datapathManager.removeTraceflowRuleFlows(session, tag, ruleID)
+
+		ruleEntry, ok := datapathManager.Rules[ruleID]
+		if !ok {
+			continue
+		}
+		for vdsID := range ruleEntry.RuleFlowMap {
+			policyBridge := datapathManager.BridgeChainMap[vdsID][POLICY_BRIDGE_KEYWORD].(*PolicyBridge)
+			if err := datapathManager.addTraceflowRuleFlow(session, tag, policyBridge, ruleEntry); err != nil {
+				log.Errorf("failed to sync traceflow %d flow of rule %s: %v", tag, ruleID, err)
+			}
+		}
+	}
+}
+
+// removeTraceflowRuleFlows removes observation flows of the rule in the traceflow session.
+func (datapathManager *DpManager) removeTraceflowRuleFlows(session *traceflowSession, tag uint8, ruleID string) {
+	for _, flow := range session.ruleFlows[ruleID] {
+		if err := flow.Delete(); err != nil {
+			log.Errorf("failed to delete traceflow %d flow %d: %v", tag, flow.FlowID, err)
+		}
+	}
+	for key, point := range datapathManager.traceflowPoints {
+		if point.tag == tag && point.observation.RuleID == ruleID {
+			delete(datapathManager.traceflowPoints, key)
+		}
+	}
+	delete(session.ruleFlows, ruleID)
+}
+
+// installTraceflowFlow installs the flow sends packet to controller and the next element, the
+// packet would be recorded as the observation.
+func (datapathManager *DpManager) installTraceflowFlow(session *traceflowSession, tag uint8, flow *ofctrl.Flow,
+	next ofctrl.FgraphElem, observation TraceflowObservation) error {
+	_ = flow.SendToController(flow.NewControllerAction(flow.Table.Switch.ControllerID, 0))
+	if err := flow.Next(next); err != nil {
+		return fmt.Errorf("failed to install traceflow flow on bridge %s table %d: %v", observation.Bridge, observation.TableID, err)
+	}
+
+	// observation flows of rule are tracked by the rule, they are replaced when the rule changes
+	if observation.RuleID != "" {
+		session.ruleFlows[observation.RuleID] = append(session.ruleFlows[observation.RuleID], flow)
+	} else {
+		session.flows = append(session.flows, flow)
+	}
+	datapathManager.traceflowPoints[traceflowPointKey{bridge: observation.Bridge, cookie: flow.FlowID}] = &traceflowPoint{
+		tag:         tag,
+		observation: observation,
+	}
+	return nil
+}
+
+// traceflowMarkMatch returns match of packet from inPort with the traceflow mark of tag.
+func traceflowMarkMatch(inPort uint32, tag uint8) ofctrl.FlowMatch {
+	traceflowMarkMask := TraceflowMarkMask
+	return ofctrl.FlowMatch{
+		Priority:    TRACEFLOW_OBSERVATION_PRIORITY,
+		InputPort:   inPort,
+		Ethertype:   PROTOCOL_IP,
+		PktMark:     TraceflowMark(tag),
+		PktMarkMask: &traceflowMarkMask,
+	}
+}
+
+// traceflowPacketMatch returns match of the packet from inPort with the tag as dscp.
+func traceflowPacketMatch(inPort uint32, tag uint8, packet *TraceflowPacket) ofctrl.FlowMatch {
+	srcIP, dstIP := packet.SrcIP.To4(), packet.DstIP.To4()
+	match := ofctrl.FlowMatch{
+		Priority:  TRACEFLOW_OBSERVATION_PRIORITY,
+		InputPort: inPort,
+		Ethertype: PROTOCOL_IP,
+		IpDscp:    tag,
+		IpSa:      &srcIP,
+		IpDa:      &dstIP,
+		IpProto:   packet.IPProtocol,
+	}
+	switch packet.IPProtocol {
+	case protocol.Type_TCP:
+		match.TcpSrcPort, match.TcpDstPort = packet.SrcPort, packet.DstPort
+	case protocol.Type_UDP:
+		match.UdpSrcPort, match.UdpDstPort = packet.SrcPort, packet.DstPort
+	}
+	return match
+}
+
+// buildTraceflowPacket build an ipv4 packet with the tag as dscp. Checksums are required,
+// or the packet would be marked as invalid by conntrack in policy bridge.
+func buildTraceflowPacket(tag uint8, packet *TraceflowPacket) (*protocol.Ethernet, error) {
+	srcIP, dstIP := packet.SrcIP.To4(), packet.DstIP.To4()
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("only ipv4 traceflow packet is supported, src %s dst %s", packet.SrcIP, packet.DstIP)
+	}
+
+	ipPacket := protocol.NewIPv4()
+	ipPacket.Version = 4
+	ipPacket.DSCP = tag
+	ipPacket.TTL = 64
+	ipPacket.Protocol = packet.IPProtocol
+	ipPacket.NWSrc = srcIP
+	ipPacket.NWDst = dstIP
+
+	var l4Packet interface {
+		MarshalBinary() ([]byte, error)
+		Len() uint16
+	}
+	switch packet.IPProtocol {
+	case protocol.Type_TCP:
+		tcpPacket := protocol.NewTCP()
+		tcpPacket.PortSrc = packet.SrcPort
+		tcpPacket.PortDst = packet.DstPort
+		tcpPacket.HdrLen = 5
+		tcpPacket.Code = 0x02 // SYN
+		tcpPacket.WinSize = 65535
+		l4Packet = tcpPacket
+	case protocol.Type_UDP:
+		udpPacket := protocol.NewUDP()
+		udpPacket.PortSrc = packet.SrcPort
+		udpPacket.PortDst = packet.DstPort
+		udpPacket.Length = udpPacket.Len()
+		l4Packet = udpPacket
+	case protocol.Type_ICMP:
+		icmpPacket := protocol.NewICMP()
+		icmpPacket.Type = 8 // echo request
+		icmpPacket.Data = []byte{0, uint8(tag), 0, 1}
+		l4Packet = icmpPacket
+	default:
+		return nil, fmt.Errorf("unsupported traceflow ip protocol %d", packet.IPProtocol)
+	}
+
+	l4Data, err := l4Packet.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if packet.IPProtocol == protocol.Type_ICMP {
+		binary.BigEndian.PutUint16(l4Data[2:4], checksum(l4Data))
+	} else {
+		pseudoHeader := make([]byte, 12, 12+len(l4Data))
+		copy(pseudoHeader[0:4], srcIP)
+		copy(pseudoHeader[4:8], dstIP)
+		pseudoHeader[9] = packet.IPProtocol
+		binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(l4Data)))
+		l4Checksum := checksum(append(pseudoHeader, l4Data...))
+		if packet.IPProtocol == protocol.Type_TCP {
+			binary.BigEndian.PutUint16(l4Data[16:18], l4Checksum)
+		} else {
+			binary.BigEndian.PutUint16(l4Data[6:8], l4Checksum)
+		}
+	}
+	ipPacket.Data = (*rawPayload)(&l4Data)
+	ipPacket.Length = ipPacket.Len()
+
+	ipData, err := ipPacket.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	ipPacket.Checksum = checksum(ipData[:ipPacket.IHL*4])
+
+	return &protocol.Ethernet{
+		HWDst:     packet.DstMac,
+		HWSrc:     packet.SrcMac,
+		Ethertype: PROTOCOL_IP,
+		Data:      ipPacket,
+	}, nil
+}
+
+// rawPayload is marshaled l4 packet with checksum filled.
+type rawPayload []byte
+
+func (p *rawPayload) Len() uint16                       { return uint16(len(*p)) }
+func (p *rawPayload) MarshalBinary() ([]byte, error)    { return *p, nil }
+func (p *rawPayload) UnmarshalBinary(data []byte) error { *p = data; return nil }
+
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
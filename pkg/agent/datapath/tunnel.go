@@ -0,0 +1,218 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libOpenflow/openflow13"
+	"github.com/contiv/ofnet/ofctrl"
+)
+
+const (
+	TunnelTypeGeneve = "geneve"
+	TunnelTypeVXLAN  = "vxlan"
+
+	// DefaultMTU is the mtu of pod interfaces without encapsulation
+	DefaultMTU = 1500
+
+	// encapsulation overhead of geneve without options and vxlan over ipv4 underlay:
+	// outer ethernet 14, ipv4 20, udp 8 and tunnel header 8
+	tunnelOverhead = 50
+)
+
+// TunnelPeer is a remote node, traffic to its pod cidrs would be encapsulated to its node ip.
+type TunnelPeer struct {
+	NodeIP   net.IP
+	PodCIDRs []net.IPNet
+}
+
+// TunnelOverhead returns bytes of encapsulation headers of the tunnel type, zero if tunnel disabled.
+func TunnelOverhead(tunnelType string) int {
+	if tunnelType == "" {
+		return 0
+	}
+	return tunnelOverhead
+}
+
+// PodMTU returns mtu of pod interfaces, encapsulated packets must fit in the default mtu of underlay.
+func PodMTU(tunnelType string) int {
+	return DefaultMTU - TunnelOverhead(tunnelType)
+}
+
+// tunnelDstPort returns udp port of the tunnel type on the underlay, ovs default ports are used.
+func tunnelDstPort(tunnelType string) uint16 {
+	if tunnelType == TunnelTypeVXLAN {
+		return 4789
+	}
+	return 6081
+}
+
+func tunnelPortName(ovsbrname string) string {
+	return fmt.Sprintf("%s-tunnel", ovsbrname)
+}
+
+// SyncTunnelPeers replaces all tunnel peers, map node name to the peer. Flows of peers removed or
+// changed would be updated on uplink bridges.
+func (datapathManager *DpManager) SyncTunnelPeers(peers map[string]*TunnelPeer) error {
+	if datapathManager.AgentInfo.TunnelType == "" {
+		return nil
+	}
+
+	datapathManager.flowReplayMutex.Lock()
+	defer datapathManager.flowReplayMutex.Unlock()
+	if !datapathManager.IsBridgesConnected() {
+		datapathManager.WaitForBridgeConnected()
+	}
+
+	datapathManager.tunnelPeerMutex.Lock()
+	datapathManager.tunnelPeers = peers
+	datapathManager.tunnelPeerMutex.Unlock()
+
+	for vdsID := range datapathManager.managedVDSMap() {
+		vdsBridgeMap, ok := datapathManager.BridgeChainMap[vdsID]
+		if !ok {
+			continue
+		}
+		uplinkBridge := vdsBridgeMap[UPLINK_BRIDGE_KEYWORD].(*UplinkBridge)
+		if err := uplinkBridge.syncTunnelPeerFlows(peers); err != nil {
+			return fmt.Errorf("failed to sync tunnel peers of bridge %s, error: %v", uplinkBridge.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (datapathManager *DpManager) getTunnelPeers() map[string]*TunnelPeer {
+	datapathManager.tunnelPeerMutex.Lock()
+	defer datapathManager.tunnelPeerMutex.Unlock()
+	return datapathManager.tunnelPeers
+}
+
+// initTunnel creates the tunnel port, and installs flows delivering decapsulated traffic to gateway.
+// Flows of known tunnel peers would be installed again.
+func (u *UplinkBridge) initTunnel() error {
+	sw := u.OfSwitch
+	tunnelType := u.datapathManager.AgentInfo.TunnelType
+	portName := tunnelPortName(u.ovsbrName)
+
+	// remote ip and vni are decided per flow
+	if _, err := ovsVsctl("--may-exist", "add-port", u.name, portName, "--", "set", "Interface", portName,
+		"type="+tunnelType, "options:remote_ip=flow", "options:key=flow",
+		fmt.Sprintf("ofport_request=%d", UPLINK_TUNNEL_PORT)); err != nil {
+		return fmt.Errorf("failed to create tunnel port %s, error: %v", portName, err)
+	}
+	if err := SetPortNoFlood(u.name, UPLINK_TUNNEL_PORT); err != nil {
+		return err
+	}
+
+	// decapsulated traffic goes to kernel through gateway, and is routed to local pods
+	fromTunnelFlow, _ := u.defaultTable.NewFlow(ofctrl.FlowMatch{
+		Priority:  HIGH_MATCH_FLOW_PRIORITY,
+		InputPort: uint32(UPLINK_TUNNEL_PORT),
+	})
+	if err := fromTunnelFlow.SetMacDa(u.datapathManager.AgentInfo.GatewayMac); err != nil {
+		return err
+	}
+	outputPortGateway, _ := sw.OutputPort(UPLINK_GATEWAY_PORT)
+	if err := fromTunnelFlow.Next(outputPortGateway); err != nil {
+		return fmt.Errorf("failed to install fromTunnelFlow flow, error: %v", err)
+	}
+
+	u.tunnelPeerFlows = make(map[string][]*ofctrl.Flow)
+	u.tunnelPeers = make(map[string]*TunnelPeer)
+	return u.syncTunnelPeerFlows(u.datapathManager.getTunnelPeers())
+}
+
+func (u *UplinkBridge) syncTunnelPeerFlows(peers map[string]*TunnelPeer) error {
+	for nodeName, peer := range u.tunnelPeers {
+		if newPeer, ok := peers[nodeName]; ok && reflect.DeepEqual(newPeer, peer) {
+			continue
+		}
+		for _, flow := range u.tunnelPeerFlows[nodeName] {
+			if err := flow.Delete(); err != nil {
+				return err
+			}
+		}
+		delete(u.tunnelPeerFlows, nodeName)
+		delete(u.tunnelPeers, nodeName)
+		log.Infof("Remove tunnel peer %s from bridge %s", nodeName, u.name)
+	}
+
+	for nodeName, peer := range peers {
+		if _, ok := u.tunnelPeers[nodeName]; ok {
+			continue
+		}
+		flows, err := u.addTunnelPeerFlows(peer)
+		if err != nil {
+			return fmt.Errorf("failed to add tunnel peer %s, error: %v", nodeName, err)
+		}
+		u.tunnelPeerFlows[nodeName] = flows
+		u.tunnelPeers[nodeName] = peer
+		log.Infof("Add tunnel peer %s node ip %s pod cidrs %v to bridge %s", nodeName, peer.NodeIP, peer.PodCIDRs, u.name)
+	}
+
+	return nil
+}
+
+// addTunnelPeerFlows encapsulates traffic to pod cidrs of the peer, from either local pods or gateway.
+// Flows installed would be removed if failed.
+func (u *UplinkBridge) addTunnelPeerFlows(peer *TunnelPeer) (flows []*ofctrl.Flow, err error) {
+	defer func() {
+		if err != nil {
+			for _, flow := range flows {
+				_ = flow.Delete()
+			}
+		}
+	}()
+
+	nodeIP := peer.NodeIP.To4()
+	if nodeIP == nil {
+		return nil, fmt.Errorf("node ip %s is not ipv4, only ipv4 underlay supported", peer.NodeIP)
+	}
+	outputPortTunnel, _ := u.OfSwitch.OutputPort(UPLINK_TUNNEL_PORT)
+
+	for i := range peer.PodCIDRs {
+		podCIDR := peer.PodCIDRs[i]
+		match := ofctrl.FlowMatch{
+			Priority:  MID_MATCH_FLOW_PRIORITY,
+			Ethertype: PROTOCOL_IP,
+			IpDa:      &podCIDR.IP,
+			IpDaMask:  (*net.IP)(&podCIDR.Mask),
+		}
+		if podCIDR.IP.To4() == nil {
+			match.Ethertype, match.IpDa, match.IpDaMask = PROTOCOL_IPV6, nil, nil
+			match.Ipv6Da, match.Ipv6DaMask = &podCIDR.IP, (*net.IP)(&podCIDR.Mask)
+		}
+
+		toTunnelFlow, _ := u.defaultTable.NewFlow(match)
+		if err := toTunnelFlow.LoadField("nxm_nx_tun_ipv4_dst", uint64(binary.BigEndian.Uint32(nodeIP)),
+			openflow13.NewNXRange(0, 31)); err != nil {
+			return flows, err
+		}
+		if err := toTunnelFlow.Next(outputPortTunnel); err != nil {
+			return flows, fmt.Errorf("failed to install toTunnelFlow flow, error: %v", err)
+		}
+		flows = append(flows, toTunnelFlow)
+	}
+
+	return flows, nil
+}
@@ -0,0 +1,31 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datapath
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPodMTU(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(PodMTU("")).Should(Equal(DefaultMTU))
+	Expect(PodMTU(TunnelTypeGeneve)).Should(Equal(DefaultMTU - tunnelOverhead))
+	Expect(PodMTU(TunnelTypeVXLAN)).Should(Equal(DefaultMTU - tunnelOverhead))
+}
@@ -26,20 +26,33 @@ import (
 	"github.com/contiv/ofnet/ofctrl"
 )
 
+//nolint
+const (
+	UPLINK_SERVICE_OUTPUT_TABLE = 45
+)
+
 type UplinkBridge struct {
 	name            string
+	ovsbrName       string
 	OfSwitch        *ofctrl.OFSwitch
 	datapathManager *DpManager
 
 	defaultTable            *ofctrl.Table
 	uplinkSwitchStatueMutex sync.RWMutex
 	isUplinkSwitchConnected bool
+
+	tunnelPeers     map[string]*TunnelPeer    // map node name to tunnel peer installed
+	tunnelPeerFlows map[string][]*ofctrl.Flow // map node name to its flows encapsulating traffic to its pods
+	egressFlowSet   *egressFlowSet            // egress rules encapsulating marked traffic to egress nodes
+	serviceLB       *serviceLB                // node ports balanced for traffic from outside
 }
 
 func NewUplinkBridge(brName string, datapathManager *DpManager) *UplinkBridge {
 	uplinkBridge := new(UplinkBridge)
 	uplinkBridge.name = fmt.Sprintf("%s-uplink", brName)
+	uplinkBridge.ovsbrName = brName
 	uplinkBridge.datapathManager = datapathManager
+	uplinkBridge.serviceLB = newServiceLB(uplinkBridge.name)
 	return uplinkBridge
 }
 
@@ -85,6 +98,7 @@ func (u *UplinkBridge) WaitForSwitchConnection() {
 }
 
 func (u *UplinkBridge) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
+	u.datapathManager.processTraceflowPacketIn(u.name, pkt)
 }
 
 func (u *UplinkBridge) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply) {
@@ -138,5 +152,22 @@ func (u *UplinkBridge) RemoveSFCRule() error {
 }
 
 func (u *UplinkBridge) BridgeInitCNI() {
-
+	if u.datapathManager.AgentInfo.EnableCNI && u.datapathManager.AgentInfo.TunnelType != "" {
+		if err := u.initTunnel(); err != nil {
+			log.Fatalf("Failed to init tunnel, error: %v", err)
+		}
+		if err := u.initEgress(); err != nil {
+			log.Fatalf("Failed to init egress, error: %v", err)
+		}
+	}
+	if u.datapathManager.AgentInfo.EnableCNI && u.datapathManager.AgentInfo.EnableServiceProxy {
+		if err := u.initServiceProxy(); err != nil {
+			log.Fatalf("Failed to init service proxy, error: %v", err)
+		}
+	}
+	if u.datapathManager.AgentInfo.EnableCNI && u.datapathManager.AgentInfo.EnableHostEndpointPolicy {
+		if err := u.initHostEndpoint(); err != nil {
+			log.Fatalf("Failed to init host endpoint, error: %v", err)
+		}
+	}
 }
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+)
+
+// Client query agent debug server on the local unix socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(socketAddr string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketAddr)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) ListRules() ([]CompleteRule, error) {
+	var rules []CompleteRule
+	return rules, c.get(RulesPath, nil, &rules)
+}
+
+func (c *Client) ListGroups() ([]policycache.GroupMembership, error) {
+	var groups []policycache.GroupMembership
+	return groups, c.get(GroupsPath, nil, &groups)
+}
+
+func (c *Client) ListDatapathRules() ([]DatapathRule, error) {
+	var rules []DatapathRule
+	return rules, c.get(DatapathRulesPath, nil, &rules)
+}
+
+func (c *Client) GetDatapathRuleFlows(ruleID string) ([]datapath.RuleFlows, error) {
+	var ruleFlows []datapath.RuleFlows
+	return ruleFlows, c.get(DatapathRuleFlowsPath, url.Values{"rule": []string{ruleID}}, &ruleFlows)
+}
+
+func (c *Client) ListEndpoints() ([]LocalEndpoint, error) {
+	var endpoints []LocalEndpoint
+	return endpoints, c.get(EndpointsPath, nil, &endpoints)
+}
+
+func (c *Client) get(path string, query url.Values, obj interface{}) error {
+	// host is ignored when dial unix socket
+	reqURL := url.URL{Scheme: "http", Host: "everoute-agent", Path: path, RawQuery: query.Encode()}
+	resp, err := c.httpClient.Get(reqURL.String())
+	if err != nil {
+		return fmt.Errorf("unable connect to agent: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agent response %s: %s", resp.Status, string(body))
+	}
+
+	return json.Unmarshal(body, obj)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+
+	"k8s.io/klog"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	"github.com/everoute/everoute/pkg/utils"
+)
+
+const (
+	// SocketAddr is the unix socket agent debug server listen on
+	SocketAddr = "/var/run/everoute/agent-debug.sock"
+
+	RulesPath             = "/v1/rules"
+	GroupsPath            = "/v1/groups"
+	DatapathRulesPath     = "/v1/datapath/rules"
+	DatapathRuleFlowsPath = "/v1/datapath/flows"
+	EndpointsPath         = "/v1/endpoints"
+)
+
+// Server serves agent internal state on a local unix socket for debugging.
+type Server struct {
+	ruleLister       utils.Lister
+	globalRuleLister utils.Lister
+	groupCache       *policycache.GroupCache
+	datapathManager  *datapath.DpManager
+}
+
+func NewServer(ruleLister, globalRuleLister utils.Lister, groupCache *policycache.GroupCache, datapathManager *datapath.DpManager) *Server {
+	return &Server{
+		ruleLister:       ruleLister,
+		globalRuleLister: globalRuleLister,
+		groupCache:       groupCache,
+		datapathManager:  datapathManager,
+	}
+}
+
+// Handler return http handler of the debug server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(RulesPath, s.listRules)
+	mux.HandleFunc(GroupsPath, s.listGroups)
+	mux.HandleFunc(DatapathRulesPath, s.listDatapathRules)
+	mux.HandleFunc(DatapathRuleFlowsPath, s.getDatapathRuleFlows)
+	mux.HandleFunc(EndpointsPath, s.listEndpoints)
+	return mux
+}
+
+func (s *Server) Run(stopChan <-chan struct{}) {
+	klog.Info("Starting agent debug server")
+
+	// remove the remaining sock file
+	if _, err := os.Stat(SocketAddr); err == nil {
+		if err = os.Remove(SocketAddr); err != nil {
+			klog.Errorf("remove remaining debug sock file error, err:%s", err)
+			return
+		}
+	}
+
+	listener, err := net.Listen("unix", SocketAddr)
+	if err != nil {
+		klog.Errorf("Failed to bind on %s: %v", SocketAddr, err)
+		return
+	}
+	httpServer := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-stopChan
+		httpServer.Close()
+	}()
+
+	if err = httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("Failed to serve debug connections: %v", err)
+	}
+}
+
+func (s *Server) listRules(w http.ResponseWriter, r *http.Request) {
+	var rules []CompleteRule
+	for _, obj := range s.ruleLister.List() {
+		completeRule := obj.(*policycache.CompleteRule)
+		ruleCopy := completeRule.DeepCopy()
+		rules = append(rules, CompleteRule{
+			RuleID:            ruleCopy.RuleID,
+			Tier:              ruleCopy.Tier,
+			Action:            ruleCopy.Action,
+			Direction:         ruleCopy.Direction,
+			SymmetricMode:     ruleCopy.SymmetricMode,
+			DefaultPolicyRule: ruleCopy.DefaultPolicyRule,
+			SrcGroups:         ruleCopy.SrcGroups,
+			DstGroups:         ruleCopy.DstGroups,
+			PolicyRules:       completeRule.ListRules(),
+		})
+	}
+	// global rules have no groups, each of them is a policy rule itself
+	for _, obj := range s.globalRuleLister.List() {
+		globalRule := obj.(policycache.PolicyRule)
+		rules = append(rules, CompleteRule{
+			RuleID:      globalRule.Name,
+			Tier:        globalRule.Tier,
+			Action:      globalRule.Action,
+			Direction:   globalRule.Direction,
+			PolicyRules: []policycache.PolicyRule{globalRule},
+		})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].RuleID < rules[j].RuleID
+	})
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.groupCache.ListGroupMembership())
+}
+
+func (s *Server) listDatapathRules(w http.ResponseWriter, r *http.Request) {
+	var rules []DatapathRule
+	for _, ruleEntry := range s.datapathManager.ListRules() {
+		rule := ruleEntry.EveroutePolicyRule
+		rules = append(rules, DatapathRule{
+			RuleID:      rule.RuleID,
+			Direction:   ruleEntry.Direction,
+			Tier:        ruleEntry.Tier,
+			Priority:    rule.Priority,
+			SrcIPAddr:   rule.SrcIPAddr,
+			DstIPAddr:   rule.DstIPAddr,
+			IPProtocol:  rule.IPProtocol,
+			SrcPort:     rule.SrcPort,
+			SrcPortMask: rule.SrcPortMask,
+			DstPort:     rule.DstPort,
+			DstPortMask: rule.DstPortMask,
+			Action:      rule.Action,
+		})
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (s *Server) getDatapathRuleFlows(w http.ResponseWriter, r *http.Request) {
+	ruleID := r.URL.Query().Get("rule")
+	if ruleID == "" {
+		http.Error(w, "query parameter rule is required", http.StatusBadRequest)
+		return
+	}
+
+	ruleFlows, err := s.datapathManager.GetRuleFlows(ruleID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, ruleFlows)
+}
+
+func (s *Server) listEndpoints(w http.ResponseWriter, r *http.Request) {
+	var endpoints []LocalEndpoint
+	for _, endpoint := range s.datapathManager.ListLocalEndpoints() {
+		endpoints = append(endpoints, LocalEndpoint{
+			InterfaceName: endpoint.InterfaceName,
+			BridgeName:    endpoint.BridgeName,
+			PortNo:        endpoint.PortNo,
+			MacAddrStr:    endpoint.MacAddrStr,
+			VlanID:        endpoint.VlanID,
+			IPAddr:        endpoint.IPAddr,
+			IPv6Addr:      endpoint.IPv6Addr,
+		})
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		klog.Errorf("failed to write debug response: %s", err)
+	}
+}
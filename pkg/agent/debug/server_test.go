@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+func TestDebugServer(t *testing.T) {
+	ruleCache := policycache.NewCompleteRuleCache()
+	_ = ruleCache.Add(&policycache.CompleteRule{
+		RuleID:      "default/policy01/ingress01",
+		Tier:        "tier2",
+		Action:      policycache.RuleActionAllow,
+		Direction:   policycache.RuleDirectionIn,
+		SrcGroups:   map[string]int32{"group01": 1},
+		SrcIPBlocks: map[string]int{"10.0.0.1/32": 1},
+		DstIPBlocks: map[string]int{"10.0.0.2/32": 1},
+		Ports:       []policycache.RulePort{{DstPort: 80, Protocol: securityv1alpha1.ProtocolTCP}},
+	})
+
+	globalRuleCache := policycache.NewGlobalRuleCache()
+	_ = globalRuleCache.Add(policycache.PolicyRule{
+		Name:      "global-ingress",
+		Action:    policycache.RuleActionDrop,
+		Direction: policycache.RuleDirectionIn,
+		RuleType:  policycache.RuleTypeGlobalDefaultRule,
+	})
+
+	groupCache := policycache.NewGroupCache()
+	groupCache.AddGroupMembership(&groupv1alpha1.GroupMembers{
+		ObjectMeta: metav1.ObjectMeta{Name: "group01"},
+		Revision:   1,
+		GroupMembers: []groupv1alpha1.GroupMember{{
+			EndpointReference: groupv1alpha1.EndpointReference{ExternalIDName: "idk", ExternalIDValue: "ep01"},
+			IPs:               []types.IPAddress{"10.0.0.1"},
+		}},
+	})
+	groupCache.AddPatch(&groupv1alpha1.GroupMembersPatch{
+		ObjectMeta:            metav1.ObjectMeta{Name: "patch01"},
+		AppliedToGroupMembers: groupv1alpha1.GroupMembersReference{Name: "group01", Revision: 1},
+		AddedGroupMembers:     []groupv1alpha1.GroupMember{{}},
+	})
+
+	datapathManager := datapath.NewDatapathManager(&datapath.Config{}, make(chan map[string]datapath.LearnedIPAddress))
+	client := runServer(t, NewServer(ruleCache, globalRuleCache, groupCache, datapathManager))
+
+	t.Run("list rules", func(t *testing.T) {
+		rules, err := client.ListRules()
+		if err != nil {
+			t.Fatalf("failed to list rules: %s", err)
+		}
+		if len(rules) != 2 || rules[0].RuleID != "default/policy01/ingress01" || rules[1].RuleID != "global-ingress" {
+			t.Fatalf("unexpected rules %+v", rules)
+		}
+		if len(rules[0].PolicyRules) != 1 {
+			t.Fatalf("unexpected rule %+v", rules[0])
+		}
+		if policyRule := rules[0].PolicyRules[0]; policyRule.SrcIPAddr != "10.0.0.1/32" || policyRule.DstPort != 80 {
+			t.Errorf("unexpected policy rule %+v", policyRule)
+		}
+		if len(rules[1].PolicyRules) != 1 || rules[1].PolicyRules[0].Action != policycache.RuleActionDrop {
+			t.Errorf("unexpected global rule %+v", rules[1])
+		}
+	})
+
+	t.Run("list groups", func(t *testing.T) {
+		groups, err := client.ListGroups()
+		if err != nil {
+			t.Fatalf("failed to list groups: %s", err)
+		}
+		if len(groups) != 1 || groups[0].Revision != 1 || len(groups[0].Members) != 1 || len(groups[0].Patches) != 1 {
+			t.Errorf("unexpected groups %+v", groups)
+		}
+	})
+
+	t.Run("list datapath rules and endpoints", func(t *testing.T) {
+		rules, err := client.ListDatapathRules()
+		if err != nil || len(rules) != 0 {
+			t.Errorf("expect empty datapath rules, got %+v, err: %v", rules, err)
+		}
+		endpoints, err := client.ListEndpoints()
+		if err != nil || len(endpoints) != 0 {
+			t.Errorf("expect empty endpoints, got %+v, err: %v", endpoints, err)
+		}
+	})
+
+	t.Run("get flows of not exist rule", func(t *testing.T) {
+		if _, err := client.GetDatapathRuleFlows("not-exist-rule"); err == nil {
+			t.Errorf("expect error when get flows of not exist rule")
+		}
+	})
+}
+
+func runServer(t *testing.T, server *Server) *Client {
+	socketAddr := filepath.Join(t.TempDir(), "debug.sock")
+	listener, err := net.Listen("unix", socketAddr)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %s", socketAddr, err)
+	}
+	httpServer := &http.Server{Handler: server.Handler()}
+	go func() { _ = httpServer.Serve(listener) }()
+	t.Cleanup(func() { httpServer.Close() })
+
+	return NewClient(socketAddr)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"net"
+
+	policycache "github.com/everoute/everoute/pkg/agent/controller/policy/cache"
+)
+
+// CompleteRule is a policy rule in agent with its expanded PolicyRules.
+type CompleteRule struct {
+	RuleID            string                    `json:"ruleID"`
+	Tier              string                    `json:"tier,omitempty"`
+	Action            policycache.RuleAction    `json:"action"`
+	Direction         policycache.RuleDirection `json:"direction"`
+	SymmetricMode     bool                      `json:"symmetricMode,omitempty"`
+	DefaultPolicyRule bool                      `json:"defaultPolicyRule,omitempty"`
+	SrcGroups         map[string]int32          `json:"srcGroups,omitempty"`
+	DstGroups         map[string]int32          `json:"dstGroups,omitempty"`
+	PolicyRules       []policycache.PolicyRule  `json:"policyRules,omitempty"`
+}
+
+// DatapathRule is a rule installed in datapath, RuleID is the name of PolicyRule.
+type DatapathRule struct {
+	RuleID      string `json:"ruleID"`
+	Direction   uint8  `json:"direction"`
+	Tier        uint8  `json:"tier"`
+	Priority    int    `json:"priority"`
+	SrcIPAddr   string `json:"srcIPAddr,omitempty"`
+	DstIPAddr   string `json:"dstIPAddr,omitempty"`
+	IPProtocol  uint8  `json:"ipProtocol,omitempty"`
+	SrcPort     uint16 `json:"srcPort,omitempty"`
+	SrcPortMask uint16 `json:"srcPortMask,omitempty"`
+	DstPort     uint16 `json:"dstPort,omitempty"`
+	DstPortMask uint16 `json:"dstPortMask,omitempty"`
+	Action      string `json:"action"`
+}
+
+// LocalEndpoint is an endpoint attached to the agent bridges.
+type LocalEndpoint struct {
+	InterfaceName string `json:"interfaceName"`
+	BridgeName    string `json:"bridgeName"`
+	PortNo        uint32 `json:"portNo"`
+	MacAddrStr    string `json:"mac"`
+	VlanID        uint16 `json:"vlanID,omitempty"`
+	IPAddr        net.IP `json:"ipAddr,omitempty"`
+	IPv6Addr      net.IP `json:"ipv6Addr,omitempty"`
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+const (
+	// StaticIPAnnotation requests static ip addresses for the pod, the addresses
+	// must belong to pools serve the pod. Dual-stack pod could request an address
+	// of each ip family, separated by comma.
+	StaticIPAnnotation = "everoute.io/ip"
+
+	// DefaultGCInterval is the default interval of releasing leaked addresses.
+	DefaultGCInterval = 5 * time.Minute
+
+	// gcGracePeriod protects the allocations just made from garbage collection,
+	// the pod list may be outdated, and pod status may not be updated with the
+	// allocated address yet.
+	gcGracePeriod = 2 * time.Minute
+)
+
+// Result is an address allocated for a pod.
+type Result struct {
+	// Pool is the name of IPPool the address allocated from.
+	Pool    string
+	IP      *net.IPNet
+	Gateway net.IP
+}
+
+// Allocator allocates pod addresses from IPPools, the allocations are recorded
+// in IPPool status. Conflicts between agents are resolved by optimistic lock
+// of the IPPool resource version.
+type Allocator struct {
+	// reader reads from apiserver directly, the cache may not contain the allocations
+	// just made by other agents.
+	reader   client.Reader
+	client   client.Client
+	nodeName string
+	// podCIDR is the pod cidr of the node, only pools in the pod cidr are reachable
+	// through the gateway interface and the tunnels between nodes.
+	podCIDR []cnitypes.IPNet
+}
+
+// NewAllocator returns an Allocator releases addresses of pods on the node, and allocates
+// addresses from pools in the node pod cidr.
+func NewAllocator(k8sReader client.Reader, k8sClient client.Client, nodeName string, podCIDR []cnitypes.IPNet) *Allocator {
+	return &Allocator{
+		reader:   k8sReader,
+		client:   k8sClient,
+		nodeName: nodeName,
+		podCIDR:  append([]cnitypes.IPNet{}, podCIDR...),
+	}
+}
+
+// Allocate allocates an address of each ip family served by pools matching the pod
+// sandbox. It returns the addresses already allocated for the same sandbox, so that
+// it is safe to retry.
+func (a *Allocator) Allocate(ctx context.Context, namespace, name, containerID string) ([]*Result, error) {
+	var results []*Result
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pools, err := a.listPools(ctx)
+		if err != nil {
+			return err
+		}
+		results = lookup(pools, containerID)
+
+		var pod corev1.Pod
+		var ns corev1.Namespace
+		if err = a.reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &pod); err != nil {
+			return fmt.Errorf("get pod %s/%s: %s", namespace, name, err)
+		}
+		if err = a.reader.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+			return fmt.Errorf("get namespace %s: %s", namespace, err)
+		}
+
+		matchPools := make(map[bool][]*pool)
+		for _, p := range pools {
+			match, err := p.matches(&pod, &ns)
+			if err != nil {
+				klog.Errorf("ignore pool %s: %s", p.Name, err)
+				continue
+			}
+			if match {
+				matchPools[p.isIPv6()] = append(matchPools[p.isIPv6()], p)
+			}
+		}
+		if len(matchPools) == 0 {
+			return fmt.Errorf("no ippool serves pod %s/%s", namespace, name)
+		}
+
+		staticIPs, err := parseStaticIPs(pod.GetAnnotations()[StaticIPAnnotation])
+		if err != nil {
+			return fmt.Errorf("pod %s/%s: %s", namespace, name, err)
+		}
+
+		for _, ipv6 := range []bool{false, true} {
+			if len(matchPools[ipv6]) == 0 && staticIPs[ipv6] == "" {
+				continue
+			}
+			if hasFamily(results, ipv6) {
+				continue
+			}
+
+			p, addr, err := selectAddress(matchPools[ipv6], staticIPs[ipv6])
+			if err != nil {
+				return fmt.Errorf("allocate address for pod %s/%s: %s", namespace, name, err)
+			}
+
+			if p.Status.Allocations == nil {
+				p.Status.Allocations = make(map[types.IPAddress]agentv1alpha1.IPAllocation)
+			}
+			p.Status.Allocations[types.IPAddress(addr.String())] = agentv1alpha1.IPAllocation{
+				Namespace:    namespace,
+				Pod:          name,
+				ContainerID:  containerID,
+				Node:         a.nodeName,
+				AllocateTime: metav1.Now(),
+			}
+			// addresses allocated before conflict would be found by lookup when retry
+			if err = a.client.Status().Update(ctx, p.IPPool); err != nil {
+				return err
+			}
+
+			result := &Result{Pool: p.Name, IP: p.ipNet(addr), Gateway: p.gateway}
+			klog.Infof("allocate address %s from ippool %s for pod %s/%s", result.IP, result.Pool, namespace, name)
+			results = append(results, result)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Lookup returns the addresses allocated for the pod sandbox, ipv4 address first.
+func (a *Allocator) Lookup(ctx context.Context, containerID string) ([]*Result, error) {
+	pools, err := a.listPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(pools, containerID), nil
+}
+
+// Release releases the addresses allocated for the pod sandbox.
+func (a *Allocator) Release(ctx context.Context, containerID string) error {
+	return a.release(ctx, func(_ types.IPAddress, allocation agentv1alpha1.IPAllocation) bool {
+		return allocation.ContainerID == containerID
+	})
+}
+
+// Run releases the leaked addresses of pods on the node periodically.
+func (a *Allocator) Run(interval time.Duration, stopChan <-chan struct{}) {
+	klog.Infof("start ipam garbage collector with interval %s", interval)
+	wait.Until(func() {
+		if err := a.GarbageCollect(context.Background()); err != nil {
+			klog.Errorf("ipam garbage collect: %s", err)
+		}
+	}, interval, stopChan)
+}
+
+// GarbageCollect releases the addresses allocated by this node, whose pod has been
+// deleted, terminated, or got another address.
+func (a *Allocator) GarbageCollect(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := a.reader.List(ctx, &pods, client.MatchingFields{"spec.nodeName": a.nodeName}); err != nil {
+		return err
+	}
+	podMap := make(map[string]*corev1.Pod, len(pods.Items))
+	for i := range pods.Items {
+		podMap[pods.Items[i].Namespace+"/"+pods.Items[i].Name] = &pods.Items[i]
+	}
+
+	return a.release(ctx, func(addr types.IPAddress, allocation agentv1alpha1.IPAllocation) bool {
+		if allocation.Node != a.nodeName {
+			return false
+		}
+		if time.Since(allocation.AllocateTime.Time) < gcGracePeriod {
+			return false
+		}
+		pod, ok := podMap[allocation.Namespace+"/"+allocation.Pod]
+		switch {
+		case !ok:
+			return true
+		case pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed:
+			return true
+		default:
+			// the pod sandbox has been recreated with another address
+			return pod.Status.PodIP != "" && !podHasAddress(pod, addr)
+		}
+	})
+}
+
+// release removes the allocations matched from all pools.
+func (a *Allocator) release(ctx context.Context, match func(types.IPAddress, agentv1alpha1.IPAllocation) bool) error {
+	pools, err := a.listPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pools {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			var ippool agentv1alpha1.IPPool
+			if err := a.reader.Get(ctx, client.ObjectKey{Name: p.Name}, &ippool); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+
+			var released []types.IPAddress
+			for addr, allocation := range ippool.Status.Allocations {
+				if match(addr, allocation) {
+					released = append(released, addr)
+					delete(ippool.Status.Allocations, addr)
+				}
+			}
+			if len(released) == 0 {
+				return nil
+			}
+
+			if err := a.client.Status().Update(ctx, &ippool); err != nil {
+				return err
+			}
+			klog.Infof("release addresses %v from ippool %s", released, ippool.Name)
+			return nil
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("release address from ippool %s: %s", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// listPools returns the valid pools in the node pod cidr sorted by name.
+func (a *Allocator) listPools(ctx context.Context) ([]*pool, error) {
+	var ippools agentv1alpha1.IPPoolList
+	if err := a.reader.List(ctx, &ippools); err != nil {
+		return nil, err
+	}
+	sort.Slice(ippools.Items, func(i, j int) bool {
+		return ippools.Items[i].Name < ippools.Items[j].Name
+	})
+
+	pools := make([]*pool, 0, len(ippools.Items))
+	for i := range ippools.Items {
+		p, err := parsePool(&ippools.Items[i])
+		if err != nil {
+			klog.Errorf("ignore invalid ippool %s: %s", ippools.Items[i].Name, err)
+			continue
+		}
+		if err = p.inPodCIDR(a.podCIDR); err != nil {
+			klog.Errorf("ignore unreachable ippool %s on node %s: %s", ippools.Items[i].Name, a.nodeName, err)
+			continue
+		}
+		pools = append(pools, p)
+	}
+	return pools, nil
+}
+
+// selectAddress selects the static address if requested, or the lowest free address
+// in the pools.
+func selectAddress(pools []*pool, staticIP string) (*pool, net.IP, error) {
+	if staticIP != "" {
+		addr := normalizeIP(net.ParseIP(staticIP))
+		if addr == nil {
+			return nil, nil, fmt.Errorf("invalid static ip %s", staticIP)
+		}
+		for _, p := range pools {
+			if !p.allocatable(addr) {
+				continue
+			}
+			if allocation, ok := p.Status.Allocations[types.IPAddress(addr.String())]; ok {
+				return nil, nil, fmt.Errorf("static ip %s has been allocated to pod %s/%s",
+					addr, allocation.Namespace, allocation.Pod)
+			}
+			return p, addr, nil
+		}
+		return nil, nil, fmt.Errorf("static ip %s not allocatable in pools serve the pod", staticIP)
+	}
+
+	for _, p := range pools {
+		if addr := p.nextFree(); addr != nil {
+			return p, addr, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("all pools serve the pod have been exhausted")
+}
+
+func lookup(pools []*pool, containerID string) []*Result {
+	var results []*Result
+	for _, p := range pools {
+		for addr, allocation := range p.Status.Allocations {
+			if allocation.ContainerID == containerID {
+				results = append(results, &Result{Pool: p.Name, IP: p.ipNet(net.ParseIP(string(addr))), Gateway: p.gateway})
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return len(results[i].IP.IP) < len(results[j].IP.IP)
+	})
+	return results
+}
+
+func hasFamily(results []*Result, ipv6 bool) bool {
+	for _, r := range results {
+		if (r.IP.IP.To4() == nil) == ipv6 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStaticIPs parses the static ip annotation, which contains at most one address
+// of each ip family, separated by comma.
+func parseStaticIPs(annotation string) (map[bool]string, error) {
+	staticIPs := make(map[bool]string)
+	if annotation == "" {
+		return staticIPs, nil
+	}
+	for _, item := range strings.Split(annotation, ",") {
+		addr := net.ParseIP(strings.TrimSpace(item))
+		if addr == nil {
+			return nil, fmt.Errorf("invalid static ip %s", item)
+		}
+		ipv6 := addr.To4() == nil
+		if staticIPs[ipv6] != "" {
+			return nil, fmt.Errorf("multiple static ips of the same family: %s", annotation)
+		}
+		staticIPs[ipv6] = addr.String()
+	}
+	return staticIPs, nil
+}
+
+func podHasAddress(pod *corev1.Pod, addr types.IPAddress) bool {
+	podIPs := []corev1.PodIP{{IP: pod.Status.PodIP}}
+	podIPs = append(podIPs, pod.Status.PodIPs...)
+	for _, podIP := range podIPs {
+		if net.ParseIP(podIP.IP).Equal(net.ParseIP(string(addr))) {
+			return true
+		}
+	}
+	return false
+}
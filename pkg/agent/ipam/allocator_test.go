@@ -0,0 +1,217 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+func newPod(namespace, name string, labels, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: namespace, Name: name, Labels: labels, Annotations: annotations,
+	}}
+}
+
+func TestNextFree(t *testing.T) {
+	p, err := parsePool(&agentv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec: agentv1alpha1.IPPoolSpec{
+			CIDR:     "10.0.0.0/29",
+			Reserved: []string{"10.0.0.2-10.0.0.3", "10.0.0.4/31"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpect error: %s", err)
+	}
+
+	if addr := p.nextFree(); addr == nil || addr.String() != "10.0.0.6" {
+		t.Fatalf("expect allocate 10.0.0.6, got %s", addr)
+	}
+	p.Status.Allocations = map[types.IPAddress]agentv1alpha1.IPAllocation{"10.0.0.6": {}}
+	if addr := p.nextFree(); addr != nil {
+		t.Fatalf("expect pool exhausted, got %s", addr)
+	}
+}
+
+func TestParseStaticIPs(t *testing.T) {
+	for _, item := range []string{"10.0.0.300", "10.0.0.1,10.0.0.2", "fd00::1,fd00::2"} {
+		if _, err := parseStaticIPs(item); err == nil {
+			t.Errorf("expect invalid static ips %s", item)
+		}
+	}
+	staticIPs, err := parseStaticIPs("fd00::1, 10.0.0.1")
+	if err != nil || staticIPs[false] != "10.0.0.1" || staticIPs[true] != "fd00::1" {
+		t.Errorf("unexpect static ips %v, err: %v", staticIPs, err)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, item := range []string{"10.0.0.300", "10.0.0.10-10.0.0.1", "10.0.0.1-fe80::1", "10.0.0.0/33"} {
+		if _, err := parseRange(item); err == nil {
+			t.Errorf("expect invalid range %s", item)
+		}
+	}
+	r, err := parseRange("10.0.0.1 - 10.0.0.10")
+	if err != nil || r.start.String() != "10.0.0.1" || r.end.String() != "10.0.0.10" {
+		t.Errorf("unexpect range %+v, err: %v", r, err)
+	}
+}
+
+func TestAllocator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = agentv1alpha1.AddToScheme(scheme)
+
+	k8sClient := fake.NewFakeClientWithScheme(scheme,
+		// out of the node pod cidr, would be selected first if not ignored
+		&agentv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool00"},
+			Spec: agentv1alpha1.IPPoolSpec{
+				CIDR:              "192.168.0.0/24",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			},
+		},
+		&agentv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool01"},
+			Spec: agentv1alpha1.IPPoolSpec{
+				CIDR:              "10.0.0.0/24",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			},
+		},
+		&agentv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool02"},
+			Spec: agentv1alpha1.IPPoolSpec{
+				CIDR:        "10.0.1.0/24",
+				Gateway:     "10.0.1.254",
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+		},
+		&agentv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool01-v6"},
+			Spec: agentv1alpha1.IPPoolSpec{
+				CIDR:              "fd00::/64",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			},
+		},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns01", Labels: map[string]string{"team": "a"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns02"}},
+		newPod("ns01", "pod01", nil, nil),
+		newPod("ns02", "pod02", map[string]string{"app": "db"}, map[string]string{StaticIPAnnotation: "10.0.1.10"}),
+		newPod("ns02", "pod03", map[string]string{"app": "db"}, map[string]string{StaticIPAnnotation: "10.0.1.10"}),
+		newPod("ns02", "pod04", nil, nil),
+		newPod("ns02", "pod05", map[string]string{"app": "db"}, map[string]string{StaticIPAnnotation: "10.0.1.11,fd00::10"}),
+	)
+	podCIDR := []cnitypes.IPNet{
+		{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(16, 32)},
+		{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(48, 128)},
+	}
+	allocator := NewAllocator(k8sClient, k8sClient, "node01", podCIDR)
+	ctx := context.Background()
+
+	r, err := allocator.Allocate(ctx, "ns01", "pod01", "container01")
+	if err != nil || len(r) != 2 {
+		t.Fatalf("expect dual-stack addresses, got %+v, err: %v", r, err)
+	}
+	if r[0].Pool != "pool01" || r[0].IP.String() != "10.0.0.2/24" || r[0].Gateway.String() != "10.0.0.1" {
+		t.Fatalf("unexpect result %+v", r[0])
+	}
+	if r[1].Pool != "pool01-v6" || r[1].IP.String() != "fd00::2/64" || r[1].Gateway.String() != "fd00::1" {
+		t.Fatalf("unexpect result %+v", r[1])
+	}
+	if retry, err := allocator.Allocate(ctx, "ns01", "pod01", "container01"); err != nil || len(retry) != 2 || retry[0].IP.String() != r[0].IP.String() {
+		t.Fatalf("expect same address for same container, got %+v, err: %v", retry, err)
+	}
+
+	r, err = allocator.Allocate(ctx, "ns02", "pod02", "container02")
+	if err != nil || len(r) != 1 || r[0].Pool != "pool02" || r[0].IP.String() != "10.0.1.10/24" || r[0].Gateway.String() != "10.0.1.254" {
+		t.Fatalf("unexpect result %+v, err: %v", r, err)
+	}
+	if _, err = allocator.Allocate(ctx, "ns02", "pod03", "container03"); err == nil {
+		t.Fatalf("expect static ip conflict")
+	}
+	if _, err = allocator.Allocate(ctx, "ns02", "pod04", "container04"); err == nil {
+		t.Fatalf("expect no pool serves pod04")
+	}
+	if _, err = allocator.Allocate(ctx, "ns02", "pod05", "container05"); err == nil {
+		t.Fatalf("expect no ipv6 pool serves pod05")
+	}
+
+	if err = allocator.Release(ctx, "container02"); err != nil {
+		t.Fatalf("unexpect error: %s", err)
+	}
+	if r, err = allocator.Lookup(ctx, "container02"); err != nil || len(r) != 0 {
+		t.Fatalf("expect address released, got %+v, err: %v", r, err)
+	}
+
+	// pod01 deleted, the leaked address should be released after grace period
+	if err = k8sClient.Delete(ctx, newPod("ns01", "pod01", nil, nil)); err != nil {
+		t.Fatalf("unexpect error: %s", err)
+	}
+	if err = allocator.GarbageCollect(ctx); err != nil {
+		t.Fatalf("unexpect error: %s", err)
+	}
+	if r, _ = allocator.Lookup(ctx, "container01"); len(r) != 2 {
+		t.Fatalf("expect address protected in grace period")
+	}
+
+	var pool agentv1alpha1.IPPool
+	_ = k8sClient.Get(ctx, client.ObjectKey{Name: "pool01"}, &pool)
+	allocation := pool.Status.Allocations["10.0.0.2"]
+	allocation.AllocateTime = metav1.NewTime(time.Now().Add(-gcGracePeriod))
+	pool.Status.Allocations["10.0.0.2"] = allocation
+	if err = k8sClient.Status().Update(ctx, &pool); err != nil {
+		t.Fatalf("unexpect error: %s", err)
+	}
+	if err = allocator.GarbageCollect(ctx); err != nil {
+		t.Fatalf("unexpect error: %s", err)
+	}
+	if r, _ = allocator.Lookup(ctx, "container01"); len(r) != 1 || r[0].Pool != "pool01-v6" {
+		t.Fatalf("expect leaked ipv4 address released, got %+v", r)
+	}
+}
+
+func TestPoolInPodCIDR(t *testing.T) {
+	podCIDR := []cnitypes.IPNet{{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(16, 32)}}
+	for cidr, expectErr := range map[string]bool{
+		"10.0.1.0/24":    false,
+		"10.0.0.0/16":    false,
+		"10.0.0.0/15":    true,
+		"192.168.0.0/24": true,
+		"fd00::/64":      true,
+	} {
+		p, err := parsePool(&agentv1alpha1.IPPool{Spec: agentv1alpha1.IPPoolSpec{CIDR: cidr}})
+		if err != nil {
+			t.Fatalf("unexpect error: %s", err)
+		}
+		if err = p.inPodCIDR(podCIDR); (err != nil) != expectErr {
+			t.Errorf("pool %s in pod cidr %v, expect error %t, got %v", cidr, podCIDR, expectErr, err)
+		}
+	}
+}
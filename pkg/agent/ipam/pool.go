@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ip"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+// ipRange is a closed range of ip addresses.
+type ipRange struct {
+	start net.IP
+	end   net.IP
+}
+
+func (r ipRange) contains(addr net.IP) bool {
+	return bytes.Compare(addr, r.start) >= 0 && bytes.Compare(addr, r.end) <= 0
+}
+
+// pool is the parsed IPPool.
+type pool struct {
+	*agentv1alpha1.IPPool
+
+	subnet   *net.IPNet
+	gateway  net.IP
+	reserved []ipRange
+}
+
+func parsePool(ippool *agentv1alpha1.IPPool) (*pool, error) {
+	_, subnet, err := net.ParseCIDR(ippool.Spec.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %s: %s", ippool.Spec.CIDR, err)
+	}
+	p := &pool{IPPool: ippool, subnet: subnet}
+
+	p.gateway = normalizeIP(ip.NextIP(subnet.IP))
+	if ippool.Spec.Gateway != "" {
+		p.gateway = normalizeIP(net.ParseIP(string(ippool.Spec.Gateway)))
+		if p.gateway == nil || !subnet.Contains(p.gateway) {
+			return nil, fmt.Errorf("gateway %s not in cidr %s", ippool.Spec.Gateway, ippool.Spec.CIDR)
+		}
+	}
+
+	for _, item := range ippool.Spec.Reserved {
+		r, err := parseRange(item)
+		if err != nil {
+			return nil, err
+		}
+		p.reserved = append(p.reserved, r)
+	}
+
+	return p, nil
+}
+
+// inPodCIDR returns error if the pool cidr is not in any of the pod cidr, addresses
+// and gateway out of the pod cidr are unreachable from the node and other nodes. Pod
+// cidr of nodes never overlap, so only one node allocates from the pool.
+func (p *pool) inPodCIDR(podCIDR []cnitypes.IPNet) error {
+	poolOnes, poolBits := p.subnet.Mask.Size()
+	for i := range podCIDR {
+		ones, bits := podCIDR[i].Mask.Size()
+		if bits == poolBits && ones <= poolOnes && (*net.IPNet)(&podCIDR[i]).Contains(p.subnet.IP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("cidr %s not in pod cidr %v", p.subnet, podCIDR)
+}
+
+// parseRange parses an ip, a cidr or a range like 10.0.0.10-10.0.0.20.
+func parseRange(item string) (ipRange, error) {
+	item = strings.TrimSpace(item)
+	switch {
+	case strings.Contains(item, "/"):
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid reserved cidr %s: %s", item, err)
+		}
+		return ipRange{start: normalizeIP(ipNet.IP), end: lastIP(ipNet)}, nil
+	case strings.Contains(item, "-"):
+		items := strings.SplitN(item, "-", 2)
+		start := normalizeIP(net.ParseIP(strings.TrimSpace(items[0])))
+		end := normalizeIP(net.ParseIP(strings.TrimSpace(items[1])))
+		if start == nil || end == nil || len(start) != len(end) || bytes.Compare(start, end) > 0 {
+			return ipRange{}, fmt.Errorf("invalid reserved range %s", item)
+		}
+		return ipRange{start: start, end: end}, nil
+	default:
+		addr := normalizeIP(net.ParseIP(item))
+		if addr == nil {
+			return ipRange{}, fmt.Errorf("invalid reserved ip %s", item)
+		}
+		return ipRange{start: addr, end: addr}, nil
+	}
+}
+
+// matches returns true if the pool serves the pod in the namespace.
+func (p *pool) matches(pod *corev1.Pod, namespace *corev1.Namespace) (bool, error) {
+	for selector, set := range map[*metav1.LabelSelector]labels.Set{
+		p.Spec.NamespaceSelector: namespace.GetLabels(),
+		p.Spec.PodSelector:       pod.GetLabels(),
+	} {
+		if selector == nil {
+			continue
+		}
+		s, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return false, fmt.Errorf("pool %s has invalid selector: %s", p.Name, err)
+		}
+		if !s.Matches(set) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// allocatable returns true if the address could be allocated from the pool,
+// regardless of whether it has been allocated.
+func (p *pool) allocatable(addr net.IP) bool {
+	addr = normalizeIP(addr)
+	if addr == nil || !p.subnet.Contains(addr) || addr.Equal(p.gateway) {
+		return false
+	}
+	// network address, which is the subnet-router anycast address in ipv6
+	if addr.Equal(p.subnet.IP) {
+		return false
+	}
+	// broadcast address of ipv4 subnet
+	if addr.To4() != nil && addr.Equal(lastIP(p.subnet)) {
+		return false
+	}
+	return !p.isReserved(addr)
+}
+
+func (p *pool) isReserved(addr net.IP) bool {
+	for _, r := range p.reserved {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextFree returns the lowest address could be allocated, nil if the pool exhausted.
+func (p *pool) nextFree() net.IP {
+	last := lastIP(p.subnet)
+	for addr := normalizeIP(p.subnet.IP); p.subnet.Contains(addr); addr = normalizeIP(ip.NextIP(addr)) {
+		if _, ok := p.Status.Allocations[types.IPAddress(addr.String())]; !ok && p.allocatable(addr) {
+			return addr
+		}
+		// skip the whole reserved range
+		for _, r := range p.reserved {
+			if r.contains(addr) {
+				addr = r.end
+			}
+		}
+		if bytes.Compare(addr, last) >= 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// isIPv6 returns true if the pool is an ipv6 pool.
+func (p *pool) isIPv6() bool {
+	return p.subnet.IP.To4() == nil
+}
+
+// ipNet returns the address with the mask of the pool.
+func (p *pool) ipNet(addr net.IP) *net.IPNet {
+	return &net.IPNet{IP: normalizeIP(addr), Mask: p.subnet.Mask}
+}
+
+func normalizeIP(addr net.IP) net.IP {
+	if v4 := addr.To4(); v4 != nil {
+		return v4
+	}
+	return addr
+}
+
+func lastIP(subnet *net.IPNet) net.IP {
+	network := normalizeIP(subnet.IP)
+	last := make(net.IP, len(network))
+	for i := range network {
+		last[i] = network[i] | ^subnet.Mask[i]
+	}
+	return last
+}
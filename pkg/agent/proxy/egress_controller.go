@@ -0,0 +1,773 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/j-keck/arping"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+)
+
+const (
+	// ip rules of egress ids look up main table without default routes first, then route table
+	// egressRouteTableBase + id, which has the default route to the egress node.
+	egressRulePriority   = 1000
+	egressRouteTableBase = 10000
+	egressRouteTableMax  = egressRouteTableBase + 1<<16
+
+	egressChain = "EVEROUTE-EGRESS"
+)
+
+// egressSyncRequest is the only request of egress controller, all egress policies are synchronized
+// together as pods are claimed by the first policy selecting them.
+var egressSyncRequest = ctrl.Request{NamespacedName: types.NamespacedName{Name: "egress"}}
+
+// EgressReconciler watch egress policies, pods and nodes. Traffic from the selected pods to outside of
+// the cluster is routed to the egress node, and SNATed to the egress ip there. Only traffic which would
+// be sent by the default route is affected, traffic to other routes in main table is masqueraded as before.
+type EgressReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	DatapathManager *datapath.DpManager
+
+	StopChan  <-chan struct{}
+	syncMutex sync.Mutex
+
+	egressIDs     map[string]uint16                // map egress policy namespaced name to its id
+	assignedIPs   map[string]bool                  // egress ips assigned to this node
+	iptablesRules map[iptables.Protocol][][]string // rules in egress chain applied
+}
+
+// egressState is the egress policy resolved with current nodes and pods.
+type egressState struct {
+	name        string
+	id          uint16
+	egressIP    net.IP
+	egressNode  *corev1.Node
+	podIPs      []net.IP // ips of the selected pods in the family of the egress ip
+	localPodIPs []net.IP // ips of the selected pods on this node
+}
+
+// Reconcile receive egress policies, pods and nodes from work queue, synchronize all egress policies
+func (r *EgressReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	klog.V(2).Infof("EgressReconciler received %s reconcile", req.NamespacedName)
+
+	if err := r.Sync(context.Background()); err != nil {
+		klog.Errorf("Sync egress policies error, err: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// Sync resolves all egress policies, and updates datapath, kernel routes, iptables and egress ips.
+func (r *EgressReconciler) Sync(ctx context.Context) error {
+	r.syncMutex.Lock()
+	defer r.syncMutex.Unlock()
+
+	policyList := agentv1alpha1.EgressPolicyList{}
+	if err := r.List(ctx, &policyList); err != nil {
+		return fmt.Errorf("list egress policies: %s", err)
+	}
+	nodeList := corev1.NodeList{}
+	if err := r.List(ctx, &nodeList); err != nil {
+		return fmt.Errorf("list nodes: %s", err)
+	}
+	podList := corev1.PodList{}
+	if err := r.List(ctx, &podList); err != nil {
+		return fmt.Errorf("list pods: %s", err)
+	}
+
+	states := r.resolveEgressStates(policyList.Items, nodeList.Items, podList.Items)
+
+	var thisNode corev1.Node
+	for _, item := range nodeList.Items {
+		if item.Name == r.DatapathManager.AgentInfo.NodeName {
+			thisNode = item
+			break
+		}
+	}
+
+	if err := r.DatapathManager.SyncEgressRules(r.egressRulesOf(states)); err != nil {
+		return fmt.Errorf("sync egress rules: %s", err)
+	}
+	r.updateEgressRoutes(states)
+	r.updateEgressIptables(states, nodeList, thisNode)
+	r.updateEgressIPs(states, policyList.Items, thisNode)
+
+	return r.updateEgressStatus(ctx, states, policyList.Items)
+}
+
+// resolveEgressStates resolves policies sorted by namespaced name, a pod selected by multiple policies
+// is claimed by the first one. Ids of policies removed are released.
+func (r *EgressReconciler) resolveEgressStates(policies []agentv1alpha1.EgressPolicy,
+	nodes []corev1.Node, pods []corev1.Pod) []*egressState {
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Namespace != policies[j].Namespace {
+			return policies[i].Namespace < policies[j].Namespace
+		}
+		return policies[i].Name < policies[j].Name
+	})
+
+	policyNames := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		policyNames[types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}.String()] = true
+	}
+	for name := range r.egressIDs {
+		if !policyNames[name] {
+			delete(r.egressIDs, name)
+		}
+	}
+
+	var states []*egressState
+	claimedPods := make(map[types.UID]bool)
+	for i := range policies {
+		policy := &policies[i]
+		name := types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}.String()
+		egressIP := net.ParseIP(string(policy.Spec.EgressIP))
+		if egressIP == nil {
+			klog.Errorf("Egress policy %s has invalid egress ip %s", name, policy.Spec.EgressIP)
+			continue
+		}
+		id, ok := r.egressIDOf(name)
+		if !ok {
+			klog.Errorf("No egress id available for egress policy %s", name)
+			continue
+		}
+
+		state := &egressState{
+			name:       name,
+			id:         id,
+			egressIP:   egressIP,
+			egressNode: egressNodeOf(policy, nodes),
+		}
+		for _, pod := range egressPodsOf(policy, pods) {
+			if claimedPods[pod.UID] {
+				continue
+			}
+			claimedPods[pod.UID] = true
+			for _, podIP := range podIPsOfFamily(pod, egressIP.To4() == nil) {
+				state.podIPs = append(state.podIPs, podIP)
+				if pod.Spec.NodeName == r.DatapathManager.AgentInfo.NodeName {
+					state.localPodIPs = append(state.localPodIPs, podIP)
+				}
+			}
+		}
+		states = append(states, state)
+	}
+
+	return states
+}
+
+// egressIDOf returns id of the egress policy, the smallest id unused is allocated for new policy.
+func (r *EgressReconciler) egressIDOf(name string) (uint16, bool) {
+	if r.egressIDs == nil {
+		r.egressIDs = make(map[string]uint16)
+	}
+	if id, ok := r.egressIDs[name]; ok {
+		return id, true
+	}
+
+	used := make(map[uint16]bool, len(r.egressIDs))
+	for _, id := range r.egressIDs {
+		used[id] = true
+	}
+	for id := uint16(1); id != 0; id++ {
+		if !used[id] {
+			r.egressIDs[name] = id
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// egressNodeOf returns the first ready node sorted by name selected by the policy, nil if not found.
+func egressNodeOf(policy *agentv1alpha1.EgressPolicy, nodes []corev1.Node) *corev1.Node {
+	selector := labels.Everything()
+	if policy.Spec.NodeSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.NodeSelector); err != nil {
+			klog.Errorf("Egress policy %s/%s has invalid node selector, err: %s", policy.Namespace, policy.Name, err)
+			return nil
+		}
+	}
+
+	var egressNode *corev1.Node
+	for i := range nodes {
+		if !selector.Matches(labels.Set(nodes[i].Labels)) || !nodeReady(nodes[i]) {
+			continue
+		}
+		if egressNode == nil || nodes[i].Name < egressNode.Name {
+			egressNode = &nodes[i]
+		}
+	}
+	return egressNode
+}
+
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// egressPodsOf returns running pods selected by the policy in its namespace, host network pods are ignored.
+func egressPodsOf(policy *agentv1alpha1.EgressPolicy, pods []corev1.Pod) []corev1.Pod {
+	selector := labels.Everything()
+	if policy.Spec.PodSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.PodSelector); err != nil {
+			klog.Errorf("Egress policy %s/%s has invalid pod selector, err: %s", policy.Namespace, policy.Name, err)
+			return nil
+		}
+	}
+
+	var selected []corev1.Pod
+	for _, pod := range pods {
+		if pod.Namespace != policy.Namespace || pod.Spec.HostNetwork || pod.Spec.NodeName == "" {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			selected = append(selected, pod)
+		}
+	}
+	return selected
+}
+
+func podIPsOfFamily(pod corev1.Pod, ipv6 bool) []net.IP {
+	podIPs := pod.Status.PodIPs
+	if len(podIPs) == 0 && pod.Status.PodIP != "" {
+		podIPs = []corev1.PodIP{{IP: pod.Status.PodIP}}
+	}
+
+	var ret []net.IP
+	for _, podIP := range podIPs {
+		if addr := net.ParseIP(podIP.IP); addr != nil && (addr.To4() == nil) == ipv6 {
+			ret = append(ret, addr)
+		}
+	}
+	return ret
+}
+
+// isRemote returns true if the egress node of the policy is another node.
+func (s *egressState) isRemote(nodeName string) bool {
+	return s.egressNode != nil && s.egressNode.Name != nodeName
+}
+
+// egressRulesOf returns rules marking local pods of policies, whose egress node is another node.
+func (r *EgressReconciler) egressRulesOf(states []*egressState) map[string]*datapath.EgressRule {
+	rules := make(map[string]*datapath.EgressRule)
+	for _, state := range states {
+		if !state.isRemote(r.DatapathManager.AgentInfo.NodeName) || len(state.localPodIPs) == 0 {
+			continue
+		}
+		rule := &datapath.EgressRule{ID: state.id, PodIPs: state.localPodIPs}
+		if r.DatapathManager.AgentInfo.TunnelType != "" {
+			rule.TunnelDst = GetNodeInternalIPOfFamily(*state.egressNode, false)
+		}
+		rules[state.name] = rule
+	}
+	return rules
+}
+
+// updateEgressRoutes routes the marked traffic of policies with remote egress node to the egress node.
+// ip rule add fwmark id/mask lookup main suppress_prefixlength 0
+// ip rule add fwmark id/mask lookup table-of-id
+// ip route add default via egress-node table table-of-id
+// In overlay mode, the route via the gateway ip of the pod cidr of the egress node on local gateway,
+// traffic would be encapsulated on uplink bridge.
+func (r *EgressReconciler) updateEgressRoutes(states []*egressState) {
+	agentInfo := r.DatapathManager.AgentInfo
+	tunnelMode := agentInfo.TunnelType != ""
+
+	targetRules := map[int][]netlink.Rule{unix.AF_INET: nil, unix.AF_INET6: nil}
+	for _, state := range states {
+		if !state.isRemote(agentInfo.NodeName) || len(state.localPodIPs) == 0 {
+			continue
+		}
+		ipv6 := state.egressIP.To4() == nil
+		family, defaultDst := unix.AF_INET, &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+		if ipv6 {
+			family, defaultDst = unix.AF_INET6, &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		}
+		table := egressRouteTableBase + int(state.id)
+
+		route := netlink.Route{Dst: defaultDst, Table: table}
+		if tunnelMode {
+			gwLink, err := netlink.LinkByName(agentInfo.GatewayName)
+			if err != nil {
+				klog.Errorf("Get gateway %s error, err: %s", agentInfo.GatewayName, err)
+				continue
+			}
+			gw := podCIDRGatewayOf(*state.egressNode, ipv6)
+			if gw == nil {
+				klog.Errorf("Fail to get podCIDR gateway of egress node %s for egress %s", state.egressNode.Name, state.name)
+				continue
+			}
+			if err = setTunnelNeigh(gwLink, gw); err != nil {
+				klog.Errorf("Set neighbor %s on %s failed, err: %s", gw, agentInfo.GatewayName, err)
+				continue
+			}
+			route.Gw, route.LinkIndex, route.Flags = gw, gwLink.Attrs().Index, int(netlink.FLAG_ONLINK)
+			route.MTU = datapath.PodMTU(agentInfo.TunnelType)
+		} else {
+			route.Gw = GetNodeInternalIPOfFamily(*state.egressNode, ipv6)
+			if route.Gw == nil {
+				klog.Errorf("Fail to get node internal IP of egress node %s for egress %s", state.egressNode.Name, state.name)
+				continue
+			}
+		}
+		if err := netlink.RouteReplace(&route); err != nil {
+			klog.Errorf("[ALERT] replace egress route %s failed, err: %s", &route, err)
+			continue
+		}
+
+		mark, mask := int(datapath.EgressMark(state.id)), int(datapath.EgressMarkMask)
+		suppressRule := netlink.NewRule()
+		suppressRule.Family, suppressRule.Priority, suppressRule.Mark, suppressRule.Mask = family, egressRulePriority, mark, mask
+		suppressRule.Table, suppressRule.SuppressPrefixlen = defaultRouteTable, 0
+		lookupRule := netlink.NewRule()
+		lookupRule.Family, lookupRule.Priority, lookupRule.Mark, lookupRule.Mask = family, egressRulePriority+1, mark, mask
+		lookupRule.Table = table
+		targetRules[family] = append(targetRules[family], *suppressRule, *lookupRule)
+	}
+
+	for family, rules := range targetRules {
+		syncEgressIPRules(family, rules)
+	}
+}
+
+// podCIDRGatewayOf returns the gateway ip of the pod cidr of the node in the ip family.
+func podCIDRGatewayOf(node corev1.Node, ipv6 bool) net.IP {
+	for _, podCIDR := range node.Spec.PodCIDRs {
+		_, dst, err := net.ParseCIDR(podCIDR)
+		if err == nil && (dst.IP.To4() == nil) == ipv6 {
+			return ip.NextIP(dst.IP)
+		}
+	}
+	return nil
+}
+
+// isEgressIPRule returns true if the rule is added by egress controller.
+func isEgressIPRule(rule netlink.Rule) bool {
+	return (rule.Priority == egressRulePriority || rule.Priority == egressRulePriority+1) &&
+		rule.Mark >= 0 && rule.Mask == int(datapath.EgressMarkMask)
+}
+
+func egressIPRuleEqual(r1, r2 netlink.Rule) bool {
+	return r1.Priority == r2.Priority && r1.Mark == r2.Mark && r1.Mask == r2.Mask &&
+		r1.Table == r2.Table && r1.SuppressPrefixlen == r2.SuppressPrefixlen
+}
+
+// syncEgressIPRules adds ip rules of the family not exist, and deletes egress ip rules and their route
+// tables not in rules.
+func syncEgressIPRules(family int, rules []netlink.Rule) {
+	oldRules, err := netlink.RuleList(family)
+	if err != nil {
+		klog.Errorf("List ip rules error, err: %s", err)
+		return
+	}
+
+	exists := make([]bool, len(rules))
+	for _, oldRule := range oldRules {
+		if !isEgressIPRule(oldRule) {
+			continue
+		}
+		found := false
+		for i := range rules {
+			if egressIPRuleEqual(oldRule, rules[i]) {
+				found, exists[i] = true, true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		oldRule.Family = family
+		if err = netlink.RuleDel(&oldRule); err != nil {
+			klog.Errorf("delete ip rule %+v failed, err: %s", oldRule, err)
+			continue
+		}
+		klog.Infof("delete ip rule %+v", oldRule)
+		if oldRule.Table >= egressRouteTableBase && oldRule.Table < egressRouteTableMax {
+			flushRouteTable(family, oldRule.Table)
+		}
+	}
+
+	for i := range rules {
+		if exists[i] {
+			continue
+		}
+		if err = netlink.RuleAdd(&rules[i]); err != nil {
+			klog.Errorf("[ALERT] add ip rule %+v failed, err: %s", rules[i], err)
+		} else {
+			klog.Infof("add ip rule %+v", rules[i])
+		}
+	}
+}
+
+func flushRouteTable(family int, table int) {
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		klog.Errorf("List routes of table %d error, err: %s", table, err)
+		return
+	}
+	for i := range routes {
+		if err = netlink.RouteDel(&routes[i]); err != nil {
+			klog.Errorf("delete route item %s failed, err: %s", &routes[i], err)
+		}
+	}
+}
+
+// updateEgressIptables rebuilds the egress chain in nat POSTROUTING when its rules changed.
+// Traffic to pod cidrs and routes in main table except the default routes is returned, marked
+// traffic of policies with remote egress node is accepted to skip masquerade, and traffic from
+// pods of policies with this egress node is SNATed to the egress ip.
+func (r *EgressReconciler) updateEgressIptables(states []*egressState, nodeList corev1.NodeList, thisNode corev1.Node) {
+	if r.iptablesRules == nil {
+		r.iptablesRules = make(map[iptables.Protocol][][]string)
+	}
+
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		if len(podCIDRsOfProtocol(thisNode, protocol)) == 0 {
+			continue
+		}
+		ruleSpecs := egressIptablesRulesOf(states, thisNode.Name, protocol,
+			egressReturnDsts(nodeList, protocol))
+		if rules, ok := r.iptablesRules[protocol]; ok && reflect.DeepEqual(rules, ruleSpecs) {
+			continue
+		}
+
+		ipt, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			klog.Errorf("init iptables of protocol %v error, err: %s", protocol, err)
+			continue
+		}
+		if err = applyEgressIptables(ipt, ruleSpecs); err != nil {
+			klog.Errorf("[ALERT] update iptables %s of protocol %v error, err: %s", egressChain, protocol, err)
+			continue
+		}
+		r.iptablesRules[protocol] = ruleSpecs
+	}
+}
+
+// egressReturnDsts returns pod cidrs of all nodes, and destinations of routes in main table except the
+// default routes, sorted and deduplicated.
+func egressReturnDsts(nodeList corev1.NodeList, protocol iptables.Protocol) []string {
+	dstSet := make(map[string]bool)
+	for _, node := range nodeList.Items {
+		for _, podCIDR := range podCIDRsOfProtocol(node, protocol) {
+			if _, dst, err := net.ParseCIDR(podCIDR); err == nil {
+				dstSet[dst.String()] = true
+			}
+		}
+	}
+
+	family := unix.AF_INET
+	if protocol == iptables.ProtocolIPv6 {
+		family = unix.AF_INET6
+	}
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: defaultRouteTable}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		klog.Errorf("List route table error, err:%s", err)
+	}
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		if ones, _ := route.Dst.Mask.Size(); ones > 0 {
+			dstSet[route.Dst.String()] = true
+		}
+	}
+
+	dsts := make([]string, 0, len(dstSet))
+	for dst := range dstSet {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+	return dsts
+}
+
+// egressIptablesRulesOf returns rules of egress chain in the protocol.
+func egressIptablesRulesOf(states []*egressState, nodeName string, protocol iptables.Protocol, returnDsts []string) [][]string {
+	var ruleSpecs [][]string
+	for _, dst := range returnDsts {
+		ruleSpecs = append(ruleSpecs, []string{"-d", dst, "-j", "RETURN"})
+	}
+
+	for _, state := range states {
+		if (state.egressIP.To4() == nil) != (protocol == iptables.ProtocolIPv6) {
+			continue
+		}
+		switch {
+		case state.isRemote(nodeName):
+			ruleSpecs = append(ruleSpecs, []string{"-m", "mark", "--mark",
+				fmt.Sprintf("%#x/%#x", datapath.EgressMark(state.id), datapath.EgressMarkMask), "-j", "ACCEPT"})
+		case state.egressNode != nil:
+			for _, podIP := range state.podIPs {
+				ruleSpecs = append(ruleSpecs, []string{"-s", podIP.String(), "-j", "SNAT", "--to-source", state.egressIP.String()})
+			}
+		}
+	}
+	return ruleSpecs
+}
+
+// applyEgressIptables replaces rules of egress chain atomically by iptables-restore, so egress traffic
+// would not fall through to MASQUERADE of EVEROUTE-OUTPUT while the chain is updating.
+func applyEgressIptables(ipt *iptables.IPTables, ruleSpecs [][]string) error {
+	restoreCmd := "iptables-restore"
+	if ipt.Proto() == iptables.ProtocolIPv6 {
+		restoreCmd = "ip6tables-restore"
+	}
+	// other chains are kept with --noflush, --wait is supported since iptables 1.6.2
+	args := []string{"--noflush"}
+	if v1, v2, v3 := ipt.GetIptablesVersion(); v1 > 1 || v1 == 1 && (v2 > 6 || v2 == 6 && v3 >= 2) {
+		args = append(args, "--wait")
+	}
+	cmd := exec.Command(restoreCmd, args...)
+	cmd.Stdin = bytes.NewReader(egressIptablesRestoreInput(ruleSpecs))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s, output: %s", restoreCmd, err, out)
+	}
+
+	// egress chain must be before EVEROUTE-OUTPUT
+	exist, err := ipt.Exists("nat", "POSTROUTING", "-j", egressChain)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return ipt.Insert("nat", "POSTROUTING", 1, "-j", egressChain)
+	}
+	return nil
+}
+
+// egressIptablesRestoreInput returns input of iptables-restore replacing all rules of egress chain, the
+// chain declared is created if not exist, and flushed.
+func egressIptablesRestoreInput(ruleSpecs [][]string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*nat\n")
+	fmt.Fprintf(&buf, ":%s - [0:0]\n", egressChain)
+	for _, ruleSpec := range ruleSpecs {
+		fmt.Fprintf(&buf, "-A %s %s\n", egressChain, strings.Join(ruleSpec, " "))
+	}
+	buf.WriteString("COMMIT\n")
+	return buf.Bytes()
+}
+
+// updateEgressIPs assigns egress ips of policies with this egress node to the interface holding the
+// node internal ip, and removes other egress ips from it.
+func (r *EgressReconciler) updateEgressIPs(states []*egressState, policies []agentv1alpha1.EgressPolicy, thisNode corev1.Node) {
+	if r.assignedIPs == nil {
+		// egress ips of all policies may be assigned before restart
+		r.assignedIPs = make(map[string]bool)
+		for _, policy := range policies {
+			if addr := net.ParseIP(string(policy.Spec.EgressIP)); addr != nil {
+				r.assignedIPs[addr.String()] = true
+			}
+		}
+	}
+
+	targetIPs := make(map[string]net.IP)
+	for _, state := range states {
+		if state.egressNode != nil && state.egressNode.Name == thisNode.Name {
+			targetIPs[state.egressIP.String()] = state.egressIP
+		}
+	}
+
+	for addrString := range r.assignedIPs {
+		if _, ok := targetIPs[addrString]; ok {
+			continue
+		}
+		addr := net.ParseIP(addrString)
+		link, _, err := linkOfNodeIP(thisNode, addr.To4() == nil)
+		if err != nil {
+			klog.Errorf("Get link of node ip error, err: %s", err)
+			continue
+		}
+		if err = netlink.AddrDel(link, &netlink.Addr{IPNet: hostIPNet(addr)}); err != nil && err != unix.EADDRNOTAVAIL {
+			klog.Errorf("delete egress ip %s from %s failed, err: %s", addr, link.Attrs().Name, err)
+			continue
+		}
+		klog.Infof("delete egress ip %s from %s", addr, link.Attrs().Name)
+		delete(r.assignedIPs, addrString)
+	}
+
+	for addrString, addr := range targetIPs {
+		link, nodeIPNet, err := linkOfNodeIP(thisNode, addr.To4() == nil)
+		if err != nil {
+			klog.Errorf("Get link of node ip error, err: %s", err)
+			continue
+		}
+		// neighbors on the link could not reach the egress ip out of its subnet
+		if !nodeIPNet.Contains(addr) {
+			klog.Errorf("[ALERT] egress ip %s not in subnet %s of node ip on %s", addr, nodeIPNet, link.Attrs().Name)
+			continue
+		}
+		if err = netlink.AddrReplace(link, &netlink.Addr{IPNet: hostIPNet(addr)}); err != nil {
+			klog.Errorf("[ALERT] add egress ip %s to %s failed, err: %s", addr, link.Attrs().Name, err)
+			continue
+		}
+		if !r.assignedIPs[addrString] {
+			klog.Infof("add egress ip %s to %s", addr, link.Attrs().Name)
+			// ipv6 neighbors learn the address from neighbor discovery
+			if addr.To4() != nil {
+				if err = arping.GratuitousArpOverIfaceByName(addr, link.Attrs().Name); err != nil {
+					klog.Errorf("send gratuitous arp of egress ip %s error, err: %s", addr, err)
+				}
+			}
+		}
+		r.assignedIPs[addrString] = true
+	}
+}
+
+// linkOfNodeIP returns the link holding the node internal ip of the ip family, and the node ip with
+// prefix of its subnet.
+func linkOfNodeIP(node corev1.Node, ipv6 bool) (netlink.Link, *net.IPNet, error) {
+	nodeIP := GetNodeInternalIPOfFamily(node, ipv6)
+	if nodeIP == nil {
+		return nil, nil, fmt.Errorf("node %s has no internal ip of ipv6 %t", node.Name, ipv6)
+	}
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(nodeIP) {
+			link, err := netlink.LinkByIndex(addr.LinkIndex)
+			return link, addr.IPNet, err
+		}
+	}
+	return nil, nil, fmt.Errorf("node ip %s not found on any link", nodeIP)
+}
+
+func hostIPNet(addr net.IP) *net.IPNet {
+	if addr.To4() != nil {
+		return &net.IPNet{IP: addr.To4(), Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: addr, Mask: net.CIDRMask(128, 128)}
+}
+
+// updateEgressStatus records this node as the egress node of policies, whose egress ip is assigned here.
+// The egress node is cleared if no ready node is selected by the policy.
+func (r *EgressReconciler) updateEgressStatus(ctx context.Context, states []*egressState, policies []agentv1alpha1.EgressPolicy) error {
+	nodeName := r.DatapathManager.AgentInfo.NodeName
+	ownedPolicies := make(map[string]bool)
+	selectedPolicies := make(map[string]bool)
+	for _, state := range states {
+		if state.egressNode == nil {
+			continue
+		}
+		selectedPolicies[state.name] = true
+		if state.egressNode.Name == nodeName && r.assignedIPs[state.egressIP.String()] {
+			ownedPolicies[state.name] = true
+		}
+	}
+
+	for i := range policies {
+		policy := &policies[i]
+		name := types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}.String()
+		switch {
+		case ownedPolicies[name] && policy.Status.EgressNode != nodeName:
+			policy.Status.EgressNode = nodeName
+		case !selectedPolicies[name] && policy.Status.EgressNode != "":
+			policy.Status.EgressNode = ""
+		default:
+			continue
+		}
+		if err := r.Status().Update(ctx, policy); err != nil {
+			return fmt.Errorf("update status of egress policy %s: %s", name, err)
+		}
+		klog.Infof("egress ip %s of egress policy %s is assigned to node %q", policy.Spec.EgressIP, name, policy.Status.EgressNode)
+	}
+	return nil
+}
+
+// SetupWithManager create and add Egress Controller to the manager.
+func (r *EgressReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+
+	c, err := controller.New("egress-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: 1,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	enqueueSync := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []ctrl.Request {
+			return []ctrl.Request{egressSyncRequest}
+		}),
+	}
+	for _, object := range []runtime.Object{&agentv1alpha1.EgressPolicy{}, &corev1.Pod{}, &corev1.Node{}} {
+		if err = c.Watch(&source.Kind{Type: object}, enqueueSync); err != nil {
+			return err
+		}
+	}
+
+	// resync every 100 seconds, in case of routes, rules or addresses changed
+	ticker := time.NewTicker(100 * time.Second)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.Sync(context.Background()); err != nil {
+					klog.Errorf("Sync egress policies error, err: %s", err)
+				}
+			case <-r.StopChan:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+
+	"github.com/coreos/go-iptables/iptables"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+)
+
+var _ = Describe("Test egress_controller.go", func() {
+	var reconciler *EgressReconciler
+	var policy *agentv1alpha1.EgressPolicy
+	var nodes []corev1.Node
+	var pods []corev1.Pod
+
+	newNode := func(name string, ready bool, labels map[string]string) corev1.Node {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+			},
+		}
+	}
+	newPod := func(name, nodeName, podIP string, labels map[string]string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name), Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: podIP},
+		}
+	}
+
+	BeforeEach(func() {
+		reconciler = &EgressReconciler{
+			DatapathManager: &datapath.DpManager{AgentInfo: &datapath.AgentConf{NodeName: "node1"}},
+		}
+		policy = &agentv1alpha1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "egress1"},
+			Spec: agentv1alpha1.EgressPolicySpec{
+				PodSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				EgressIP:     "192.168.1.100",
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"egress": "true"}},
+			},
+		}
+		nodes = []corev1.Node{
+			newNode("node3", true, map[string]string{"egress": "true"}),
+			newNode("node2", false, map[string]string{"egress": "true"}),
+			newNode("node1", true, nil),
+		}
+		pods = []corev1.Pod{
+			newPod("web1", "node1", "10.244.1.2", map[string]string{"app": "web"}),
+			newPod("web2", "node3", "10.244.3.2", map[string]string{"app": "web"}),
+			newPod("db1", "node1", "10.244.1.3", map[string]string{"app": "db"}),
+		}
+	})
+
+	It("Test egressNodeOf", func() {
+		Expect(egressNodeOf(policy, nodes).Name).Should(Equal("node3"))
+
+		nodes[1].Status.Conditions[0].Status = corev1.ConditionTrue
+		Expect(egressNodeOf(policy, nodes).Name).Should(Equal("node2"))
+
+		policy.Spec.NodeSelector = nil
+		Expect(egressNodeOf(policy, nodes).Name).Should(Equal("node1"))
+
+		policy.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"egress": "false"}}
+		Expect(egressNodeOf(policy, nodes)).Should(BeNil())
+	})
+
+	It("Test egressPodsOf", func() {
+		pods = append(pods, newPod("web3", "node1", "10.244.1.4", map[string]string{"app": "web"}))
+		pods[3].Status.Phase = corev1.PodSucceeded
+		pods = append(pods, newPod("web4", "node1", "192.168.1.1", map[string]string{"app": "web"}))
+		pods[4].Spec.HostNetwork = true
+		pods = append(pods, newPod("web5", "node1", "10.244.1.5", map[string]string{"app": "web"}))
+		pods[5].Namespace = "kube-system"
+
+		selected := egressPodsOf(policy, pods)
+		Expect(selected).Should(HaveLen(2))
+		Expect(selected[0].Name).Should(Equal("web1"))
+		Expect(selected[1].Name).Should(Equal("web2"))
+	})
+
+	It("Test resolveEgressStates", func() {
+		policy2 := policy.DeepCopy()
+		policy2.Name, policy2.Spec.PodSelector, policy2.Spec.EgressIP = "egress0", nil, "192.168.1.101"
+		policy2.Spec.NodeSelector = nil
+		policy3 := policy.DeepCopy()
+		policy3.Name, policy3.Spec.EgressIP = "egress2", "fd00::100"
+
+		states := reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy, *policy2, *policy3}, nodes, pods)
+		Expect(states).Should(HaveLen(3))
+		Expect(states[0].name).Should(Equal("default/egress0"))
+		Expect(states[0].id).Should(Equal(uint16(1)))
+		Expect(states[0].egressNode.Name).Should(Equal("node1"))
+		Expect(states[0].podIPs).Should(HaveLen(3))
+		Expect(states[0].localPodIPs).Should(HaveLen(2))
+		Expect(states[0].isRemote("node1")).Should(BeFalse())
+		// pods are claimed by the first policy
+		Expect(states[1].name).Should(Equal("default/egress1"))
+		Expect(states[1].podIPs).Should(BeEmpty())
+		Expect(states[2].podIPs).Should(BeEmpty())
+
+		// ids are kept for existing policies and reused after released
+		states = reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy, *policy3}, nodes, pods)
+		Expect(states).Should(HaveLen(2))
+		Expect(states[0].id).Should(Equal(uint16(2)))
+		Expect(states[0].podIPs).Should(HaveLen(2))
+		Expect(states[0].localPodIPs).Should(HaveLen(1))
+		Expect(states[0].localPodIPs[0].String()).Should(Equal("10.244.1.2"))
+		Expect(states[0].isRemote("node1")).Should(BeTrue())
+		Expect(states[1].id).Should(Equal(uint16(3)))
+		states = reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy2, *policy}, nodes, pods)
+		Expect(states[0].id).Should(Equal(uint16(1)))
+	})
+
+	It("Test egressIptablesRulesOf", func() {
+		policy2 := policy.DeepCopy()
+		policy2.Name, policy2.Spec.PodSelector, policy2.Spec.EgressIP = "egress0", nil, "192.168.1.101"
+		policy2.Spec.NodeSelector = nil
+		states := reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy2}, nodes, pods)
+		states = append(states, reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy2, *policy}, nodes, pods[:2])[1])
+
+		rules := egressIptablesRulesOf(states, "node1", iptables.ProtocolIPv4, []string{"10.244.0.0/16"})
+		Expect(rules).Should(Equal([][]string{
+			{"-d", "10.244.0.0/16", "-j", "RETURN"},
+			{"-s", "10.244.1.2", "-j", "SNAT", "--to-source", "192.168.1.101"},
+			{"-s", "10.244.3.2", "-j", "SNAT", "--to-source", "192.168.1.101"},
+			{"-s", "10.244.1.3", "-j", "SNAT", "--to-source", "192.168.1.101"},
+			{"-m", "mark", "--mark", "0x20000/0xffff0000", "-j", "ACCEPT"},
+		}))
+		Expect(egressIptablesRulesOf(states, "node1", iptables.ProtocolIPv6, nil)).Should(BeEmpty())
+	})
+
+	It("Test egressIptablesRestoreInput", func() {
+		input := egressIptablesRestoreInput([][]string{
+			{"-d", "10.244.0.0/16", "-j", "RETURN"},
+			{"-s", "10.244.1.2", "-j", "SNAT", "--to-source", "192.168.1.101"},
+		})
+		Expect(string(input)).Should(Equal("*nat\n" +
+			":EVEROUTE-EGRESS - [0:0]\n" +
+			"-A EVEROUTE-EGRESS -d 10.244.0.0/16 -j RETURN\n" +
+			"-A EVEROUTE-EGRESS -s 10.244.1.2 -j SNAT --to-source 192.168.1.101\n" +
+			"COMMIT\n"))
+		// the chain is flushed without rules
+		Expect(string(egressIptablesRestoreInput(nil))).Should(Equal("*nat\n:EVEROUTE-EGRESS - [0:0]\nCOMMIT\n"))
+	})
+
+	It("Test egressRulesOf", func() {
+		states := reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy}, nodes, pods)
+		rules := reconciler.egressRulesOf(states)
+		Expect(rules).Should(HaveLen(1))
+		Expect(rules["default/egress1"].ID).Should(Equal(uint16(1)))
+		Expect(rules["default/egress1"].PodIPs).Should(HaveLen(1))
+		Expect(rules["default/egress1"].TunnelDst).Should(BeNil())
+
+		reconciler.DatapathManager.AgentInfo.NodeName = "node3"
+		states = reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy}, nodes, pods)
+		Expect(reconciler.egressRulesOf(states)).Should(BeEmpty())
+	})
+
+	It("Test updateEgressStatus", func() {
+		scheme := runtime.NewScheme()
+		Expect(agentv1alpha1.AddToScheme(scheme)).Should(Succeed())
+		policy.Status.EgressNode = "node2"
+		reconciler.Client = fake.NewFakeClientWithScheme(scheme, policy.DeepCopy())
+		ctx := context.Background()
+		getEgressNode := func() string {
+			var item agentv1alpha1.EgressPolicy
+			Expect(reconciler.Get(ctx, types.NamespacedName{Namespace: "default", Name: "egress1"}, &item)).Should(Succeed())
+			return item.Status.EgressNode
+		}
+
+		// egress ip not assigned to this node yet
+		policy.Spec.NodeSelector = nil
+		states := reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{*policy}, nodes, pods)
+		Expect(reconciler.updateEgressStatus(ctx, states, []agentv1alpha1.EgressPolicy{*policy})).Should(Succeed())
+		Expect(getEgressNode()).Should(Equal("node2"))
+
+		reconciler.assignedIPs = map[string]bool{"192.168.1.100": true}
+		Expect(reconciler.updateEgressStatus(ctx, states, []agentv1alpha1.EgressPolicy{*policy})).Should(Succeed())
+		Expect(getEgressNode()).Should(Equal("node1"))
+
+		// no ready node selected by the policy
+		var item agentv1alpha1.EgressPolicy
+		Expect(reconciler.Get(ctx, types.NamespacedName{Namespace: "default", Name: "egress1"}, &item)).Should(Succeed())
+		item.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"egress": "false"}}
+		states = reconciler.resolveEgressStates([]agentv1alpha1.EgressPolicy{item}, nodes, pods)
+		Expect(reconciler.updateEgressStatus(ctx, states, []agentv1alpha1.EgressPolicy{item})).Should(Succeed())
+		Expect(getEgressNode()).Should(BeEmpty())
+	})
+})
@@ -23,6 +23,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
@@ -63,10 +64,27 @@ func GetNodeInternalIP(node corev1.Node) net.IP {
 	return nil
 }
 
+// GetNodeInternalIPOfFamily returns the node internal ip of the ip family, nil if not found.
+func GetNodeInternalIPOfFamily(node corev1.Node, ipv6 bool) net.IP {
+	for _, item := range node.Status.Addresses {
+		if item.Type != corev1.NodeInternalIP {
+			continue
+		}
+		if addr := net.ParseIP(item.Address); addr != nil && (addr.To4() == nil) == ipv6 {
+			return addr
+		}
+	}
+	return nil
+}
+
 func GetRouteByDst(dst *net.IPNet) []netlink.Route {
 	var ret []netlink.Route
+	family := unix.AF_INET
+	if dst.IP.To4() == nil {
+		family = unix.AF_INET6
+	}
 	// List all route item in current node
-	routeList, err := netlink.RouteList(nil, unix.AF_INET)
+	routeList, err := netlink.RouteList(nil, family)
 	if err != nil {
 		klog.Errorf("List route table error, err:%s", err)
 		return ret
@@ -93,31 +111,61 @@ func RouteEqual(r1, r2 netlink.Route) bool {
 // for example, if there are two nodes in cluster and node2 has two pod cidrs, Here are route item in node1:
 // ip route add node2-podCIDR-1 via node2
 // ip route add node2-podCIDR-2 via node2
+// The route via the node internal ip of the same ip family as the pod cidr.
+// In overlay mode, the route via the gateway ip of the pod cidr on local gateway instead, traffic
+// would be encapsulated on uplink bridge:
+// ip route add node2-podCIDR-1 via node2-podCIDR-1-gateway dev gw onlink
 func (r *NodeReconciler) UpdateRoute(nodeList corev1.NodeList, thisNode corev1.Node) {
 	var oldRoute []netlink.Route
 	var targetRoute []netlink.Route
 	var err error
 
+	tunnelMode := r.DatapathManager.AgentInfo.TunnelType != ""
+	gwLink, err := netlink.LinkByName(r.DatapathManager.AgentInfo.GatewayName)
+	if err != nil {
+		klog.Errorf("Get gateway %s error, err: %s", r.DatapathManager.AgentInfo.GatewayName, err)
+		return
+	}
+
 	for _, item := range nodeList.Items {
 		// ignore current node
 		if item.Name == thisNode.Name {
 			continue
 		}
-		gw := GetNodeInternalIP(item)
-		if gw == nil {
-			klog.Errorf("Fail to get node internal IP in node: %s", item.Name)
-			continue
-		}
 		// multi-podCIDRs will create multi-routeItem
 		for _, podCIDR := range item.Spec.PodCIDRs {
 			dst, err := netlink.ParseIPNet(podCIDR)
 			if err != nil {
 				klog.Errorf("Parse podCIDR %s failed, err: %s", podCIDR, err)
+				continue
 			}
 			tempRoute := GetRouteByDst(dst)
 			if len(tempRoute) != 0 {
 				oldRoute = append(oldRoute, tempRoute...)
 			}
+
+			if tunnelMode {
+				gw := ip.NextIP(dst.IP)
+				if err = setTunnelNeigh(gwLink, gw); err != nil {
+					klog.Errorf("Set neighbor %s on %s failed, err: %s", gw, r.DatapathManager.AgentInfo.GatewayName, err)
+					continue
+				}
+				targetRoute = append(targetRoute, netlink.Route{
+					Dst:       dst,
+					Gw:        gw,
+					LinkIndex: gwLink.Attrs().Index,
+					Flags:     int(netlink.FLAG_ONLINK),
+					MTU:       datapath.PodMTU(r.DatapathManager.AgentInfo.TunnelType),
+					Table:     defaultRouteTable,
+				})
+				continue
+			}
+
+			gw := GetNodeInternalIPOfFamily(item, dst.IP.To4() == nil)
+			if gw == nil {
+				klog.Errorf("Fail to get node internal IP for podCIDR %s in node: %s", podCIDR, item.Name)
+				continue
+			}
 			targetRoute = append(targetRoute, netlink.Route{
 				Dst:   dst,
 				Gw:    gw,
@@ -150,6 +198,10 @@ func (r *NodeReconciler) UpdateRoute(nodeList corev1.NodeList, thisNode corev1.N
 		} else {
 			klog.Infof("delete route item %s", &delRoute[i])
 		}
+		// remove neighbor of the overlay route
+		if delRoute[i].LinkIndex == gwLink.Attrs().Index && delRoute[i].Gw != nil {
+			_ = netlink.NeighDel(&netlink.Neigh{LinkIndex: gwLink.Attrs().Index, IP: delRoute[i].Gw})
+		}
 	}
 	for i := range targetRoute {
 		// skip existed route
@@ -164,18 +216,85 @@ func (r *NodeReconciler) UpdateRoute(nodeList corev1.NodeList, thisNode corev1.N
 	}
 }
 
+// setTunnelNeigh sets a permanent neighbor on local gateway for the gateway ip of remote pod cidr, so
+// that the traffic could be sent to uplink bridge without arp or neighbor discovery.
+func setTunnelNeigh(gwLink netlink.Link, addr net.IP) error {
+	family := netlink.FAMILY_V4
+	if addr.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	fakeMac, _ := net.ParseMAC(datapath.FACK_MAC)
+	return netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    gwLink.Attrs().Index,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		IP:           addr,
+		HardwareAddr: fakeMac,
+	})
+}
+
+// tunnelPeersOf returns other nodes as tunnel peers, map node name to the peer.
+func tunnelPeersOf(nodeList corev1.NodeList, thisNode corev1.Node) map[string]*datapath.TunnelPeer {
+	peers := make(map[string]*datapath.TunnelPeer)
+	for _, item := range nodeList.Items {
+		if item.Name == thisNode.Name {
+			continue
+		}
+		nodeIP := GetNodeInternalIPOfFamily(item, false)
+		if nodeIP == nil {
+			klog.Errorf("Fail to get node internal IPv4 in node: %s", item.Name)
+			continue
+		}
+		peer := &datapath.TunnelPeer{NodeIP: nodeIP}
+		for _, podCIDR := range item.Spec.PodCIDRs {
+			_, dst, err := net.ParseCIDR(podCIDR)
+			if err != nil {
+				klog.Errorf("Parse podCIDR %s failed, err: %s", podCIDR, err)
+				continue
+			}
+			peer.PodCIDRs = append(peer.PodCIDRs, *dst)
+		}
+		peers[item.Name] = peer
+	}
+	return peers
+}
+
 // UpdateIptables will be called when Node has been updated, or every 100 seconds.
 // This function will update iptables in linux kernel.
 // iptables used to DNAT for the OUTPUT traffic( outside of the cluster)
+// Both iptables and ip6tables would be updated on dual-stack node.
 func (r *NodeReconciler) UpdateIptables(nodeList corev1.NodeList, thisNode corev1.Node) {
-	var exist bool
-	var err error
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		if len(podCIDRsOfProtocol(thisNode, protocol)) == 0 {
+			continue
+		}
+		ipt, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			klog.Errorf("init iptables of protocol %v error, err: %s", protocol, err)
+			continue
+		}
+		r.updateIptables(ipt, protocol, nodeList, thisNode)
+	}
+}
 
-	ipt, err := iptables.New()
-	if err != nil {
-		klog.Errorf("init iptables error, err: %s", err)
-		return
+// podCIDRsOfProtocol returns pod cidrs of the node in the ip family of protocol.
+func podCIDRsOfProtocol(node corev1.Node, protocol iptables.Protocol) []string {
+	var podCIDRs []string
+	for _, podCIDR := range node.Spec.PodCIDRs {
+		ip, _, err := net.ParseCIDR(podCIDR)
+		if err != nil {
+			continue
+		}
+		if (ip.To4() == nil) == (protocol == iptables.ProtocolIPv6) {
+			podCIDRs = append(podCIDRs, podCIDR)
+		}
 	}
+	return podCIDRs
+}
+
+func (r *NodeReconciler) updateIptables(ipt *iptables.IPTables, protocol iptables.Protocol, nodeList corev1.NodeList, thisNode corev1.Node) {
+	var exist bool
+	var err error
 
 	// set FORWARD in filter to accept
 	err = ipt.ChangePolicy("filter", "FORWARD", "ACCEPT")
@@ -208,7 +327,7 @@ func (r *NodeReconciler) UpdateIptables(nodeList corev1.NodeList, thisNode corev
 	}
 
 	// check and add MASQUERADE in EVEROUTE-OUTPUT"
-	for _, podCIDR := range thisNode.Spec.PodCIDRs {
+	for _, podCIDR := range podCIDRsOfProtocol(thisNode, protocol) {
 		ruleSpec := []string{"-s", podCIDR, "-j", "MASQUERADE"}
 		if exist, err = ipt.Exists("nat", "EVEROUTE-OUTPUT", ruleSpec...); err != nil {
 			klog.Errorf("Check MASQUERADE rule in nat EVEROUTE-OUTPUT error, rule: %s, err: %s", ruleSpec, err)
@@ -223,14 +342,27 @@ func (r *NodeReconciler) UpdateIptables(nodeList corev1.NodeList, thisNode corev
 		}
 	}
 
+	// check and add MASQUERADE of node port traffic from outside in EVEROUTE-OUTPUT, traffic to local
+	// backends is skipped by ACCEPT of gw-local
+	if r.DatapathManager.AgentInfo.EnableServiceProxy {
+		ruleSpec := []string{"-m", "mark", "--mark", fmt.Sprintf("%#x/%#x", datapath.NodePortMark, datapath.NodePortMark), "-j", "MASQUERADE"}
+		if exist, err = ipt.Exists("nat", "EVEROUTE-OUTPUT", ruleSpec...); err != nil {
+			klog.Errorf("Check MASQUERADE rule in nat EVEROUTE-OUTPUT error, rule: %s, err: %s", ruleSpec, err)
+		} else if !exist {
+			if err = ipt.Append("nat", "EVEROUTE-OUTPUT", ruleSpec...); err != nil {
+				klog.Errorf("Add MASQUERADE rule in nat EVEROUTE-OUTPUT error, rule: %s, err: %s", ruleSpec, err)
+			}
+		}
+	}
+
 	// check and add ACCEPT in EVEROUTE-OUTPUT
 	// ACCEPT is used to skip the traffic inside the cluster.
-	for _, podCIDR := range thisNode.Spec.PodCIDRs {
+	for _, podCIDR := range podCIDRsOfProtocol(thisNode, protocol) {
 		for _, nodeItem := range nodeList.Items {
 			if nodeItem.Name == thisNode.Name {
 				continue
 			}
-			for _, otherPodCIDR := range nodeItem.Spec.PodCIDRs {
+			for _, otherPodCIDR := range podCIDRsOfProtocol(nodeItem, protocol) {
 				ruleSpec := []string{"-s", podCIDR, "-d", otherPodCIDR, "-j", "ACCEPT"}
 				if exist, err = ipt.Exists("nat", "EVEROUTE-OUTPUT", ruleSpec...); err != nil {
 					klog.Errorf("Check ACCEPT rule in nat EVEROUTE-OUTPUT error, rule: %s, err: %s", ruleSpec, err)
@@ -301,6 +433,12 @@ func (r *NodeReconciler) UpdateNetwork() {
 
 	r.UpdateRoute(nodeList, currentNode)
 	r.UpdateIptables(nodeList, currentNode)
+
+	if r.DatapathManager.AgentInfo.TunnelType != "" {
+		if err := r.DatapathManager.SyncTunnelPeers(tunnelPeersOf(nodeList, currentNode)); err != nil {
+			klog.Errorf("Sync tunnel peers error, err: %s", err)
+		}
+	}
 }
 
 // Reconcile receive node from work queue, synchronize network config
@@ -19,6 +19,7 @@ package proxy
 import (
 	"net"
 
+	"github.com/coreos/go-iptables/iptables"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/vishvananda/netlink"
@@ -52,7 +53,7 @@ var _ = Describe("Test route.go", func() {
 		_, dst2, _ := net.ParseCIDR("10.244.2.0/24")
 		route1 = netlink.Route{
 			Src: net.ParseIP("192.168.2.100"),
-			Dst: dst2,
+			Dst: dst1,
 			Gw:  net.ParseIP("192.168.2.1"),
 		}
 		route2 = netlink.Route{
@@ -80,7 +81,35 @@ var _ = Describe("Test route.go", func() {
 
 	It("Test GetNodeInternalIP", func() {
 		ret := GetNodeInternalIP(*node1)
-		Expect(ret).Should(Equal("192.168.1.1"))
+		Expect(ret.String()).Should(Equal("192.168.1.1"))
+	})
+
+	It("Test GetNodeInternalIPOfFamily", func() {
+		node1.Status.Addresses = append(node1.Status.Addresses, corev1.NodeAddress{
+			Type:    corev1.NodeInternalIP,
+			Address: "fd00::1",
+		})
+		Expect(GetNodeInternalIPOfFamily(*node1, false).String()).Should(Equal("192.168.1.1"))
+		Expect(GetNodeInternalIPOfFamily(*node1, true).String()).Should(Equal("fd00::1"))
+	})
+
+	It("Test podCIDRsOfProtocol", func() {
+		node1.Spec.PodCIDRs = append(node1.Spec.PodCIDRs, "fd00:10:244:1::/64")
+		Expect(podCIDRsOfProtocol(*node1, iptables.ProtocolIPv4)).Should(Equal([]string{"10.244.1.0/24"}))
+		Expect(podCIDRsOfProtocol(*node1, iptables.ProtocolIPv6)).Should(Equal([]string{"fd00:10:244:1::/64"}))
+	})
+
+	It("Test tunnelPeersOf", func() {
+		node2 := node1.DeepCopy()
+		node2.Name = "node2"
+		node2.Spec.PodCIDRs = []string{"10.244.2.0/24", "fd00:10:244:2::/64"}
+		node2.Status.Addresses[0].Address = "192.168.1.2"
+
+		peers := tunnelPeersOf(corev1.NodeList{Items: []corev1.Node{*node1, *node2}}, *node1)
+		Expect(peers).Should(HaveLen(1))
+		Expect(peers["node2"].NodeIP.String()).Should(Equal("192.168.1.2"))
+		Expect(peers["node2"].PodCIDRs).Should(HaveLen(2))
+		Expect(peers["node2"].PodCIDRs[1].String()).Should(Equal("fd00:10:244:2::/64"))
 	})
 
 	It("Test RouteEqual", func() {
@@ -0,0 +1,218 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+// defaultAffinityTimeout is the default timeout of client ip session affinity, same as kube-proxy.
+const defaultAffinityTimeout = 10800
+
+// ServiceReconciler watch services and endpointslices, and load balance service cluster ips and node ports
+// on datapath.
+type ServiceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	DatapathManager *datapath.DpManager
+}
+
+// Reconcile receive service from work queue, synchronize its ports and backends to datapath
+func (r *ServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	klog.V(2).Infof("ServiceReconciler received service %s reconcile", req.NamespacedName)
+	ctx := context.Background()
+
+	service := corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, &service); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.DatapathManager.SyncService(req.NamespacedName.String(), nil)
+		}
+		klog.Errorf("Get service %s error, err: %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	sliceList := discoveryv1beta1.EndpointSliceList{}
+	if err := r.List(ctx, &sliceList, client.InNamespace(req.Namespace),
+		client.MatchingLabels{discoveryv1beta1.LabelServiceName: req.Name}); err != nil {
+		klog.Errorf("List endpointslices of service %s error, err: %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	ports := servicePortsOf(&service, sliceList.Items)
+	if err := r.DatapathManager.SyncService(req.NamespacedName.String(), ports); err != nil {
+		klog.Errorf("Sync service %s error, err: %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// servicePortsOf returns ports of each cluster ip of the service, with ready backends from endpointslices
+// of the same ip family, and node ports if exposed. Headless services and protocols other than tcp and
+// udp are ignored.
+func servicePortsOf(service *corev1.Service, slices []discoveryv1beta1.EndpointSlice) []*datapath.ServicePort {
+	clusterIPs := service.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && service.Spec.ClusterIP != "" {
+		clusterIPs = []string{service.Spec.ClusterIP}
+	}
+
+	var affinityTimeout uint16
+	if service.Spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		timeout := int32(defaultAffinityTimeout)
+		if config := service.Spec.SessionAffinityConfig; config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+			timeout = *config.ClientIP.TimeoutSeconds
+		}
+		if timeout > math.MaxUint16 {
+			timeout = math.MaxUint16
+		}
+		affinityTimeout = uint16(timeout)
+	}
+
+	var ports []*datapath.ServicePort
+	for _, clusterIPString := range clusterIPs {
+		clusterIP := net.ParseIP(clusterIPString)
+		if clusterIP == nil {
+			// headless service has cluster ip None
+			continue
+		}
+		addressType := discoveryv1beta1.AddressTypeIPv4
+		if clusterIP.To4() == nil {
+			addressType = discoveryv1beta1.AddressTypeIPv6
+		}
+
+		for _, servicePort := range service.Spec.Ports {
+			var protocol uint8
+			switch servicePort.Protocol {
+			case corev1.ProtocolTCP, "":
+				protocol = datapath.PROTOCOL_TCP
+			case corev1.ProtocolUDP:
+				protocol = datapath.PROTOCOL_UDP
+			default:
+				continue
+			}
+
+			ports = append(ports, &datapath.ServicePort{
+				ClusterIP:       clusterIP,
+				Protocol:        protocol,
+				Port:            uint16(servicePort.Port),
+				NodePort:        uint16(servicePort.NodePort),
+				AffinityTimeout: affinityTimeout,
+				Backends:        serviceBackendsOf(servicePort, addressType, slices),
+			})
+		}
+	}
+
+	return ports
+}
+
+// serviceBackendsOf returns ready endpoints of the service port in endpointslices of the address type,
+// sorted by ip and port.
+func serviceBackendsOf(servicePort corev1.ServicePort, addressType discoveryv1beta1.AddressType,
+	slices []discoveryv1beta1.EndpointSlice) []datapath.ServiceBackend {
+	var backends []datapath.ServiceBackend
+	backendSet := make(map[string]bool)
+
+	for _, slice := range slices {
+		if slice.AddressType != addressType {
+			continue
+		}
+		for _, slicePort := range slice.Ports {
+			if slicePort.Port == nil || slicePort.Name == nil || *slicePort.Name != servicePort.Name {
+				continue
+			}
+			if slicePort.Protocol != nil && *slicePort.Protocol != servicePort.Protocol {
+				continue
+			}
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+					continue
+				}
+				for _, address := range endpoint.Addresses {
+					ip := net.ParseIP(address)
+					key := fmt.Sprintf("%s/%d", address, *slicePort.Port)
+					if ip == nil || backendSet[key] {
+						continue
+					}
+					backendSet[key] = true
+					backends = append(backends, datapath.ServiceBackend{IP: ip, Port: uint16(*slicePort.Port)})
+				}
+			}
+		}
+	}
+
+	sort.Slice(backends, func(i, j int) bool {
+		if !backends[i].IP.Equal(backends[j].IP) {
+			return backends[i].IP.String() < backends[j].IP.String()
+		}
+		return backends[i].Port < backends[j].Port
+	})
+	return backends
+}
+
+// SetupWithManager create and add Service Controller to the manager.
+func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+
+	c, err := controller.New("service-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// endpointslices changes enqueue the service owns them
+	return c.Watch(&source.Kind{Type: &discoveryv1beta1.EndpointSlice{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []ctrl.Request {
+			serviceName, ok := obj.Meta.GetLabels()[discoveryv1beta1.LabelServiceName]
+			if !ok {
+				return nil
+			}
+			return []ctrl.Request{{NamespacedName: types.NamespacedName{
+				Namespace: obj.Meta.GetNamespace(),
+				Name:      serviceName,
+			}}}
+		}),
+	})
+}
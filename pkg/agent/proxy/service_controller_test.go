@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/everoute/everoute/pkg/agent/datapath"
+)
+
+var _ = Describe("Test service_controller.go", func() {
+	var service *corev1.Service
+	var slice *discoveryv1beta1.EndpointSlice
+	BeforeEach(func() {
+		service = &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc1"},
+			Spec: corev1.ServiceSpec{
+				ClusterIP:  "10.96.0.10",
+				ClusterIPs: []string{"10.96.0.10"},
+				Ports: []corev1.ServicePort{
+					{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53},
+					{Name: "sctp", Protocol: corev1.ProtocolSCTP, Port: 9999},
+				},
+			},
+		}
+
+		portName, port, ready, notReady := "dns", int32(5353), true, false
+		protocol := corev1.ProtocolUDP
+		slice = &discoveryv1beta1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "svc1-abcde",
+				Labels:    map[string]string{discoveryv1beta1.LabelServiceName: "svc1"},
+			},
+			AddressType: discoveryv1beta1.AddressTypeIPv4,
+			Ports:       []discoveryv1beta1.EndpointPort{{Name: &portName, Protocol: &protocol, Port: &port}},
+			Endpoints: []discoveryv1beta1.Endpoint{
+				{Addresses: []string{"10.244.2.2"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready}},
+				{Addresses: []string{"10.244.1.2"}},
+				{Addresses: []string{"10.244.3.2"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &notReady}},
+			},
+		}
+	})
+
+	It("Test servicePortsOf", func() {
+		ports := servicePortsOf(service, []discoveryv1beta1.EndpointSlice{*slice})
+		Expect(ports).Should(HaveLen(1))
+		Expect(ports[0].Key()).Should(Equal("10.96.0.10/17/53"))
+		Expect(ports[0].NodePort).Should(BeZero())
+		Expect(ports[0].AffinityTimeout).Should(BeZero())
+		Expect(ports[0].Backends).Should(HaveLen(2))
+		Expect(ports[0].Backends[0].IP.String()).Should(Equal("10.244.1.2"))
+		Expect(ports[0].Backends[0].Port).Should(Equal(uint16(5353)))
+		Expect(ports[0].Backends[1].IP.String()).Should(Equal("10.244.2.2"))
+	})
+
+	It("Test servicePortsOf with session affinity", func() {
+		service.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+		ports := servicePortsOf(service, []discoveryv1beta1.EndpointSlice{*slice})
+		Expect(ports).Should(HaveLen(1))
+		Expect(ports[0].AffinityTimeout).Should(Equal(uint16(defaultAffinityTimeout)))
+	})
+
+	It("Test servicePortsOf node port service", func() {
+		service.Spec.Type = corev1.ServiceTypeNodePort
+		service.Spec.Ports[0].NodePort = 30053
+		ports := servicePortsOf(service, []discoveryv1beta1.EndpointSlice{*slice})
+		Expect(ports).Should(HaveLen(1))
+		Expect(ports[0].Key()).Should(Equal("10.96.0.10/17/53"))
+		Expect(ports[0].NodePort).Should(Equal(uint16(30053)))
+		Expect(ports[0].Backends).Should(HaveLen(2))
+	})
+
+	It("Test servicePortsOf headless service", func() {
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+		service.Spec.ClusterIPs = []string{corev1.ClusterIPNone}
+		Expect(servicePortsOf(service, []discoveryv1beta1.EndpointSlice{*slice})).Should(BeEmpty())
+	})
+
+	It("Test servicePortsOf dual-stack service", func() {
+		service.Spec.ClusterIPs = append(service.Spec.ClusterIPs, "fd00:10:96::a")
+		ports := servicePortsOf(service, []discoveryv1beta1.EndpointSlice{*slice})
+		Expect(ports).Should(HaveLen(2))
+		Expect(ports[1].Protocol).Should(Equal(uint8(datapath.PROTOCOL_UDP)))
+		// no ipv6 endpointslices
+		Expect(ports[1].Backends).Should(BeEmpty())
+	})
+})
@@ -28,6 +28,12 @@ func init() {
 	SchemeBuilder.Register(
 		&AgentInfo{},
 		&AgentInfoList{},
+		&EgressPolicy{},
+		&EgressPolicyList{},
+		&IPPool{},
+		&IPPoolList{},
+		&Traceflow{},
+		&TraceflowList{},
 	)
 }
 
@@ -93,8 +93,22 @@ type OVSInterface struct {
 	Ofport      int32                           `json:"ofport,omitempty"`
 	Mac         string                          `json:"mac,omitempty"`
 	IPMap       map[types.IPAddress]metav1.Time `json:"ipmap,omitempty"`
+	// IPOrigins records where each ip in IPMap learned from.
+	IPOrigins map[types.IPAddress]IPOrigin `json:"ipOrigins,omitempty"`
 }
 
+// IPOrigin is the way how agent learned an interface ip address.
+type IPOrigin string
+
+const (
+	// IPOriginARP means the ip learned from arp packets sent by the interface.
+	IPOriginARP IPOrigin = "ARP"
+	// IPOriginNDP means the ip learned from ipv6 neighbor discovery packets sent by the interface.
+	IPOriginNDP IPOrigin = "NDP"
+	// IPOriginDHCP means the ip bound to the interface by dhcp snooping.
+	IPOriginDHCP IPOrigin = "DHCP"
+)
+
 type AgentConditionType string
 
 const (
@@ -120,3 +134,259 @@ type AgentInfoList struct {
 	metav1.ListMeta `json:"metadata,omitempty"`
 	Items           []AgentInfo `json:"items"`
 }
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:openapi-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,path=traceflows
+// +kubebuilder:printcolumn:name="Source",type="string",JSONPath=".spec.source.endpoint"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Traceflow injects a synthetic packet from the source endpoint, and reports
+// the tables it hit and the policy decision made on the path to destination.
+type Traceflow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TraceflowSpec   `json:"spec"`
+	Status TraceflowStatus `json:"status,omitempty"`
+}
+
+// TraceflowSpec describes where the synthetic packet comes from and goes to.
+type TraceflowSpec struct {
+	// Source is the endpoint which the packet injected from.
+	Source TraceflowEndpoint `json:"source"`
+	// Destination of the packet, could be an endpoint or an ip address.
+	Destination TraceflowDestination `json:"destination"`
+	// Packet describes the transport header of the packet, an ICMP echo request
+	// would be sent if protocol is empty.
+	Packet TraceflowPacket `json:"packet,omitempty"`
+	// Timeout of the traceflow in seconds, default 20 seconds.
+	// +kubebuilder:validation:Minimum=5
+	// +kubebuilder:validation:Maximum=300
+	Timeout int32 `json:"timeout,omitempty"`
+}
+
+// TraceflowEndpoint reference to an endpoint.
+type TraceflowEndpoint struct {
+	// Namespace of the endpoint.
+	Namespace string `json:"namespace"`
+	// Endpoint is the name of the endpoint.
+	Endpoint string `json:"endpoint"`
+}
+
+// TraceflowDestination is an endpoint or an ip address, only one of them should be set.
+type TraceflowDestination struct {
+	// Namespace of the endpoint.
+	Namespace string `json:"namespace,omitempty"`
+	// Endpoint is the name of the endpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+	// IP address of the destination.
+	IP types.IPAddress `json:"ip,omitempty"`
+}
+
+// TraceflowPacket describes the transport header of the packet.
+type TraceflowPacket struct {
+	// Protocol of the packet, TCP, UDP or ICMP.
+	// +kubebuilder:validation:Enum=TCP;UDP;ICMP
+	Protocol string `json:"protocol,omitempty"`
+	// SrcPort is the tcp or udp source port, a random port would be used if not set.
+	SrcPort int32 `json:"srcPort,omitempty"`
+	// DstPort is the tcp or udp destination port.
+	DstPort int32 `json:"dstPort,omitempty"`
+}
+
+type TraceflowPhase string
+
+const (
+	// TraceflowPhaseRunning means tag has been allocated, agents are tracing the packet.
+	TraceflowPhaseRunning TraceflowPhase = "Running"
+	// TraceflowPhaseSucceeded means all agents on the path have reported results.
+	TraceflowPhaseSucceeded TraceflowPhase = "Succeeded"
+	// TraceflowPhaseFailed means the traceflow could not be completed, see reason for details.
+	TraceflowPhaseFailed TraceflowPhase = "Failed"
+)
+
+// TraceflowStatus contains the observations reported by agents.
+type TraceflowStatus struct {
+	Phase  TraceflowPhase `json:"phase,omitempty"`
+	Reason string         `json:"reason,omitempty"`
+	// Tag is the dscp value marks the synthetic packet, allocated by everoute-controller.
+	Tag int32 `json:"tag,omitempty"`
+	// StartTime is the time when the tag allocated.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Results reported by agents, the source agent reports the egress half and
+	// the destination agent reports the ingress half.
+	Results []TraceflowNodeResult `json:"results,omitempty"`
+}
+
+type TraceflowRole string
+
+const (
+	TraceflowRoleSender   TraceflowRole = "Sender"
+	TraceflowRoleReceiver TraceflowRole = "Receiver"
+)
+
+// TraceflowNodeResult is the observations on an agent.
+type TraceflowNodeResult struct {
+	// Agent is the name of the agent reports the result.
+	Agent        string                 `json:"agent"`
+	Role         TraceflowRole          `json:"role"`
+	Timestamp    metav1.Time            `json:"timestamp"`
+	Observations []TraceflowObservation `json:"observations,omitempty"`
+}
+
+type TraceflowAction string
+
+const (
+	// TraceflowActionInjected means the packet has been injected into the bridge.
+	TraceflowActionInjected TraceflowAction = "Injected"
+	// TraceflowActionForwarded means the packet forwarded to the next bridge.
+	TraceflowActionForwarded TraceflowAction = "Forwarded"
+	// TraceflowActionDelivered means the packet delivered to the destination endpoint.
+	TraceflowActionDelivered TraceflowAction = "Delivered"
+	// TraceflowActionDropped means the packet dropped in the bridge.
+	TraceflowActionDropped TraceflowAction = "Dropped"
+)
+
+// TraceflowObservation is a table the packet hit.
+type TraceflowObservation struct {
+	Bridge    string          `json:"bridge"`
+	TableID   int32           `json:"tableID"`
+	TableName string          `json:"tableName,omitempty"`
+	Action    TraceflowAction `json:"action,omitempty"`
+	// PolicyRule is the datapath rule matched the packet in this table.
+	PolicyRule string `json:"policyRule,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TraceflowList contains a list of Traceflow
+type TraceflowList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Traceflow `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:openapi-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,path=ippools
+// +kubebuilder:printcolumn:name="CIDR",type="string",JSONPath=".spec.cidr"
+// +kubebuilder:printcolumn:name="Gateway",type="string",JSONPath=".spec.gateway"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// IPPool is a range of addresses which pod ips allocated from by everoute ipam,
+// the allocations are recorded in the status of the pool.
+type IPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPPoolSpec   `json:"spec"`
+	Status IPPoolStatus `json:"status,omitempty"`
+}
+
+// IPPoolSpec describes the addresses of the pool and the pods it serves.
+type IPPoolSpec struct {
+	// CIDR of the pool, e.g. 10.0.0.0/24. It must be in the pod cidr of a node, pools
+	// out of the node pod cidr are ignored by the agent of the node. So a pool is per
+	// node, it serves only pods on the node which pod cidr contains the pool.
+	CIDR string `json:"cidr"`
+	// Gateway of the pods allocated from the pool, default the first ip in CIDR.
+	Gateway types.IPAddress `json:"gateway,omitempty"`
+	// Reserved addresses would never be allocated, each item could be an ip, a cidr
+	// or a range like 10.0.0.10-10.0.0.20. Network, broadcast and gateway addresses
+	// are always reserved.
+	Reserved []string `json:"reserved,omitempty"`
+	// NamespaceSelector selects the namespaces of pods the pool serves. This field
+	// follows standard label selector semantics, nil selects all namespaces.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector selects the pods the pool serves. This field follows standard
+	// label selector semantics, nil selects all pods.
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+// IPPoolStatus records the addresses allocated from the pool.
+type IPPoolStatus struct {
+	// Allocations of the pool, keyed by the allocated ip address.
+	Allocations map[types.IPAddress]IPAllocation `json:"allocations,omitempty"`
+}
+
+// IPAllocation is the owner of an allocated ip address.
+type IPAllocation struct {
+	// Namespace of the pod.
+	Namespace string `json:"namespace"`
+	// Pod is the name of the pod.
+	Pod string `json:"pod"`
+	// ContainerID is the infra container id of the pod sandbox.
+	ContainerID string `json:"containerID"`
+	// Node the pod scheduled to, the agent on the node releases the address.
+	Node string `json:"node"`
+	// AllocateTime is the time when the address allocated.
+	AllocateTime metav1.Time `json:"allocateTime"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IPPoolList contains a list of IPPool
+type IPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPPool `json:"items"`
+}
+
+// +genclient
+// +k8s:openapi-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,path=egresspolicies
+// +kubebuilder:printcolumn:name="EgressIP",type="string",JSONPath=".spec.egressIP"
+// +kubebuilder:printcolumn:name="EgressNode",type="string",JSONPath=".status.egressNode"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// EgressPolicy SNATs traffic from the selected pods in its namespace to outside of the
+// cluster with the egress ip, the traffic leaves the cluster from the egress node.
+type EgressPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressPolicySpec   `json:"spec"`
+	Status EgressPolicyStatus `json:"status,omitempty"`
+}
+
+// EgressPolicySpec describes the pods and the egress ip of the policy.
+type EgressPolicySpec struct {
+	// PodSelector selects the pods in the namespace of the policy. This field follows
+	// standard label selector semantics, nil selects all pods in the namespace.
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// EgressIP is the source ip of the traffic after SNAT. It would be assigned to the
+	// interface holding the node ip of the egress node, so it must be in the same subnet.
+	EgressIP types.IPAddress `json:"egressIP"`
+	// NodeSelector selects the candidates of the egress node. This field follows standard
+	// label selector semantics, nil selects all nodes. The first ready candidate sorted by
+	// name is the egress node, the egress ip moves to the next one when the node goes down.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+// EgressPolicyStatus records where the egress ip is assigned.
+type EgressPolicyStatus struct {
+	// EgressNode is the node which owns the egress ip currently.
+	EgressNode string `json:"egressNode,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EgressPolicyList contains a list of EgressPolicy
+type EgressPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressPolicy `json:"items"`
+}
@@ -278,6 +278,26 @@ type EndpointSpec struct {
 	// Type of this Endpoint
 	// +kubebuilder:default="dynamic"
 	Type EndpointType `json:"type,omitempty"`
+
+	// Bandwidth limits traffic of the endpoint, nil if unlimited.
+	Bandwidth *BandwidthLimit `json:"bandwidth,omitempty"`
+}
+
+// BandwidthLimit limits traffic of an endpoint on its ovs interface, rates are in bits per second
+// and bursts are in bits. Zero rate means unlimited in the direction, zero burst means the default.
+type BandwidthLimit struct {
+	// IngressRate limits traffic to the endpoint, shaped by linux-htb qos of the ovs port.
+	// +kubebuilder:validation:Minimum=0
+	IngressRate int64 `json:"ingressRate,omitempty"`
+	// IngressBurst is the burst of traffic to the endpoint.
+	// +kubebuilder:validation:Minimum=0
+	IngressBurst int64 `json:"ingressBurst,omitempty"`
+	// EgressRate limits traffic from the endpoint, policed by ingress policing of the ovs interface.
+	// +kubebuilder:validation:Minimum=0
+	EgressRate int64 `json:"egressRate,omitempty"`
+	// EgressBurst is the burst of traffic from the endpoint.
+	// +kubebuilder:validation:Minimum=0
+	EgressBurst int64 `json:"egressBurst,omitempty"`
 }
 
 // EndpointReference uniquely identifies an endpoint
@@ -27,6 +27,9 @@ import (
 type AgentV1alpha1Interface interface {
 	RESTClient() rest.Interface
 	AgentInfosGetter
+	EgressPoliciesGetter
+	IPPoolsGetter
+	TraceflowsGetter
 }
 
 // AgentV1alpha1Client is used to interact with features provided by the agent.everoute.io group.
@@ -38,6 +41,18 @@ func (c *AgentV1alpha1Client) AgentInfos() AgentInfoInterface {
 	return newAgentInfos(c)
 }
 
+func (c *AgentV1alpha1Client) EgressPolicies(namespace string) EgressPolicyInterface {
+	return newEgressPolicies(c, namespace)
+}
+
+func (c *AgentV1alpha1Client) IPPools() IPPoolInterface {
+	return newIPPools(c)
+}
+
+func (c *AgentV1alpha1Client) Traceflows() TraceflowInterface {
+	return newTraceflows(c)
+}
+
 // NewForConfig creates a new AgentV1alpha1Client for the given config.
 func NewForConfig(c *rest.Config) (*AgentV1alpha1Client, error) {
 	config := *c
@@ -32,6 +32,18 @@ func (c *FakeAgentV1alpha1) AgentInfos() v1alpha1.AgentInfoInterface {
 	return &FakeAgentInfos{c}
 }
 
+func (c *FakeAgentV1alpha1) EgressPolicies(namespace string) v1alpha1.EgressPolicyInterface {
+	return &FakeEgressPolicies{c, namespace}
+}
+
+func (c *FakeAgentV1alpha1) IPPools() v1alpha1.IPPoolInterface {
+	return &FakeIPPools{c}
+}
+
+func (c *FakeAgentV1alpha1) Traceflows() v1alpha1.TraceflowInterface {
+	return &FakeTraceflows{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeAgentV1alpha1) RESTClient() rest.Interface {
@@ -19,3 +19,9 @@ limitations under the License.
 package v1alpha1
 
 type AgentInfoExpansion interface{}
+
+type EgressPolicyExpansion interface{}
+
+type IPPoolExpansion interface{}
+
+type TraceflowExpansion interface{}
@@ -26,6 +26,12 @@ import (
 type Interface interface {
 	// AgentInfos returns a AgentInfoInformer.
 	AgentInfos() AgentInfoInformer
+	// EgressPolicies returns a EgressPolicyInformer.
+	EgressPolicies() EgressPolicyInformer
+	// IPPools returns a IPPoolInformer.
+	IPPools() IPPoolInformer
+	// Traceflows returns a TraceflowInformer.
+	Traceflows() TraceflowInformer
 }
 
 type version struct {
@@ -43,3 +49,18 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 func (v *version) AgentInfos() AgentInfoInformer {
 	return &agentInfoInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
 }
+
+// EgressPolicies returns a EgressPolicyInformer.
+func (v *version) EgressPolicies() EgressPolicyInformer {
+	return &egressPolicyInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// IPPools returns a IPPoolInformer.
+func (v *version) IPPools() IPPoolInformer {
+	return &iPPoolInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
+// Traceflows returns a TraceflowInformer.
+func (v *version) Traceflows() TraceflowInformer {
+	return &traceflowInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
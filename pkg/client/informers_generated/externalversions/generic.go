@@ -57,6 +57,12 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	// Group=agent.everoute.io, Version=v1alpha1
 	case v1alpha1.SchemeGroupVersion.WithResource("agentinfos"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Agent().V1alpha1().AgentInfos().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("egresspolicies"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Agent().V1alpha1().EgressPolicies().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("ippools"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Agent().V1alpha1().IPPools().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("traceflows"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Agent().V1alpha1().Traceflows().Informer()}, nil
 
 		// Group=group.everoute.io, Version=v1alpha1
 	case groupv1alpha1.SchemeGroupVersion.WithResource("endpointgroups"):
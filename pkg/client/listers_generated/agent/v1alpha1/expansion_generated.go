@@ -21,3 +21,19 @@ package v1alpha1
 // AgentInfoListerExpansion allows custom methods to be added to
 // AgentInfoLister.
 type AgentInfoListerExpansion interface{}
+
+// EgressPolicyListerExpansion allows custom methods to be added to
+// EgressPolicyLister.
+type EgressPolicyListerExpansion interface{}
+
+// EgressPolicyNamespaceListerExpansion allows custom methods to be added to
+// EgressPolicyNamespaceLister.
+type EgressPolicyNamespaceListerExpansion interface{}
+
+// IPPoolListerExpansion allows custom methods to be added to
+// IPPoolLister.
+type IPPoolListerExpansion interface{}
+
+// TraceflowListerExpansion allows custom methods to be added to
+// TraceflowLister.
+type TraceflowListerExpansion interface{}
@@ -48,4 +48,24 @@ const (
 	ControllerRuntimeBurst = 2000
 
 	AgentNodeNameENV = "NODE_NAME"
+
+	// DisableSpoofGuardAnnotation set to "true" on Endpoint to skip spoof guard of it
+	DisableSpoofGuardAnnotation = "everoute.io/disable-spoof-guard"
+
+	// IngressBandwidthAnnotation and EgressBandwidthAnnotation set on Pod limit its bandwidth, same as kubenet
+	IngressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	EgressBandwidthAnnotation  = "kubernetes.io/egress-bandwidth"
+
+	// PodInterfacesAnnotation set on Pod by cni records its secondary interfaces in json
+	PodInterfacesAnnotation = "everoute.io/interfaces"
+	// NetworkLabel and InterfaceLabel set on Endpoint of pod secondary interface, are network name and
+	// interface name of the attachment
+	NetworkLabel   = "everoute.io/network"
+	InterfaceLabel = "everoute.io/interface"
+
+	// HostEndpointNamespace is the dedicated namespace of Endpoints represent interfaces of nodes,
+	// policies of nodes should be created in the namespace. NetworkPolicies don't select host endpoints.
+	HostEndpointNamespace = "everoute-host-endpoint"
+	// HostEndpointLabel set to "true" on Endpoint of node, together with labels of the node
+	HostEndpointLabel = "everoute.io/host-endpoint"
 )
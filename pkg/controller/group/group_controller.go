@@ -20,7 +20,10 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -35,6 +38,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
@@ -49,6 +53,10 @@ import (
 type GroupReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	endpointChangeLock sync.Mutex
+	// endpointChangeTime map group name to the time when its endpoint ip addresses changed
+	endpointChangeTime map[string]time.Time
 }
 
 // Reconcile receive endpointgroup from work queue, first it create groupmemberspatch,
@@ -120,7 +128,11 @@ func (r *GroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return nil
+	err = metrics.Registry.Register(r)
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
 }
 
 func (r *GroupReconciler) addEndpoint(e event.CreateEvent, q workqueue.RateLimitingInterface) {
@@ -136,6 +148,7 @@ func (r *GroupReconciler) addEndpoint(e event.CreateEvent, q workqueue.RateLimit
 
 	// Find all endpointgroup keys which match the endpoint's labels.
 	groupNameSet := r.filterEndpointGroupsByEndpoint(context.Background(), endpoint)
+	r.recordEndpointChange(groupNameSet)
 
 	// Enqueue groups to queue for reconciler process.
 	for groupName := range groupNameSet {
@@ -163,6 +176,9 @@ func (r *GroupReconciler) updateEndpoint(e event.UpdateEvent, q workqueue.RateLi
 	ctx := context.Background()
 	oldGroupSet := r.filterEndpointGroupsByEndpoint(ctx, oldEndpoint)
 	newGroupSet := r.filterEndpointGroupsByEndpoint(ctx, newEndpoint)
+	if !utils.EqualIPs(newEndpoint.Status.IPs, oldEndpoint.Status.IPs) {
+		r.recordEndpointChange(oldGroupSet.Union(newGroupSet))
+	}
 
 	for groupName := range oldGroupSet.Union(newGroupSet) {
 		q.Add(ctrl.Request{NamespacedName: k8stypes.NamespacedName{
@@ -434,6 +450,8 @@ func (r *GroupReconciler) processEndpointGroupDelete(ctx context.Context, group
 		return ctrl.Result{}, err
 	}
 
+	r.forgetEndpointChange(group.Name)
+
 	group.ObjectMeta.Finalizers = []string{}
 	err = r.Update(ctx, group)
 	if err != nil {
@@ -451,7 +469,7 @@ func (r *GroupReconciler) processEndpointGroupUpdate(ctx context.Context, group
 		return ctrl.Result{}, err
 	}
 
-	currGroupMembers, err := r.fetchCurrGroupMembers(ctx, &group)
+	currGroupMembers, err := r.FetchCurrGroupMembers(ctx, &group)
 	if err != nil {
 		klog.Errorf("while process endpointgroup %s update, can't fetch curr groupmembers: %s", group.Name, err)
 		return ctrl.Result{}, err
@@ -477,6 +495,7 @@ func (r *GroupReconciler) processEndpointGroupUpdate(ctx context.Context, group
 		klog.Errorf("failed to sync patch of revision %d for group %s: %s", members.Revision, group.Name, err)
 		return ctrl.Result{}, err
 	}
+	r.observePatchWritten(group.Name, &patch)
 
 	err = r.syncGroupMembers(ctx, group.Name, members)
 	if err != nil {
@@ -493,8 +512,9 @@ func (r *GroupReconciler) processEndpointGroupUpdate(ctx context.Context, group
 	return ctrl.Result{}, nil
 }
 
-// fetchCurrGroupMembers get endpoints by selector, and return as GroupMembers
-func (r *GroupReconciler) fetchCurrGroupMembers(ctx context.Context, group *groupv1alpha1.EndpointGroup) (*groupv1alpha1.GroupMembers, error) {
+// FetchCurrGroupMembers get endpoints by selector, and return as GroupMembers. It is
+// also used by policy simulator to calculate group members offline.
+func (r *GroupReconciler) FetchCurrGroupMembers(ctx context.Context, group *groupv1alpha1.EndpointGroup) (*groupv1alpha1.GroupMembers, error) {
 	var (
 		matchedNamespaces []string
 		matchedEndpoints  []securityv1alpha1.Endpoint
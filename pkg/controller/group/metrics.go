@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+)
+
+var (
+	groupMembersDesc = prometheus.NewDesc(
+		"everoute_controller_group_members",
+		"Number of members in the group.",
+		[]string{"group"}, nil,
+	)
+
+	patchMembers = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "everoute_controller_group_patch_members",
+		Help:    "Number of added, updated or removed members in each GroupMembersPatch.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"type"})
+	endpointChangeToPatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "everoute_controller_endpoint_change_to_patch_duration_seconds",
+		Help:    "Duration from an endpoint ip address change observed to the GroupMembersPatch written.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	})
+)
+
+// Describe implements prometheus.Collector
+func (r *GroupReconciler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- groupMembersDesc
+	patchMembers.Describe(ch)
+	endpointChangeToPatchDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (r *GroupReconciler) Collect(ch chan<- prometheus.Metric) {
+	patchMembers.Collect(ch)
+	endpointChangeToPatchDuration.Collect(ch)
+
+	groupMembersList := groupv1alpha1.GroupMembersList{}
+	if err := r.List(context.Background(), &groupMembersList); err != nil {
+		klog.Errorf("failed to list groupmembers: %s", err)
+		return
+	}
+	for _, groupMembers := range groupMembersList.Items {
+		ch <- prometheus.MustNewConstMetric(groupMembersDesc, prometheus.GaugeValue, float64(len(groupMembers.GroupMembers)), groupMembers.Name)
+	}
+}
+
+// recordEndpointChange records the time when endpoint ip addresses of the groups changed,
+// the earliest change time of a group is kept until the patch written.
+func (r *GroupReconciler) recordEndpointChange(groupNames sets.String) {
+	r.endpointChangeLock.Lock()
+	defer r.endpointChangeLock.Unlock()
+
+	if r.endpointChangeTime == nil {
+		r.endpointChangeTime = make(map[string]time.Time)
+	}
+	for groupName := range groupNames {
+		if _, ok := r.endpointChangeTime[groupName]; !ok {
+			r.endpointChangeTime[groupName] = time.Now()
+		}
+	}
+}
+
+// observePatchWritten records the patch size, and duration since endpoint ip addresses
+// changed if the patch written. Recorded change would be removed once members synced.
+func (r *GroupReconciler) observePatchWritten(groupName string, patch *groupv1alpha1.GroupMembersPatch) {
+	if !IsEmptyPatch(*patch) {
+		patchMembers.WithLabelValues("added").Observe(float64(len(patch.AddedGroupMembers)))
+		patchMembers.WithLabelValues("updated").Observe(float64(len(patch.UpdatedGroupMembers)))
+		patchMembers.WithLabelValues("removed").Observe(float64(len(patch.RemovedGroupMembers)))
+	}
+
+	r.endpointChangeLock.Lock()
+	defer r.endpointChangeLock.Unlock()
+
+	changeTime, ok := r.endpointChangeTime[groupName]
+	if !ok {
+		return
+	}
+	if !IsEmptyPatch(*patch) {
+		endpointChangeToPatchDuration.Observe(time.Since(changeTime).Seconds())
+	}
+	delete(r.endpointChangeTime, groupName)
+}
+
+// forgetEndpointChange removes recorded endpoint change time of the group.
+func (r *GroupReconciler) forgetEndpointChange(groupName string) {
+	r.endpointChangeLock.Lock()
+	defer r.endpointChangeLock.Unlock()
+	delete(r.endpointChangeTime, groupName)
+}
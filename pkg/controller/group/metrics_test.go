@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	groupv1alpha1 "github.com/everoute/everoute/pkg/apis/group/v1alpha1"
+)
+
+func TestGroupReconcilerMetrics(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = groupv1alpha1.AddToScheme(scheme)
+	members := &groupv1alpha1.GroupMembers{
+		ObjectMeta:   metav1.ObjectMeta{Name: "group01"},
+		GroupMembers: []groupv1alpha1.GroupMember{{}, {}},
+	}
+	r := &GroupReconciler{Client: fake.NewFakeClientWithScheme(scheme, members), Scheme: scheme}
+
+	r.recordEndpointChange(sets.NewString("group01", "group02"))
+	r.observePatchWritten("group01", &groupv1alpha1.GroupMembersPatch{
+		AddedGroupMembers: []groupv1alpha1.GroupMember{{}},
+	})
+	r.observePatchWritten("group02", &groupv1alpha1.GroupMembersPatch{})
+	if len(r.endpointChangeTime) != 0 {
+		t.Errorf("expect endpoint changes cleaned after patch written, got %v", r.endpointChangeTime)
+	}
+
+	expect := `
+# HELP everoute_controller_group_members Number of members in the group.
+# TYPE everoute_controller_group_members gauge
+everoute_controller_group_members{group="group01"} 2
+`
+	err := testutil.CollectAndCompare(r, strings.NewReader(expect), "everoute_controller_group_members")
+	if err != nil {
+		t.Fatalf("unexpect metrics: %s", err)
+	}
+
+	if count := testutil.CollectAndCount(endpointChangeToPatchDuration); count != 1 {
+		t.Errorf("expect 1 endpoint change to patch duration series, got %d", count)
+	}
+	if count := testutil.CollectAndCount(patchMembers); count != 3 {
+		t.Errorf("expect 3 patch members series, got %d", count)
+	}
+}
@@ -154,7 +154,7 @@ func getSecurityPolicy(networkPolicy *networkingv1.NetworkPolicy) *v1alpha1.Secu
 			Tier:          constants.Tier2,
 			SymmetricMode: false,
 			AppliedTo: []v1alpha1.ApplyToPeer{{
-				EndpointSelector: networkPolicy.Spec.PodSelector.DeepCopy(),
+				EndpointSelector: excludeHostEndpoints(&networkPolicy.Spec.PodSelector),
 			}},
 			PolicyTypes: append([]networkingv1.PolicyType{}, networkPolicy.Spec.PolicyTypes...),
 		},
@@ -229,8 +229,25 @@ func getSecurityPolicyPeer(networkPolicyPeer []networkingv1.NetworkPolicyPeer) [
 			EndpointSelector:  peer.PodSelector.DeepCopy(),
 			NamespaceSelector: peer.NamespaceSelector.DeepCopy(),
 		}
+		if peer.PodSelector != nil || peer.NamespaceSelector != nil {
+			netPeer.EndpointSelector = excludeHostEndpoints(peer.PodSelector)
+		}
 		securityPolicyPeer = append(securityPolicyPeer, netPeer)
 	}
 
 	return securityPolicyPeer
 }
+
+// excludeHostEndpoints returns copy of the pod selector, which doesn't select host endpoints in the
+// same namespace. NetworkPolicy selects pods only, nil selector selects all pods.
+func excludeHostEndpoints(podSelector *metav1.LabelSelector) *metav1.LabelSelector {
+	selector := &metav1.LabelSelector{}
+	if podSelector != nil {
+		selector = podSelector.DeepCopy()
+	}
+	selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      constants.HostEndpointLabel,
+		Operator: metav1.LabelSelectorOpDoesNotExist,
+	})
+	return selector
+}
@@ -126,6 +126,13 @@ var _ = Describe("pod controller", func() {
 			Expect(securityPolicy.Spec.Tier).Should(Equal(constants.Tier2))
 			Expect(securityPolicy.Spec.SymmetricMode).Should(BeFalse())
 			Expect(len(securityPolicy.Spec.IngressRules)).Should(Equal(1))
+			// host endpoints of nodes are not pods
+			excludeHostEndpoint := metav1.LabelSelectorRequirement{
+				Key:      constants.HostEndpointLabel,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			}
+			Expect(securityPolicy.Spec.AppliedTo[0].EndpointSelector.MatchExpressions).Should(ContainElement(excludeHostEndpoint))
+			Expect(securityPolicy.Spec.IngressRules[0].From[0].EndpointSelector.MatchExpressions).Should(ContainElement(excludeHostEndpoint))
 
 			Expect(k8sClient.Delete(ctx, networkPolicy)).Should(Succeed())
 			Eventually(func() int {
@@ -0,0 +1,203 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/types"
+	"github.com/everoute/everoute/pkg/utils"
+)
+
+// NodeReconciler watch node and sync to host endpoint, the endpoint in HostEndpointNamespace
+// represents interfaces of the node, policies could select nodes by the endpoint.
+type NodeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// Reconcile receive node from work queue, synchronize the host endpoint
+func (r *NodeReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	klog.Infof("NodeReconciler received node %s reconcile", req.Name)
+
+	node := corev1.Node{}
+	endpointReq := k8stypes.NamespacedName{
+		Namespace: constants.HostEndpointNamespace,
+		Name:      hostEndpointName(req.Name),
+	}
+
+	// delete host endpoint if node is not found
+	if err := r.Get(ctx, req.NamespacedName, &node); client.IgnoreNotFound(err) != nil {
+		klog.Errorf("Get node %s error, err: %s", req.Name, err)
+		return ctrl.Result{}, err
+	} else if err != nil {
+		klog.Infof("Delete host endpoint %s", endpointReq)
+		endpoint := v1alpha1.Endpoint{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      endpointReq.Name,
+				Namespace: endpointReq.Namespace,
+			},
+		}
+		if err = r.Delete(ctx, &endpoint); err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Delete host endpoint %s failed, err: %s", endpointReq, err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	expectEndpoint := hostEndpointOf(&node)
+	endpoint := v1alpha1.Endpoint{}
+	err := r.Get(ctx, endpointReq, &endpoint)
+	switch errors.ReasonForError(err) {
+	case metav1.StatusReasonNotFound:
+		endpoint = *expectEndpoint.DeepCopy()
+		if err := r.Create(ctx, &endpoint); err != nil {
+			klog.Errorf("create host endpoint %s err: %s", endpointReq, err)
+			return ctrl.Result{}, err
+		}
+	case metav1.StatusReasonUnknown: // no error
+		if !reflect.DeepEqual(endpoint.Labels, expectEndpoint.Labels) || !reflect.DeepEqual(endpoint.Spec, expectEndpoint.Spec) {
+			endpoint.Labels, endpoint.Spec = expectEndpoint.Labels, expectEndpoint.Spec
+			if err := r.Update(ctx, &endpoint); err != nil {
+				klog.Errorf("update host endpoint %s err: %s", endpointReq, err)
+				return ctrl.Result{}, err
+			}
+		}
+	default: // other errors
+		klog.Errorf("Get host endpoint error, err: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	// ips of static endpoint are synchronized from node
+	if !reflect.DeepEqual(endpoint.Status, expectEndpoint.Status) {
+		endpoint.Status = expectEndpoint.Status
+		if err := r.Status().Update(ctx, &endpoint); err != nil {
+			klog.Errorf("update host endpoint status %s err: %s", endpointReq, err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// hostEndpointName returns name of the endpoint represents the node.
+func hostEndpointName(nodeName string) string {
+	return "node-" + nodeName
+}
+
+// hostEndpointOf returns the host endpoint of the node. The endpoint is labeled with labels of the node
+// and HostEndpointLabel, its addresses are addresses of the node and gateway addresses of pod cidrs.
+func hostEndpointOf(node *corev1.Node) *v1alpha1.Endpoint {
+	endpointName := hostEndpointName(node.Name)
+	labels := map[string]string{}
+	for key, value := range node.Labels {
+		labels[key] = value
+	}
+	labels[constants.HostEndpointLabel] = "true"
+
+	return &v1alpha1.Endpoint{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      endpointName,
+			Namespace: constants.HostEndpointNamespace,
+			Labels:    labels,
+		},
+		Spec: v1alpha1.EndpointSpec{
+			Reference: v1alpha1.EndpointReference{
+				ExternalIDName: "node-uuid",
+				ExternalIDValue: utils.EncodeNamespacedName(k8stypes.NamespacedName{
+					Name:      endpointName,
+					Namespace: constants.HostEndpointNamespace,
+				}),
+			},
+			Type: v1alpha1.EndpointStatic,
+		},
+		Status: v1alpha1.EndpointStatus{
+			IPs:    nodeIPs(node),
+			Agents: []string{node.Name},
+		},
+	}
+}
+
+// nodeIPs returns internal and external addresses of the node, and the first address of each pod
+// cidr, which is assigned to gateway of the node by cni.
+func nodeIPs(node *corev1.Node) []types.IPAddress {
+	var ips []types.IPAddress
+	seen := sets.NewString()
+	addIP := func(addr net.IP) {
+		if addr != nil && !seen.Has(addr.String()) {
+			seen.Insert(addr.String())
+			ips = append(ips, types.IPAddress(addr.String()))
+		}
+	}
+
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+			addIP(net.ParseIP(address.Address))
+		}
+	}
+	podCIDRs := node.Spec.PodCIDRs
+	if len(podCIDRs) == 0 && node.Spec.PodCIDR != "" {
+		podCIDRs = []string{node.Spec.PodCIDR}
+	}
+	for _, podCIDR := range podCIDRs {
+		if _, cidr, err := net.ParseCIDR(podCIDR); err == nil {
+			addIP(ip.NextIP(cidr.IP))
+		}
+	}
+	return ips
+}
+
+// SetupWithManager create and add Node Controller to the manager.
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+
+	c, err := controller.New("node-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = c.Watch(&source.Kind{Type: &corev1.Node{}}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+
+	return nil
+}
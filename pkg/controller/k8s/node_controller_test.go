@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+	everoutetypes "github.com/everoute/everoute/pkg/types"
+)
+
+var _ = Describe("node controller", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: constants.HostEndpointNamespace}}
+		if err := k8sClient.Create(ctx, namespace); !errors.IsAlreadyExists(err) {
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+	})
+
+	Context("Test add node", func() {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node1",
+				Labels: map[string]string{
+					TestLabelKey: TestLabelValue,
+					"label1":     "value1",
+				},
+			},
+			Spec: corev1.NodeSpec{
+				PodCIDRs: []string{"10.0.1.0/24", "fd00:1::/64"},
+			},
+		}
+		endpointReq := types.NamespacedName{
+			Name:      "node-" + node.Name,
+			Namespace: constants.HostEndpointNamespace,
+		}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, node.DeepCopy())).Should(Succeed())
+		})
+		AfterEach(func() {
+			Eventually(func() int {
+				nodeList := corev1.NodeList{}
+				Expect(k8sClient.List(ctx, &nodeList, client.MatchingLabels{TestLabelKey: TestLabelValue})).Should(Succeed())
+				for index := range nodeList.Items {
+					Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &nodeList.Items[index]))).Should(Succeed())
+				}
+				Expect(k8sClient.List(ctx, &nodeList, client.MatchingLabels{TestLabelKey: TestLabelValue})).Should(Succeed())
+				return len(nodeList.Items)
+			}, time.Minute, interval).Should(BeZero())
+
+			Eventually(func() int {
+				endpointList := securityv1alpha1.EndpointList{}
+				Expect(k8sClient.List(ctx, &endpointList)).Should(Succeed())
+				return len(endpointList.Items)
+			}, time.Minute, interval).Should(BeZero())
+		})
+
+		It("should create and delete a host endpoint", func() {
+			endpoint := securityv1alpha1.Endpoint{}
+			Eventually(func() []everoutetypes.IPAddress {
+				_ = k8sClient.Get(ctx, endpointReq, &endpoint)
+				return endpoint.Status.IPs
+			}, timeout, interval).Should(ConsistOf(everoutetypes.IPAddress("10.0.1.1"), everoutetypes.IPAddress("fd00:1::1")))
+			Expect(endpoint.Status.Agents).Should(Equal([]string{node.Name}))
+			Expect(endpoint.Spec.Type).Should(Equal(securityv1alpha1.EndpointStatic))
+			Expect(endpoint.Labels).Should(HaveKeyWithValue("label1", "value1"))
+			Expect(endpoint.Labels).Should(HaveKeyWithValue(constants.HostEndpointLabel, "true"))
+
+			Expect(k8sClient.Delete(ctx, node)).Should(Succeed())
+			Eventually(func() int {
+				endpointList := securityv1alpha1.EndpointList{}
+				Expect(k8sClient.List(ctx, &endpointList)).Should(Succeed())
+				return len(endpointList.Items)
+			}, timeout, interval).Should(BeZero())
+		})
+
+		It("should update host endpoint with node labels and addresses", func() {
+			Eventually(func() error {
+				return k8sClient.Get(ctx, endpointReq, &securityv1alpha1.Endpoint{})
+			}, timeout, interval).Should(Succeed())
+
+			nodeGet := corev1.Node{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: node.Name}, &nodeGet)).Should(Succeed())
+			nodeGet.Labels["label1"] = "value2"
+			Expect(k8sClient.Update(ctx, &nodeGet)).Should(Succeed())
+			nodeGet.Status.Addresses = []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.1.10"},
+				{Type: corev1.NodeHostName, Address: node.Name},
+			}
+			Expect(k8sClient.Status().Update(ctx, &nodeGet)).Should(Succeed())
+
+			Eventually(func() map[string]string {
+				endpoint := securityv1alpha1.Endpoint{}
+				Expect(k8sClient.Get(ctx, endpointReq, &endpoint)).Should(Succeed())
+				return endpoint.Labels
+			}, timeout, interval).Should(HaveKeyWithValue("label1", "value2"))
+			Eventually(func() []everoutetypes.IPAddress {
+				endpoint := securityv1alpha1.Endpoint{}
+				Expect(k8sClient.Get(ctx, endpointReq, &endpoint)).Should(Succeed())
+				return endpoint.Status.IPs
+			}, timeout, interval).Should(ConsistOf(everoutetypes.IPAddress("192.168.1.10"),
+				everoutetypes.IPAddress("10.0.1.1"), everoutetypes.IPAddress("fd00:1::1")))
+		})
+	})
+})
@@ -19,6 +19,7 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
@@ -26,6 +27,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -68,6 +70,11 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			klog.Errorf("Delete Endpoint %s failed, err: %s", endpointName, err)
 			return ctrl.Result{}, err
 		}
+		// endpoints of secondary interfaces are also deleted by garbage collector with their owner
+		if err = r.syncSecondaryEndpoints(ctx, req.NamespacedName, nil); err != nil {
+			klog.Errorf("Delete secondary endpoints of pod %s failed, err: %s", req.NamespacedName, err)
+			return ctrl.Result{}, err
+		}
 		return ctrl.Result{}, nil
 	}
 
@@ -94,6 +101,7 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			Namespace: req.Namespace,
 		})
 		endpoint.Spec.Type = v1alpha1.EndpointStatic
+		endpoint.Spec.Bandwidth = podBandwidth(&pod)
 		endpoint.ObjectMeta.Labels = map[string]string{}
 		for key, value := range pod.ObjectMeta.Labels {
 			endpoint.ObjectMeta.Labels[key] = value
@@ -104,11 +112,12 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, err
 		}
 	case metav1.StatusReasonUnknown: // no error
-		// update pod label
+		// update pod label and bandwidth
 		endpoint.ObjectMeta.Labels = map[string]string{} // clear old labels
 		for key, value := range pod.ObjectMeta.Labels {
 			endpoint.ObjectMeta.Labels[key] = value
 		}
+		endpoint.Spec.Bandwidth = podBandwidth(&pod)
 		// submit update
 		if err := r.Update(ctx, &endpoint); err != nil {
 			klog.Errorf("update endpoint %s err: %s", endpointName, err)
@@ -116,7 +125,7 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 		// update status
 		endpoint.Status.Agents = []string{pod.Spec.NodeName}
-		endpoint.Status.IPs = []types.IPAddress{types.IPAddress(pod.Status.PodIP)}
+		endpoint.Status.IPs = podIPs(&pod)
 		if err := r.Status().Update(ctx, &endpoint); err != nil {
 			klog.Errorf("update endpoint status %s err: %s", endpointName, err)
 			return ctrl.Result{}, err
@@ -127,9 +136,140 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
+	if err := r.syncSecondaryEndpoints(ctx, req.NamespacedName, &pod); err != nil {
+		klog.Errorf("sync secondary endpoints of pod %s err: %s", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// syncSecondaryEndpoints creates or updates endpoints of secondary interfaces recorded on the pod by cni,
+// and deletes endpoints of interfaces removed. All endpoints of the pod are deleted if pod is nil.
+func (r *PodReconciler) syncSecondaryEndpoints(ctx context.Context, podKey k8stypes.NamespacedName, pod *corev1.Pod) error {
+	expectEndpoints := make(map[string]*v1alpha1.Endpoint)
+	if pod != nil {
+		interfaces, err := utils.PodInterfacesFromAnnotations(pod.Annotations)
+		if err != nil {
+			klog.Errorf("ignore secondary interfaces of pod %s: %s", podKey, err)
+			return nil
+		}
+		for _, item := range interfaces {
+			endpoint := secondaryEndpointOf(pod, item)
+			expectEndpoints[endpoint.Name] = endpoint
+		}
+	}
+
+	var endpointList v1alpha1.EndpointList
+	if err := r.List(ctx, &endpointList, client.InNamespace(podKey.Namespace)); err != nil {
+		return err
+	}
+	for index := range endpointList.Items {
+		endpoint := &endpointList.Items[index]
+		if !isOwnedByPod(endpoint, podKey.Name) {
+			continue
+		}
+		expectEndpoint, ok := expectEndpoints[endpoint.Name]
+		if !ok {
+			klog.Infof("Delete endpoint %s of pod secondary interface", endpoint.Name)
+			if err := r.Delete(ctx, endpoint); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		delete(expectEndpoints, endpoint.Name)
+		if reflect.DeepEqual(endpoint.Labels, expectEndpoint.Labels) && reflect.DeepEqual(endpoint.Spec, expectEndpoint.Spec) {
+			continue
+		}
+		endpoint.Labels, endpoint.Spec = expectEndpoint.Labels, expectEndpoint.Spec
+		if err := r.Update(ctx, endpoint); err != nil {
+			return err
+		}
+	}
+
+	for _, endpoint := range expectEndpoints {
+		klog.Infof("Create endpoint %s of pod secondary interface", endpoint.Name)
+		if err := r.Create(ctx, endpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secondaryEndpointOf returns the endpoint of the pod secondary interface. The endpoint is labeled with
+// labels of the pod, and its network and interface name. Its addresses are learned by agent.
+func secondaryEndpointOf(pod *corev1.Pod, podInterface types.PodInterface) *v1alpha1.Endpoint {
+	endpointName := utils.SecondaryEndpointName(pod.Name, podInterface.Interface)
+	labels := map[string]string{}
+	for key, value := range pod.Labels {
+		labels[key] = value
+	}
+	for key, value := range map[string]string{
+		constants.NetworkLabel:   podInterface.Network,
+		constants.InterfaceLabel: podInterface.Interface,
+	} {
+		if value != "" && len(validation.IsValidLabelValue(value)) == 0 {
+			labels[key] = value
+		}
+	}
+
+	isController := true
+	return &v1alpha1.Endpoint{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      endpointName,
+			Namespace: pod.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+				Controller: &isController,
+			}},
+		},
+		Spec: v1alpha1.EndpointSpec{
+			VID: uint32(podInterface.VLAN),
+			Reference: v1alpha1.EndpointReference{
+				ExternalIDName: "iface-id",
+				ExternalIDValue: utils.EncodeNamespacedName(k8stypes.NamespacedName{
+					Name:      endpointName,
+					Namespace: pod.Namespace,
+				}),
+			},
+			Type: v1alpha1.EndpointDynamic,
+		},
+	}
+}
+
+// isOwnedByPod returns true if the endpoint is of secondary interface of the pod.
+func isOwnedByPod(endpoint *v1alpha1.Endpoint, podName string) bool {
+	owner := metav1.GetControllerOf(endpoint)
+	return owner != nil && owner.Kind == "Pod" && owner.APIVersion == corev1.SchemeGroupVersion.String() && owner.Name == podName
+}
+
+// podIPs returns addresses of all ip families of the pod, PodIPs would be empty
+// on cluster not enables dual-stack.
+func podIPs(pod *corev1.Pod) []types.IPAddress {
+	if len(pod.Status.PodIPs) == 0 {
+		return []types.IPAddress{types.IPAddress(pod.Status.PodIP)}
+	}
+	ips := make([]types.IPAddress, 0, len(pod.Status.PodIPs))
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, types.IPAddress(podIP.IP))
+	}
+	return ips
+}
+
+// podBandwidth returns bandwidth limit of the pod annotations, invalid annotations are ignored.
+func podBandwidth(pod *corev1.Pod) *v1alpha1.BandwidthLimit {
+	bandwidth, err := utils.BandwidthLimitFromAnnotations(pod.Annotations)
+	if err != nil {
+		klog.Errorf("ignore bandwidth of pod %s/%s: %s", pod.Namespace, pod.Name, err)
+		return nil
+	}
+	return bandwidth
+}
+
 // SetupWithManager create and add Endpoint Controller to the manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if mgr == nil {
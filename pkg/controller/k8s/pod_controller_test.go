@@ -23,11 +23,14 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+	everoutetypes "github.com/everoute/everoute/pkg/types"
 	"github.com/everoute/everoute/pkg/utils"
 )
 
@@ -152,6 +155,111 @@ var _ = Describe("pod controller", func() {
 
 		})
 
+		It("should update endpoint ips with dual-stack pod ips", func() {
+			Eventually(func() int {
+				endpointList := securityv1alpha1.EndpointList{}
+				Expect(k8sClient.List(ctx, &endpointList)).Should(Succeed())
+				return len(endpointList.Items)
+			}, time.Minute, interval).Should(Equal(1))
+
+			podGet := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, podReq, podGet)).Should(Succeed())
+			podGet.Status.PodIP = "10.0.0.2"
+			podGet.Status.PodIPs = []corev1.PodIP{{IP: "10.0.0.2"}, {IP: "fd00::2"}}
+			Expect(k8sClient.Status().Update(ctx, podGet)).Should(Succeed())
+
+			Eventually(func() []everoutetypes.IPAddress {
+				Expect(k8sClient.Get(ctx, endpointReq, &endpoint)).Should(Succeed())
+				return endpoint.Status.IPs
+			}, timeout, interval).Should(ConsistOf(everoutetypes.IPAddress("10.0.0.2"), everoutetypes.IPAddress("fd00::2")))
+		})
+
+		It("should update endpoint bandwidth with pod bandwidth annotations", func() {
+			Eventually(func() int {
+				endpointList := securityv1alpha1.EndpointList{}
+				Expect(k8sClient.List(ctx, &endpointList)).Should(Succeed())
+				return len(endpointList.Items)
+			}, time.Minute, interval).Should(Equal(1))
+
+			podGet := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, podReq, podGet)).Should(Succeed())
+			podGet.Annotations = map[string]string{
+				constants.IngressBandwidthAnnotation: "10M",
+				constants.EgressBandwidthAnnotation:  "20M",
+			}
+			Expect(k8sClient.Update(ctx, podGet)).Should(Succeed())
+
+			Eventually(func() *securityv1alpha1.BandwidthLimit {
+				Expect(k8sClient.Get(ctx, endpointReq, &endpoint)).Should(Succeed())
+				return endpoint.Spec.Bandwidth
+			}, timeout, interval).Should(Equal(&securityv1alpha1.BandwidthLimit{IngressRate: 10000000, EgressRate: 20000000}))
+
+			Expect(k8sClient.Get(ctx, podReq, podGet)).Should(Succeed())
+			podGet.Annotations = nil
+			Expect(k8sClient.Update(ctx, podGet)).Should(Succeed())
+
+			Eventually(func() *securityv1alpha1.BandwidthLimit {
+				Expect(k8sClient.Get(ctx, endpointReq, &endpoint)).Should(Succeed())
+				return endpoint.Spec.Bandwidth
+			}, timeout, interval).Should(BeNil())
+		})
+
+		It("should create and delete endpoints of pod secondary interfaces", func() {
+			Eventually(func() int {
+				endpointList := securityv1alpha1.EndpointList{}
+				Expect(k8sClient.List(ctx, &endpointList)).Should(Succeed())
+				return len(endpointList.Items)
+			}, time.Minute, interval).Should(Equal(1))
+
+			podGet := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, podReq, podGet)).Should(Succeed())
+			podGet.Annotations = map[string]string{
+				constants.PodInterfacesAnnotation: utils.PodInterfacesAnnotationValue([]everoutetypes.PodInterface{
+					{Interface: "net1", Network: "vlan100", VDS: "vds1", VLAN: 100},
+					{Interface: "net2", Network: "invalid/network", VDS: "vds2"},
+				}),
+			}
+			Expect(k8sClient.Update(ctx, podGet)).Should(Succeed())
+
+			secondaryReq := types.NamespacedName{Name: utils.SecondaryEndpointName(pod.Name, "net1"), Namespace: pod.Namespace}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, secondaryReq, &endpoint)
+			}, timeout, interval).Should(Succeed())
+			Expect(endpoint.Spec.VID).Should(Equal(uint32(100)))
+			Expect(endpoint.Spec.Type).Should(Equal(securityv1alpha1.EndpointDynamic))
+			Expect(endpoint.Spec.Reference.ExternalIDName).Should(Equal("iface-id"))
+			Expect(endpoint.Spec.Reference.ExternalIDValue).Should(Equal(utils.EncodeNamespacedName(secondaryReq)))
+			Expect(endpoint.ObjectMeta.Labels).Should(Equal(map[string]string{
+				TestLabelKey:             TestLabelValue,
+				"label1":                 "value1",
+				constants.NetworkLabel:   "vlan100",
+				constants.InterfaceLabel: "net1",
+			}))
+			Expect(endpoint.ObjectMeta.OwnerReferences).Should(HaveLen(1))
+			Expect(endpoint.ObjectMeta.OwnerReferences[0].Name).Should(Equal(pod.Name))
+
+			Eventually(func() int {
+				endpointList := securityv1alpha1.EndpointList{}
+				Expect(k8sClient.List(ctx, &endpointList)).Should(Succeed())
+				return len(endpointList.Items)
+			}, timeout, interval).Should(Equal(3))
+
+			Expect(k8sClient.Get(ctx, podReq, podGet)).Should(Succeed())
+			podGet.Annotations[constants.PodInterfacesAnnotation] = utils.PodInterfacesAnnotationValue([]everoutetypes.PodInterface{
+				{Interface: "net2", Network: "invalid/network", VDS: "vds2"},
+			})
+			Expect(k8sClient.Update(ctx, podGet)).Should(Succeed())
+
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, secondaryReq, &endpoint))
+			}, timeout, interval).Should(BeTrue())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      utils.SecondaryEndpointName(pod.Name, "net2"),
+				Namespace: pod.Namespace,
+			}, &endpoint)).Should(Succeed())
+			Expect(endpoint.ObjectMeta.Labels).ShouldNot(HaveKey(constants.NetworkLabel))
+		})
+
 		It("should update an endpoint - remove a label", func() {
 			Eventually(func() int {
 				endpointList := securityv1alpha1.EndpointList{}
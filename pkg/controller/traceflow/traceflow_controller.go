@@ -0,0 +1,300 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package traceflow
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+const (
+	// maxTag is the max dscp value could be used as traceflow tag.
+	maxTag = 63
+	// defaultTimeout is the default traceflow timeout in seconds.
+	defaultTimeout = 20
+	// allocateRetryInterval is the interval of retry allocate tag when all tags are in use.
+	allocateRetryInterval = 2 * time.Second
+)
+
+// TraceflowReconciler allocate tag for new traceflows, and complete traceflows when all
+// expected results reported or timeout.
+type TraceflowReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	tagLock sync.Mutex
+	// tags contains allocated tags, map tag to the traceflow name
+	tags map[int32]string
+}
+
+// Reconcile receive traceflow from work queue, allocate or release tag for it.
+func (r *TraceflowReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	klog.V(2).Infof("TraceflowReconciler received traceflow %s reconcile", req.Name)
+
+	r.tagLock.Lock()
+	defer r.tagLock.Unlock()
+
+	var traceflow agentv1alpha1.Traceflow
+	if err := r.Get(ctx, req.NamespacedName, &traceflow); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			r.releaseTagLocked(req.Name)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	switch traceflow.Status.Phase {
+	case "":
+		return r.startTraceflowLocked(ctx, &traceflow)
+	case agentv1alpha1.TraceflowPhaseRunning:
+		return r.checkTraceflowLocked(ctx, &traceflow)
+	default:
+		r.releaseTagLocked(req.Name)
+		return ctrl.Result{}, nil
+	}
+}
+
+// SetupWithManager create and add traceflow controller to the manager.
+func (r *TraceflowReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if mgr == nil {
+		return fmt.Errorf("can't setup with nil manager")
+	}
+	r.tags = make(map[int32]string)
+
+	c, err := controller.New("traceflow-controller", mgr, controller.Options{
+		MaxConcurrentReconciles: constants.DefaultMaxConcurrentReconciles,
+		Reconciler:              r,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(&source.Kind{Type: &agentv1alpha1.Traceflow{}}, &handler.EnqueueRequestForObject{})
+}
+
+func (r *TraceflowReconciler) startTraceflowLocked(ctx context.Context, traceflow *agentv1alpha1.Traceflow) (ctrl.Result, error) {
+	if err := r.checkIPv4(ctx, traceflow); err != nil {
+		// agents only trace ipv4 packet, fail the traceflow instead of waiting for results timeout
+		traceflow.Status.Phase = agentv1alpha1.TraceflowPhaseFailed
+		traceflow.Status.Reason = err.Error()
+		if err = r.Status().Update(ctx, traceflow); err != nil {
+			klog.Errorf("failed to update traceflow %s status: %s", traceflow.Name, err)
+			return ctrl.Result{}, err
+		}
+		klog.Infof("traceflow %s has been rejected: %s", traceflow.Name, traceflow.Status.Reason)
+		return ctrl.Result{}, nil
+	}
+
+	var traceflowList agentv1alpha1.TraceflowList
+	if err := r.List(ctx, &traceflowList); err != nil {
+		klog.Errorf("unable to list traceflows: %s", err)
+		return ctrl.Result{}, err
+	}
+	// tags allocated before controller restart should not be reused
+	for _, item := range traceflowList.Items {
+		if item.Status.Phase == agentv1alpha1.TraceflowPhaseRunning && item.Status.Tag != 0 {
+			r.tags[item.Status.Tag] = item.Name
+		}
+	}
+
+	tag := allocateTag(r.tags)
+	if tag == 0 {
+		klog.Infof("no tag available for traceflow %s, wait for other traceflows complete", traceflow.Name)
+		return ctrl.Result{RequeueAfter: allocateRetryInterval}, nil
+	}
+
+	startTime := metav1.Now()
+	traceflow.Status.Phase = agentv1alpha1.TraceflowPhaseRunning
+	traceflow.Status.Tag = tag
+	traceflow.Status.StartTime = &startTime
+	if err := r.Status().Update(ctx, traceflow); err != nil {
+		klog.Errorf("failed to update traceflow %s status: %s", traceflow.Name, err)
+		return ctrl.Result{}, err
+	}
+	r.tags[tag] = traceflow.Name
+	klog.Infof("traceflow %s started with tag %d", traceflow.Name, tag)
+
+	return ctrl.Result{RequeueAfter: timeoutOf(traceflow)}, nil
+}
+
+func (r *TraceflowReconciler) checkTraceflowLocked(ctx context.Context, traceflow *agentv1alpha1.Traceflow) (ctrl.Result, error) {
+	r.tags[traceflow.Status.Tag] = traceflow.Name
+
+	expectReceiver, err := r.hasDestinationEndpoint(ctx, traceflow)
+	if err != nil {
+		klog.Errorf("unable to fetch traceflow %s destination: %s", traceflow.Name, err)
+		return ctrl.Result{}, err
+	}
+
+	var remaining time.Duration
+	if traceflow.Status.StartTime != nil {
+		remaining = timeoutOf(traceflow) - time.Since(traceflow.Status.StartTime.Time)
+	}
+
+	switch {
+	case isTraceflowCompleted(&traceflow.Status, expectReceiver):
+		traceflow.Status.Phase = agentv1alpha1.TraceflowPhaseSucceeded
+	case remaining <= 0:
+		traceflow.Status.Phase = agentv1alpha1.TraceflowPhaseFailed
+		traceflow.Status.Reason = "timeout waiting for agents report results"
+	default:
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	if err = r.Status().Update(ctx, traceflow); err != nil {
+		klog.Errorf("failed to update traceflow %s status: %s", traceflow.Name, err)
+		return ctrl.Result{}, err
+	}
+	r.releaseTagLocked(traceflow.Name)
+	klog.Infof("traceflow %s has been completed with phase %s", traceflow.Name, traceflow.Status.Phase)
+
+	return ctrl.Result{}, nil
+}
+
+// hasDestinationEndpoint returns true if the destination of traceflow is a known endpoint,
+// then the agent where the endpoint located is expected to report results.
+func (r *TraceflowReconciler) hasDestinationEndpoint(ctx context.Context, traceflow *agentv1alpha1.Traceflow) (bool, error) {
+	destination := traceflow.Spec.Destination
+
+	if destination.Endpoint != "" {
+		var endpoint securityv1alpha1.Endpoint
+		err := r.Get(ctx, k8stypes.NamespacedName{Namespace: destination.Namespace, Name: destination.Endpoint}, &endpoint)
+		if err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		return len(endpoint.Status.Agents) != 0, nil
+	}
+
+	var endpointList securityv1alpha1.EndpointList
+	if err := r.List(ctx, &endpointList); err != nil {
+		return false, err
+	}
+	dstIP := net.ParseIP(string(destination.IP))
+	for _, endpoint := range endpointList.Items {
+		for _, ip := range endpoint.Status.IPs {
+			if dstIP != nil && dstIP.Equal(net.ParseIP(string(ip))) && len(endpoint.Status.Agents) != 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// checkIPv4 returns error if the source or destination of traceflow has no ipv4 address, only
+// ipv4 packet is supported by traceflow. Endpoints not found are checked by agents.
+func (r *TraceflowReconciler) checkIPv4(ctx context.Context, traceflow *agentv1alpha1.Traceflow) error {
+	source, destination := traceflow.Spec.Source, traceflow.Spec.Destination
+
+	var endpoint securityv1alpha1.Endpoint
+	err := r.Get(ctx, k8stypes.NamespacedName{Namespace: source.Namespace, Name: source.Endpoint}, &endpoint)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil && !hasIPv4(endpoint.Status.IPs) {
+		return fmt.Errorf("only ipv4 traceflow is supported, source endpoint %s/%s has no ipv4 address", source.Namespace, source.Endpoint)
+	}
+
+	if destination.Endpoint == "" {
+		if !hasIPv4([]types.IPAddress{destination.IP}) {
+			return fmt.Errorf("only ipv4 traceflow is supported, destination ip %s is not ipv4", destination.IP)
+		}
+		return nil
+	}
+	endpoint = securityv1alpha1.Endpoint{}
+	err = r.Get(ctx, k8stypes.NamespacedName{Namespace: destination.Namespace, Name: destination.Endpoint}, &endpoint)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil && !hasIPv4(endpoint.Status.IPs) {
+		return fmt.Errorf("only ipv4 traceflow is supported, destination endpoint %s/%s has no ipv4 address", destination.Namespace, destination.Endpoint)
+	}
+	return nil
+}
+
+func (r *TraceflowReconciler) releaseTagLocked(name string) {
+	for tag, traceflowName := range r.tags {
+		if traceflowName == name {
+			delete(r.tags, tag)
+		}
+	}
+}
+
+// allocateTag returns the min unused tag, or zero if all tags are in use.
+func allocateTag(tags map[int32]string) int32 {
+	for tag := int32(1); tag <= maxTag; tag++ {
+		if _, ok := tags[tag]; !ok {
+			return tag
+		}
+	}
+	return 0
+}
+
+// isTraceflowCompleted returns true if the sender has reported results, and the packet has
+// been dropped, or the receiver has reported results when receiver is expected.
+func isTraceflowCompleted(status *agentv1alpha1.TraceflowStatus, expectReceiver bool) bool {
+	var hasSender, hasReceiver, dropped bool
+
+	for _, result := range status.Results {
+		switch result.Role {
+		case agentv1alpha1.TraceflowRoleSender:
+			hasSender = true
+		case agentv1alpha1.TraceflowRoleReceiver:
+			hasReceiver = true
+		}
+		for _, observation := range result.Observations {
+			if observation.Action == agentv1alpha1.TraceflowActionDropped {
+				dropped = true
+			}
+		}
+	}
+
+	return hasSender && (dropped || hasReceiver || !expectReceiver)
+}
+
+func hasIPv4(ips []types.IPAddress) bool {
+	for _, ip := range ips {
+		if net.ParseIP(string(ip)).To4() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func timeoutOf(traceflow *agentv1alpha1.Traceflow) time.Duration {
+	if traceflow.Spec.Timeout == 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(traceflow.Spec.Timeout) * time.Second
+}
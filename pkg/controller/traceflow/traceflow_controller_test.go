@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package traceflow
+
+import (
+	"testing"
+
+	agentv1alpha1 "github.com/everoute/everoute/pkg/apis/agent/v1alpha1"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+func TestAllocateTag(t *testing.T) {
+	tags := make(map[int32]string)
+	if tag := allocateTag(tags); tag != 1 {
+		t.Errorf("expect allocate tag 1, got %d", tag)
+	}
+
+	tags[1] = "traceflow01"
+	tags[3] = "traceflow03"
+	if tag := allocateTag(tags); tag != 2 {
+		t.Errorf("expect allocate tag 2, got %d", tag)
+	}
+
+	for tag := int32(1); tag <= maxTag; tag++ {
+		tags[tag] = "traceflow"
+	}
+	if tag := allocateTag(tags); tag != 0 {
+		t.Errorf("expect no tag available, got %d", tag)
+	}
+}
+
+func TestIsTraceflowCompleted(t *testing.T) {
+	sender := agentv1alpha1.TraceflowNodeResult{
+		Agent: "agent01",
+		Role:  agentv1alpha1.TraceflowRoleSender,
+		Observations: []agentv1alpha1.TraceflowObservation{
+			{Bridge: "ovsbr0", Action: agentv1alpha1.TraceflowActionInjected},
+		},
+	}
+	droppedSender := agentv1alpha1.TraceflowNodeResult{
+		Agent: "agent01",
+		Role:  agentv1alpha1.TraceflowRoleSender,
+		Observations: []agentv1alpha1.TraceflowObservation{
+			{Bridge: "ovsbr0", Action: agentv1alpha1.TraceflowActionInjected},
+			{Bridge: "ovsbr0-policy", Action: agentv1alpha1.TraceflowActionDropped},
+		},
+	}
+	receiver := agentv1alpha1.TraceflowNodeResult{
+		Agent: "agent02",
+		Role:  agentv1alpha1.TraceflowRoleReceiver,
+		Observations: []agentv1alpha1.TraceflowObservation{
+			{Bridge: "ovsbr0", Action: agentv1alpha1.TraceflowActionDelivered},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		results        []agentv1alpha1.TraceflowNodeResult
+		expectReceiver bool
+		expect         bool
+	}{
+		{name: "no results", expectReceiver: true, expect: false},
+		{name: "wait for receiver", results: []agentv1alpha1.TraceflowNodeResult{sender}, expectReceiver: true, expect: false},
+		{name: "receiver not expected", results: []agentv1alpha1.TraceflowNodeResult{sender}, expectReceiver: false, expect: true},
+		{name: "dropped by sender", results: []agentv1alpha1.TraceflowNodeResult{droppedSender}, expectReceiver: true, expect: true},
+		{name: "delivered", results: []agentv1alpha1.TraceflowNodeResult{sender, receiver}, expectReceiver: true, expect: true},
+		{name: "wait for sender", results: []agentv1alpha1.TraceflowNodeResult{receiver}, expectReceiver: true, expect: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &agentv1alpha1.TraceflowStatus{Results: tt.results}
+			if completed := isTraceflowCompleted(status, tt.expectReceiver); completed != tt.expect {
+				t.Errorf("expect completed %t, got %t", tt.expect, completed)
+			}
+		})
+	}
+}
+
+func TestHasIPv4(t *testing.T) {
+	tests := []struct {
+		name   string
+		ips    []types.IPAddress
+		expect bool
+	}{
+		{name: "no ips", expect: false},
+		{name: "empty ip", ips: []types.IPAddress{""}, expect: false},
+		{name: "ipv4", ips: []types.IPAddress{"10.0.0.1"}, expect: true},
+		{name: "ipv6", ips: []types.IPAddress{"fd00::1"}, expect: false},
+		{name: "dual stack", ips: []types.IPAddress{"fd00::1", "10.0.0.1"}, expect: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if has := hasIPv4(tt.ips); has != tt.expect {
+				t.Errorf("expect has ipv4 %t, got %t", tt.expect, has)
+			}
+		})
+	}
+}
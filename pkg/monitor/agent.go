@@ -105,7 +105,9 @@ type AgentMonitor struct {
 	ovsdbCache                 map[string]map[string]ovsdb.Row
 	ipCacheLock                sync.RWMutex
 	ipCache                    map[string]map[types.IPAddress]metav1.Time
-	ofPortIPAddressMonitorChan chan map[string]net.IP
+	ipOriginCache              map[string]map[types.IPAddress]agentv1alpha1.IPOrigin
+	ipWithdrawnCache           map[string]map[types.IPAddress]bool
+	ofPortIPAddressMonitorChan chan map[string]datapath.LearnedIPAddress
 
 	ovsdbEventHandler                  ovsdbEventHandler
 	localEndpointHardwareAddrCacheLock sync.RWMutex
@@ -116,13 +118,15 @@ type AgentMonitor struct {
 }
 
 // NewAgentMonitor return a new agentMonitor with kubernetes client and ipMonitor.
-func NewAgentMonitor(client client.Client, ofPortIPAddressMonitorChan chan map[string]net.IP) (*AgentMonitor, error) {
+func NewAgentMonitor(client client.Client, ofPortIPAddressMonitorChan chan map[string]datapath.LearnedIPAddress) (*AgentMonitor, error) {
 	monitor := &AgentMonitor{
 		k8sClient:                          client,
 		cacheLock:                          sync.RWMutex{},
 		ipCacheLock:                        sync.RWMutex{},
 		ovsdbCache:                         make(map[string]map[string]ovsdb.Row),
 		ipCache:                            make(map[string]map[types.IPAddress]metav1.Time),
+		ipOriginCache:                      make(map[string]map[types.IPAddress]agentv1alpha1.IPOrigin),
+		ipWithdrawnCache:                   make(map[string]map[types.IPAddress]bool),
 		ofPortIPAddressMonitorChan:         ofPortIPAddressMonitorChan,
 		localEndpointHardwareAddrCache:     make(map[string]uint32),
 		localEndpointHardwareAddrCacheLock: sync.RWMutex{},
@@ -165,7 +169,7 @@ func (monitor *AgentMonitor) Run(stopChan <-chan struct{}) {
 	<-stopChan
 }
 
-func (monitor *AgentMonitor) HandleOfPortIPAddressUpdate(ofPortIPAddressMonitorChan <-chan map[string]net.IP, stopChan <-chan struct{}) {
+func (monitor *AgentMonitor) HandleOfPortIPAddressUpdate(ofPortIPAddressMonitorChan <-chan map[string]datapath.LearnedIPAddress, stopChan <-chan struct{}) {
 	for {
 		select {
 		case localEndpointInfo := <-ofPortIPAddressMonitorChan:
@@ -176,17 +180,42 @@ func (monitor *AgentMonitor) HandleOfPortIPAddressUpdate(ofPortIPAddressMonitorC
 	}
 }
 
-func (monitor *AgentMonitor) updateOfPortIPAddress(localEndpointInfo map[string]net.IP) {
+func (monitor *AgentMonitor) updateOfPortIPAddress(localEndpointInfo map[string]datapath.LearnedIPAddress) {
 	monitor.ipCacheLock.Lock()
 	defer monitor.ipCacheLock.Unlock()
 
-	for bridgePort, ip := range localEndpointInfo {
-		if _, ok := monitor.ipCache[bridgePort]; !ok {
-			monitor.ipCache[bridgePort] = make(map[types.IPAddress]metav1.Time)
+	for bridgePort, learnedIP := range localEndpointInfo {
+		ip := learnedIP.IP
+		if learnedIP.Withdrawn {
+			// withdrawn ip should be removed from the agentinfo in control plane
+			delete(monitor.ipCache[bridgePort], types.IPAddress(ip.String()))
+			delete(monitor.ipOriginCache[bridgePort], types.IPAddress(ip.String()))
+			if monitor.ipWithdrawnCache[bridgePort] == nil {
+				monitor.ipWithdrawnCache[bridgePort] = make(map[types.IPAddress]bool)
+			}
+			monitor.ipWithdrawnCache[bridgePort][types.IPAddress(ip.String())] = true
+			continue
 		}
-		monitor.ipCache[bridgePort] = map[types.IPAddress]metav1.Time{
+		delete(monitor.ipWithdrawnCache[bridgePort], types.IPAddress(ip.String()))
+
+		ipMap := map[types.IPAddress]metav1.Time{
 			types.IPAddress(ip.String()): metav1.NewTime(time.Now()),
 		}
+		ipOriginMap := map[types.IPAddress]agentv1alpha1.IPOrigin{
+			types.IPAddress(ip.String()): learnedIP.Origin,
+		}
+		// new learned ip replaces the old one of the same family, an
+		// endpoint could have both ipv4 and ipv6 address at the same time
+		for oldIP, updateTime := range monitor.ipCache[bridgePort] {
+			if (net.ParseIP(string(oldIP)).To4() != nil) != (ip.To4() != nil) {
+				ipMap[oldIP] = updateTime
+				if origin, ok := monitor.ipOriginCache[bridgePort][oldIP]; ok {
+					ipOriginMap[oldIP] = origin
+				}
+			}
+		}
+		monitor.ipCache[bridgePort] = ipMap
+		monitor.ipOriginCache[bridgePort] = ipOriginMap
 	}
 
 	monitor.syncQueue.Add(monitor.Name())
@@ -319,6 +348,8 @@ func (monitor *AgentMonitor) syncAgentInfo() error {
 		return err
 	}
 	monitor.ipCache = make(map[string]map[types.IPAddress]metav1.Time)
+	monitor.ipOriginCache = make(map[string]map[types.IPAddress]agentv1alpha1.IPOrigin)
+	monitor.ipWithdrawnCache = make(map[string]map[types.IPAddress]bool)
 
 	return nil
 }
@@ -331,7 +362,11 @@ func (monitor *AgentMonitor) mergeAgentInfo(localAgentInfo, cpAgentInfo *agentv1
 				if matchIntf == nil {
 					continue
 				}
+				withdrawnIPs := monitor.ipWithdrawnCache[fmt.Sprintf("%s-%d", ovsBr.Name, intf.Ofport)]
 				for key, value := range matchIntf.IPMap {
+					if withdrawnIPs[key] {
+						continue
+					}
 					if localAgentInfo.OVSInfo.Bridges[i].Ports[j].Interfaces[k].IPMap == nil {
 						localAgentInfo.OVSInfo.Bridges[i].Ports[j].Interfaces[k].IPMap = make(map[types.IPAddress]metav1.Time)
 					}
@@ -339,6 +374,17 @@ func (monitor *AgentMonitor) mergeAgentInfo(localAgentInfo, cpAgentInfo *agentv1
 						localAgentInfo.OVSInfo.Bridges[i].Ports[j].Interfaces[k].IPMap[key] = value
 					}
 				}
+				for key, value := range matchIntf.IPOrigins {
+					if withdrawnIPs[key] {
+						continue
+					}
+					if localAgentInfo.OVSInfo.Bridges[i].Ports[j].Interfaces[k].IPOrigins == nil {
+						localAgentInfo.OVSInfo.Bridges[i].Ports[j].Interfaces[k].IPOrigins = make(map[types.IPAddress]agentv1alpha1.IPOrigin)
+					}
+					if _, ok := intf.IPOrigins[key]; !ok {
+						localAgentInfo.OVSInfo.Bridges[i].Ports[j].Interfaces[k].IPOrigins[key] = value
+					}
+				}
 			}
 		}
 	}
@@ -758,6 +804,7 @@ func (monitor *AgentMonitor) fetchInterfaceLocked(uuid ovsdb.UUID, bridgeName st
 	if ok && ofport >= 0 {
 		iface.Ofport = int32(ofport)
 		iface.IPMap = monitor.ipCache[fmt.Sprintf("%s-%d", bridgeName, iface.Ofport)]
+		iface.IPOrigins = monitor.ipOriginCache[fmt.Sprintf("%s-%d", bridgeName, iface.Ofport)]
 	}
 
 	return &iface
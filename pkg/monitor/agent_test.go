@@ -63,7 +63,7 @@ var (
 	agentName                  string
 	monitor                    *AgentMonitor
 	stopChan                   chan struct{}
-	ofPortIPAddressMonitorChan chan map[string]net.IP
+	ofPortIPAddressMonitorChan chan map[string]datapath.LearnedIPAddress
 	localEndpointLock          sync.RWMutex
 	localEndpointMap           map[uint32]net.HardwareAddr
 )
@@ -459,18 +459,18 @@ func updateInterface(client *ovsdb.OvsdbClient, ifaceName string, externalIDs ma
 	return err
 }
 
-func addOfPortIPAddress(brName string, ofPort uint32, ipAddr net.IP, ofPortIPAddressMonitorChan chan map[string]net.IP) error {
-	ofPortInfo := map[string]net.IP{fmt.Sprintf("%s-%d", brName, ofPort): ipAddr}
+func addOfPortIPAddress(brName string, ofPort uint32, ipAddr net.IP, ofPortIPAddressMonitorChan chan map[string]datapath.LearnedIPAddress) error {
+	ofPortInfo := map[string]datapath.LearnedIPAddress{fmt.Sprintf("%s-%d", brName, ofPort): {IP: ipAddr, Origin: agentv1alpha1.IPOriginARP}}
 	ofPortIPAddressMonitorChan <- ofPortInfo
 	return nil
 }
 
-func updateIPAddress(brName string, ofPort uint32, newIPAddr net.IP, ofPortIPAddressMonitorChan chan map[string]net.IP) error {
+func updateIPAddress(brName string, ofPort uint32, newIPAddr net.IP, ofPortIPAddressMonitorChan chan map[string]datapath.LearnedIPAddress) error {
 	monitor.ipCacheLock.RLock()
 	defer monitor.ipCacheLock.RUnlock()
 
-	ofPortInfo := map[string]net.IP{
-		fmt.Sprintf("%s-%d", brName, ofPort): newIPAddr,
+	ofPortInfo := map[string]datapath.LearnedIPAddress{
+		fmt.Sprintf("%s-%d", brName, ofPort): {IP: newIPAddr, Origin: agentv1alpha1.IPOriginARP},
 	}
 	ofPortIPAddressMonitorChan <- ofPortInfo
 	return nil
@@ -702,8 +702,8 @@ func isNotFoundError(err error) bool {
 	}
 }
 
-func startAgentMonitor(k8sClient client.Client) (*AgentMonitor, chan struct{}, chan map[string]net.IP) {
-	ofPortIPAddressMonitorChan = make(chan map[string]net.IP, 1024)
+func startAgentMonitor(k8sClient client.Client) (*AgentMonitor, chan struct{}, chan map[string]datapath.LearnedIPAddress) {
+	ofPortIPAddressMonitorChan = make(chan map[string]datapath.LearnedIPAddress, 1024)
 	localEndpointMap = make(map[uint32]net.HardwareAddr)
 
 	monitor, err := NewAgentMonitor(k8sClient, ofPortIPAddressMonitorChan)
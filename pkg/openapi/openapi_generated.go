@@ -1,3 +1,4 @@
+//go:build !ignore_autogenerated
 // +build !ignore_autogenerated
 
 /*
@@ -36,10 +37,28 @@ func GetOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenA
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.AgentInfo":             schema_pkg_apis_agent_v1alpha1_AgentInfo(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.AgentInfoList":         schema_pkg_apis_agent_v1alpha1_AgentInfoList(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.BondConfig":            schema_pkg_apis_agent_v1alpha1_BondConfig(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicy":          schema_pkg_apis_agent_v1alpha1_EgressPolicy(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicyList":      schema_pkg_apis_agent_v1alpha1_EgressPolicyList(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicySpec":      schema_pkg_apis_agent_v1alpha1_EgressPolicySpec(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicyStatus":    schema_pkg_apis_agent_v1alpha1_EgressPolicyStatus(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPAllocation":          schema_pkg_apis_agent_v1alpha1_IPAllocation(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPool":                schema_pkg_apis_agent_v1alpha1_IPPool(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolList":            schema_pkg_apis_agent_v1alpha1_IPPoolList(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolSpec":            schema_pkg_apis_agent_v1alpha1_IPPoolSpec(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolStatus":          schema_pkg_apis_agent_v1alpha1_IPPoolStatus(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSBridge":             schema_pkg_apis_agent_v1alpha1_OVSBridge(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSInfo":               schema_pkg_apis_agent_v1alpha1_OVSInfo(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSInterface":          schema_pkg_apis_agent_v1alpha1_OVSInterface(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSPort":               schema_pkg_apis_agent_v1alpha1_OVSPort(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.Traceflow":             schema_pkg_apis_agent_v1alpha1_Traceflow(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowDestination":  schema_pkg_apis_agent_v1alpha1_TraceflowDestination(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowEndpoint":     schema_pkg_apis_agent_v1alpha1_TraceflowEndpoint(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowList":         schema_pkg_apis_agent_v1alpha1_TraceflowList(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowNodeResult":   schema_pkg_apis_agent_v1alpha1_TraceflowNodeResult(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowObservation":  schema_pkg_apis_agent_v1alpha1_TraceflowObservation(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowPacket":       schema_pkg_apis_agent_v1alpha1_TraceflowPacket(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowSpec":         schema_pkg_apis_agent_v1alpha1_TraceflowSpec(ref),
+		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowStatus":       schema_pkg_apis_agent_v1alpha1_TraceflowStatus(ref),
 		"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.VlanConfig":            schema_pkg_apis_agent_v1alpha1_VlanConfig(ref),
 		"github.com/everoute/everoute/pkg/apis/group/v1alpha1.EndpointGroup":         schema_pkg_apis_group_v1alpha1_EndpointGroup(ref),
 		"github.com/everoute/everoute/pkg/apis/group/v1alpha1.EndpointGroupList":     schema_pkg_apis_group_v1alpha1_EndpointGroupList(ref),
@@ -52,6 +71,7 @@ func GetOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenA
 		"github.com/everoute/everoute/pkg/apis/group/v1alpha1.GroupMembersPatchList": schema_pkg_apis_group_v1alpha1_GroupMembersPatchList(ref),
 		"github.com/everoute/everoute/pkg/apis/group/v1alpha1.GroupMembersReference": schema_pkg_apis_group_v1alpha1_GroupMembersReference(ref),
 		"github.com/everoute/everoute/pkg/apis/security/v1alpha1.ApplyToPeer":        schema_pkg_apis_security_v1alpha1_ApplyToPeer(ref),
+		"github.com/everoute/everoute/pkg/apis/security/v1alpha1.BandwidthLimit":     schema_pkg_apis_security_v1alpha1_BandwidthLimit(ref),
 		"github.com/everoute/everoute/pkg/apis/security/v1alpha1.Endpoint":           schema_pkg_apis_security_v1alpha1_Endpoint(ref),
 		"github.com/everoute/everoute/pkg/apis/security/v1alpha1.EndpointList":       schema_pkg_apis_security_v1alpha1_EndpointList(ref),
 		"github.com/everoute/everoute/pkg/apis/security/v1alpha1.EndpointReference":  schema_pkg_apis_security_v1alpha1_EndpointReference(ref),
@@ -525,188 +545,950 @@ func schema_pkg_apis_agent_v1alpha1_BondConfig(ref common.ReferenceCallback) com
 	}
 }
 
+func schema_pkg_apis_agent_v1alpha1_EgressPolicy(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "EgressPolicy SNATs traffic from the selected pods in its namespace to outside of the cluster with the egress ip, the traffic leaves the cluster from the egress node.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicySpec"),
+						},
+					},
+					"status": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicyStatus"),
+						},
+					},
+				},
+				Required: []string{"spec"},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicySpec", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicyStatus", "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_EgressPolicyList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "EgressPolicyList contains a list of EgressPolicy",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicy"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.EgressPolicy", "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_EgressPolicySpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "EgressPolicySpec describes the pods and the egress ip of the policy.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"podSelector": {
+						SchemaProps: spec.SchemaProps{
+							Description: "PodSelector selects the pods in the namespace of the policy. This field follows standard label selector semantics, nil selects all pods in the namespace.",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector"),
+						},
+					},
+					"egressIP": {
+						SchemaProps: spec.SchemaProps{
+							Description: "EgressIP is the source ip of the traffic after SNAT. It would be assigned to the interface holding the node ip of the egress node, so it must be in the same subnet.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"nodeSelector": {
+						SchemaProps: spec.SchemaProps{
+							Description: "NodeSelector selects the candidates of the egress node. This field follows standard label selector semantics, nil selects all nodes. The first ready candidate sorted by name is the egress node, the egress ip moves to the next one when the node goes down.",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector"),
+						},
+					},
+				},
+				Required: []string{"egressIP"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_EgressPolicyStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "EgressPolicyStatus records where the egress ip is assigned.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"egressNode": {
+						SchemaProps: spec.SchemaProps{
+							Description: "EgressNode is the node which owns the egress ip currently.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_IPAllocation(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "IPAllocation is the owner of an allocated ip address.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"namespace": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Namespace of the pod.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"pod": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Pod is the name of the pod.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"containerID": {
+						SchemaProps: spec.SchemaProps{
+							Description: "ContainerID is the infra container id of the pod sandbox.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"node": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Node the pod scheduled to, the agent on the node releases the address.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"allocateTime": {
+						SchemaProps: spec.SchemaProps{
+							Description: "AllocateTime is the time when the address allocated.",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+				},
+				Required: []string{"namespace", "pod", "containerID", "node", "allocateTime"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_IPPool(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "IPPool is a range of addresses which pod ips allocated from by everoute ipam, the allocations are recorded in the status of the pool.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolSpec"),
+						},
+					},
+					"status": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolStatus"),
+						},
+					},
+				},
+				Required: []string{"spec"},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolSpec", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPoolStatus", "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_IPPoolList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "IPPoolList contains a list of IPPool",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPool"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPPool", "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_IPPoolSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "IPPoolSpec describes the addresses of the pool and the pods it serves.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"cidr": {
+						SchemaProps: spec.SchemaProps{
+							Description: "CIDR of the pool, e.g. 10.0.0.0/24.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"gateway": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Gateway of the pods allocated from the pool, default the first ip in CIDR.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"reserved": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Reserved addresses would never be allocated, each item could be an ip, a cidr or a range like 10.0.0.10-10.0.0.20. Network, broadcast and gateway addresses are always reserved.",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Type:   []string{"string"},
+										Format: "",
+									},
+								},
+							},
+						},
+					},
+					"namespaceSelector": {
+						SchemaProps: spec.SchemaProps{
+							Description: "NamespaceSelector selects the namespaces of pods the pool serves. This field follows standard label selector semantics, nil selects all namespaces.",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector"),
+						},
+					},
+					"podSelector": {
+						SchemaProps: spec.SchemaProps{
+							Description: "PodSelector selects the pods the pool serves. This field follows standard label selector semantics, nil selects all pods.",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector"),
+						},
+					},
+				},
+				Required: []string{"cidr"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_IPPoolStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "IPPoolStatus records the addresses allocated from the pool.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"allocations": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Allocations of the pool, keyed by the allocated ip address.",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPAllocation"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.IPAllocation"},
+	}
+}
+
 func schema_pkg_apis_agent_v1alpha1_OVSBridge(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Type: []string{"object"},
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"ports": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSPort"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSPort"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_OVSInfo(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"version": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"bridges": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSBridge"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSBridge"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_OVSInterface(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"externalIDs": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Type:   []string{"string"},
+										Format: "",
+									},
+								},
+							},
+						},
+					},
+					"type": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"ofport": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"integer"},
+							Format: "int32",
+						},
+					},
+					"mac": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"ipmap": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+									},
+								},
+							},
+						},
+					},
+					"ipOrigins": {
+						SchemaProps: spec.SchemaProps{
+							Description: "IPOrigins records where each ip in IPMap learned from.",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Type:   []string{"string"},
+										Format: "",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_OVSPort(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"interfaces": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSInterface"),
+									},
+								},
+							},
+						},
+					},
+					"externalIDs": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Type:   []string{"string"},
+										Format: "",
+									},
+								},
+							},
+						},
+					},
+					"vlanConfig": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.VlanConfig"),
+						},
+					},
+					"bondConfig": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.BondConfig"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.BondConfig", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSInterface", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.VlanConfig"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_Traceflow(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "Traceflow injects a synthetic packet from the source endpoint, and reports the tables it hit and the policy decision made on the path to destination.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowSpec"),
+						},
+					},
+					"status": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowStatus"),
+						},
+					},
+				},
+				Required: []string{"spec"},
+			},
+		},
+		Dependencies: []string{
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowSpec", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowStatus", "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_TraceflowDestination(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "TraceflowDestination is an endpoint or an ip address, only one of them should be set.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"namespace": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Namespace of the endpoint.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"endpoint": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Endpoint is the name of the endpoint.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"ip": {
+						SchemaProps: spec.SchemaProps{
+							Description: "IP address of the destination.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_TraceflowEndpoint(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "TraceflowEndpoint reference to an endpoint.",
+				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
-					"name": {
+					"namespace": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Description: "Namespace of the endpoint.",
+							Type:        []string{"string"},
+							Format:      "",
 						},
 					},
-					"ports": {
+					"endpoint": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Endpoint is the name of the endpoint.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+				},
+				Required: []string{"namespace", "endpoint"},
+			},
+		},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_TraceflowList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "TraceflowList contains a list of Traceflow",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
 						SchemaProps: spec.SchemaProps{
 							Type: []string{"array"},
 							Items: &spec.SchemaOrArray{
 								Schema: &spec.Schema{
 									SchemaProps: spec.SchemaProps{
-										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSPort"),
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.Traceflow"),
 									},
 								},
 							},
 						},
 					},
 				},
+				Required: []string{"items"},
 			},
 		},
 		Dependencies: []string{
-			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSPort"},
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.Traceflow", "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"},
 	}
 }
 
-func schema_pkg_apis_agent_v1alpha1_OVSInfo(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_pkg_apis_agent_v1alpha1_TraceflowNodeResult(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Type: []string{"object"},
+				Description: "TraceflowNodeResult is the observations on an agent.",
+				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
-					"version": {
+					"agent": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Agent is the name of the agent reports the result.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"role": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
 							Format: "",
 						},
 					},
-					"bridges": {
+					"timestamp": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+					"observations": {
 						SchemaProps: spec.SchemaProps{
 							Type: []string{"array"},
 							Items: &spec.SchemaOrArray{
 								Schema: &spec.Schema{
 									SchemaProps: spec.SchemaProps{
-										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSBridge"),
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowObservation"),
 									},
 								},
 							},
 						},
 					},
 				},
+				Required: []string{"agent", "role", "timestamp"},
 			},
 		},
 		Dependencies: []string{
-			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSBridge"},
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowObservation", "k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
 	}
 }
 
-func schema_pkg_apis_agent_v1alpha1_OVSInterface(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_pkg_apis_agent_v1alpha1_TraceflowObservation(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Type: []string{"object"},
+				Description: "TraceflowObservation is a table the packet hit.",
+				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
-					"name": {
+					"bridge": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
 							Format: "",
 						},
 					},
-					"externalIDs": {
+					"tableID": {
 						SchemaProps: spec.SchemaProps{
-							Type: []string{"object"},
-							AdditionalProperties: &spec.SchemaOrBool{
-								Allows: true,
-								Schema: &spec.Schema{
-									SchemaProps: spec.SchemaProps{
-										Type:   []string{"string"},
-										Format: "",
-									},
-								},
-							},
+							Type:   []string{"integer"},
+							Format: "int32",
 						},
 					},
-					"type": {
+					"tableName": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
 							Format: "",
 						},
 					},
-					"ofport": {
+					"action": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"integer"},
-							Format: "int32",
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
-					"mac": {
+					"policyRule": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Description: "PolicyRule is the datapath rule matched the packet in this table.",
+							Type:        []string{"string"},
+							Format:      "",
 						},
 					},
-					"ipmap": {
+				},
+				Required: []string{"bridge", "tableID"},
+			},
+		},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_TraceflowPacket(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "TraceflowPacket describes the transport header of the packet.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"protocol": {
 						SchemaProps: spec.SchemaProps{
-							Type: []string{"object"},
-							AdditionalProperties: &spec.SchemaOrBool{
-								Allows: true,
-								Schema: &spec.Schema{
-									SchemaProps: spec.SchemaProps{
-										Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
-									},
-								},
-							},
+							Description: "Protocol of the packet, TCP, UDP or ICMP.",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"srcPort": {
+						SchemaProps: spec.SchemaProps{
+							Description: "SrcPort is the tcp or udp source port, a random port would be used if not set.",
+							Type:        []string{"integer"},
+							Format:      "int32",
+						},
+					},
+					"dstPort": {
+						SchemaProps: spec.SchemaProps{
+							Description: "DstPort is the tcp or udp destination port.",
+							Type:        []string{"integer"},
+							Format:      "int32",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func schema_pkg_apis_agent_v1alpha1_TraceflowSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "TraceflowSpec describes where the synthetic packet comes from and goes to.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"source": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Source is the endpoint which the packet injected from.",
+							Ref:         ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowEndpoint"),
+						},
+					},
+					"destination": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Destination of the packet, could be an endpoint or an ip address.",
+							Ref:         ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowDestination"),
+						},
+					},
+					"packet": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Packet describes the transport header of the packet, an ICMP echo request would be sent if protocol is empty.",
+							Ref:         ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowPacket"),
+						},
+					},
+					"timeout": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Timeout of the traceflow in seconds, default 20 seconds.",
+							Type:        []string{"integer"},
+							Format:      "int32",
 						},
 					},
 				},
+				Required: []string{"source", "destination"},
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowDestination", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowEndpoint", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowPacket"},
 	}
 }
 
-func schema_pkg_apis_agent_v1alpha1_OVSPort(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_pkg_apis_agent_v1alpha1_TraceflowStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Type: []string{"object"},
+				Description: "TraceflowStatus contains the observations reported by agents.",
+				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
-					"name": {
+					"phase": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
 							Format: "",
 						},
 					},
-					"interfaces": {
+					"reason": {
 						SchemaProps: spec.SchemaProps{
-							Type: []string{"array"},
-							Items: &spec.SchemaOrArray{
-								Schema: &spec.Schema{
-									SchemaProps: spec.SchemaProps{
-										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSInterface"),
-									},
-								},
-							},
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
-					"externalIDs": {
+					"tag": {
 						SchemaProps: spec.SchemaProps{
-							Type: []string{"object"},
-							AdditionalProperties: &spec.SchemaOrBool{
-								Allows: true,
-								Schema: &spec.Schema{
-									SchemaProps: spec.SchemaProps{
-										Type:   []string{"string"},
-										Format: "",
-									},
-								},
-							},
+							Description: "Tag is the dscp value marks the synthetic packet, allocated by everoute-controller.",
+							Type:        []string{"integer"},
+							Format:      "int32",
 						},
 					},
-					"vlanConfig": {
+					"startTime": {
 						SchemaProps: spec.SchemaProps{
-							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.VlanConfig"),
+							Description: "StartTime is the time when the tag allocated.",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
 						},
 					},
-					"bondConfig": {
+					"results": {
 						SchemaProps: spec.SchemaProps{
-							Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.BondConfig"),
+							Description: "Results reported by agents, the source agent reports the egress half and the destination agent reports the ingress half.",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowNodeResult"),
+									},
+								},
+							},
 						},
 					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.BondConfig", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.OVSInterface", "github.com/everoute/everoute/pkg/apis/agent/v1alpha1.VlanConfig"},
+			"github.com/everoute/everoute/pkg/apis/agent/v1alpha1.TraceflowNodeResult", "k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
 	}
 }
 
@@ -1214,6 +1996,47 @@ func schema_pkg_apis_security_v1alpha1_ApplyToPeer(ref common.ReferenceCallback)
 	}
 }
 
+func schema_pkg_apis_security_v1alpha1_BandwidthLimit(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "BandwidthLimit limits traffic of an endpoint on its ovs interface, rates are in bits per second and bursts are in bits. Zero rate means unlimited in the direction, zero burst means the default.",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"ingressRate": {
+						SchemaProps: spec.SchemaProps{
+							Description: "IngressRate limits traffic to the endpoint, shaped by linux-htb qos of the ovs port.",
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+					"ingressBurst": {
+						SchemaProps: spec.SchemaProps{
+							Description: "IngressBurst is the burst of traffic to the endpoint.",
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+					"egressRate": {
+						SchemaProps: spec.SchemaProps{
+							Description: "EgressRate limits traffic from the endpoint, policed by ingress policing of the ovs interface.",
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+					"egressBurst": {
+						SchemaProps: spec.SchemaProps{
+							Description: "EgressBurst is the burst of traffic from the endpoint.",
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func schema_pkg_apis_security_v1alpha1_Endpoint(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
@@ -1363,12 +2186,18 @@ func schema_pkg_apis_security_v1alpha1_EndpointSpec(ref common.ReferenceCallback
 							Format:      "",
 						},
 					},
+					"bandwidth": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Bandwidth limits traffic of the endpoint, nil if unlimited.",
+							Ref:         ref("github.com/everoute/everoute/pkg/apis/security/v1alpha1.BandwidthLimit"),
+						},
+					},
 				},
 				Required: []string{"vid", "reference"},
 			},
 		},
 		Dependencies: []string{
-			"github.com/everoute/everoute/pkg/apis/security/v1alpha1.EndpointReference"},
+			"github.com/everoute/everoute/pkg/apis/security/v1alpha1.BandwidthLimit", "github.com/everoute/everoute/pkg/apis/security/v1alpha1.EndpointReference"},
 	}
 }
 
@@ -0,0 +1,29 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+// PodInterface is a secondary interface of pod, attached to a managed vds by cni.
+type PodInterface struct {
+	// Interface is name of the interface in the pod, e.g. net1.
+	Interface string `json:"interface"`
+	// Network is name of the network config of the attachment.
+	Network string `json:"network,omitempty"`
+	// VDS is the managed vds which the interface attached to.
+	VDS string `json:"vds"`
+	// VLAN is the access vlan of the interface on the vds.
+	VLAN uint16 `json:"vlan,omitempty"`
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+var (
+	// bandwidth allowed in annotations, same as kubelet
+	minBandwidth = resource.MustParse("1k")
+	maxBandwidth = resource.MustParse("1P")
+)
+
+// BandwidthLimitFromAnnotations returns bandwidth limit of the pod annotations in bits per second,
+// nil if neither ingress nor egress bandwidth annotation set.
+func BandwidthLimitFromAnnotations(annotations map[string]string) (*securityv1alpha1.BandwidthLimit, error) {
+	ingressRate, err := parseBandwidth(annotations, constants.IngressBandwidthAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	egressRate, err := parseBandwidth(annotations, constants.EgressBandwidthAnnotation)
+	if err != nil {
+		return nil, err
+	}
+
+	if ingressRate == 0 && egressRate == 0 {
+		return nil, nil
+	}
+	return &securityv1alpha1.BandwidthLimit{IngressRate: ingressRate, EgressRate: egressRate}, nil
+}
+
+func parseBandwidth(annotations map[string]string, key string) (int64, error) {
+	value, ok := annotations[key]
+	if !ok {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid annotation %s=%s: %s", key, value, err)
+	}
+	if quantity.Cmp(minBandwidth) < 0 || quantity.Cmp(maxBandwidth) > 0 {
+		return 0, fmt.Errorf("annotation %s=%s out of range [%s, %s]", key, value, minBandwidth.String(), maxBandwidth.String())
+	}
+	return quantity.Value(), nil
+}
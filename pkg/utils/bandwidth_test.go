@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	securityv1alpha1 "github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+func TestBandwidthLimitFromAnnotations(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *securityv1alpha1.BandwidthLimit
+		wantErr     bool
+	}{
+		{
+			name:        "should return nil without bandwidth annotations",
+			annotations: map[string]string{"foo": "bar"},
+		},
+		{
+			name: "should parse ingress and egress bandwidth",
+			annotations: map[string]string{
+				constants.IngressBandwidthAnnotation: "10M",
+				constants.EgressBandwidthAnnotation:  "1Gi",
+			},
+			want: &securityv1alpha1.BandwidthLimit{IngressRate: 10000000, EgressRate: 1 << 30},
+		},
+		{
+			name:        "should parse egress bandwidth only",
+			annotations: map[string]string{constants.EgressBandwidthAnnotation: "500k"},
+			want:        &securityv1alpha1.BandwidthLimit{EgressRate: 500000},
+		},
+		{
+			name:        "should fail on invalid quantity",
+			annotations: map[string]string{constants.IngressBandwidthAnnotation: "10Mbps"},
+			wantErr:     true,
+		},
+		{
+			name:        "should fail on bandwidth too small",
+			annotations: map[string]string{constants.IngressBandwidthAnnotation: "100"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BandwidthLimitFromAnnotations(tt.annotations)
+			if tt.wantErr {
+				Expect(err).Should(HaveOccurred())
+				return
+			}
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(got).Should(Equal(tt.want))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+// PodInterfacesFromAnnotations returns secondary interfaces of the pod recorded by cni, nil if not recorded.
+func PodInterfacesFromAnnotations(annotations map[string]string) ([]types.PodInterface, error) {
+	value, ok := annotations[constants.PodInterfacesAnnotation]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	var interfaces []types.PodInterface
+	if err := json.Unmarshal([]byte(value), &interfaces); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s=%s: %s", constants.PodInterfacesAnnotation, value, err)
+	}
+	return interfaces, nil
+}
+
+// PodInterfacesAnnotationValue returns value of the annotation recording the secondary interfaces.
+func PodInterfacesAnnotationValue(interfaces []types.PodInterface) string {
+	value, _ := json.Marshal(interfaces)
+	return string(value)
+}
+
+// SecondaryEndpointName returns name of the endpoint of the pod secondary interface. The prefix is
+// different from "pod-" of the endpoint of pod primary interface.
+func SecondaryEndpointName(podName, ifname string) string {
+	return fmt.Sprintf("podnet-%s-%s", podName, ifname)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/types"
+)
+
+func TestPodInterfacesFromAnnotations(t *testing.T) {
+	RegisterTestingT(t)
+
+	interfaces, err := PodInterfacesFromAnnotations(map[string]string{"foo": "bar"})
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(interfaces).Should(BeNil())
+
+	_, err = PodInterfacesFromAnnotations(map[string]string{constants.PodInterfacesAnnotation: "net1"})
+	Expect(err).Should(HaveOccurred())
+
+	expect := []types.PodInterface{
+		{Interface: "net1", Network: "vlan100", VDS: "vds1", VLAN: 100},
+		{Interface: "net2", VDS: "vds2"},
+	}
+	value := PodInterfacesAnnotationValue(expect)
+	Expect(value).Should(Equal(`[{"interface":"net1","network":"vlan100","vds":"vds1","vlan":100},{"interface":"net2","vds":"vds2"}]`))
+	interfaces, err = PodInterfacesFromAnnotations(map[string]string{constants.PodInterfacesAnnotation: value})
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(interfaces).Should(Equal(expect))
+}
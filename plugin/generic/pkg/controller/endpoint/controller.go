@@ -0,0 +1,302 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	kubeerror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	crd "github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	"github.com/everoute/everoute/pkg/utils"
+	"github.com/everoute/everoute/plugin/generic/pkg/informer"
+	"github.com/everoute/everoute/plugin/generic/pkg/schema"
+)
+
+type Controller struct {
+	// name of this controller
+	name string
+	// namespace which endpoint should create in
+	namespace string
+
+	crdClient clientset.Interface
+
+	vmInformer       cache.SharedIndexInformer
+	vmLister         utils.Lister
+	vmInformerSynced cache.InformerSynced
+
+	endpointInformer       cache.SharedIndexInformer
+	endpointLister         utils.Lister
+	endpointInformerSynced cache.InformerSynced
+
+	// endpointQueue contains endpoint to process. The element in queue
+	// is endpoint name. And we use nic ID as endpoint name.
+	endpointQueue workqueue.RateLimitingInterface
+}
+
+const (
+	NicIndex = "nicIndex"
+
+	ExternalIDName = "iface-id"
+)
+
+// New creates a new instance of controller.
+func New(
+	factory informer.SharedInformerFactory,
+	crdFactory crd.SharedInformerFactory,
+	crdClient clientset.Interface,
+	resyncPeriod time.Duration,
+	namespace string,
+) *Controller {
+	vmInformer := factory.VM()
+	endpointInformer := crdFactory.Security().V1alpha1().Endpoints().Informer()
+
+	c := &Controller{
+		name:                   "GenericEndpointController",
+		namespace:              namespace,
+		crdClient:              crdClient,
+		vmInformer:             vmInformer,
+		vmLister:               vmInformer.GetIndexer(),
+		vmInformerSynced:       vmInformer.HasSynced,
+		endpointInformer:       endpointInformer,
+		endpointLister:         endpointInformer.GetIndexer(),
+		endpointInformerSynced: endpointInformer.HasSynced,
+		endpointQueue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	// ignore error, error only when informer has already started
+	_ = vmInformer.AddIndexers(cache.Indexers{
+		NicIndex: NicIndexFunc,
+	})
+
+	vmInformer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.addVM,
+			UpdateFunc: c.updateVM,
+			DeleteFunc: c.deleteVM,
+		},
+		resyncPeriod,
+	)
+
+	// Handle endpoint events, so that endpoints left after the controller
+	// restart or unexpectedly modified could be resynced.
+	endpointInformer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handleEndpoint,
+			UpdateFunc: c.updateEndpoint,
+			DeleteFunc: c.handleEndpoint,
+		},
+		resyncPeriod,
+	)
+
+	return c
+}
+
+// Run begins processing items, and will continue until a value is sent down stopCh or it is closed.
+func (c *Controller) Run(workers uint, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+	defer c.endpointQueue.ShutDown()
+
+	if !cache.WaitForNamedCacheSync(c.name, stopCh, c.vmInformerSynced, c.endpointInformerSynced) {
+		return
+	}
+
+	for i := uint(0); i < workers; i++ {
+		go wait.Until(utils.ReconcileWorker(c.name, c.endpointQueue, c.syncEndpoint), time.Second, stopCh)
+	}
+
+	<-stopCh
+}
+
+// NicIndexFunc index vm by its nics ID.
+func NicIndexFunc(obj interface{}) ([]string, error) {
+	var nics []string
+	for _, nic := range obj.(*schema.VM).NICs {
+		nics = append(nics, nic.ID)
+	}
+	return nics, nil
+}
+
+func (c *Controller) addVM(new interface{}) {
+	c.enqueueVMNics(new.(*schema.VM))
+}
+
+func (c *Controller) updateVM(old interface{}, new interface{}) {
+	oldVM := old.(*schema.VM)
+	newVM := new.(*schema.VM)
+
+	if reflect.DeepEqual(oldVM, newVM) {
+		return
+	}
+
+	c.enqueueVMNics(oldVM)
+	c.enqueueVMNics(newVM)
+}
+
+func (c *Controller) deleteVM(old interface{}) {
+	if d, ok := old.(cache.DeletedFinalStateUnknown); ok {
+		old = d.Obj
+	}
+	c.enqueueVMNics(old.(*schema.VM))
+}
+
+func (c *Controller) enqueueVMNics(vm *schema.VM) {
+	for _, nic := range vm.NICs {
+		c.endpointQueue.Add(nic.ID)
+	}
+}
+
+func (c *Controller) handleEndpoint(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	c.endpointQueue.Add(obj.(*v1alpha1.Endpoint).GetName())
+}
+
+func (c *Controller) updateEndpoint(_, new interface{}) {
+	c.handleEndpoint(new)
+}
+
+// syncEndpoint process create/update/delete event for endpoint
+func (c *Controller) syncEndpoint(key string) error {
+	vms, err := c.vmLister.ByIndex(NicIndex, key)
+	if err != nil {
+		return err
+	}
+
+	switch len(vms) {
+	case 0:
+		// delete this endpoint
+		return c.processEndpointDelete(key)
+	case 1:
+		// create or update endpoint from nic
+		return c.processEndpointUpdate(vms[0].(*schema.VM), key)
+	default:
+		return fmt.Errorf("got multiple vms %+v for nic %s", vms, key)
+	}
+}
+
+func (c *Controller) processEndpointDelete(key string) error {
+	_, exists, err := c.endpointLister.GetByKey(fmt.Sprintf("%s/%s", c.namespace, key))
+	if err == nil && !exists {
+		// object has been delete already
+		return nil
+	}
+
+	err = c.crdClient.SecurityV1alpha1().Endpoints(c.namespace).Delete(context.Background(), key, metav1.DeleteOptions{})
+	if err == nil || kubeerror.IsNotFound(err) {
+		klog.Infof("endpoint %s has been delete by %s", key, c.name)
+		return nil
+	}
+	return err
+}
+
+func (c *Controller) processEndpointUpdate(vm *schema.VM, nicKey string) error {
+	nic, exists := fetchNic(vm, nicKey)
+	if !exists {
+		return fmt.Errorf("unable find nic %s in vm %+v", nicKey, vm)
+	}
+	// endpoint of the nic is stale when the nic becomes invalid, remove it
+	if errs := validation.IsDNS1123Subdomain(nic.ID); len(errs) != 0 {
+		klog.Errorf("ignore nic %s on vm %s: invalid endpoint name: %v", nic.ID, vm.ID, errs)
+		return c.processEndpointDelete(nicKey)
+	}
+	if nic.InterfaceID == "" {
+		klog.V(4).Infof("ignore nic %s on vm %s with empty interfaceID", nic.ID, vm.ID)
+		return c.processEndpointDelete(nicKey)
+	}
+
+	obj, exists, err := c.endpointLister.GetByKey(fmt.Sprintf("%s/%s", c.namespace, nicKey))
+	if err != nil {
+		return fmt.Errorf("get endpoint receive error: %s", err)
+	}
+
+	if !exists {
+		ep := &v1alpha1.Endpoint{}
+		c.setEndpoint(ep, nic, ValidLabels(vm.ID, vm.Labels))
+
+		klog.Infof("will add endpoint from vm %s nic %s: %+v", vm.ID, nicKey, ep)
+		_, err = c.crdClient.SecurityV1alpha1().Endpoints(c.namespace).Create(context.Background(), ep, metav1.CreateOptions{})
+		return err
+	}
+
+	ep := obj.(*v1alpha1.Endpoint).DeepCopy()
+	if c.setEndpoint(ep, nic, ValidLabels(vm.ID, vm.Labels)) {
+		klog.Infof("will update endpoint from vm %s nic %s: %+v", vm.ID, nicKey, ep)
+		_, err = c.crdClient.SecurityV1alpha1().Endpoints(c.namespace).Update(context.Background(), ep, metav1.UpdateOptions{})
+		return err
+	}
+
+	return nil
+}
+
+// set endpoint return false if endpoint not changes
+func (c *Controller) setEndpoint(ep *v1alpha1.Endpoint, nic *schema.NIC, labels map[string]string) bool {
+	var epCopy = ep.DeepCopy()
+
+	ep.Name = nic.ID
+	ep.Labels = labels
+	ep.Namespace = c.namespace
+	ep.Spec.VID = nic.VlanID
+	ep.Spec.Reference.ExternalIDName = ExternalIDName
+	ep.Spec.Reference.ExternalIDValue = nic.InterfaceID
+	ep.Spec.Type = v1alpha1.EndpointDynamic
+
+	return !reflect.DeepEqual(ep, epCopy)
+}
+
+func fetchNic(vm *schema.VM, nicKey string) (*schema.NIC, bool) {
+	for _, nic := range vm.NICs {
+		if nicKey == nic.ID {
+			return &nic, true
+		}
+	}
+	return nil, false
+}
+
+// ValidLabels return labels which are valid kubernetes labels
+func ValidLabels(vmID string, labels map[string]string) map[string]string {
+	validLabels := make(map[string]string, len(labels))
+	for key, value := range labels {
+		validKey := len(validation.IsQualifiedName(key)) == 0
+		validValue := len(validation.IsValidLabelValue(value)) == 0
+		if !validKey || !validValue {
+			klog.Infof("ignore vm %s invalid kubernetes label %s=%s", vmID, key, value)
+			continue
+		}
+		validLabels[key] = value
+	}
+
+	if len(validLabels) == 0 {
+		// If labels length is zero, would return nil instead of an empty map.
+		// Consistent with the empty labels returned by the apiserver.
+		return nil
+	}
+	return validLabels
+}
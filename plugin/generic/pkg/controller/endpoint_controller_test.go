@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/plugin/generic/pkg/controller/endpoint"
+)
+
+var _ = Describe("GenericEndpointController", func() {
+	var ctx = context.Background()
+
+	AfterEach(func() {
+		writeInventory("vm.yaml", "")
+		Eventually(func() int {
+			epList, err := crdClient.SecurityV1alpha1().Endpoints(namespace).List(ctx, metav1.ListOptions{})
+			Expect(err).Should(Succeed())
+			return len(epList.Items)
+		}, timeout, interval).Should(BeZero())
+	})
+
+	Context("vm with nics has been created", func() {
+		BeforeEach(func() {
+			writeInventory("vm.yaml", `
+vms:
+- id: vm-01
+  labels:
+    app: web
+    "invalid label": value
+  nics:
+  - id: nic-01
+    interfaceID: iface-01
+    vlanID: 10
+  - id: nic-02
+    interfaceID: iface-02
+`)
+		})
+
+		It("should create endpoint for each nic", func() {
+			Eventually(func() int {
+				epList, err := crdClient.SecurityV1alpha1().Endpoints(namespace).List(ctx, metav1.ListOptions{})
+				Expect(err).Should(Succeed())
+				return len(epList.Items)
+			}, timeout, interval).Should(Equal(2))
+
+			ep, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-01", metav1.GetOptions{})
+			Expect(err).Should(Succeed())
+			Expect(ep.Labels).Should(Equal(map[string]string{"app": "web"}))
+			Expect(ep.Spec.VID).Should(Equal(uint32(10)))
+			Expect(ep.Spec.Type).Should(Equal(v1alpha1.EndpointDynamic))
+			Expect(ep.Spec.Reference.ExternalIDName).Should(Equal(endpoint.ExternalIDName))
+			Expect(ep.Spec.Reference.ExternalIDValue).Should(Equal("iface-01"))
+		})
+
+		When("update vm labels and remove a nic", func() {
+			BeforeEach(func() {
+				writeInventory("vm.yaml", `
+vms:
+- id: vm-01
+  labels:
+    app: db
+  nics:
+  - id: nic-01
+    interfaceID: iface-01
+    vlanID: 10
+`)
+			})
+
+			It("should update endpoint labels and delete the endpoint of removed nic", func() {
+				Eventually(func() map[string]string {
+					ep, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-01", metav1.GetOptions{})
+					if err != nil {
+						return nil
+					}
+					return ep.Labels
+				}, timeout, interval).Should(Equal(map[string]string{"app": "db"}))
+
+				Eventually(func() int {
+					epList, err := crdClient.SecurityV1alpha1().Endpoints(namespace).List(ctx, metav1.ListOptions{})
+					Expect(err).Should(Succeed())
+					return len(epList.Items)
+				}, timeout, interval).Should(Equal(1))
+			})
+		})
+		When("remove interfaceID of a nic", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					_, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-02", metav1.GetOptions{})
+					return err
+				}, timeout, interval).Should(Succeed())
+				writeInventory("vm.yaml", `
+vms:
+- id: vm-01
+  nics:
+  - id: nic-01
+    interfaceID: iface-01
+  - id: nic-02
+`)
+			})
+
+			It("should delete the endpoint of the nic", func() {
+				Eventually(func() error {
+					_, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-02", metav1.GetOptions{})
+					return err
+				}, timeout, interval).ShouldNot(Succeed())
+				_, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-01", metav1.GetOptions{})
+				Expect(err).Should(Succeed())
+			})
+		})
+	})
+
+	Context("endpoint has been deleted unexpectedly", func() {
+		BeforeEach(func() {
+			writeInventory("vm.yaml", `
+vms:
+- id: vm-02
+  nics:
+  - id: nic-03
+    interfaceID: iface-03
+`)
+			Eventually(func() error {
+				_, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-03", metav1.GetOptions{})
+				return err
+			}, timeout, interval).Should(Succeed())
+			Expect(crdClient.SecurityV1alpha1().Endpoints(namespace).Delete(ctx, "nic-03", metav1.DeleteOptions{})).Should(Succeed())
+		})
+
+		It("should recreate the endpoint", func() {
+			Eventually(func() error {
+				_, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, "nic-03", metav1.GetOptions{})
+				return err
+			}, timeout, interval).Should(Succeed())
+		})
+	})
+})
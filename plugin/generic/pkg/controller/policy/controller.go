@@ -0,0 +1,460 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	crd "github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	"github.com/everoute/everoute/pkg/constants"
+	"github.com/everoute/everoute/pkg/utils"
+	"github.com/everoute/everoute/plugin/generic/pkg/informer"
+	"github.com/everoute/everoute/plugin/generic/pkg/schema"
+)
+
+const (
+	vmIndex = "vmIndex"
+)
+
+// Controller sync SecurityPolicy from generic source as v1alpha1.SecurityPolicy.
+type Controller struct {
+	// name of this controller
+	name string
+	// namespace which SecurityPolicy should create in
+	namespace string
+
+	crdClient clientset.Interface
+
+	vmInformer       cache.SharedIndexInformer
+	vmLister         utils.Lister
+	vmInformerSynced cache.InformerSynced
+
+	policyInformer       cache.SharedIndexInformer
+	policyLister         utils.Lister
+	policyInformerSynced cache.InformerSynced
+
+	crdPolicyInformer       cache.SharedIndexInformer
+	crdPolicyLister         utils.Lister
+	crdPolicyInformerSynced cache.InformerSynced
+
+	// policyQueue contains policy to process. The element in queue
+	// is policy ID, we use policy ID as SecurityPolicy name.
+	policyQueue workqueue.RateLimitingInterface
+}
+
+// New creates a new instance of controller.
+func New(
+	factory informer.SharedInformerFactory,
+	crdFactory crd.SharedInformerFactory,
+	crdClient clientset.Interface,
+	resyncPeriod time.Duration,
+	namespace string,
+) *Controller {
+	vmInformer := factory.VM()
+	policyInformer := factory.SecurityPolicy()
+	crdPolicyInformer := crdFactory.Security().V1alpha1().SecurityPolicies().Informer()
+
+	c := &Controller{
+		name:                    "GenericPolicyController",
+		namespace:               namespace,
+		crdClient:               crdClient,
+		vmInformer:              vmInformer,
+		vmLister:                vmInformer.GetIndexer(),
+		vmInformerSynced:        vmInformer.HasSynced,
+		policyInformer:          policyInformer,
+		policyLister:            policyInformer.GetIndexer(),
+		policyInformerSynced:    policyInformer.HasSynced,
+		crdPolicyInformer:       crdPolicyInformer,
+		crdPolicyLister:         crdPolicyInformer.GetIndexer(),
+		crdPolicyInformerSynced: crdPolicyInformer.HasSynced,
+		policyQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	// ignore error, error only when informer has already started
+	_ = policyInformer.AddIndexers(cache.Indexers{
+		vmIndex: c.vmIndexFunc,
+	})
+
+	vmInformer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handleVM,
+			UpdateFunc: c.updateVM,
+			DeleteFunc: c.handleVM,
+		},
+		resyncPeriod,
+	)
+
+	policyInformer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handlePolicy,
+			UpdateFunc: c.updatePolicy,
+			DeleteFunc: c.handlePolicy,
+		},
+		resyncPeriod,
+	)
+
+	// Handle crd policy events, so that policies left after the controller
+	// restart or unexpectedly modified could be resynced.
+	crdPolicyInformer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handleCRDPolicy,
+			UpdateFunc: c.updateCRDPolicy,
+			DeleteFunc: c.handleCRDPolicy,
+		},
+		resyncPeriod,
+	)
+
+	return c
+}
+
+// Run begins processing items, and will continue until a value is sent down stopCh or it is closed.
+func (c *Controller) Run(workers uint, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+	defer c.policyQueue.ShutDown()
+
+	if !cache.WaitForNamedCacheSync(c.name, stopCh, c.vmInformerSynced, c.policyInformerSynced, c.crdPolicyInformerSynced) {
+		return
+	}
+
+	for i := uint(0); i < workers; i++ {
+		go wait.Until(utils.ReconcileWorker(c.name, c.policyQueue, c.syncPolicy), time.Second, stopCh)
+	}
+
+	<-stopCh
+}
+
+// vmIndexFunc index policy by the vms it references.
+func (c *Controller) vmIndexFunc(obj interface{}) ([]string, error) {
+	policy := obj.(*schema.SecurityPolicy)
+	vms := sets.NewString()
+
+	for _, peer := range policy.AppliedTo {
+		vms.Insert(peer.VM)
+	}
+	for _, rule := range append(policy.Ingress, policy.Egress...) {
+		for _, peer := range rule.Peers {
+			vms.Insert(peer.VM)
+		}
+	}
+
+	vms.Delete("")
+	return vms.List(), nil
+}
+
+func (c *Controller) handleVM(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	policies, _ := c.policyLister.ByIndex(vmIndex, obj.(*schema.VM).GetID())
+	for _, policy := range policies {
+		c.policyQueue.Add(policy.(*schema.SecurityPolicy).GetID())
+	}
+}
+
+func (c *Controller) updateVM(old, new interface{}) {
+	oldVM := old.(*schema.VM)
+	newVM := new.(*schema.VM)
+
+	// only nics of the vm would affect policies
+	if reflect.DeepEqual(oldVM.NICs, newVM.NICs) {
+		return
+	}
+	c.handleVM(newVM)
+}
+
+func (c *Controller) handlePolicy(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	c.policyQueue.Add(obj.(*schema.SecurityPolicy).GetID())
+}
+
+func (c *Controller) updatePolicy(old, new interface{}) {
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+	c.handlePolicy(new)
+}
+
+func (c *Controller) handleCRDPolicy(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	c.policyQueue.Add(obj.(*v1alpha1.SecurityPolicy).GetName())
+}
+
+func (c *Controller) updateCRDPolicy(_, new interface{}) {
+	c.handleCRDPolicy(new)
+}
+
+func (c *Controller) syncPolicy(key string) error {
+	obj, exists, err := c.policyLister.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("get policy %s: %s", key, err)
+	}
+	if !exists {
+		return c.processPolicyDelete(key)
+	}
+
+	policy, err := c.parseSecurityPolicy(obj.(*schema.SecurityPolicy))
+	if err != nil {
+		// keep the last applied policy, removes it would open the traffic it restricts
+		return fmt.Errorf("invalid policy %s, keep the last applied one: %s", key, err)
+	}
+	if policy == nil {
+		// the policy applied to nothing, remove it
+		return c.processPolicyDelete(key)
+	}
+	return c.processPolicyUpdate(policy)
+}
+
+func (c *Controller) processPolicyDelete(key string) error {
+	_, exists, err := c.crdPolicyLister.GetByKey(fmt.Sprintf("%s/%s", c.namespace, key))
+	if err == nil && !exists {
+		// object has been delete already
+		return nil
+	}
+
+	err = c.crdClient.SecurityV1alpha1().SecurityPolicies(c.namespace).Delete(context.Background(), key, metav1.DeleteOptions{})
+	if err == nil || kubeerror.IsNotFound(err) {
+		klog.Infof("policy %s has been delete by %s", key, c.name)
+		return nil
+	}
+	return err
+}
+
+func (c *Controller) processPolicyUpdate(policy *v1alpha1.SecurityPolicy) error {
+	obj, exists, err := c.crdPolicyLister.GetByKey(fmt.Sprintf("%s/%s", c.namespace, policy.GetName()))
+	if err != nil {
+		return fmt.Errorf("get policy receive error: %s", err)
+	}
+
+	if !exists {
+		klog.Infof("will add policy %s: %+v", policy.GetName(), policy.Spec)
+		_, err = c.crdClient.SecurityV1alpha1().SecurityPolicies(c.namespace).Create(context.Background(), policy, metav1.CreateOptions{})
+		return err
+	}
+
+	oldPolicy := obj.(*v1alpha1.SecurityPolicy)
+	if reflect.DeepEqual(oldPolicy.Spec, policy.Spec) {
+		return nil
+	}
+
+	newPolicy := oldPolicy.DeepCopy()
+	newPolicy.Spec = policy.Spec
+	klog.Infof("will update policy %s: %+v", policy.GetName(), policy.Spec)
+	_, err = c.crdClient.SecurityV1alpha1().SecurityPolicies(c.namespace).Update(context.Background(), newPolicy, metav1.UpdateOptions{})
+	return err
+}
+
+// parseSecurityPolicy returns nil if the policy applied to nothing, e.g. all
+// the vms in AppliedTo not found.
+func (c *Controller) parseSecurityPolicy(policy *schema.SecurityPolicy) (*v1alpha1.SecurityPolicy, error) {
+	var tier = policy.Tier
+	if tier == "" {
+		tier = constants.Tier2
+	}
+
+	appliedTo, err := c.parseAppliedTo(policy.AppliedTo)
+	if err != nil {
+		return nil, err
+	}
+	if len(policy.AppliedTo) != 0 && len(appliedTo) == 0 {
+		return nil, nil
+	}
+
+	ingress, err := c.parseRules(policy.Ingress)
+	if err != nil {
+		return nil, err
+	}
+	egress, err := c.parseRules(policy.Egress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1alpha1.SecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policy.GetID(),
+			Namespace: c.namespace,
+		},
+		Spec: v1alpha1.SecurityPolicySpec{
+			Tier:          tier,
+			SymmetricMode: policy.SymmetricMode,
+			AppliedTo:     appliedTo,
+			IngressRules:  ingress,
+			EgressRules:   egress,
+			DefaultRule:   v1alpha1.DefaultRuleDrop,
+			PolicyTypes:   policyTypesOf(policy),
+		},
+	}, nil
+}
+
+// policyTypesOf returns the directions which the policy has rules, traffic of
+// the other direction would not be limited by the policy. Policy without any
+// rules limits ingress traffic only, the same as v1alpha1.SecurityPolicy.
+func policyTypesOf(policy *schema.SecurityPolicy) []networkingv1.PolicyType {
+	var policyTypes []networkingv1.PolicyType
+	if len(policy.Ingress) != 0 || len(policy.Egress) == 0 {
+		policyTypes = append(policyTypes, networkingv1.PolicyTypeIngress)
+	}
+	if len(policy.Egress) != 0 {
+		policyTypes = append(policyTypes, networkingv1.PolicyTypeEgress)
+	}
+	return policyTypes
+}
+
+func (c *Controller) parseAppliedTo(peers []schema.PolicyPeer) ([]v1alpha1.ApplyToPeer, error) {
+	var appliedTo []v1alpha1.ApplyToPeer
+
+	for _, peer := range peers {
+		switch {
+		case peer.VM != "":
+			nics, err := c.vmNicIDs(peer.VM)
+			if err != nil {
+				return nil, err
+			}
+			for i := range nics {
+				appliedTo = append(appliedTo, v1alpha1.ApplyToPeer{Endpoint: &nics[i]})
+			}
+		case peer.Labels != nil:
+			appliedTo = append(appliedTo, v1alpha1.ApplyToPeer{
+				EndpointSelector: &metav1.LabelSelector{MatchLabels: peer.Labels},
+			})
+		default:
+			return nil, fmt.Errorf("appliedTo peer %+v must set vm or labels", peer)
+		}
+	}
+
+	return appliedTo, nil
+}
+
+func (c *Controller) parseRules(rules []schema.PolicyRule) ([]v1alpha1.Rule, error) {
+	var parsedRules []v1alpha1.Rule
+
+	for _, rule := range rules {
+		peers, err := c.parsePeers(rule.Peers)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s", rule.Name, err)
+		}
+		if len(rule.Peers) != 0 && len(peers) == 0 {
+			// empty peers means all, skip the rule when its peers not found
+			continue
+		}
+
+		ports := make([]v1alpha1.SecurityPolicyPort, 0, len(rule.Ports))
+		for _, port := range rule.Ports {
+			ports = append(ports, v1alpha1.SecurityPolicyPort{
+				Protocol:  v1alpha1.Protocol(strings.ToUpper(port.Protocol)),
+				PortRange: port.Port,
+			})
+		}
+
+		// rule.From would be ignored when rule is egress, rule.To would
+		// be ignored when rule is ingress, so set both of them.
+		parsedRules = append(parsedRules, v1alpha1.Rule{
+			Name:  rule.Name,
+			Ports: ports,
+			From:  peers,
+			To:    peers,
+		})
+	}
+
+	return parsedRules, nil
+}
+
+func (c *Controller) parsePeers(peers []schema.PolicyPeer) ([]v1alpha1.SecurityPolicyPeer, error) {
+	var parsedPeers []v1alpha1.SecurityPolicyPeer
+
+	for _, peer := range peers {
+		switch {
+		case peer.VM != "":
+			nics, err := c.vmNicIDs(peer.VM)
+			if err != nil {
+				return nil, err
+			}
+			for _, nic := range nics {
+				parsedPeers = append(parsedPeers, v1alpha1.SecurityPolicyPeer{
+					Endpoint: &v1alpha1.NamespacedName{Name: nic, Namespace: c.namespace},
+				})
+			}
+		case peer.Labels != nil:
+			parsedPeers = append(parsedPeers, v1alpha1.SecurityPolicyPeer{
+				EndpointSelector: &metav1.LabelSelector{MatchLabels: peer.Labels},
+			})
+		case peer.IPBlock != "":
+			cidr, err := parseIPBlock(peer.IPBlock)
+			if err != nil {
+				return nil, err
+			}
+			parsedPeers = append(parsedPeers, v1alpha1.SecurityPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		default:
+			return nil, fmt.Errorf("peer %+v must set one of vm, labels or ipBlock", peer)
+		}
+	}
+
+	return parsedPeers, nil
+}
+
+// vmNicIDs returns nic IDs of the vm, empty if the vm not found.
+func (c *Controller) vmNicIDs(vmID string) ([]string, error) {
+	obj, exists, err := c.vmLister.GetByKey(vmID)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var nics []string
+	for _, nic := range obj.(*schema.VM).NICs {
+		nics = append(nics, nic.ID)
+	}
+	return nics, nil
+}
+
+// parseIPBlock parse ip or cidr into cidr, e.g. 10.0.0.1 => 10.0.0.1/32
+func parseIPBlock(ipBlock string) (string, error) {
+	if _, _, err := net.ParseCIDR(ipBlock); err == nil {
+		return ipBlock, nil
+	}
+
+	ip := net.ParseIP(ipBlock)
+	switch {
+	case ip == nil:
+		return "", fmt.Errorf("invalid ipBlock %s", ipBlock)
+	case ip.To4() != nil:
+		return ipBlock + "/32", nil
+	default:
+		return ipBlock + "/128", nil
+	}
+}
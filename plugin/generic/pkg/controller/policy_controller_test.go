@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/constants"
+)
+
+var _ = Describe("GenericPolicyController", func() {
+	var ctx = context.Background()
+
+	getPolicy := func(name string) func() (*v1alpha1.SecurityPolicy, error) {
+		return func() (*v1alpha1.SecurityPolicy, error) {
+			return crdClient.SecurityV1alpha1().SecurityPolicies(namespace).Get(ctx, name, metav1.GetOptions{})
+		}
+	}
+
+	AfterEach(func() {
+		writeInventory("vm.yaml", "")
+		writeInventory("policy.yaml", "")
+		Eventually(func() int {
+			policyList, err := crdClient.SecurityV1alpha1().SecurityPolicies(namespace).List(ctx, metav1.ListOptions{})
+			Expect(err).Should(Succeed())
+			return len(policyList.Items)
+		}, timeout, interval).Should(BeZero())
+	})
+
+	Context("policy applied to existing vm", func() {
+		BeforeEach(func() {
+			writeInventory("vm.yaml", `
+vms:
+- id: vm-01
+  nics:
+  - id: nic-01
+    interfaceID: iface-01
+`)
+			writeInventory("policy.yaml", `
+securityPolicies:
+- id: policy-01
+  appliedTo:
+  - vm: vm-01
+  ingress:
+  - name: web
+    peers:
+    - labels:
+        app: web
+    - ipBlock: 10.0.0.1
+    ports:
+    - protocol: tcp
+      port: "80,443"
+  egress:
+  - name: dns
+    peers:
+    - ipBlock: 10.0.0.0/24
+    ports:
+    - protocol: UDP
+      port: "53"
+`)
+		})
+
+		It("should create SecurityPolicy", func() {
+			Eventually(getPolicy("policy-01"), timeout, interval).ShouldNot(BeNil())
+			policy, _ := getPolicy("policy-01")()
+
+			Expect(policy.Spec.Tier).Should(Equal(constants.Tier2))
+			Expect(policy.Spec.AppliedTo).Should(HaveLen(1))
+			Expect(*policy.Spec.AppliedTo[0].Endpoint).Should(Equal("nic-01"))
+
+			Expect(policy.Spec.IngressRules).Should(HaveLen(1))
+			Expect(policy.Spec.IngressRules[0].From).Should(ConsistOf(
+				v1alpha1.SecurityPolicyPeer{EndpointSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+				v1alpha1.SecurityPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.1/32"}},
+			))
+			Expect(policy.Spec.IngressRules[0].Ports).Should(ConsistOf(
+				v1alpha1.SecurityPolicyPort{Protocol: v1alpha1.ProtocolTCP, PortRange: "80,443"},
+			))
+
+			Expect(policy.Spec.EgressRules).Should(HaveLen(1))
+			Expect(policy.Spec.EgressRules[0].To).Should(ConsistOf(
+				v1alpha1.SecurityPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/24"}},
+			))
+			Expect(policy.Spec.PolicyTypes).Should(ConsistOf(networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress))
+		})
+
+		When("add nic to the vm", func() {
+			BeforeEach(func() {
+				writeInventory("vm.yaml", `
+vms:
+- id: vm-01
+  nics:
+  - id: nic-01
+    interfaceID: iface-01
+  - id: nic-02
+    interfaceID: iface-02
+`)
+			})
+
+			It("should update SecurityPolicy appliedTo", func() {
+				Eventually(func() []string {
+					policy, err := getPolicy("policy-01")()
+					if err != nil {
+						return nil
+					}
+					var endpoints []string
+					for _, peer := range policy.Spec.AppliedTo {
+						endpoints = append(endpoints, *peer.Endpoint)
+					}
+					return endpoints
+				}, timeout, interval).Should(ConsistOf("nic-01", "nic-02"))
+			})
+		})
+
+		When("update the policy with invalid ipBlock", func() {
+			BeforeEach(func() {
+				Eventually(getPolicy("policy-01"), timeout, interval).ShouldNot(BeNil())
+				writeInventory("policy.yaml", `
+securityPolicies:
+- id: policy-01
+  appliedTo:
+  - vm: vm-01
+  ingress:
+  - name: invalid
+    peers:
+    - ipBlock: 10.0.0.256
+`)
+			})
+
+			It("should keep the last applied SecurityPolicy", func() {
+				Consistently(func() int {
+					policy, err := getPolicy("policy-01")()
+					Expect(err).Should(Succeed())
+					return len(policy.Spec.EgressRules)
+				}, interval*4, interval).Should(Equal(1))
+			})
+		})
+
+		When("remove the vm", func() {
+			BeforeEach(func() {
+				Eventually(getPolicy("policy-01"), timeout, interval).ShouldNot(BeNil())
+				writeInventory("vm.yaml", "")
+			})
+
+			It("should delete SecurityPolicy applied to nothing", func() {
+				Eventually(func() error {
+					_, err := getPolicy("policy-01")()
+					return err
+				}, timeout, interval).ShouldNot(Succeed())
+			})
+		})
+	})
+
+	Context("policy with egress rules only", func() {
+		BeforeEach(func() {
+			writeInventory("policy.yaml", `
+securityPolicies:
+- id: policy-03
+  appliedTo:
+  - labels:
+      app: db
+  egress:
+  - name: dns
+    ports:
+    - protocol: UDP
+      port: "53"
+`)
+		})
+
+		It("should create SecurityPolicy limits egress traffic only", func() {
+			Eventually(getPolicy("policy-03"), timeout, interval).ShouldNot(BeNil())
+			policy, _ := getPolicy("policy-03")()
+			Expect(policy.Spec.PolicyTypes).Should(ConsistOf(networkingv1.PolicyTypeEgress))
+		})
+	})
+
+	Context("policy with invalid ipBlock", func() {
+		BeforeEach(func() {
+			writeInventory("policy.yaml", `
+securityPolicies:
+- id: policy-02
+  appliedTo:
+  - labels:
+      app: db
+  ingress:
+  - name: invalid
+    peers:
+    - ipBlock: 10.0.0.256
+`)
+		})
+
+		It("should not create SecurityPolicy", func() {
+			Consistently(func() error {
+				_, err := getPolicy("policy-02")()
+				return err
+			}, interval*4, interval).ShouldNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset/fake"
+	"github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	"github.com/everoute/everoute/plugin/generic/pkg/controller/endpoint"
+	"github.com/everoute/everoute/plugin/generic/pkg/controller/policy"
+	"github.com/everoute/everoute/plugin/generic/pkg/informer"
+	"github.com/everoute/everoute/plugin/generic/pkg/source"
+)
+
+var (
+	crdClient    clientset.Interface
+	inventoryDir string
+	namespace    = metav1.NamespaceDefault
+	stopCh       = make(chan struct{})
+)
+
+const (
+	timeout  = time.Second * 10
+	interval = time.Millisecond * 250
+)
+
+func TestGenericController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "GenericController Suite")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+
+	By("create inventory dir and fake client")
+	inventoryDir, err = ioutil.TempDir("", "generic-inventory-")
+	Expect(err).Should(Succeed())
+	crdClient = fake.NewSimpleClientset()
+	crdFactory := externalversions.NewSharedInformerFactory(crdClient, 0)
+	factory := informer.NewSharedInformerFactory(source.NewDirectorySource(inventoryDir), interval, 0)
+
+	By("create and start GenericEndpointController and GenericPolicyController")
+	endpointController := endpoint.New(factory, crdFactory, crdClient, 0, namespace)
+	go endpointController.Run(10, stopCh)
+	policyController := policy.New(factory, crdFactory, crdClient, 0, namespace)
+	go policyController.Run(10, stopCh)
+
+	By("start factory and crdFactory and wait for cache sync")
+	factory.Start(stopCh)
+	crdFactory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	crdFactory.WaitForCacheSync(stopCh)
+}, 60)
+
+var _ = AfterSuite(func() {
+	By("tearing down the environment")
+	close(stopCh)
+	Expect(os.RemoveAll(inventoryDir)).Should(Succeed())
+})
+
+// writeInventory writes the inventory file in inventoryDir, remove it if content is empty.
+func writeInventory(name, content string) {
+	path := filepath.Join(inventoryDir, name)
+	if content == "" {
+		Expect(os.RemoveAll(path)).Should(Succeed())
+		return
+	}
+	Expect(ioutil.WriteFile(path, []byte(content), 0600)).Should(Succeed())
+}
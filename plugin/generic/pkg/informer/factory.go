@@ -0,0 +1,374 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package informer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/everoute/everoute/pkg/utils/informer"
+	"github.com/everoute/everoute/plugin/generic/pkg/schema"
+	"github.com/everoute/everoute/plugin/generic/pkg/source"
+)
+
+// SharedInformerFactory provides shared informers for all resources
+type SharedInformerFactory interface {
+	// Start initializes all requested informers
+	Start(stopCh <-chan struct{})
+	// WaitForCacheSync waits for all started informers' cache were synced
+	WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool
+	// InformerFor returns the SharedIndexInformer for obj.
+	InformerFor(obj schema.Object) cache.SharedIndexInformer
+
+	// VM return informer for &schema.VM{}
+	VM() cache.SharedIndexInformer
+	// SecurityPolicy return informer for &schema.SecurityPolicy{}
+	SecurityPolicy() cache.SharedIndexInformer
+}
+
+// NewSharedInformerFactory constructs a new instance of sharedInformerFactory for all resources,
+// the source would be loaded every pollPeriod, and once it changes if the source is a source.Notifier.
+// The loaded document is shared by all the informers.
+func NewSharedInformerFactory(source source.Source, pollPeriod, defaultResync time.Duration) SharedInformerFactory {
+	factory := &sharedInformerFactory{
+		source:           source,
+		pollPeriod:       pollPeriod,
+		defaultResync:    defaultResync,
+		informers:        make(map[reflect.Type]cache.SharedIndexInformer),
+		startedInformers: make(map[reflect.Type]bool),
+		reflectors:       make(map[*reflector]struct{}),
+	}
+	return factory
+}
+
+type sharedInformerFactory struct {
+	source        source.Source
+	pollPeriod    time.Duration
+	lock          sync.Mutex
+	defaultResync time.Duration
+
+	informers map[reflect.Type]cache.SharedIndexInformer
+	// startedInformers is used for tracking which informers have been started.
+	// This allows Start() to be called multiple times safely.
+	startedInformers map[reflect.Type]bool
+
+	// pollerStarted is used for tracking whether the source poller has been started.
+	pollerStarted bool
+	// document is the last document loaded from the source, nil if never loaded.
+	document *schema.Document
+	// reflectors are the running reflectors which the loaded document should send to.
+	reflectors map[*reflector]struct{}
+}
+
+// Start implements SharedInformerFactory.Start
+func (f *sharedInformerFactory) Start(stopCh <-chan struct{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for informerType, sharedInformer := range f.informers {
+		if !f.startedInformers[informerType] {
+			go sharedInformer.Run(stopCh)
+			f.startedInformers[informerType] = true
+		}
+	}
+
+	if !f.pollerStarted {
+		go f.pollWorker(stopCh)
+		f.pollerStarted = true
+	}
+}
+
+// WaitForCacheSync implements SharedInformerFactory.WaitForCacheSync
+func (f *sharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool {
+	informers := func() map[reflect.Type]cache.SharedIndexInformer {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		informers := map[reflect.Type]cache.SharedIndexInformer{}
+		for informerType, sharedInformer := range f.informers {
+			if f.startedInformers[informerType] {
+				informers[informerType] = sharedInformer
+			}
+		}
+		return informers
+	}()
+
+	res := map[reflect.Type]bool{}
+	for informType, sharedInformer := range informers {
+		res[informType] = cache.WaitForCacheSync(stopCh, sharedInformer.HasSynced)
+	}
+	return res
+}
+
+// VM implements SharedInformerFactory.VM
+func (f *sharedInformerFactory) VM() cache.SharedIndexInformer {
+	return f.InformerFor(&schema.VM{})
+}
+
+// SecurityPolicy implements SharedInformerFactory.SecurityPolicy
+func (f *sharedInformerFactory) SecurityPolicy() cache.SharedIndexInformer {
+	return f.InformerFor(&schema.SecurityPolicy{})
+}
+
+// InformerFor implements SharedInformerFactory.InformerFor
+func (f *sharedInformerFactory) InformerFor(obj schema.Object) cache.SharedIndexInformer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	informerType := reflect.TypeOf(obj)
+	sharedInformer, exists := f.informers[informerType]
+	if exists {
+		return sharedInformer
+	}
+
+	sharedInformer = informer.NewSharedIndexInformer(f.newReflector, obj, ObjectKey, f.defaultResync, cache.Indexers{})
+	f.informers[informerType] = sharedInformer
+
+	return sharedInformer
+}
+
+func (f *sharedInformerFactory) newReflector(options *informer.ReflectorOptions) informer.Reflector {
+	return &reflector{
+		factory:      f,
+		documents:    make(chan *schema.Document, 1),
+		store:        options.Store,
+		expectType:   reflect.TypeOf(options.ExpectedType),
+		resyncPeriod: options.ResyncPeriod,
+		shouldResync: options.ShouldResync,
+		clock:        options.Clock,
+	}
+}
+
+// pollWorker loads the source every pollPeriod or once the source changed, and
+// sends the loaded document to all the running reflectors.
+func (f *sharedInformerFactory) pollWorker(stopCh <-chan struct{}) {
+	klog.Infof("start poller with source %s", f.source)
+	defer klog.Infof("stop poller with source %s", f.source)
+
+	var changed <-chan struct{}
+	if notifier, ok := f.source.(source.Notifier); ok {
+		var err error
+		if changed, err = notifier.Notify(stopCh); err != nil {
+			klog.Errorf("unable watch source %s, changes would be loaded on next poll: %s", f.source, err)
+		}
+	}
+
+	ticker := time.NewTicker(f.pollPeriod)
+	defer ticker.Stop()
+
+	for {
+		f.loadSource()
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case _, ok := <-changed:
+			if !ok {
+				changed = nil
+			}
+		}
+	}
+}
+
+func (f *sharedInformerFactory) loadSource() {
+	defer runtime.HandleCrash()
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.pollPeriod)
+	defer cancel()
+
+	document, err := f.source.Load(ctx)
+	if err != nil {
+		klog.Errorf("failed to load from %s: %s", f.source, err)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.document = document
+	for r := range f.reflectors {
+		r.send(document)
+	}
+}
+
+// addReflector registers the reflector to receive the loaded documents, returns
+// the last loaded document, nil if never loaded.
+func (f *sharedInformerFactory) addReflector(r *reflector) *schema.Document {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.reflectors[r] = struct{}{}
+	return f.document
+}
+
+func (f *sharedInformerFactory) removeReflector(r *reflector) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.reflectors, r)
+}
+
+func ObjectKey(obj interface{}) (string, error) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return d.Key, nil
+	}
+	resource, ok := obj.(schema.Object)
+	if ok && !reflect.ValueOf(resource).IsNil() {
+		return resource.GetID(), nil
+	}
+	return "", fmt.Errorf("unsupport resource type %s, object: %v", obj, obj)
+}
+
+// reflector receives the documents loaded by the factory, causes all changes
+// of the expect type to be reflected in the given store.
+type reflector struct {
+	factory *sharedInformerFactory
+	// documents receives the latest document loaded from the source
+	documents chan *schema.Document
+	// The destination to sync up with the source
+	store cache.Store
+
+	// An example object of the type we expect to place in the store.
+	expectType reflect.Type
+
+	// resyncPeriod is the period at which shouldResync is considered.
+	resyncPeriod time.Duration
+	// shouldResync is invoked periodically and whenever it returns `true` the Store's Resync operation is invoked
+	shouldResync cache.ShouldResyncFunc
+	// clock allows tests to manipulate time
+	clock clock.Clock
+
+	// lastSynced contains objects replaced into the store last time,
+	// nil if the store has never been replaced
+	lastSynced map[string]interface{}
+}
+
+// Run replaces objects in the store with each document loaded by the factory.
+// Run will exit when stopCh is closed.
+func (r *reflector) Run(stopCh <-chan struct{}) {
+	klog.Infof("start reflector for object %s, with source %s", r.expectType, r.factory.source)
+	defer klog.Infof("stop reflector for object %s, with source %s", r.expectType, r.factory.source)
+
+	document := r.factory.addReflector(r)
+	defer r.factory.removeReflector(r)
+	if document != nil {
+		r.syncWorker(document)
+	}
+
+	go r.resyncWorker(stopCh)
+	for {
+		select {
+		case <-stopCh:
+			return
+		case document = <-r.documents:
+			r.syncWorker(document)
+		}
+	}
+}
+
+// Resource version not support by source.
+func (r *reflector) LastSyncResourceVersion() string {
+	return "<unknown>"
+}
+
+// send replaces the pending document with the document, it should be called
+// with the factory lock held.
+func (r *reflector) send(document *schema.Document) {
+	select {
+	case <-r.documents:
+	default:
+	}
+	r.documents <- document
+}
+
+func (r *reflector) syncWorker(document *schema.Document) {
+	defer runtime.HandleCrash()
+
+	if err := r.syncWith(document); err != nil {
+		klog.Errorf("unable save objects %s: %s", r.expectType, err)
+	}
+}
+
+// syncWith replaces the store's items with objects of the expect type in the document.
+func (r *reflector) syncWith(document *schema.Document) error {
+	var found []interface{}
+
+	switch r.expectType {
+	case reflect.TypeOf(&schema.VM{}):
+		for _, vm := range document.VMs {
+			found = append(found, vm)
+		}
+	case reflect.TypeOf(&schema.SecurityPolicy{}):
+		for _, policy := range document.SecurityPolicies {
+			found = append(found, policy)
+		}
+	default:
+		return fmt.Errorf("unsupport object type %s", r.expectType)
+	}
+
+	// The store emit update events for all objects on every replace, ignore
+	// the unchanged objects to prevent unnecessary process.
+	synced := make(map[string]interface{}, len(found))
+	for _, obj := range found {
+		key, err := ObjectKey(obj)
+		if err != nil {
+			return err
+		}
+		synced[key] = obj
+	}
+	if r.lastSynced != nil && reflect.DeepEqual(r.lastSynced, synced) {
+		return nil
+	}
+
+	if err := r.store.Replace(found, r.LastSyncResourceVersion()); err != nil {
+		return err
+	}
+	r.lastSynced = synced
+	return nil
+}
+
+// resyncWorker will resync store when every after resyncPeriod and shouldResync
+func (r *reflector) resyncWorker(stopCh <-chan struct{}) {
+	if r.resyncPeriod == 0 {
+		return
+	}
+
+	ticker := r.clock.NewTicker(r.resyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-stopCh:
+			return
+		}
+		if r.shouldResync == nil || r.shouldResync() {
+			if err := r.store.Resync(); err != nil {
+				klog.Errorf("reflector of type %s, unable resync store: %s", r.expectType, err)
+			}
+		}
+	}
+}
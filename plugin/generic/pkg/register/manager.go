@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package register
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	"github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	"github.com/everoute/everoute/plugin/generic/pkg/controller/endpoint"
+	"github.com/everoute/everoute/plugin/generic/pkg/controller/policy"
+	"github.com/everoute/everoute/plugin/generic/pkg/informer"
+	"github.com/everoute/everoute/plugin/generic/pkg/source"
+)
+
+type Options struct {
+	// will enable controller if "Enable" empty or true
+	Enable *bool
+	// load inventory documents from the directory
+	Directory string
+	// load inventory document from the url, take precedence over Directory
+	URL          string
+	PollPeriod   time.Duration
+	ResyncPeriod time.Duration
+	WorkerNumber uint
+	Namespace    string
+}
+
+// InitFlags set and load options from flagset.
+func InitFlags(opts *Options, flagset *flag.FlagSet, flagPrefix string) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+	if opts.Enable == nil {
+		opts.Enable = new(bool)
+	}
+	var withPrefix = func(name string) string { return flagPrefix + name }
+
+	flagset.BoolVar(opts.Enable, withPrefix("enable"), false, "If true, generic plugin will start (default false)")
+	flagset.StringVar(&opts.Directory, withPrefix("directory"), "", "Load inventory yaml/json documents from the directory")
+	flagset.StringVar(&opts.URL, withPrefix("url"), "", "Load inventory yaml/json document from the http url")
+	flagset.StringVar(&opts.Namespace, withPrefix("namespace"), "generic-space", "Namespace which endpoint and security policy should create in")
+	flagset.UintVar(&opts.WorkerNumber, withPrefix("worker-number"), 10, "Controller worker number")
+	flagset.DurationVar(&opts.PollPeriod, withPrefix("poll-period"), 10*time.Second, "Period of load inventory from source")
+	flagset.DurationVar(&opts.ResyncPeriod, withPrefix("resync-period"), 10*time.Hour, "Controller resync period")
+}
+
+// AddToManager allow you register controller to Manager.
+func AddToManager(opts *Options, mgr manager.Manager) error {
+	if opts.Enable != nil && !*opts.Enable {
+		return nil
+	}
+
+	var inventorySource source.Source
+	switch {
+	case opts.URL != "":
+		inventorySource = source.NewHTTPSource(opts.URL, &http.Client{Timeout: opts.PollPeriod})
+	case opts.Directory != "":
+		inventorySource = source.NewDirectorySource(opts.Directory)
+	default:
+		return fmt.Errorf("generic plugin requires either url or directory")
+	}
+
+	crdClient, err := clientset.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	factory := informer.NewSharedInformerFactory(inventorySource, opts.PollPeriod, opts.ResyncPeriod)
+	// cache endpoints and security policies in the namespace
+	crdFactory := externalversions.NewSharedInformerFactoryWithOptions(crdClient, opts.ResyncPeriod, externalversions.WithNamespace(opts.Namespace))
+
+	endpointController := endpoint.New(factory, crdFactory, crdClient, opts.ResyncPeriod, opts.Namespace)
+	policyController := policy.New(factory, crdFactory, crdClient, opts.ResyncPeriod, opts.Namespace)
+
+	err = mgr.Add(manager.RunnableFunc(func(stopChan <-chan struct{}) error {
+		factory.Start(stopChan)
+		crdFactory.Start(stopChan)
+
+		go endpointController.Run(opts.WorkerNumber, stopChan)
+		go policyController.Run(opts.WorkerNumber, stopChan)
+
+		<-stopChan
+		return nil
+	}))
+
+	return err
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+// Object lets you work with object metadata from inventory documents.
+type Object interface {
+	// GetID returns the object ID.
+	GetID() string
+}
+
+// Document is the neutral schema which describes an inventory. An inventory
+// could be split into multiple documents, e.g. multiple files in a directory.
+type Document struct {
+	VMs              []*VM             `json:"vms,omitempty"`
+	SecurityPolicies []*SecurityPolicy `json:"securityPolicies,omitempty"`
+}
+
+// Merge appends all objects of other document into the document.
+func (d *Document) Merge(other *Document) {
+	d.VMs = append(d.VMs, other.VMs...)
+	d.SecurityPolicies = append(d.SecurityPolicies, other.SecurityPolicies...)
+}
+
+// VM is a virtual machine (or any other workload) with network interfaces.
+type VM struct {
+	// ID is the unique value for this vm
+	ID     string            `json:"id"`
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	NICs   []NIC             `json:"nics,omitempty"`
+}
+
+// GetID returns the vm ID.
+func (vm *VM) GetID() string { return vm.ID }
+
+// NIC is a network interface of a vm. Each nic would be mapped to an Endpoint.
+type NIC struct {
+	// ID is the unique value for this nic, it's used as the endpoint name,
+	// so must be a valid kubernetes object name.
+	ID string `json:"id"`
+	// InterfaceID is the external_ids:iface-id of the ovs interface.
+	InterfaceID string `json:"interfaceID"`
+	VlanID      uint32 `json:"vlanID,omitempty"`
+}
+
+// SecurityPolicy describes what network traffic is allowed for a set of vms.
+// Only the directions with rules would be limited, a policy without any rules
+// limits ingress traffic.
+type SecurityPolicy struct {
+	// ID is the unique value for this policy, it's used as the SecurityPolicy
+	// name, so must be a valid kubernetes object name.
+	ID string `json:"id"`
+	// Tier of the policy, default tier2.
+	Tier          string       `json:"tier,omitempty"`
+	SymmetricMode bool         `json:"symmetricMode,omitempty"`
+	AppliedTo     []PolicyPeer `json:"appliedTo,omitempty"`
+	Ingress       []PolicyRule `json:"ingress,omitempty"`
+	Egress        []PolicyRule `json:"egress,omitempty"`
+}
+
+// GetID returns the policy ID.
+func (p *SecurityPolicy) GetID() string { return p.ID }
+
+// PolicyPeer selects vms or ip addresses. Exactly one field should be set.
+type PolicyPeer struct {
+	// VM selects all nics of the vm with the ID.
+	VM string `json:"vm,omitempty"`
+	// Labels selects nics of vms which has all the labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// IPBlock selects an ip or cidr, e.g. 10.0.0.1 or 10.0.0.0/24.
+	IPBlock string `json:"ipBlock,omitempty"`
+}
+
+// PolicyRule allows traffic from/to the peers on the ports. Empty peers
+// or ports means all.
+type PolicyRule struct {
+	Name  string       `json:"name"`
+	Peers []PolicyPeer `json:"peers,omitempty"`
+	Ports []PolicyPort `json:"ports,omitempty"`
+}
+
+// PolicyPort describes the protocol and ports to match in a rule.
+type PolicyPort struct {
+	// Protocol could be TCP, UDP or ICMP.
+	Protocol string `json:"protocol"`
+	// Port is a port, a range like 20-80 or multiple ports like 20,22-24.
+	Port string `json:"port,omitempty"`
+}
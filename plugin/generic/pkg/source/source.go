@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+
+	"github.com/everoute/everoute/plugin/generic/pkg/schema"
+)
+
+// Source know how to load the inventory document.
+type Source interface {
+	// Load returns the current inventory.
+	Load(ctx context.Context) (*schema.Document, error)
+	// String returns the description of the source.
+	String() string
+}
+
+// Notifier is implemented by the sources which could notify their changes,
+// so the changes can be loaded without waiting for the next poll.
+type Notifier interface {
+	// Notify returns a channel which receives after the source changed. The
+	// channel would be closed when stopCh is closed.
+	Notify(stopCh <-chan struct{}) (<-chan struct{}, error)
+}
+
+// NewDirectorySource returns a Source which merges all YAML or JSON documents
+// (with suffix .yaml, .yml or .json) in the directory.
+func NewDirectorySource(dir string) Source {
+	return &directorySource{dir: dir}
+}
+
+type directorySource struct {
+	dir string
+}
+
+func (s *directorySource) Load(_ context.Context) (*schema.Document, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(s.dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	document := &schema.Document{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fileDocument, err := Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("decode document %s: %s", file, err)
+		}
+		document.Merge(fileDocument)
+	}
+	return document, nil
+}
+
+// Notify watches the directory instead of the files, because the files may be
+// replaced, created or removed.
+func (s *directorySource) Notify(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watcher.Add(s.dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer close(changed)
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case err := <-watcher.Errors:
+				klog.Errorf("watcher of %s error: %s", s, err)
+			case event := <-watcher.Events:
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				// changes would be merged if there is a pending one
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed, nil
+}
+
+func (s *directorySource) String() string {
+	return "dir://" + s.dir
+}
+
+// NewHTTPSource returns a Source which get YAML or JSON document from the url.
+func NewHTTPSource(url string, client *http.Client) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSource{url: url, client: client}
+}
+
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSource) Load(ctx context.Context) (*schema.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, application/yaml")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpect response code %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return Decode(data)
+}
+
+func (s *httpSource) String() string {
+	return s.url
+}
+
+// Decode decode YAML or JSON data into document.
+func Decode(data []byte) (*schema.Document, error) {
+	var document schema.Document
+	if err := yaml.UnmarshalStrict(data, &document); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
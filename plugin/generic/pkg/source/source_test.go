@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const vmDocument = `
+vms:
+- id: vm01
+  labels:
+    app: web
+  nics:
+  - id: vm01-nic0
+    interfaceID: 9a1b7c5e
+    vlanID: 10
+`
+
+const policyDocument = `{
+  "securityPolicies": [{
+    "id": "allow-web",
+    "appliedTo": [{"labels": {"app": "web"}}],
+    "ingress": [{"name": "http", "ports": [{"protocol": "TCP", "port": "80"}]}]
+  }]
+}`
+
+func TestDirectorySource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-source-")
+	if err != nil {
+		t.Fatalf("unable create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, filepath.Join(dir, "vms.yaml"), vmDocument)
+	writeFile(t, filepath.Join(dir, "policies.json"), policyDocument)
+	writeFile(t, filepath.Join(dir, "README.md"), "not a document")
+
+	document, err := NewDirectorySource(dir).Load(context.Background())
+	if err != nil {
+		t.Fatalf("unexpect error while load document: %s", err)
+	}
+	if len(document.VMs) != 1 || len(document.VMs[0].NICs) != 1 || document.VMs[0].NICs[0].VlanID != 10 {
+		t.Fatalf("unexpect vms %+v", document.VMs)
+	}
+	if len(document.SecurityPolicies) != 1 || document.SecurityPolicies[0].Ingress[0].Ports[0].Port != "80" {
+		t.Fatalf("unexpect security policies %+v", document.SecurityPolicies)
+	}
+
+	writeFile(t, filepath.Join(dir, "invalid.yaml"), "vms: [{unknownField: value}]")
+	if _, err = NewDirectorySource(dir).Load(context.Background()); err == nil {
+		t.Fatalf("should got error when load document with unknown field")
+	}
+}
+
+func TestDirectorySourceNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-source-")
+	if err != nil {
+		t.Fatalf("unable create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stopCh := make(chan struct{})
+	changed, err := NewDirectorySource(dir).(Notifier).Notify(stopCh)
+	if err != nil {
+		t.Fatalf("unexpect error while watch directory: %s", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "vms.yaml"), vmDocument)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("should notify after file created")
+	}
+
+	close(stopCh)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-changed:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("should close the channel after stopped")
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inventory" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(vmDocument))
+	}))
+	defer server.Close()
+
+	document, err := NewHTTPSource(server.URL+"/inventory", nil).Load(context.Background())
+	if err != nil {
+		t.Fatalf("unexpect error while load document: %s", err)
+	}
+	if len(document.VMs) != 1 || document.VMs[0].ID != "vm01" {
+		t.Fatalf("unexpect vms %+v", document.VMs)
+	}
+
+	if _, err = NewHTTPSource(server.URL+"/notfound", nil).Load(context.Background()); err == nil {
+		t.Fatalf("should got error when server response not found")
+	}
+}
+
+func writeFile(t *testing.T, name, data string) {
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatalf("unable write file %s: %s", name, err)
+	}
+}
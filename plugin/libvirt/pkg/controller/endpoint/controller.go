@@ -0,0 +1,341 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	kubeerror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	crd "github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	"github.com/everoute/everoute/pkg/utils"
+	"github.com/everoute/everoute/plugin/libvirt/pkg/domain"
+)
+
+// Controller sync endpoints from libvirt domains running on this host. Each
+// interface attached to openvswitch would be mapped to an endpoint.
+type Controller struct {
+	// name of this controller
+	name string
+	// namespace which endpoint should create in
+	namespace string
+	// hostname identify endpoints owned by this host
+	hostname string
+
+	crdClient clientset.Interface
+
+	source     domain.Source
+	syncPeriod time.Duration
+	// domainStore contains running domains listed from source
+	domainStore cache.Indexer
+	// domainStoreSynced is set to 1 after domains listed from source successfully, endpoints
+	// must not be synced before that, or all endpoints would be deleted as no domain running
+	domainStoreSynced int32
+
+	endpointInformer       cache.SharedIndexInformer
+	endpointLister         utils.Lister
+	endpointInformerSynced cache.InformerSynced
+
+	// endpointQueue contains endpoint to process. The element in queue
+	// is the ovs interfaceid of the domain interface.
+	endpointQueue workqueue.RateLimitingInterface
+}
+
+const (
+	interfaceIndex = "interfaceIndex"
+
+	ExternalIDName = "iface-id"
+	EndpointPrefix = "libvirt.ep."
+	// HostAnnotation records which host the endpoint domain currently running on.
+	HostAnnotation = "libvirt.everoute.io/host"
+)
+
+// New creates a new instance of controller.
+func New(
+	source domain.Source,
+	crdFactory crd.SharedInformerFactory,
+	crdClient clientset.Interface,
+	syncPeriod time.Duration,
+	resyncPeriod time.Duration,
+	namespace string,
+	hostname string,
+) *Controller {
+	endpointInformer := crdFactory.Security().V1alpha1().Endpoints().Informer()
+
+	c := &Controller{
+		name:                   "LibvirtEndpointController",
+		namespace:              namespace,
+		hostname:               hostname,
+		crdClient:              crdClient,
+		source:                 source,
+		syncPeriod:             syncPeriod,
+		endpointInformer:       endpointInformer,
+		endpointLister:         endpointInformer.GetIndexer(),
+		endpointInformerSynced: endpointInformer.HasSynced,
+		endpointQueue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	c.domainStore = cache.NewIndexer(domainKeyFunc, cache.Indexers{
+		interfaceIndex: interfaceIndexFunc,
+	})
+
+	// Handle endpoint events, so that endpoints unexpectedly modified or left
+	// after the agent restart could be resynced.
+	endpointInformer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handleEndpoint,
+			UpdateFunc: c.updateEndpoint,
+			DeleteFunc: c.handleEndpoint,
+		},
+		resyncPeriod,
+	)
+
+	return c
+}
+
+// Run begins processing items, and will continue until a value is sent down stopCh or it is closed.
+func (c *Controller) Run(workers uint, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+	defer c.endpointQueue.ShutDown()
+
+	go wait.Until(c.syncDomains, c.syncPeriod, stopCh)
+
+	if !cache.WaitForNamedCacheSync(c.name, stopCh, c.endpointInformerSynced, c.hasDomainStoreSynced) {
+		return
+	}
+
+	for i := uint(0); i < workers; i++ {
+		go wait.Until(utils.ReconcileWorker(c.name, c.endpointQueue, c.syncEndpoint), time.Second, stopCh)
+	}
+
+	<-stopCh
+}
+
+func domainKeyFunc(obj interface{}) (string, error) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return d.Key, nil
+	}
+	return obj.(*domain.Domain).UUID, nil
+}
+
+func interfaceIndexFunc(obj interface{}) ([]string, error) {
+	var interfaceIDs []string
+	for _, iface := range obj.(*domain.Domain).OvsInterfaces() {
+		interfaceIDs = append(interfaceIDs, iface.InterfaceID())
+	}
+	return interfaceIDs, nil
+}
+
+// syncDomains list domains from source and update the domain store,
+// interfaces of changed domains would be enqueued.
+func (c *Controller) syncDomains() {
+	domains, err := c.source.List()
+	if err != nil {
+		klog.Errorf("%s failed to list domains: %s", c.name, err)
+		return
+	}
+
+	runningDomains := sets.NewString()
+	for _, newDomain := range domains {
+		runningDomains.Insert(newDomain.UUID)
+
+		obj, exists, _ := c.domainStore.GetByKey(newDomain.UUID)
+		if exists && reflect.DeepEqual(obj, newDomain) {
+			continue
+		}
+		if exists {
+			c.enqueueInterfaces(obj.(*domain.Domain))
+		}
+		klog.Infof("%s receive domain %s(%s) start or update", c.name, newDomain.Name, newDomain.UUID)
+		_ = c.domainStore.Update(newDomain)
+		c.enqueueInterfaces(newDomain)
+	}
+
+	for _, obj := range c.domainStore.List() {
+		oldDomain := obj.(*domain.Domain)
+		if runningDomains.Has(oldDomain.UUID) {
+			continue
+		}
+		klog.Infof("%s receive domain %s(%s) stop", c.name, oldDomain.Name, oldDomain.UUID)
+		_ = c.domainStore.Delete(oldDomain)
+		c.enqueueInterfaces(oldDomain)
+	}
+
+	atomic.StoreInt32(&c.domainStoreSynced, 1)
+}
+
+func (c *Controller) hasDomainStoreSynced() bool {
+	return atomic.LoadInt32(&c.domainStoreSynced) == 1
+}
+
+func (c *Controller) enqueueInterfaces(d *domain.Domain) {
+	for _, iface := range d.OvsInterfaces() {
+		c.endpointQueue.Add(iface.InterfaceID())
+	}
+}
+
+func (c *Controller) handleEndpoint(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	name := obj.(*v1alpha1.Endpoint).GetName()
+	if strings.HasPrefix(name, EndpointPrefix) {
+		c.endpointQueue.Add(strings.TrimPrefix(name, EndpointPrefix))
+	}
+}
+
+func (c *Controller) updateEndpoint(_, new interface{}) {
+	c.handleEndpoint(new)
+}
+
+// syncEndpoint process create/update/delete event for endpoint
+func (c *Controller) syncEndpoint(key string) error {
+	domains, err := c.domainStore.ByIndex(interfaceIndex, key)
+	if err != nil {
+		return err
+	}
+
+	switch len(domains) {
+	case 0:
+		// delete this endpoint
+		return c.processEndpointDelete(key)
+	case 1:
+		// create or update endpoint from domain interface
+		return c.processEndpointUpdate(domains[0].(*domain.Domain), key)
+	default:
+		return fmt.Errorf("got multiple domains %+v for interface %s", domains, key)
+	}
+}
+
+func (c *Controller) processEndpointDelete(key string) error {
+	name := EndpointName(key)
+	obj, exists, err := c.endpointLister.GetByKey(fmt.Sprintf("%s/%s", c.namespace, name))
+	if err == nil && !exists {
+		// object has been delete already
+		return nil
+	}
+	if exists && obj.(*v1alpha1.Endpoint).GetAnnotations()[HostAnnotation] != c.hostname {
+		// the domain has been migrated to another host, endpoint owned by that host now
+		return nil
+	}
+
+	err = c.crdClient.SecurityV1alpha1().Endpoints(c.namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err == nil || kubeerror.IsNotFound(err) {
+		klog.Infof("endpoint %s has been delete by %s", name, c.name)
+		return nil
+	}
+	return err
+}
+
+func (c *Controller) processEndpointUpdate(d *domain.Domain, key string) error {
+	iface, exists := fetchInterface(d, key)
+	if !exists {
+		return fmt.Errorf("unable find interface %s in domain %+v", key, d)
+	}
+	labels := validLabels(d)
+
+	obj, exists, err := c.endpointLister.GetByKey(fmt.Sprintf("%s/%s", c.namespace, EndpointName(key)))
+	if err != nil {
+		return fmt.Errorf("get endpoint receive error: %s", err)
+	}
+
+	if !exists {
+		ep := &v1alpha1.Endpoint{}
+		c.setEndpoint(ep, iface, labels)
+
+		klog.Infof("will add endpoint from domain %s interface %s: %+v", d.UUID, key, ep)
+		_, err = c.crdClient.SecurityV1alpha1().Endpoints(c.namespace).Create(context.Background(), ep, metav1.CreateOptions{})
+		return err
+	}
+
+	ep := obj.(*v1alpha1.Endpoint).DeepCopy()
+	if c.setEndpoint(ep, iface, labels) {
+		klog.Infof("will update endpoint from domain %s interface %s: %+v", d.UUID, key, ep)
+		_, err = c.crdClient.SecurityV1alpha1().Endpoints(c.namespace).Update(context.Background(), ep, metav1.UpdateOptions{})
+		return err
+	}
+
+	return nil
+}
+
+// set endpoint return false if endpoint not changes
+func (c *Controller) setEndpoint(ep *v1alpha1.Endpoint, iface *domain.Interface, labels map[string]string) bool {
+	var epCopy = ep.DeepCopy()
+
+	ep.Name = EndpointName(iface.InterfaceID())
+	ep.Namespace = c.namespace
+	ep.Labels = labels
+	if ep.Annotations == nil {
+		ep.Annotations = make(map[string]string, 1)
+	}
+	ep.Annotations[HostAnnotation] = c.hostname
+	ep.Spec.VID = iface.VlanID()
+	ep.Spec.Reference.ExternalIDName = ExternalIDName
+	ep.Spec.Reference.ExternalIDValue = iface.InterfaceID()
+	ep.Spec.Type = v1alpha1.EndpointDynamic
+
+	return !reflect.DeepEqual(ep, epCopy)
+}
+
+// EndpointName return endpoint name of the domain interface.
+func EndpointName(interfaceID string) string {
+	return EndpointPrefix + interfaceID
+}
+
+func fetchInterface(d *domain.Domain, interfaceID string) (*domain.Interface, bool) {
+	for _, iface := range d.OvsInterfaces() {
+		if iface.InterfaceID() == interfaceID {
+			return &iface, true
+		}
+	}
+	return nil, false
+}
+
+// validLabels return domain labels which are valid kubernetes labels
+func validLabels(d *domain.Domain) map[string]string {
+	labels := d.GetLabels()
+	for key, value := range labels {
+		validKey := len(validation.IsQualifiedName(key)) == 0
+		validValue := len(validation.IsValidLabelValue(value)) == 0
+		if !validKey || !validValue {
+			klog.Infof("ignore domain %s invalid kubernetes label %s=%s", d.UUID, key, value)
+			delete(labels, key)
+		}
+	}
+
+	if len(labels) == 0 {
+		// If labels length is zero, would return nil instead of an empty map.
+		// Consistent with the empty labels returned by the apiserver.
+		return nil
+	}
+	return labels
+}
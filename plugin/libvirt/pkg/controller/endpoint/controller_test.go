@@ -0,0 +1,243 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoint_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"github.com/everoute/everoute/pkg/apis/security/v1alpha1"
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset/fake"
+	"github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	controller "github.com/everoute/everoute/plugin/libvirt/pkg/controller/endpoint"
+	"github.com/everoute/everoute/plugin/libvirt/pkg/domain"
+)
+
+const domainTemplate = `
+<domain type='kvm'>
+  <name>%s</name>
+  <uuid>%s</uuid>
+  <metadata>
+    <everoute:labels xmlns:everoute="http://everoute.io/libvirt/1.0">
+      <everoute:label key="app" value="%s"/>
+    </everoute:labels>
+  </metadata>
+  <devices>
+    <interface type='bridge'>
+      <mac address='52:54:00:aa:bb:01'/>
+      <source bridge='ovsbr0'/>
+      <virtualport type='openvswitch'>
+        <parameters interfaceid='%s'/>
+      </virtualport>
+      <vlan>
+        <tag id='%d'/>
+      </vlan>
+    </interface>
+  </devices>
+</domain>
+`
+
+type testDomain struct {
+	name        string
+	uuid        string
+	app         string
+	interfaceID string
+	vlan        uint32
+}
+
+var _ = Describe("LibvirtEndpointController", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+	AfterEach(func() {
+		files, err := filepath.Glob(filepath.Join(domainDir, "*.xml"))
+		Expect(err).Should(Succeed())
+		for _, file := range files {
+			Expect(os.Remove(file)).Should(Succeed())
+		}
+		assertEndpointsNum(ctx, 0)
+	})
+
+	When("start domain with ovs interface", func() {
+		var vm *testDomain
+
+		BeforeEach(func() {
+			vm = newTestDomain("web")
+			By(fmt.Sprintf("start domain %+v", vm))
+			startDomain(vm)
+		})
+		It("should create endpoint", func() {
+			assertEndpointsNum(ctx, 1)
+			assertHasEndpoint(ctx, vm)
+		})
+
+		When("update domain labels", func() {
+			BeforeEach(func() {
+				assertEndpointsNum(ctx, 1)
+				vm.app = "db"
+				By(fmt.Sprintf("update domain %+v", vm))
+				startDomain(vm)
+			})
+			It("should update endpoint labels", func() {
+				assertHasEndpoint(ctx, vm)
+			})
+		})
+
+		When("stop domain", func() {
+			BeforeEach(func() {
+				assertEndpointsNum(ctx, 1)
+				By(fmt.Sprintf("stop domain %+v", vm))
+				stopDomain(vm)
+			})
+			It("should delete endpoint", func() {
+				assertEndpointsNum(ctx, 0)
+			})
+		})
+	})
+
+	When("endpoint owned by another host", func() {
+		var ep *v1alpha1.Endpoint
+
+		BeforeEach(func() {
+			interfaceID := string(uuid.NewUUID())
+			ep = &v1alpha1.Endpoint{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        controller.EndpointName(interfaceID),
+					Namespace:   namespace,
+					Annotations: map[string]string{controller.HostAnnotation: "host-b"},
+				},
+				Spec: v1alpha1.EndpointSpec{
+					Reference: v1alpha1.EndpointReference{
+						ExternalIDName:  controller.ExternalIDName,
+						ExternalIDValue: interfaceID,
+					},
+					Type: v1alpha1.EndpointDynamic,
+				},
+			}
+			By(fmt.Sprintf("create endpoint %+v", ep))
+			_, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Create(ctx, ep, metav1.CreateOptions{})
+			Expect(err).Should(Succeed())
+		})
+		AfterEach(func() {
+			err := crdClient.SecurityV1alpha1().Endpoints(namespace).Delete(ctx, ep.Name, metav1.DeleteOptions{})
+			Expect(err).Should(Succeed())
+		})
+		It("should not delete the endpoint", func() {
+			Consistently(func() int {
+				return listEndpointsNum(ctx)
+			}, timeout/5, interval).Should(Equal(1))
+		})
+	})
+})
+
+var _ = Describe("LibvirtEndpointController with unavailable source", func() {
+	It("should not delete local endpoints before domains listed", func() {
+		ctx := context.Background()
+		interfaceID := string(uuid.NewUUID())
+		ep := &v1alpha1.Endpoint{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        controller.EndpointName(interfaceID),
+				Namespace:   namespace,
+				Annotations: map[string]string{controller.HostAnnotation: hostname},
+			},
+			Spec: v1alpha1.EndpointSpec{
+				Reference: v1alpha1.EndpointReference{
+					ExternalIDName:  controller.ExternalIDName,
+					ExternalIDValue: interfaceID,
+				},
+				Type: v1alpha1.EndpointDynamic,
+			},
+		}
+		client := fake.NewSimpleClientset(ep)
+		factory := externalversions.NewSharedInformerFactory(client, 0)
+		stop := make(chan struct{})
+		defer close(stop)
+
+		ctroller := controller.New(failedSource{}, factory, client, interval, 0, namespace, hostname)
+		go ctroller.Run(1, stop)
+		factory.Start(stop)
+		factory.WaitForCacheSync(stop)
+
+		Consistently(func() error {
+			_, err := client.SecurityV1alpha1().Endpoints(namespace).Get(ctx, ep.Name, metav1.GetOptions{})
+			return err
+		}, timeout/5, interval).Should(Succeed())
+	})
+})
+
+// failedSource always fails to list domains, e.g. virsh unavailable
+type failedSource struct{}
+
+func (failedSource) List() ([]*domain.Domain, error) {
+	return nil, errors.New("list domains failed")
+}
+
+func newTestDomain(app string) *testDomain {
+	return &testDomain{
+		name:        "vm-" + string(uuid.NewUUID())[:8],
+		uuid:        string(uuid.NewUUID()),
+		app:         app,
+		interfaceID: string(uuid.NewUUID()),
+		vlan:        10,
+	}
+}
+
+func startDomain(d *testDomain) {
+	data := fmt.Sprintf(domainTemplate, d.name, d.uuid, d.app, d.interfaceID, d.vlan)
+	Expect(ioutil.WriteFile(filepath.Join(domainDir, d.uuid+".xml"), []byte(data), 0644)).Should(Succeed())
+}
+
+func stopDomain(d *testDomain) {
+	Expect(os.Remove(filepath.Join(domainDir, d.uuid+".xml"))).Should(Succeed())
+}
+
+func listEndpointsNum(ctx context.Context) int {
+	epList, err := crdClient.SecurityV1alpha1().Endpoints(namespace).List(ctx, metav1.ListOptions{})
+	Expect(err).Should(Succeed())
+	return len(epList.Items)
+}
+
+func assertEndpointsNum(ctx context.Context, numOfEndpoints int) {
+	Eventually(func() int {
+		return listEndpointsNum(ctx)
+	}, timeout, interval).Should(Equal(numOfEndpoints))
+}
+
+func assertHasEndpoint(ctx context.Context, d *testDomain) {
+	Eventually(func() bool {
+		ep, err := crdClient.SecurityV1alpha1().Endpoints(namespace).Get(ctx, controller.EndpointName(d.interfaceID), metav1.GetOptions{})
+		if err != nil {
+			return false
+		}
+		return ep.Labels["app"] == d.app &&
+			ep.Annotations[controller.HostAnnotation] == hostname &&
+			ep.Spec.VID == d.vlan &&
+			ep.Spec.Reference.ExternalIDName == controller.ExternalIDName &&
+			ep.Spec.Reference.ExternalIDValue == d.interfaceID
+	}, timeout, interval).Should(BeTrue())
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoint_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset/fake"
+	"github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	controller "github.com/everoute/everoute/plugin/libvirt/pkg/controller/endpoint"
+	"github.com/everoute/everoute/plugin/libvirt/pkg/domain"
+)
+
+var (
+	crdClient clientset.Interface
+	domainDir string
+	namespace = metav1.NamespaceDefault
+	hostname  = "host-a"
+	stopCh    = make(chan struct{})
+)
+
+const (
+	timeout  = time.Second * 10
+	interval = time.Millisecond * 250
+)
+
+func TestEndpointController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LibvirtEndpointController Suite")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+
+	By("create domain dir and fake client")
+	domainDir, err = ioutil.TempDir("", "libvirt-domains-")
+	Expect(err).Should(Succeed())
+	crdClient = fake.NewSimpleClientset()
+	crdFactory := externalversions.NewSharedInformerFactory(crdClient, 0)
+
+	By("create and start EndpointController")
+	ctroller := controller.New(domain.NewFileSource(domainDir), crdFactory, crdClient, interval, 0, namespace, hostname)
+	go ctroller.Run(10, stopCh)
+
+	By("start crdFactory and wait for cache sync")
+	crdFactory.Start(stopCh)
+	crdFactory.WaitForCacheSync(stopCh)
+}, 60)
+
+var _ = AfterSuite(func() {
+	By("tearing down the environment")
+	close(stopCh)
+	Expect(os.RemoveAll(domainDir)).Should(Succeed())
+})
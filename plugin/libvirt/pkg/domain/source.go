@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Source know how to list running domains on the host.
+type Source interface {
+	// List returns all running domains.
+	List() ([]*Domain, error)
+}
+
+// NewVirshSource returns a Source which read domains from libvirtd by virsh.
+// If uri is empty, the default connection uri of virsh would be used.
+func NewVirshSource(uri string) Source {
+	return &virshSource{uri: uri}
+}
+
+type virshSource struct {
+	uri string
+}
+
+func (s *virshSource) List() ([]*Domain, error) {
+	output, err := s.virsh("list", "--name")
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []*Domain
+	for _, name := range strings.Split(string(output), "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		data, err := s.virsh("dumpxml", name)
+		if err != nil {
+			return nil, err
+		}
+		domain, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse domain %s: %s", name, err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+func (s *virshSource) virsh(args ...string) ([]byte, error) {
+	if s.uri != "" {
+		args = append([]string{"--connect", s.uri}, args...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("virsh", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("virsh %s: %s, stderr: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// NewFileSource returns a Source which read domains from xml files in the
+// directory, each file contains one running domain. The file Source is a
+// stand-in of libvirtd, mostly used for tests.
+func NewFileSource(dir string) Source {
+	return &fileSource{dir: dir}
+}
+
+type fileSource struct {
+	dir string
+}
+
+func (s *fileSource) List() ([]*Domain, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []*Domain
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		domain, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse domain from %s: %s", file, err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
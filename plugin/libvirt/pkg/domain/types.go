@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// MetadataNamespace is the xml namespace of everoute metadata in libvirt domain.
+// Labels of the domain are defined in the metadata like:
+//
+//	<metadata>
+//	  <everoute:labels xmlns:everoute="http://everoute.io/libvirt/1.0">
+//	    <everoute:label key="app" value="web"/>
+//	  </everoute:labels>
+//	</metadata>
+const MetadataNamespace = "http://everoute.io/libvirt/1.0"
+
+const (
+	// VirtualPortOpenvswitch is the virtualport type of interface attached to ovs.
+	VirtualPortOpenvswitch = "openvswitch"
+)
+
+// Domain is the subset of libvirt domain xml everoute cares about.
+// More: https://libvirt.org/formatdomain.html
+type Domain struct {
+	XMLName  xml.Name  `xml:"domain"`
+	Name     string    `xml:"name"`
+	UUID     string    `xml:"uuid"`
+	Metadata *Metadata `xml:"metadata"`
+	Devices  Devices   `xml:"devices"`
+}
+
+type Metadata struct {
+	Labels *Labels `xml:"http://everoute.io/libvirt/1.0 labels"`
+}
+
+type Labels struct {
+	Labels []Label `xml:"http://everoute.io/libvirt/1.0 label"`
+}
+
+type Label struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type Devices struct {
+	Interfaces []Interface `xml:"interface"`
+}
+
+type Interface struct {
+	Type        string           `xml:"type,attr"`
+	MAC         *MAC             `xml:"mac"`
+	Source      *InterfaceSource `xml:"source"`
+	VirtualPort *VirtualPort     `xml:"virtualport"`
+	Vlan        *Vlan            `xml:"vlan"`
+	Target      *Target          `xml:"target"`
+}
+
+type MAC struct {
+	Address string `xml:"address,attr"`
+}
+
+type InterfaceSource struct {
+	Bridge string `xml:"bridge,attr"`
+}
+
+type VirtualPort struct {
+	Type       string          `xml:"type,attr"`
+	Parameters *PortParameters `xml:"parameters"`
+}
+
+type PortParameters struct {
+	InterfaceID string `xml:"interfaceid,attr"`
+}
+
+type Vlan struct {
+	Tags []VlanTag `xml:"tag"`
+}
+
+type VlanTag struct {
+	ID uint32 `xml:"id,attr"`
+}
+
+type Target struct {
+	Dev string `xml:"dev,attr"`
+}
+
+// Parse decode libvirt domain xml into Domain.
+func Parse(data []byte) (*Domain, error) {
+	var domain Domain
+	if err := xml.Unmarshal(data, &domain); err != nil {
+		return nil, fmt.Errorf("unmarshal domain xml: %s", err)
+	}
+	if domain.UUID == "" {
+		return nil, fmt.Errorf("domain %s has empty uuid", domain.Name)
+	}
+	domain.UUID = strings.ToLower(domain.UUID)
+	return &domain, nil
+}
+
+// GetLabels return labels defined in the domain everoute metadata.
+func (d *Domain) GetLabels() map[string]string {
+	if d.Metadata == nil || d.Metadata.Labels == nil || len(d.Metadata.Labels.Labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(d.Metadata.Labels.Labels))
+	for _, label := range d.Metadata.Labels.Labels {
+		labels[label.Key] = label.Value
+	}
+	return labels
+}
+
+// OvsInterfaces return interfaces attached to openvswitch with interfaceid.
+func (d *Domain) OvsInterfaces() []Interface {
+	var ifaces []Interface
+	for _, iface := range d.Devices.Interfaces {
+		if iface.InterfaceID() == "" {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces
+}
+
+// InterfaceID return the ovs iface-id of the interface, return empty if the
+// interface not attached to openvswitch.
+func (i *Interface) InterfaceID() string {
+	if i.VirtualPort == nil || i.VirtualPort.Type != VirtualPortOpenvswitch || i.VirtualPort.Parameters == nil {
+		return ""
+	}
+	return strings.ToLower(i.VirtualPort.Parameters.InterfaceID)
+}
+
+// VlanID return the access vlan of the interface, return zero if no vlan
+// or the interface is a trunk.
+func (i *Interface) VlanID() uint32 {
+	if i.Vlan == nil || len(i.Vlan.Tags) != 1 {
+		return 0
+	}
+	return i.Vlan.Tags[0].ID
+}
+
+// MacAddress return the mac address of the interface.
+func (i *Interface) MacAddress() string {
+	if i.MAC == nil {
+		return ""
+	}
+	return strings.ToLower(i.MAC.Address)
+}
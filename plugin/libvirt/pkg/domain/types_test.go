@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+const domainXML = `
+<domain type='kvm'>
+  <name>vm01</name>
+  <uuid>0B0A5E2C-5F6F-4E3C-8D86-5B8E2A9E1F6A</uuid>
+  <metadata>
+    <everoute:labels xmlns:everoute="http://everoute.io/libvirt/1.0">
+      <everoute:label key="app" value="web"/>
+      <everoute:label key="env" value="prod"/>
+    </everoute:labels>
+    <other:info xmlns:other="http://example.com/other">
+      <other:label key="ignored" value="true"/>
+    </other:info>
+  </metadata>
+  <devices>
+    <interface type='bridge'>
+      <mac address='52:54:00:AA:BB:01'/>
+      <source bridge='ovsbr0'/>
+      <virtualport type='openvswitch'>
+        <parameters interfaceid='9A1B7C5E-6C3B-4C1B-9E5F-1D2F3A4B5C6D'/>
+      </virtualport>
+      <vlan>
+        <tag id='10'/>
+      </vlan>
+      <target dev='vnet0'/>
+    </interface>
+    <interface type='network'>
+      <mac address='52:54:00:aa:bb:02'/>
+      <source network='default'/>
+      <target dev='vnet1'/>
+    </interface>
+  </devices>
+</domain>
+`
+
+func TestParse(t *testing.T) {
+	domain, err := Parse([]byte(domainXML))
+	if err != nil {
+		t.Fatalf("unexpect error while parse domain: %s", err)
+	}
+
+	if domain.Name != "vm01" || domain.UUID != "0b0a5e2c-5f6f-4e3c-8d86-5b8e2a9e1f6a" {
+		t.Fatalf("unexpect domain name %s or uuid %s", domain.Name, domain.UUID)
+	}
+
+	expectLabels := map[string]string{"app": "web", "env": "prod"}
+	if !reflect.DeepEqual(domain.GetLabels(), expectLabels) {
+		t.Fatalf("expect labels %+v, got %+v", expectLabels, domain.GetLabels())
+	}
+
+	ifaces := domain.OvsInterfaces()
+	if len(ifaces) != 1 {
+		t.Fatalf("expect one ovs interface, got %+v", ifaces)
+	}
+	if ifaces[0].InterfaceID() != "9a1b7c5e-6c3b-4c1b-9e5f-1d2f3a4b5c6d" {
+		t.Fatalf("unexpect interfaceid %s", ifaces[0].InterfaceID())
+	}
+	if ifaces[0].VlanID() != 10 {
+		t.Fatalf("unexpect vlan %d", ifaces[0].VlanID())
+	}
+	if ifaces[0].MacAddress() != "52:54:00:aa:bb:01" {
+		t.Fatalf("unexpect mac address %s", ifaces[0].MacAddress())
+	}
+}
+
+func TestParseWithoutUUID(t *testing.T) {
+	_, err := Parse([]byte(`<domain><name>vm01</name></domain>`))
+	if err == nil {
+		t.Fatalf("should got error when parse domain without uuid")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Everoute Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package register
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/everoute/everoute/pkg/client/clientset_generated/clientset"
+	"github.com/everoute/everoute/pkg/client/informers_generated/externalversions"
+	"github.com/everoute/everoute/plugin/libvirt/pkg/controller/endpoint"
+	"github.com/everoute/everoute/plugin/libvirt/pkg/domain"
+)
+
+type Options struct {
+	// will enable controller if "Enable" empty or true
+	Enable *bool
+	// libvirt connection uri, use virsh default uri if empty
+	URI string
+	// read domain xml from the directory instead of libvirtd if set
+	DomainDir    string
+	Hostname     string
+	SyncPeriod   time.Duration
+	ResyncPeriod time.Duration
+	WorkerNumber uint
+	Namespace    string
+}
+
+// InitFlags set and load options from flagset.
+func InitFlags(opts *Options, flagset *flag.FlagSet, flagPrefix string) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+	if opts.Enable == nil {
+		opts.Enable = new(bool)
+	}
+	var withPrefix = func(name string) string { return flagPrefix + name }
+
+	flagset.BoolVar(opts.Enable, withPrefix("enable"), false, "If true, libvirt plugin will start (default false)")
+	flagset.StringVar(&opts.URI, withPrefix("uri"), "", "Libvirt connection uri, use virsh default uri if empty")
+	flagset.StringVar(&opts.DomainDir, withPrefix("domain-dir"), "", "Read domain xml files from the directory instead of libvirtd")
+	flagset.StringVar(&opts.Hostname, withPrefix("hostname"), "", "Hostname which identify endpoints owned by this host, use os hostname if empty")
+	flagset.StringVar(&opts.Namespace, withPrefix("namespace"), "libvirt-space", "Namespace which endpoint should create in")
+	flagset.UintVar(&opts.WorkerNumber, withPrefix("worker-number"), 10, "Controller worker number")
+	flagset.DurationVar(&opts.SyncPeriod, withPrefix("sync-period"), 5*time.Second, "Period of list domains from libvirt")
+	flagset.DurationVar(&opts.ResyncPeriod, withPrefix("resync-period"), 10*time.Hour, "Controller resync period")
+}
+
+// AddToManager allow you register controller to Manager.
+func AddToManager(opts *Options, mgr manager.Manager) error {
+	if opts.Enable != nil && !*opts.Enable {
+		return nil
+	}
+
+	hostname := opts.Hostname
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return fmt.Errorf("unable get hostname: %s", err)
+		}
+	}
+
+	var source = domain.NewVirshSource(opts.URI)
+	if opts.DomainDir != "" {
+		source = domain.NewFileSource(opts.DomainDir)
+	}
+
+	crdClient, err := clientset.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	// cache endpoints in the namespace
+	crdFactory := externalversions.NewSharedInformerFactoryWithOptions(crdClient, opts.ResyncPeriod, externalversions.WithNamespace(opts.Namespace))
+	endpointController := endpoint.New(source, crdFactory, crdClient, opts.SyncPeriod, opts.ResyncPeriod, opts.Namespace, hostname)
+
+	err = mgr.Add(manager.RunnableFunc(func(stopChan <-chan struct{}) error {
+		crdFactory.Start(stopChan)
+
+		go endpointController.Run(opts.WorkerNumber, stopChan)
+
+		<-stopChan
+		return nil
+	}))
+
+	return err
+}
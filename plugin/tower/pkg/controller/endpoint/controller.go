@@ -116,7 +116,7 @@ func New(
 		endpointInformer:       endpointInforer,
 		endpointLister:         endpointInforer.GetIndexer(),
 		endpointInformerSynced: endpointInforer.HasSynced,
-		endpointQueue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		endpointQueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-endpoint"),
 
 		systemEndpointInformer:        systemEndpointInformer,
 		systemEndpointLister:          systemEndpointInformer.GetIndexer(),
@@ -124,7 +124,7 @@ func New(
 		everouteClusterInformer:       erClusterInformer,
 		everouteClusterLister:         erClusterInformer.GetIndexer(),
 		everouteClusterInformerSynced: erClusterInformer.HasSynced,
-		staticEndpointQueue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		staticEndpointQueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-static-endpoint"),
 	}
 
 	// ignore error, error only when informer has already started
@@ -80,7 +80,7 @@ func New(
 		globalPolicyInformer:          globalPolicyInformer,
 		globalPolicyLister:            globalPolicyInformer.GetIndexer(),
 		globalPolicyInformerSynced:    globalPolicyInformer.HasSynced,
-		reconcileQueue:                workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		reconcileQueue:                workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-global-policy"),
 	}
 
 	globalPolicyInformer.AddEventHandlerWithResyncPeriod(
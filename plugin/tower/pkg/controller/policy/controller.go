@@ -157,10 +157,10 @@ func New(
 		systemEndpointInformer:        systemEndpointInformer,
 		systemEndpointLister:          systemEndpointInformer.GetIndexer(),
 		systemEndpointInformerSynced:  systemEndpointInformer.HasSynced,
-		isolationPolicyQueue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		securityPolicyQueue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		systemEndpointPolicyQueue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		everouteClusterPolicyQueue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		isolationPolicyQueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-isolation-policy"),
+		securityPolicyQueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-security-policy"),
+		systemEndpointPolicyQueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-system-endpoint-policy"),
+		everouteClusterPolicyQueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tower-everoute-cluster-policy"),
 	}
 
 	// when vm's vnics changes, enqueue related IsolationPolicy
@@ -24,11 +24,11 @@ import (
 
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
 
+	"github.com/everoute/everoute/pkg/utils"
+	"github.com/everoute/everoute/pkg/utils/informer"
 	"github.com/everoute/everoute/plugin/tower/pkg/client"
 	"github.com/everoute/everoute/plugin/tower/pkg/schema"
-	"github.com/everoute/everoute/plugin/tower/third_party/forked/client-go/informer"
 )
 
 // SharedInformerFactory provides shared informers for all resources
@@ -188,25 +188,7 @@ func TowerObjectKey(obj interface{}) (string, error) {
 	return "", fmt.Errorf("unsupport resource type %s, object: %v", obj, obj)
 }
 
+// ReconcileWorker returns a worker which processes keys from the queue with processFunc.
 func ReconcileWorker(name string, queue workqueue.RateLimitingInterface, processFunc func(string) error) func() {
-	return func() {
-		for {
-			key, quit := queue.Get()
-			if quit {
-				return
-			}
-
-			err := processFunc(key.(string))
-			if err != nil {
-				queue.Done(key)
-				queue.AddRateLimited(key)
-				klog.Errorf("%s got error while sync %s: %s", name, key.(string), err)
-				continue
-			}
-
-			// stop the rate limiter from tracking the key
-			queue.Done(key)
-			queue.Forget(key)
-		}
-	}
+	return utils.ReconcileWorker(name, queue, processFunc)
 }
@@ -16,29 +16,12 @@ limitations under the License.
 
 package informer
 
-// Lister know how to list and get object from store.
-type Lister interface {
-	KeyLister
-
-	// List returns a list of all the currently non-empty accumulators
-	List() []interface{}
-
-	// GetByKey returns the accumulator associated with the given key
-	GetByKey(key string) (interface{}, bool, error)
+import (
+	"github.com/everoute/everoute/pkg/utils"
+)
 
-	// ByIndex returns the stored objects whose set of indexed values
-	// for the named index includes the given indexed value
-	ByIndex(indexName, indexedValue string) ([]interface{}, error)
-}
+// Lister know how to list and get object from store.
+type Lister = utils.Lister
 
 // KeyLister know how to list keys from store.
-type KeyLister interface {
-
-	// ListKeys returns the storage keys of the stored objects
-	ListKeys() []string
-
-	// IndexKeys returns the storage keys of the stored objects whose
-	// set of indexed values for the named index includes the given
-	// indexed value
-	IndexKeys(indexName, indexedValue string) ([]string, error)
-}
+type KeyLister = utils.KeyLister
@@ -31,10 +31,10 @@ import (
 	"k8s.io/client-go/tools/cache"
 	klog "k8s.io/klog"
 
+	"github.com/everoute/everoute/pkg/utils/informer"
 	"github.com/everoute/everoute/plugin/tower/pkg/client"
 	"github.com/everoute/everoute/plugin/tower/pkg/schema"
 	"github.com/everoute/everoute/plugin/tower/pkg/utils"
-	"github.com/everoute/everoute/plugin/tower/third_party/forked/client-go/informer"
 )
 
 // NewReflectorBuilder return a NewReflectorFunc with giving client